@@ -0,0 +1,242 @@
+package annotation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/lewtec/rotulador/internal/domain"
+)
+
+// AdminChartDays is how many days the annotations chart of the admin page covers
+const AdminChartDays = 30
+
+// AdminOverview summarizes the whole project for its admins
+type AdminOverview struct {
+	Images int64
+	Stats  *domain.AnnotationStats
+	Tasks  []*AdminTask
+	Days   []*AdminDay
+	Users  []*AdminUser
+}
+
+// AdminTask is the progress of a task and how its annotations split among its classes
+type AdminTask struct {
+	*ConfigTask
+	PhaseProgress *PhaseProgress
+	Annotations   int64
+	Classes       []*AdminClass
+}
+
+// AdminClass is the share of the annotations of a task given to a class
+type AdminClass struct {
+	ID          string
+	Name        string
+	Annotations int64
+	Percent     float64
+}
+
+// AdminDay is a bar of the annotations chart, sized against the busiest day
+type AdminDay struct {
+	Day         time.Time
+	Annotations int64
+	Percent     float64
+}
+
+// AdminUser is the work of a user, configured or seen in the annotations
+type AdminUser struct {
+	Name        string
+	Role        string // Empty for users that are not in the config, such as those of a single sign-on
+	Annotations int64
+	Percent     float64 // Share of every annotation
+	Last        *domain.AnnotationWithImage
+	LastTask    string
+}
+
+// AdminOverview computes the summary of the admin page, with a chart of the
+// AdminChartDays days up to now
+func (a *AnnotatorApp) AdminOverview(ctx context.Context, now time.Time) (*AdminOverview, error) {
+	var overview AdminOverview
+	var err error
+	if overview.Images, err = a.imageRepo.Count(ctx); err != nil {
+		return nil, fmt.Errorf("while counting images: %w", err)
+	}
+	if overview.Stats, err = a.annotationRepo.GetStats(ctx); err != nil {
+		return nil, fmt.Errorf("while getting annotation stats: %w", err)
+	}
+
+	classCounts, err := a.annotationRepo.CountByClass(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while counting annotations by class: %w", err)
+	}
+	for stageIndex, task := range a.Config.Tasks {
+		progress, err := a.GetPhaseProgressStats(ctx, task.ID)
+		if err != nil {
+			return nil, fmt.Errorf("while getting the progress of task %s: %w", task.ID, err)
+		}
+		counts := map[string]int64{}
+		adminTask := &AdminTask{ConfigTask: task, PhaseProgress: progress}
+		for _, count := range classCounts {
+			if count.StageIndex == stageIndex {
+				counts[count.OptionValue] = count.Annotations
+				adminTask.Annotations += count.Annotations
+			}
+		}
+		// Classes in the order of the config, then values no longer in it
+		var values []string
+		for _, class := range task.OrderedClasses() {
+			values = append(values, class.ID)
+		}
+		for _, value := range slices.Sorted(maps.Keys(counts)) {
+			if !slices.Contains(values, value) {
+				values = append(values, value)
+			}
+		}
+		for _, value := range values {
+			class := &AdminClass{ID: value, Name: value, Annotations: counts[value]}
+			if config := task.Classes[value]; config != nil && config.Name != "" {
+				class.Name = config.Name
+			}
+			if adminTask.Annotations > 0 {
+				class.Percent = float64(class.Annotations) / float64(adminTask.Annotations) * 100
+			}
+			adminTask.Classes = append(adminTask.Classes, class)
+		}
+		overview.Tasks = append(overview.Tasks, adminTask)
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	first := today.AddDate(0, 0, 1-AdminChartDays)
+	dailyCounts, err := a.annotationRepo.CountByDay(ctx, first)
+	if err != nil {
+		return nil, fmt.Errorf("while counting annotations by day: %w", err)
+	}
+	perDay := map[string]int64{}
+	var busiest int64
+	for _, count := range dailyCounts {
+		perDay[count.Day] = count.Annotations
+		busiest = max(busiest, count.Annotations)
+	}
+	for day := first; !day.After(today); day = day.AddDate(0, 0, 1) {
+		adminDay := &AdminDay{Day: day, Annotations: perDay[day.Format(time.DateOnly)]}
+		if busiest > 0 {
+			adminDay.Percent = float64(adminDay.Annotations) / float64(busiest) * 100
+		}
+		overview.Days = append(overview.Days, adminDay)
+	}
+
+	annotators, err := a.annotationRepo.ListUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while listing annotators: %w", err)
+	}
+	usernames := slices.Sorted(maps.Keys(a.Config.Authentication))
+	for _, username := range annotators {
+		if !slices.Contains(usernames, username) {
+			usernames = append(usernames, username)
+		}
+	}
+	for _, username := range usernames {
+		user := &AdminUser{Name: username}
+		if auth := a.Config.Authentication[username]; auth != nil {
+			user.Role = auth.Role
+		}
+		if user.Annotations, err = a.annotationRepo.CountByUser(ctx, username); err != nil {
+			return nil, fmt.Errorf("while counting the annotations of %s: %w", username, err)
+		}
+		if overview.Stats.TotalAnnotations > 0 {
+			user.Percent = float64(user.Annotations) / float64(overview.Stats.TotalAnnotations) * 100
+		}
+		if user.Annotations > 0 {
+			last, err := a.annotationRepo.GetByUser(ctx, username, 1, 0)
+			if err != nil {
+				return nil, fmt.Errorf("while getting the last annotation of %s: %w", username, err)
+			}
+			if len(last) > 0 {
+				user.Last, user.LastTask = last[0], a.StageName(last[0].StageIndex)
+			}
+		}
+		overview.Users = append(overview.Users, user)
+	}
+	return &overview, nil
+}
+
+// serveAdmin serves the admin page, and a backup of the database at /admin/backup
+func (a *AnnotatorApp) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if !user.IsAdmin() || (r.Method != http.MethodGet && !user.HasScope(ScopeAdmin)) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/admin/backup" && r.Method == http.MethodPost:
+		a.serveBackup(w, r)
+		return
+	case r.URL.Path == "/admin/" && r.Method == http.MethodGet:
+	default:
+		http.NotFoundHandler().ServeHTTP(w, r)
+		return
+	}
+
+	overview, err := a.AdminOverview(r.Context(), time.Now())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "computing admin overview", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	data := map[string]interface{}{
+		"Title":     "Admin",
+		"Overview":  overview,
+		"ChartDays": AdminChartDays,
+	}
+	if err := RenderPageWithRequest(r, w, "admin.html", data); err != nil {
+		slog.ErrorContext(r.Context(), "rendering admin template", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// serveBackup downloads a copy of the database, see BackupDatabase
+func (a *AnnotatorApp) serveBackup(w http.ResponseWriter, r *http.Request) {
+	dir, err := os.MkdirTemp("", "rotulador-backup-")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "creating backup folder", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "annotations.db")
+	if err := BackupDatabase(r.Context(), a.Database, filename); err != nil {
+		slog.ErrorContext(r.Context(), "backing up the database", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "opening backup", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "opening backup", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	a.audit(r.Context(), "downloaded database backup", "user", requestUser(r).Name, "client_ip", clientIP(r), "bytes", stat.Size())
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Length", fmt.Sprint(stat.Size()))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="annotations-%s.db"`, time.Now().Format("20060102-150405")))
+	if _, err := io.Copy(w, file); err != nil {
+		slog.WarnContext(r.Context(), "sending backup", "error", err)
+	}
+}
@@ -0,0 +1,126 @@
+package annotation
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminOverview(t *testing.T) {
+	app := newTestAppWithUsers(t, map[string]string{"alice": "role: annotator"}, testConfigTasks, 3)
+	ctx := t.Context()
+	for _, ann := range []AnnotationResponse{
+		{ImageID: testImageHash(0), TaskID: "has_car", User: "admin", Value: "true"},
+		{ImageID: testImageHash(1), TaskID: "has_car", User: "admin", Value: "true"},
+		{ImageID: testImageHash(2), TaskID: "has_car", User: "alice", Value: "false"},
+		{ImageID: testImageHash(0), TaskID: "car_kind", User: "carol", Value: "toy"},
+	} {
+		if err := app.SubmitAnnotation(ctx, ann); err != nil {
+			t.Fatalf("SubmitAnnotation() error = %v", err)
+		}
+	}
+
+	overview, err := app.AdminOverview(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("AdminOverview() error = %v", err)
+	}
+	if overview.Images != 3 || overview.Stats.TotalAnnotations != 4 || overview.Stats.TotalUsers != 3 {
+		t.Errorf("images = %d, stats = %+v", overview.Images, overview.Stats)
+	}
+
+	t.Run("classes are counted per task, in the order of the config", func(t *testing.T) {
+		hasCar, carKind := overview.Tasks[0], overview.Tasks[1]
+		if hasCar.Annotations != 3 || hasCar.Classes[0].Name != "Yes" || hasCar.Classes[0].Annotations != 2 || hasCar.Classes[1].Annotations != 1 {
+			t.Errorf("has_car classes = %+v %+v", hasCar.Classes[0], hasCar.Classes[1])
+		}
+		if len(carKind.Classes) != 3 || carKind.Classes[1].ID != "toy" || carKind.Classes[1].Percent != 100 || carKind.Classes[0].Annotations != 0 {
+			t.Errorf("car_kind classes = %+v", carKind.Classes)
+		}
+		if carKind.PhaseProgress.Completed != 1 || carKind.PhaseProgress.Pending != 1 {
+			t.Errorf("car_kind progress = %+v", carKind.PhaseProgress)
+		}
+	})
+
+	t.Run("the chart ends today with every annotation", func(t *testing.T) {
+		if len(overview.Days) != AdminChartDays {
+			t.Fatalf("days = %d, want %d", len(overview.Days), AdminChartDays)
+		}
+		today := overview.Days[len(overview.Days)-1]
+		if today.Day.Format(time.DateOnly) != time.Now().Format(time.DateOnly) || today.Annotations != 4 || today.Percent != 100 {
+			t.Errorf("today = %+v", today)
+		}
+		if overview.Days[0].Annotations != 0 {
+			t.Errorf("first day = %+v", overview.Days[0])
+		}
+	})
+
+	t.Run("users include those only seen in the annotations", func(t *testing.T) {
+		names := []string{}
+		for _, user := range overview.Users {
+			names = append(names, user.Name)
+		}
+		if strings.Join(names, ",") != "admin,alice,carol" {
+			t.Fatalf("users = %v", names)
+		}
+		admin, carol := overview.Users[0], overview.Users[2]
+		if admin.Role != RoleAdmin || admin.Annotations != 2 || admin.Percent != 50 || admin.Last == nil || admin.LastTask != "has_car" {
+			t.Errorf("admin = %+v", admin)
+		}
+		if carol.Role != "" || carol.Annotations != 1 || carol.LastTask != "car_kind" || carol.Last.OptionValue != "toy" {
+			t.Errorf("carol = %+v", carol)
+		}
+	})
+}
+
+func TestAdminPage(t *testing.T) {
+	app := newTestAppWithUsers(t, map[string]string{"alice": "role: annotator"}, testConfigTasks, 1)
+	handler := app.GetHTTPHandler()
+	if err := app.SubmitAnnotation(t.Context(), AnnotationResponse{ImageID: testImageHash(0), TaskID: "has_car", User: "alice", Value: "true"}); err != nil {
+		t.Fatalf("SubmitAnnotation() error = %v", err)
+	}
+
+	t.Run("admins see every task and user", func(t *testing.T) {
+		rec := doRequest(t, handler, http.MethodGet, "/admin/", nil)
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		for _, want := range []string{"Car kind", `href="/audit/user/alice"`, `hx-post="/ingestion/"`, `href="/api/v1/export?format=csv"`, `action="/admin/backup"`} {
+			if !strings.Contains(body, want) {
+				t.Errorf("page does not contain %s", want)
+			}
+		}
+	})
+
+	t.Run("other users are forbidden", func(t *testing.T) {
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			target := map[string]string{http.MethodGet: "/admin/", http.MethodPost: "/admin/backup"}[method]
+			if rec := doRequestAs(t, handler, "alice", method, target, nil); rec.Code != http.StatusForbidden {
+				t.Errorf("%s %s: status = %d, want 403", method, target, rec.Code)
+			}
+		}
+	})
+
+	t.Run("backups are a copy of the database", func(t *testing.T) {
+		rec := doRequest(t, handler, http.MethodPost, "/admin/backup", nil)
+		if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Disposition"), `attachment; filename="annotations-`) {
+			t.Fatalf("status = %d, headers = %v", rec.Code, rec.Header())
+		}
+		filename := filepath.Join(t.TempDir(), "backup.db")
+		if err := os.WriteFile(filename, rec.Body.Bytes(), 0600); err != nil {
+			t.Fatalf("failed to write backup: %v", err)
+		}
+		db, err := GetDatabase(filename)
+		if err != nil {
+			t.Fatalf("GetDatabase() error = %v", err)
+		}
+		defer db.Close()
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM annotations").Scan(&count); err != nil || count != 1 {
+			t.Errorf("backup annotations = %d, %v", count, err)
+		}
+	})
+}
@@ -0,0 +1,546 @@
+package annotation
+
+import (
+	"context"
+	_ "embed"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lewtec/rotulador/internal/domain"
+)
+
+const (
+	// apiDefaultPageSize is how many items a listing returns when no limit is given
+	apiDefaultPageSize = 100
+	// apiMaxPageSize caps the limit of listings
+	apiMaxPageSize = 1000
+	// apiMaxBatchSize caps the annotations of a single submission
+	apiMaxBatchSize = 1000
+	// apiMaxBodySize caps request bodies
+	apiMaxBodySize = 4 << 20
+)
+
+// openAPIDocument describes the API, keep it in sync with apiHandler
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// APIError is returned, under "error", by every failed API request
+type APIError struct {
+	// Code is a short identifier derived from the HTTP status, such as not_found
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// APITask is a task along with its progress
+type APITask struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	ShortName string            `json:"short_name,omitempty"`
+	Type      string            `json:"type,omitempty"`
+	If        map[string]string `json:"if,omitempty"`
+	Classes   []APIClass        `json:"classes"`
+	Progress  *PhaseProgress    `json:"progress"`
+}
+
+// APIClass is a class of a task, in declaration order
+type APIClass struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Key         string `json:"key,omitempty"`
+	Color       string `json:"color,omitempty"`
+	Icon        string `json:"icon,omitempty"`
+}
+
+// APIStep is an image waiting for an annotation in a task
+type APIStep struct {
+	Task     string `json:"task"`
+	Image    string `json:"image"`
+	Filename string `json:"filename"`
+}
+
+// APIAnnotation is a stored annotation
+type APIAnnotation struct {
+	ID          int64      `json:"id"`
+	Task        string     `json:"task"`
+	Image       string     `json:"image"`
+	Filename    string     `json:"filename,omitempty"`
+	User        string     `json:"user"`
+	Value       string     `json:"value"`
+	AnnotatedAt time.Time  `json:"annotated_at"`
+	FlaggedAt   *time.Time `json:"flagged_at,omitempty"`
+	FlagReason  string     `json:"flag_reason,omitempty"`
+}
+
+// APIAnnotationInput is an annotation to submit
+type APIAnnotationInput struct {
+	Task  string `json:"task"`
+	Image string `json:"image"`
+	Value string `json:"value"`
+	Sure  bool   `json:"sure"`
+}
+
+// APIImage is an image along with the annotations the user may see
+type APIImage struct {
+	SHA256      string          `json:"sha256"`
+	Filename    string          `json:"filename"`
+	IngestedAt  time.Time       `json:"ingested_at"`
+	URL         string          `json:"url"`
+	Annotations []APIAnnotation `json:"annotations"`
+}
+
+// isAPIRequest tells whether a request is for the JSON API
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Error("api: writing response", "error", err)
+	}
+}
+
+// writeAPIError writes an APIError whose code is derived from the status
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	code := strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	writeJSON(w, status, map[string]APIError{"error": {Code: code, Message: message}})
+}
+
+// denyRequest ends a request refused before reaching a handler, with an APIError for the API
+func denyRequest(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if isAPIRequest(r) {
+		writeAPIError(w, status, message)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// encodeCursor and decodeCursor turn the ID where a listing stopped into an opaque cursor
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	value, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errors.New("invalid cursor")
+	}
+	id, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errors.New("invalid cursor")
+	}
+	return id, nil
+}
+
+// pageLimit reads the limit parameter of a listing
+func pageLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return apiDefaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > apiMaxPageSize {
+		return 0, fmt.Errorf("limit must be between 1 and %d", apiMaxPageSize)
+	}
+	return limit, nil
+}
+
+func (a *AnnotatorApp) apiTask(ctx context.Context, task *ConfigTask) (*APITask, error) {
+	progress, err := a.GetPhaseProgressStats(ctx, task.ID)
+	if err != nil {
+		return nil, err
+	}
+	result := &APITask{
+		ID:        task.ID,
+		Name:      task.Name,
+		ShortName: task.ShortName,
+		Type:      task.Type,
+		If:        task.If,
+		Classes:   []APIClass{},
+		Progress:  progress,
+	}
+	for _, class := range task.OrderedClasses() {
+		result.Classes = append(result.Classes, APIClass{
+			ID:          class.ID,
+			Name:        class.Name,
+			Description: class.Description,
+			Key:         class.Key,
+			Color:       class.Color,
+			Icon:        class.Icon,
+		})
+	}
+	return result, nil
+}
+
+func (a *AnnotatorApp) apiAnnotation(ann *domain.Annotation, filename string) APIAnnotation {
+	result := APIAnnotation{
+		ID:          ann.ID,
+		Image:       ann.ImageSHA256,
+		Filename:    filename,
+		User:        ann.Username,
+		Value:       ann.OptionValue,
+		AnnotatedAt: ann.AnnotatedAt,
+		FlaggedAt:   ann.FlaggedAt,
+		FlagReason:  ann.FlagReason,
+	}
+	if ann.StageIndex < len(a.Config.Tasks) {
+		result.Task = a.Config.Tasks[ann.StageIndex].ID
+	}
+	return result
+}
+
+// canSeeAnnotation tells whether the user may read an annotation: their own,
+// or anyone's for reviewers, in the tasks they can see
+func (a *AnnotatorApp) canSeeAnnotation(user *RequestUser, ann *domain.Annotation) bool {
+	if ann.StageIndex >= len(a.Config.Tasks) || !user.CanSeeTask(a.Config.Tasks[ann.StageIndex].ID) {
+		return false
+	}
+	return ann.Username == user.Name || user.CanReview()
+}
+
+// validateAnnotationInput checks that the user may submit an annotation, returning the status and reason when not
+func (a *AnnotatorApp) validateAnnotationInput(ctx context.Context, user *RequestUser, input APIAnnotationInput) (int, error) {
+	task := a.GetTask(input.Task)
+	if task == nil || !user.CanSeeTask(input.Task) {
+		return http.StatusNotFound, fmt.Errorf("no such task: %q", input.Task)
+	}
+	if !user.CanAnnotateTask(input.Task) {
+		return http.StatusForbidden, fmt.Errorf("you may not annotate task %s", input.Task)
+	}
+	if _, ok := task.Classes[input.Value]; !ok {
+		return http.StatusBadRequest, fmt.Errorf("%q is not a class of task %s", input.Value, input.Task)
+	}
+	img, err := a.imageRepo.GetBySHA256(ctx, input.Image)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if img == nil {
+		return http.StatusNotFound, fmt.Errorf("no such image: %q", input.Image)
+	}
+	return 0, nil
+}
+
+// apiHandler serves the JSON API under /api/v1
+func (a *AnnotatorApp) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/v1/tasks", func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		tasks := []*APITask{}
+		for _, task := range a.Config.Tasks {
+			if !user.CanSeeTask(task.ID) {
+				continue
+			}
+			result, err := a.apiTask(r.Context(), task)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "api: getting progress", "task", task.ID, "error", err)
+				writeAPIError(w, http.StatusInternalServerError, "could not get the progress of the tasks")
+				return
+			}
+			tasks = append(tasks, result)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"tasks": tasks})
+	})
+
+	mux.HandleFunc("GET /api/v1/tasks/{task}", func(w http.ResponseWriter, r *http.Request) {
+		task := a.GetTask(r.PathValue("task"))
+		if task == nil || !requestUser(r).CanSeeTask(task.ID) {
+			writeAPIError(w, http.StatusNotFound, "no such task")
+			return
+		}
+		result, err := a.apiTask(r.Context(), task)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "api: getting progress", "task", task.ID, "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "could not get the progress of the task")
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+
+	mux.HandleFunc("GET /api/v1/next", func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if !user.CanAnnotate() {
+			writeAPIError(w, http.StatusForbidden, "you may not annotate")
+			return
+		}
+		taskID := r.URL.Query().Get("task")
+		if taskID != "" && (a.GetTask(taskID) == nil || !user.CanSeeTask(taskID)) {
+			writeAPIError(w, http.StatusNotFound, "no such task")
+			return
+		}
+		step, err := a.NextAnnotationStep(r.Context(), user, taskID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "api: getting next step", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "could not get the next step")
+			return
+		}
+		var result *APIStep
+		if step != nil {
+			result = &APIStep{Task: step.TaskID, Image: step.ImageID, Filename: step.ImageName}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"step": result})
+	})
+
+	mux.HandleFunc("GET /api/v1/annotations", func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		query := r.URL.Query()
+		limit, err := pageLimit(r)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var beforeID int64
+		if cursor := query.Get("cursor"); cursor != "" {
+			if beforeID, err = decodeCursor(cursor); err != nil {
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		filter := domain.AnnotationFilter{Username: query.Get("user"), ImageSHA256: query.Get("image")}
+		// Users other than reviewers only list their own annotations
+		if !user.CanReview() {
+			if filter.Username != "" && filter.Username != user.Name {
+				writeAPIError(w, http.StatusForbidden, "you may only list your own annotations")
+				return
+			}
+			filter.Username = user.Name
+		}
+		if taskID := query.Get("task"); taskID != "" {
+			stageIndex := a.taskStageIndex(taskID)
+			if stageIndex == -1 || !user.CanSeeTask(taskID) {
+				writeAPIError(w, http.StatusNotFound, "no such task")
+				return
+			}
+			filter.StageIndex = &stageIndex
+		}
+		if query.Has("value") {
+			value := query.Get("value")
+			filter.OptionValue = &value
+		}
+
+		// One more than asked tells whether there is a next page
+		annotations, err := a.annotationRepo.ListPage(r.Context(), filter, beforeID, limit+1)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "api: listing annotations", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "could not list the annotations")
+			return
+		}
+		response := map[string]any{}
+		if len(annotations) > limit {
+			annotations = annotations[:limit]
+			response["next_cursor"] = encodeCursor(annotations[limit-1].ID)
+		}
+		results := []APIAnnotation{}
+		for _, ann := range annotations {
+			if a.canSeeAnnotation(user, &ann.Annotation) {
+				results = append(results, a.apiAnnotation(&ann.Annotation, ann.ImageFilename))
+			}
+		}
+		response["annotations"] = results
+		writeJSON(w, http.StatusOK, response)
+	})
+
+	mux.HandleFunc("POST /api/v1/annotations", func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if !user.CanAnnotate() {
+			writeAPIError(w, http.StatusForbidden, "you may not annotate")
+			return
+		}
+		var body struct {
+			Annotations []APIAnnotationInput `json:"annotations"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodySize)).Decode(&body); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		if len(body.Annotations) == 0 || len(body.Annotations) > apiMaxBatchSize {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("send between 1 and %d annotations", apiMaxBatchSize))
+			return
+		}
+
+		responses := make([]AnnotationResponse, len(body.Annotations))
+		for idx, input := range body.Annotations {
+			if status, err := a.validateAnnotationInput(r.Context(), user, input); err != nil {
+				if status == http.StatusInternalServerError {
+					slog.ErrorContext(r.Context(), "api: checking annotation", "error", err)
+					err = errors.New("could not check the annotation")
+				}
+				writeAPIError(w, status, fmt.Sprintf("annotations[%d]: %s", idx, err))
+				return
+			}
+			responses[idx] = AnnotationResponse{
+				ImageID:  input.Image,
+				TaskID:   input.Task,
+				User:     user.Name,
+				Value:    input.Value,
+				Sure:     input.Sure,
+				ClientIP: clientIP(r),
+			}
+		}
+		if err := a.SubmitAnnotationBatch(r.Context(), responses); err != nil {
+			slog.ErrorContext(r.Context(), "api: submitting annotations", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "could not store the annotations")
+			return
+		}
+
+		results := make([]APIAnnotation, 0, len(responses))
+		for _, response := range responses {
+			ann, err := a.annotationRepo.Get(r.Context(), response.ImageID, user.Name, a.taskStageIndex(response.TaskID))
+			if err != nil || ann == nil {
+				slog.ErrorContext(r.Context(), "api: reading submitted annotation", "error", err)
+				writeAPIError(w, http.StatusInternalServerError, "could not read the stored annotations")
+				return
+			}
+			results = append(results, a.apiAnnotation(ann, ""))
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{"annotations": results})
+	})
+
+	mux.HandleFunc("GET /api/v1/images/{sha256}", func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		img, err := a.imageRepo.GetBySHA256(r.Context(), r.PathValue("sha256"))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "api: getting image", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "could not get the image")
+			return
+		}
+		if img == nil {
+			writeAPIError(w, http.StatusNotFound, "no such image")
+			return
+		}
+		annotations, err := a.annotationRepo.GetForImage(r.Context(), img.SHA256)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "api: getting annotations of image", "image", img.SHA256, "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "could not get the annotations of the image")
+			return
+		}
+		result := APIImage{
+			SHA256:      img.SHA256,
+			Filename:    img.Filename,
+			IngestedAt:  img.IngestedAt,
+			URL:         a.url("/asset/" + img.SHA256),
+			Annotations: []APIAnnotation{},
+		}
+		for _, ann := range annotations {
+			if a.canSeeAnnotation(user, ann) {
+				result.Annotations = append(result.Annotations, a.apiAnnotation(ann, ""))
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+
+	mux.HandleFunc("GET /api/v1/export", func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		query := r.URL.Query()
+		filter := domain.AnnotationFilter{}
+		if !user.CanReview() {
+			filter.Username = user.Name
+		}
+		if taskID := query.Get("task"); taskID != "" {
+			stageIndex := a.taskStageIndex(taskID)
+			if stageIndex == -1 || !user.CanSeeTask(taskID) {
+				writeAPIError(w, http.StatusNotFound, "no such task")
+				return
+			}
+			filter.StageIndex = &stageIndex
+		}
+		format := stringOr(query.Get("format"), "jsonl")
+		if format != "jsonl" && format != "csv" {
+			writeAPIError(w, http.StatusBadRequest, "format must be jsonl or csv")
+			return
+		}
+
+		// Pages are read as they are written, so exports of any size use little memory
+		var beforeID int64
+		var writeRow func(APIAnnotation) error
+		var csvWriter *csv.Writer
+		for {
+			page, err := a.annotationRepo.ListPage(r.Context(), filter, beforeID, apiMaxPageSize)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "api: exporting annotations", "error", err)
+				if writeRow == nil {
+					writeAPIError(w, http.StatusInternalServerError, "could not export the annotations")
+				}
+				return
+			}
+			if writeRow == nil {
+				if format == "csv" {
+					w.Header().Set("Content-Type", "text/csv")
+					w.Header().Set("Content-Disposition", `attachment; filename="annotations.csv"`)
+					csvWriter = csv.NewWriter(w)
+					csvWriter.Write([]string{"id", "task", "image", "filename", "user", "value", "annotated_at", "flagged_at", "flag_reason"})
+					writeRow = func(ann APIAnnotation) error {
+						var flaggedAt string
+						if ann.FlaggedAt != nil {
+							flaggedAt = ann.FlaggedAt.Format(time.RFC3339)
+						}
+						return csvWriter.Write([]string{
+							strconv.FormatInt(ann.ID, 10), ann.Task, ann.Image, ann.Filename, ann.User, ann.Value,
+							ann.AnnotatedAt.Format(time.RFC3339), flaggedAt, ann.FlagReason,
+						})
+					}
+				} else {
+					w.Header().Set("Content-Type", "application/x-ndjson")
+					encoder := json.NewEncoder(w)
+					writeRow = func(ann APIAnnotation) error { return encoder.Encode(ann) }
+				}
+			}
+			for _, ann := range page {
+				if !a.canSeeAnnotation(user, &ann.Annotation) {
+					continue
+				}
+				if err := writeRow(a.apiAnnotation(&ann.Annotation, ann.ImageFilename)); err != nil {
+					slog.ErrorContext(r.Context(), "api: writing export", "error", err)
+					return
+				}
+			}
+			if len(page) < apiMaxPageSize {
+				break
+			}
+			beforeID = page[len(page)-1].ID
+		}
+		if csvWriter != nil {
+			csvWriter.Flush()
+		}
+	})
+
+	mux.HandleFunc("GET /api/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDocument)
+	})
+
+	mux.HandleFunc("GET /api/v1/ingestion", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, a.IngestionStatus())
+	})
+
+	mux.HandleFunc("POST /api/v1/ingestion", func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if !user.IsAdmin() || !user.HasScope(ScopeAdmin) {
+			writeAPIError(w, http.StatusForbidden, "only admins may start an ingestion")
+			return
+		}
+		if !a.StartIngestion(a.backgroundContext()) {
+			writeAPIError(w, http.StatusConflict, "an ingestion is already running")
+			return
+		}
+		slog.InfoContext(r.Context(), "api: ingestion started", "user", user.Name, "dir", a.ImagesDir)
+		writeJSON(w, http.StatusAccepted, a.IngestionStatus())
+	})
+
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "no such endpoint")
+	})
+	return mux
+}
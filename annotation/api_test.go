@@ -0,0 +1,289 @@
+package annotation
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiRequest sends a request to the API as a user created by writeTestConfigWithUsers,
+// decoding the JSON response into out when given
+func apiRequest(t *testing.T, handler http.Handler, username, method, target string, body any, out any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("failed to encode body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(username, "changeme")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("%s %s: Content-Type = %q", method, target, got)
+	}
+	if out != nil && rec.Code < 300 {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: invalid JSON %q: %v", method, target, rec.Body.String(), err)
+		}
+	}
+	return rec
+}
+
+// apiErrorCode returns the code of an APIError response
+func apiErrorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body map[string]APIError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid error body %q: %v", rec.Body.String(), err)
+	}
+	return body["error"].Code
+}
+
+func TestAPI(t *testing.T) {
+	app := newTestAppWithUsers(t, map[string]string{
+		"alice": "role: annotator",
+		"bob":   "role: annotator, deny_tasks: [car_kind]",
+		"rita":  "role: reviewer",
+	}, testConfigTasks, 3)
+	handler := app.GetHTTPHandler()
+
+	submit := func(t *testing.T, username string, inputs ...APIAnnotationInput) *httptest.ResponseRecorder {
+		t.Helper()
+		return apiRequest(t, handler, username, http.MethodPost, "/api/v1/annotations", map[string]any{"annotations": inputs}, nil)
+	}
+
+	t.Run("tasks with progress", func(t *testing.T) {
+		var body struct{ Tasks []APITask }
+		apiRequest(t, handler, "alice", http.MethodGet, "/api/v1/tasks", nil, &body)
+		if len(body.Tasks) != 2 || body.Tasks[0].ID != "has_car" || body.Tasks[0].Progress.Pending != 3 || len(body.Tasks[1].Classes) != 3 {
+			t.Errorf("tasks = %+v", body.Tasks)
+		}
+		apiRequest(t, handler, "bob", http.MethodGet, "/api/v1/tasks", nil, &body)
+		if len(body.Tasks) != 1 {
+			t.Errorf("hidden tasks listed: %+v", body.Tasks)
+		}
+		if rec := apiRequest(t, handler, "bob", http.MethodGet, "/api/v1/tasks/car_kind", nil, nil); rec.Code != http.StatusNotFound || apiErrorCode(t, rec) != "not_found" {
+			t.Errorf("hidden task: status = %d", rec.Code)
+		}
+	})
+
+	t.Run("next step and submission", func(t *testing.T) {
+		var next struct{ Step *APIStep }
+		apiRequest(t, handler, "alice", http.MethodGet, "/api/v1/next?task=has_car", nil, &next)
+		if next.Step == nil || next.Step.Task != "has_car" {
+			t.Fatalf("step = %+v", next.Step)
+		}
+
+		var created struct{ Annotations []APIAnnotation }
+		rec := apiRequest(t, handler, "alice", http.MethodPost, "/api/v1/annotations", map[string]any{"annotations": []APIAnnotationInput{
+			{Task: "has_car", Image: next.Step.Image, Value: "true", Sure: true},
+		}}, &created)
+		if rec.Code != http.StatusCreated || len(created.Annotations) != 1 || created.Annotations[0].User != "alice" || created.Annotations[0].Value != "true" {
+			t.Fatalf("submit: status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		apiRequest(t, handler, "alice", http.MethodGet, "/api/v1/next?task=car_kind", nil, &next)
+		if next.Step == nil || next.Step.Task != "car_kind" {
+			t.Errorf("dependent task should have a step now: %+v", next.Step)
+		}
+	})
+
+	t.Run("submissions are validated as a whole", func(t *testing.T) {
+		valid := APIAnnotationInput{Task: "has_car", Image: testImageHash(1), Value: "false"}
+		for name, tt := range map[string]struct {
+			username string
+			input    APIAnnotationInput
+			status   int
+		}{
+			"unknown class": {"alice", APIAnnotationInput{Task: "has_car", Image: testImageHash(1), Value: "maybe"}, http.StatusBadRequest},
+			"unknown image": {"alice", APIAnnotationInput{Task: "has_car", Image: "missing", Value: "true"}, http.StatusNotFound},
+			"unknown task":  {"alice", APIAnnotationInput{Task: "nope", Image: testImageHash(1), Value: "true"}, http.StatusNotFound},
+			"denied task":   {"bob", APIAnnotationInput{Task: "car_kind", Image: testImageHash(1), Value: "toy"}, http.StatusNotFound},
+		} {
+			rec := submit(t, tt.username, valid, tt.input)
+			if rec.Code != tt.status || !strings.Contains(rec.Body.String(), "annotations[1]") {
+				t.Errorf("%s: status = %d, body = %s", name, rec.Code, rec.Body.String())
+			}
+		}
+		if ann, _ := app.annotationRepo.Get(context.Background(), testImageHash(1), "alice", 0); ann != nil && ann.OptionValue == "false" {
+			t.Error("valid annotation stored along with an invalid one")
+		}
+		if rec := apiRequest(t, handler, "alice", http.MethodPost, "/api/v1/annotations", "not an object", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("bad JSON: status = %d", rec.Code)
+		}
+	})
+
+	t.Run("listing with filters and cursors", func(t *testing.T) {
+		for idx := 0; idx < 3; idx++ {
+			if rec := submit(t, "bob", APIAnnotationInput{Task: "has_car", Image: testImageHash(idx), Value: "false"}); rec.Code != http.StatusCreated {
+				t.Fatalf("submit: status = %d", rec.Code)
+			}
+		}
+
+		var seen []int64
+		cursor := ""
+		for page := 0; page < 5; page++ {
+			var body struct {
+				Annotations []APIAnnotation
+				NextCursor  string `json:"next_cursor"`
+			}
+			apiRequest(t, handler, "rita", http.MethodGet, "/api/v1/annotations?user=bob&limit=2&cursor="+cursor, nil, &body)
+			for _, ann := range body.Annotations {
+				seen = append(seen, ann.ID)
+				if ann.User != "bob" || ann.Filename == "" {
+					t.Errorf("unexpected annotation %+v", ann)
+				}
+			}
+			if body.NextCursor == "" {
+				break
+			}
+			cursor = body.NextCursor
+		}
+		if len(seen) != 3 || seen[0] < seen[1] || seen[1] < seen[2] {
+			t.Errorf("paged IDs = %v, want 3 newest first", seen)
+		}
+
+		var body struct{ Annotations []APIAnnotation }
+		apiRequest(t, handler, "rita", http.MethodGet, "/api/v1/annotations?task=has_car&value=true", nil, &body)
+		if len(body.Annotations) != 1 || body.Annotations[0].User != "alice" {
+			t.Errorf("filtered = %+v", body.Annotations)
+		}
+		apiRequest(t, handler, "alice", http.MethodGet, "/api/v1/annotations", nil, &body)
+		for _, ann := range body.Annotations {
+			if ann.User != "alice" {
+				t.Errorf("annotators should only list their own: %+v", ann)
+			}
+		}
+		if rec := apiRequest(t, handler, "alice", http.MethodGet, "/api/v1/annotations?user=bob", nil, nil); rec.Code != http.StatusForbidden {
+			t.Errorf("listing others: status = %d, want 403", rec.Code)
+		}
+		for _, query := range []string{"cursor=bogus", "limit=0", "limit=100000"} {
+			if rec := apiRequest(t, handler, "rita", http.MethodGet, "/api/v1/annotations?"+query, nil, nil); rec.Code != http.StatusBadRequest || apiErrorCode(t, rec) != "bad_request" {
+				t.Errorf("%s: status = %d", query, rec.Code)
+			}
+		}
+	})
+
+	t.Run("image metadata", func(t *testing.T) {
+		var img APIImage
+		apiRequest(t, handler, "rita", http.MethodGet, "/api/v1/images/"+testImageHash(0), nil, &img)
+		if img.Filename != "0.png" || img.URL != "/asset/"+testImageHash(0) || len(img.Annotations) == 0 {
+			t.Errorf("image = %+v", img)
+		}
+		apiRequest(t, handler, "alice", http.MethodGet, "/api/v1/images/"+testImageHash(0), nil, &img)
+		for _, ann := range img.Annotations {
+			if ann.User != "alice" {
+				t.Errorf("annotators should only see their own: %+v", ann)
+			}
+		}
+		if rec := apiRequest(t, handler, "alice", http.MethodGet, "/api/v1/images/missing", nil, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("missing image: status = %d", rec.Code)
+		}
+	})
+
+	t.Run("export", func(t *testing.T) {
+		send := func(username, target string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.SetBasicAuth(username, "changeme")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}
+		rec := send("rita", "/api/v1/export?task=has_car")
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" || len(lines) != 4 {
+			t.Fatalf("jsonl: status = %d, %d lines", rec.Code, len(lines))
+		}
+		var ann APIAnnotation
+		if err := json.Unmarshal([]byte(lines[0]), &ann); err != nil || ann.Task != "has_car" {
+			t.Errorf("jsonl line = %q, %v", lines[0], err)
+		}
+
+		rec = send("bob", "/api/v1/export?format=csv")
+		rows, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil || rec.Header().Get("Content-Type") != "text/csv" || len(rows) != 4 || rows[0][0] != "id" {
+			t.Fatalf("csv: rows = %v, %v", rows, err)
+		}
+		for _, row := range rows[1:] {
+			if row[4] != "bob" {
+				t.Errorf("annotators should only export their own: %v", row)
+			}
+		}
+		if rec := send("rita", "/api/v1/export?format=xml"); rec.Code != http.StatusBadRequest {
+			t.Errorf("unknown format: status = %d", rec.Code)
+		}
+	})
+
+	t.Run("OpenAPI document is public", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+		var doc struct {
+			OpenAPI string
+			Paths   map[string]any
+		}
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &doc) != nil || doc.Paths["/api/v1/annotations"] == nil {
+			t.Errorf("status = %d, body = %.100s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("errors are JSON", func(t *testing.T) {
+		if rec := apiRequest(t, handler, "alice", http.MethodGet, "/api/v1/nothing", nil, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("unknown endpoint: status = %d", rec.Code)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized || apiErrorCode(t, rec) != "unauthorized" {
+			t.Errorf("unauthenticated: status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestAPIIngestion(t *testing.T) {
+	app := newTestAppWithUsers(t, map[string]string{"alice": "role: annotator"}, testConfigTasks, 0)
+	handler := app.GetHTTPHandler()
+	for idx := 0; idx < 2; idx++ {
+		var buf bytes.Buffer
+		png.Encode(&buf, image.NewGray(image.Rect(0, 0, idx+1, 1)))
+		if err := os.WriteFile(filepath.Join(app.ImagesDir, string(rune('a'+idx))+".png"), buf.Bytes(), 0644); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+	}
+
+	if rec := apiRequest(t, handler, "alice", http.MethodPost, "/api/v1/ingestion", nil, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("annotator: status = %d, want 403", rec.Code)
+	}
+	var status IngestionStatus
+	if rec := apiRequest(t, handler, "admin", http.MethodPost, "/api/v1/ingestion", nil, &status); rec.Code != http.StatusAccepted || status.StartedAt == nil {
+		t.Fatalf("start: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for status.Running && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		apiRequest(t, handler, "alice", http.MethodGet, "/api/v1/ingestion", nil, &status)
+	}
+	if status.Running || status.Error != "" || status.FinishedAt == nil {
+		t.Fatalf("ingestion = %+v", status)
+	}
+	var body struct{ Tasks []APITask }
+	apiRequest(t, handler, "alice", http.MethodGet, "/api/v1/tasks", nil, &body)
+	if body.Tasks[0].Progress.Total != 2 {
+		t.Errorf("progress after ingestion = %+v", body.Tasks[0].Progress)
+	}
+}
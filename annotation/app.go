@@ -7,13 +7,17 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
-	"sort"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"math/rand"
 
@@ -23,6 +27,8 @@ import (
 	"github.com/lewtec/rotulador/db/migrations"
 	"github.com/lewtec/rotulador/internal/domain"
 	"github.com/lewtec/rotulador/internal/repository"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type AnnotatorApp struct {
@@ -33,18 +39,76 @@ type AnnotatorApp struct {
 	i18n           map[string]string
 	imageRepo      *repository.ImageRepository
 	annotationRepo *repository.AnnotationRepository
+	eventRepo      *repository.AnnotationEventRepository
+	sessionRepo    *repository.SessionRepository
+	apiTokenRepo   *repository.APITokenRepository
+	webhookRepo    *repository.WebhookDeliveryRepository
+
+	// AuditLogger receives logins and annotation changes, the default logger is used when nil
+	AuditLogger *slog.Logger
+	// TracerProvider receives the spans of requests, queries and ingestion, the global one is used when nil
+	TracerProvider trace.TracerProvider
+	// BackgroundContext bounds the background work started by requests, such as
+	// ingestions run again by admins, context.Background() is used when nil
+	BackgroundContext context.Context
+	// BasePath is the URL path prefix the app is served under, such as
+	// /rotulador, see NormalizeBasePath. The app is served at / when empty.
+	BasePath string
+
+	// Key signing session cookies, see sessionKey
+	sessionKeyMutex sync.Mutex
+	sessionKeyValue []byte
+
+	// Failed logins, see AttemptLogin
+	throttle *loginThrottle
+
+	// Provider of single sign-on, discovered on first use
+	oidc oidcProvider
+
+	// Background image ingestion, see StartIngestion
+	ingestion ingestionTracker
+
+	// Goroutines of StartIngestion, StartWebhooks, StartViewPruning and scheduleCompletionCheck, see Wait
+	background sync.WaitGroup
+
+	// Subscribers of live events, see serveEvents
+	events eventBroker
+
+	// Answers waiting to be checked for completed tasks, see scheduleCompletionCheck
+	completion completionCheck
+
+	// Background delivery of webhook calls, see StartWebhooks
+	webhooks *webhookDispatcher
+
+	// Metrics served at /metrics, see serveMetrics
+	metrics *appMetrics
+
+	// Users introduced by the proxy header and missing from the config, see provisionedAuth
+	provisionedUsers sync.Map
 }
 
 func (a *AnnotatorApp) init() {
-	if a.ImagesDir[len(a.ImagesDir)-1] == '/' {
-		a.ImagesDir = a.ImagesDir[:len(a.ImagesDir)-1]
-	}
+	a.ImagesDir = strings.TrimSuffix(a.ImagesDir, "/")
 	if a.OffsetAdvance == 0 {
 		a.OffsetAdvance = 10
 	}
-	// Initialize repositories
-	a.imageRepo = repository.NewImageRepository(a.Database)
-	a.annotationRepo = repository.NewAnnotationRepository(a.Database)
+	if a.metrics == nil {
+		a.metrics = newAppMetrics()
+	}
+	// Initialize repositories, their queries measured in the metrics
+	db := a.timed(a.Database)
+	a.imageRepo = repository.NewImageRepository(db)
+	a.annotationRepo = repository.NewAnnotationRepository(db)
+	a.eventRepo = repository.NewAnnotationEventRepository(db)
+	a.sessionRepo = repository.NewSessionRepository(db)
+	a.apiTokenRepo = repository.NewAPITokenRepository(db)
+	a.webhookRepo = repository.NewWebhookDeliveryRepository(db)
+	if a.throttle == nil {
+		a.throttle = newLoginThrottle()
+	}
+	if a.webhooks == nil {
+		a.webhooks = newWebhookDispatcher()
+	}
 }
 
 func stringOr(str, or string) string {
@@ -81,15 +145,20 @@ type TaskWithCount struct {
 }
 
 type PhaseProgress struct {
-	Completed              int     // Images completed in this phase
-	Pending                int     // Images eligible but not yet annotated
-	FilteredWrongClass     int     // Images annotated in dependency phase but with wrong class
-	NotYetAnnotated        int     // Images not yet annotated in dependency phase
-	Total                  int     // Total images in the entire dataset
-	CompletedPercent       float64 // Percentage of completed images
-	PendingPercent         float64 // Percentage of pending images
-	FilteredPercent        float64 // Percentage of filtered (wrong class) images
-	NotYetAnnotatedPercent float64 // Percentage of not yet annotated images
+	Completed              int     `json:"completed"`                 // Images completed in this phase
+	Pending                int     `json:"pending"`                   // Images eligible but not yet annotated
+	FilteredWrongClass     int     `json:"filtered_wrong_class"`      // Images annotated in dependency phase but with wrong class
+	NotYetAnnotated        int     `json:"not_yet_annotated"`         // Images not yet annotated in dependency phase
+	Total                  int     `json:"total"`                     // Total images in the entire dataset
+	CompletedPercent       float64 `json:"completed_percent"`         // Percentage of completed images
+	PendingPercent         float64 `json:"pending_percent"`           // Percentage of pending images
+	FilteredPercent        float64 `json:"filtered_percent"`          // Percentage of filtered (wrong class) images
+	NotYetAnnotatedPercent float64 `json:"not_yet_annotated_percent"` // Percentage of not yet annotated images
+}
+
+// isCompleted tells whether every image of the task is annotated, or filtered out by its dependencies
+func (p *PhaseProgress) isCompleted() bool {
+	return p.Total > 0 && p.Pending == 0 && p.NotYetAnnotated == 0
 }
 
 // getCachedImageList returns the list of all images, using cache if available
@@ -279,7 +348,10 @@ func (a *AnnotatorApp) CountAvailableImages(ctx context.Context, taskID string)
 }
 
 // GetPhaseProgressStats calculates comprehensive progress statistics for a task
-func (a *AnnotatorApp) GetPhaseProgressStats(ctx context.Context, taskID string) (*PhaseProgress, error) {
+func (a *AnnotatorApp) GetPhaseProgressStats(ctx context.Context, taskID string) (_ *PhaseProgress, err error) {
+	ctx, span := a.tracer().Start(ctx, "GetPhaseProgressStats", trace.WithAttributes(attribute.String("task", taskID)))
+	defer func() { endSpan(span, err) }()
+
 	// Get total images in the entire dataset
 	totalCount, err := a.imageRepo.Count(ctx)
 	if err != nil {
@@ -436,11 +508,16 @@ func (a *AnnotatorApp) GetPhaseProgressStats(ctx context.Context, taskID string)
 	}, nil
 }
 
-func (a *AnnotatorApp) NextAnnotationStep(ctx context.Context, taskID string) (*AnnotationStep, error) {
+// NextAnnotationStep picks an image to annotate in the task, or in the first task
+// with pending images when taskID is empty. A nil user means no restrictions.
+func (a *AnnotatorApp) NextAnnotationStep(ctx context.Context, user *RequestUser, taskID string) (*AnnotationStep, error) {
 	// If no task specified, try each task in order
 	if taskID == "" {
 		for _, task := range a.Config.Tasks {
-			step, err := a.NextAnnotationStep(ctx, task.ID)
+			if user != nil && !user.CanAnnotateTask(task.ID) {
+				continue
+			}
+			step, err := a.NextAnnotationStep(ctx, user, task.ID)
 			if err != nil {
 				return nil, err
 			}
@@ -463,7 +540,101 @@ func (a *AnnotatorApp) NextAnnotationStep(ctx context.Context, taskID string) (*
 	if stageIndex == -1 {
 		return nil, fmt.Errorf("task not found: %s", taskID)
 	}
+	// Users only get the tasks they may work on
+	if user != nil && !user.CanAnnotateTask(taskID) {
+		return nil, nil
+	}
+
+	candidateImages, err := a.listCandidateImages(ctx, stageIndex, a.OffsetAdvance)
+	if err != nil {
+		return nil, err
+	}
+
+	// No images available
+	if len(candidateImages) == 0 {
+		return nil, nil
+	}
+
+	// Randomly select one image
+	selectedImage := candidateImages[rand.Intn(len(candidateImages))]
+
+	return &AnnotationStep{
+		TaskID:    taskID,
+		ImageID:   selectedImage.SHA256,
+		ImageName: selectedImage.Filename,
+	}, nil
+}
+
+// NextAnnotationBatch returns up to size images that are eligible for the task,
+// picked at random among the first gridCandidatePages pages of candidates so
+// that annotators working on the grid at the same time get different images
+// and not yet annotated, in listing order. Used by the grid mode.
+func (a *AnnotatorApp) NextAnnotationBatch(ctx context.Context, taskID string, size int) ([]*AnnotationStep, error) {
+	stageIndex := a.taskStageIndex(taskID)
+	if stageIndex == -1 {
+		return nil, fmt.Errorf("task not found: %s", taskID)
+	}
+
+	candidateImages, err := a.listCandidateImages(ctx, stageIndex, size*gridCandidatePages)
+	if err != nil {
+		return nil, err
+	}
+	rand.Shuffle(len(candidateImages), func(i, j int) {
+		candidateImages[i], candidateImages[j] = candidateImages[j], candidateImages[i]
+	})
+	candidateImages = candidateImages[:min(size, len(candidateImages))]
+
+	steps := make([]*AnnotationStep, len(candidateImages))
+	for idx, img := range candidateImages {
+		steps[idx] = &AnnotationStep{
+			TaskID:    taskID,
+			ImageID:   img.SHA256,
+			ImageName: img.Filename,
+		}
+	}
+	return steps, nil
+}
+
+// isEligibleImage tells whether an image may be answered in a stage, as when it
+// was listed by listCandidateImages: it passes the dependencies of the task and
+// nobody has answered it yet
+func (a *AnnotatorApp) isEligibleImage(ctx context.Context, stageIndex int, imageSHA256 string) (bool, error) {
+	annotations, err := a.annotationRepo.GetForImage(ctx, imageSHA256)
+	if err != nil {
+		return false, fmt.Errorf("while getting annotations: %w", err)
+	}
+	for _, ann := range annotations {
+		if ann.StageIndex == stageIndex {
+			return false, nil
+		}
+	}
+	for depTaskID, requiredValue := range a.Config.Tasks[stageIndex].If {
+		depStageIndex := a.taskStageIndex(depTaskID)
+		if depStageIndex == -1 {
+			continue
+		}
+		if !slices.ContainsFunc(annotations, func(ann *domain.Annotation) bool {
+			return ann.StageIndex == depStageIndex && ann.OptionValue == requiredValue
+		}) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// taskStageIndex returns the position of the task in the config, or -1 if it does not exist
+func (a *AnnotatorApp) taskStageIndex(taskID string) int {
+	for i, task := range a.Config.Tasks {
+		if task.ID == taskID {
+			return i
+		}
+	}
+	return -1
+}
 
+// listCandidateImages returns up to limit images that pass the task dependencies
+// and don't have an annotation for the stage yet
+func (a *AnnotatorApp) listCandidateImages(ctx context.Context, stageIndex int, limit int) ([]*domain.Image, error) {
 	task := a.Config.Tasks[stageIndex]
 
 	// Pre-fetch all dependency data before looping (optimization: move queries outside loop)
@@ -471,13 +642,7 @@ func (a *AnnotatorApp) NextAnnotationStep(ctx context.Context, taskID string) (*
 	if len(task.If) > 0 {
 		for depTaskID, requiredValue := range task.If {
 			// Find the stage index for the dependency task
-			depStageIndex := -1
-			for i, t := range a.Config.Tasks {
-				if t.ID == depTaskID {
-					depStageIndex = i
-					break
-				}
-			}
+			depStageIndex := a.taskStageIndex(depTaskID)
 			if depStageIndex == -1 {
 				continue
 			}
@@ -504,7 +669,7 @@ func (a *AnnotatorApp) NextAnnotationStep(ctx context.Context, taskID string) (*
 	}
 
 	// Filter images based on dependencies and annotation status
-	var candidateImages []string
+	var candidateImages []*domain.Image
 	for _, img := range allImages {
 		// Check if image already has annotation for this stage
 		hasAnnotation, err := a.annotationRepo.CheckAnnotationExists(ctx, img.SHA256, "", int64(stageIndex))
@@ -527,33 +692,15 @@ func (a *AnnotatorApp) NextAnnotationStep(ctx context.Context, taskID string) (*
 		}
 
 		if valid {
-			candidateImages = append(candidateImages, img.SHA256)
-			// Limit candidates to OffsetAdvance for performance
-			if len(candidateImages) >= a.OffsetAdvance {
+			candidateImages = append(candidateImages, img)
+			// Limit candidates for performance
+			if len(candidateImages) >= limit {
 				break
 			}
 		}
 	}
 
-	// No images available
-	if len(candidateImages) == 0 {
-		return nil, nil
-	}
-
-	// Randomly select one image SHA256
-	selectedSHA256 := candidateImages[rand.Intn(len(candidateImages))]
-
-	// Get image details
-	selectedImage, err := a.imageRepo.GetBySHA256(ctx, selectedSHA256)
-	if err != nil {
-		return nil, fmt.Errorf("while getting image details: %w", err)
-	}
-
-	return &AnnotationStep{
-		TaskID:    taskID,
-		ImageID:   selectedSHA256,
-		ImageName: selectedImage.Filename,
-	}, nil
+	return candidateImages, nil
 }
 
 func (a *AnnotatorApp) GetImageFilename(ctx context.Context, sha256 string) (filename string, err error) {
@@ -565,40 +712,356 @@ func (a *AnnotatorApp) GetImageFilename(ctx context.Context, sha256 string) (fil
 		}
 		return "", err
 	}
+	if img == nil {
+		return "", fmt.Errorf("image not found: %s", sha256)
+	}
 
 	return img.Filename, nil
 }
 
 type AnnotationResponse struct {
-	ImageID string
-	TaskID  string
-	User    string
-	Value   string
-	Sure    bool
+	ImageID  string
+	TaskID   string
+	User     string
+	Value    string
+	Sure     bool
+	ClientIP string // Recorded in the audit log
 }
 
 func (a *AnnotatorApp) SubmitAnnotation(ctx context.Context, annotation AnnotationResponse) error {
-	// Find stage index for this task
-	stageIndex := -1
-	for i, task := range a.Config.Tasks {
-		if task.ID == annotation.TaskID {
-			stageIndex = i
-			break
+	return a.SubmitAnnotationBatch(ctx, []AnnotationResponse{annotation})
+}
+
+// SubmitAnnotationBatch stores several annotations in a single transaction.
+// Either all of them are saved or none is.
+func (a *AnnotatorApp) SubmitAnnotationBatch(ctx context.Context, annotations []AnnotationResponse) error {
+	answeredAt := time.Now()
+	err := a.withAnnotationTx(ctx, func(w *annotationWriter) error {
+		var timed []timedAnnotation
+		for _, annotation := range annotations {
+			stageIndex := a.taskStageIndex(annotation.TaskID)
+			if stageIndex == -1 {
+				return fmt.Errorf("no such task: %s", annotation.TaskID)
+			}
+			previous, err := w.annotations.Get(ctx, annotation.ImageID, annotation.User, stageIndex)
+			if err != nil {
+				return fmt.Errorf("while getting previous annotation for image %s: %w", annotation.ImageID, err)
+			}
+			// ImageID is already the SHA256 hash, use it directly
+			created, err := w.annotations.Create(ctx, annotation.ImageID, annotation.User, stageIndex, annotation.Value)
+			if err != nil {
+				return fmt.Errorf("while creating annotation for image %s: %w", annotation.ImageID, err)
+			}
+			servedAt, err := w.annotations.TakeView(ctx, annotation.ImageID, annotation.User, stageIndex)
+			if err != nil {
+				return fmt.Errorf("while getting view of image %s: %w", annotation.ImageID, err)
+			}
+			if servedAt != nil {
+				timed = append(timed, timedAnnotation{id: created.ID, servedAt: *servedAt})
+			}
+
+			event := domain.AnnotationEvent{
+				Type:        domain.AnnotationEventCreate,
+				ImageSHA256: annotation.ImageID,
+				Username:    annotation.User,
+				StageIndex:  stageIndex,
+				NewValue:    &annotation.Value,
+				Actor:       annotation.User,
+				ClientIP:    annotation.ClientIP,
+			}
+			if previous != nil {
+				event.Type = domain.AnnotationEventUpdate
+				event.OldValue = &previous.OptionValue
+			}
+			if err := w.record(ctx, event); err != nil {
+				return err
+			}
+
+			if previous != nil && previous.OptionValue != annotation.Value {
+				if err := a.flagDependentAnnotations(ctx, w, event); err != nil {
+					return fmt.Errorf("while flagging dependent annotations: %w", err)
+				}
+			}
+			if err := a.unflagDependentAnnotations(ctx, w, event); err != nil {
+				return fmt.Errorf("while clearing flags of dependent annotations: %w", err)
+			}
+		}
+
+		// Images served together (a grid page) split the time spent on them
+		servedTogether := make(map[int64]int, len(timed))
+		for _, t := range timed {
+			servedTogether[t.servedAt.UnixNano()]++
+		}
+		for _, t := range timed {
+			duration := answeredAt.Sub(t.servedAt) / time.Duration(servedTogether[t.servedAt.UnixNano()])
+			if err := w.annotations.SetTiming(ctx, t.id, t.servedAt, max(duration, 0)); err != nil {
+				return fmt.Errorf("while storing annotation timing: %w", err)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, annotation := range annotations {
+		a.metrics.annotations.WithLabelValues(annotation.TaskID, annotation.User).Inc()
 	}
+	a.publishAnnotations(ctx, annotations)
+	return nil
+}
+
+// timedAnnotation is an annotation answered after its image was served
+type timedAnnotation struct {
+	id       int64
+	servedAt time.Time
+}
+
+// RecordViews stores that images were just shown to a user for a task, so the
+// time until the answer arrives can be measured
+func (a *AnnotatorApp) RecordViews(ctx context.Context, taskID, user string, imageIDs ...string) error {
+	stageIndex := a.taskStageIndex(taskID)
 	if stageIndex == -1 {
-		return fmt.Errorf("no such task: %s", annotation.TaskID)
+		return fmt.Errorf("no such task: %s", taskID)
+	}
+	servedAt := time.Now()
+	for _, imageID := range imageIDs {
+		if err := a.annotationRepo.RecordView(ctx, imageID, user, stageIndex, servedAt); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+const (
+	// viewRetention is how long a view waits for its answer. Views older than the
+	// default window of the stats page are pruned, as the images were skipped.
+	viewRetention = statsDefaultDays * 24 * time.Hour
+	// viewPruneInterval is how often StartViewPruning prunes the views
+	viewPruneInterval = 24 * time.Hour
+)
+
+// pruneViews deletes the views of images served before the viewRetention that were never answered
+func (a *AnnotatorApp) pruneViews(ctx context.Context, now time.Time) {
+	pruned, err := a.annotationRepo.PruneViews(ctx, now.Add(-viewRetention))
+	if err != nil {
+		slog.WarnContext(ctx, "stats: pruning unanswered views", "error", err)
+		return
+	}
+	if pruned > 0 {
+		slog.InfoContext(ctx, "stats: pruned unanswered views", "views", pruned)
+	}
+}
+
+// StartViewPruning prunes the unanswered views every viewPruneInterval in the
+// background until ctx is done, besides the pruning of every ingestion
+func (a *AnnotatorApp) StartViewPruning(ctx context.Context) {
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		ticker := time.NewTicker(viewPruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				a.pruneViews(ctx, now)
+			}
+		}
+	}()
+}
+
+// annotationWriter groups the repositories used to change annotations inside a transaction
+type annotationWriter struct {
+	annotations   *repository.AnnotationRepository
+	events        *repository.AnnotationEventRepository
+	configVersion string
+	// recorded events, sent to the audit stream once committed
+	recorded []domain.AnnotationEvent
+}
 
-	// ImageID is already the SHA256 hash, use it directly
-	_, err := a.annotationRepo.Create(ctx, annotation.ImageID, annotation.User, stageIndex, annotation.Value)
+// record appends an event to the audit log, stamping the config version
+func (w *annotationWriter) record(ctx context.Context, event domain.AnnotationEvent) error {
+	event.ConfigVersion = w.configVersion
+	if _, err := w.events.Create(ctx, event); err != nil {
+		return fmt.Errorf("while recording %s event: %w", event.Type, err)
+	}
+	w.recorded = append(w.recorded, event)
+	return nil
+}
+
+// withAnnotationTx runs fn in a transaction, committing only if it succeeds
+func (a *AnnotatorApp) withAnnotationTx(ctx context.Context, fn func(w *annotationWriter) error) error {
+	tx, err := a.Database.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("while creating annotation: %w", err)
+		return fmt.Errorf("while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	w := &annotationWriter{
+		annotations: repository.NewAnnotationRepositoryWithTx(a.timed(tx)),
+		events:      repository.NewAnnotationEventRepositoryWithTx(a.timed(tx)),
+	}
+	if a.Config != nil {
+		w.configVersion = a.Config.Version
+	}
+	if err := fn(w); err != nil {
+		return err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("while committing annotations: %w", err)
+	}
+	for _, event := range w.recorded {
+		a.audit(ctx, "annotation "+event.Type,
+			"image", event.ImageSHA256,
+			"user", event.Username,
+			"task", a.StageName(event.StageIndex),
+			"old", auditValue(event.OldValue),
+			"new", auditValue(event.NewValue),
+			"actor", event.Actor,
+			"client_ip", event.ClientIP,
+		)
+	}
 	return nil
 }
 
+// flagDependentAnnotations flags the annotations of the image in tasks whose
+// `if` requirement is no longer met after the change described by cause.
+// Tasks depending on a flagged task are flagged as well.
+func (a *AnnotatorApp) flagDependentAnnotations(ctx context.Context, w *annotationWriter, cause domain.AnnotationEvent) error {
+	changedTaskID := a.Config.Tasks[cause.StageIndex].ID
+	visited := map[string]bool{changedTaskID: true}
+	var flagDependents func(taskID string, newValue *string) error
+	flagDependents = func(taskID string, newValue *string) error {
+		for stageIndex, task := range a.Config.Tasks {
+			requiredValue, ok := task.If[taskID]
+			if !ok || visited[task.ID] || (newValue != nil && requiredValue == *newValue) {
+				continue
+			}
+			var reason string
+			if newValue != nil {
+				reason = fmt.Sprintf("%s changed to %q, but this task requires %q", taskID, *newValue, requiredValue)
+			} else {
+				reason = fmt.Sprintf("%s was flagged", taskID)
+			}
+			existing, err := w.annotations.GetForImage(ctx, cause.ImageSHA256)
+			if err != nil {
+				return err
+			}
+			if !slices.ContainsFunc(existing, func(ann *domain.Annotation) bool { return ann.StageIndex == stageIndex }) {
+				continue
+			}
+			flagged, err := w.annotations.FlagForImageStage(ctx, cause.ImageSHA256, stageIndex, reason)
+			if err != nil {
+				return err
+			}
+			// Annotations flagged already keep their flag, and its time
+			for _, ann := range existing {
+				if ann.StageIndex != stageIndex || ann.FlaggedAt != nil {
+					continue
+				}
+				err := w.record(ctx, domain.AnnotationEvent{
+					Type:        domain.AnnotationEventFlag,
+					ImageSHA256: ann.ImageSHA256,
+					Username:    ann.Username,
+					StageIndex:  stageIndex,
+					OldValue:    &ann.OptionValue,
+					NewValue:    &ann.OptionValue,
+					Actor:       cause.Actor,
+					ClientIP:    cause.ClientIP,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			visited[task.ID] = true
+			if flagged > 0 {
+				slog.InfoContext(ctx, "flagged dependent annotations", "count", flagged, "image", cause.ImageSHA256, "task", task.ID, "reason", reason)
+			}
+			// Anything depending on the flagged task is suspicious too
+			if err := flagDependents(task.ID, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return flagDependents(changedTaskID, cause.NewValue)
+}
+
+// unflagDependentAnnotations clears the flags of the annotations of the image
+// in tasks whose `if` requirements are met again after the change described
+// by cause, counting only answers that are not flagged themselves. Tasks
+// depending on a cleared task are cleared as well.
+func (a *AnnotatorApp) unflagDependentAnnotations(ctx context.Context, w *annotationWriter, cause domain.AnnotationEvent) error {
+	if cause.NewValue == nil {
+		return nil
+	}
+	changedTaskID := a.Config.Tasks[cause.StageIndex].ID
+	visited := map[string]bool{changedTaskID: true}
+	var unflagDependents func(taskID string, newValue *string) error
+	unflagDependents = func(taskID string, newValue *string) error {
+		for stageIndex, task := range a.Config.Tasks {
+			requiredValue, ok := task.If[taskID]
+			if !ok || visited[task.ID] || (newValue != nil && requiredValue != *newValue) {
+				continue
+			}
+			existing, err := w.annotations.GetForImage(ctx, cause.ImageSHA256)
+			if err != nil {
+				return err
+			}
+			eligible := true
+			for depTaskID, depValue := range task.If {
+				depStageIndex := a.taskStageIndex(depTaskID)
+				if depStageIndex == -1 {
+					continue
+				}
+				if !slices.ContainsFunc(existing, func(ann *domain.Annotation) bool {
+					return ann.StageIndex == depStageIndex && ann.OptionValue == depValue && ann.FlaggedAt == nil
+				}) {
+					eligible = false
+				}
+			}
+			if !eligible {
+				continue
+			}
+			unflagged, err := w.annotations.UnflagForImageStage(ctx, cause.ImageSHA256, stageIndex)
+			if err != nil {
+				return err
+			}
+			if unflagged == 0 {
+				continue
+			}
+			for _, ann := range existing {
+				if ann.StageIndex != stageIndex || ann.FlaggedAt == nil {
+					continue
+				}
+				err := w.record(ctx, domain.AnnotationEvent{
+					Type:        domain.AnnotationEventUnflag,
+					ImageSHA256: ann.ImageSHA256,
+					Username:    ann.Username,
+					StageIndex:  stageIndex,
+					OldValue:    &ann.OptionValue,
+					NewValue:    &ann.OptionValue,
+					Actor:       cause.Actor,
+					ClientIP:    cause.ClientIP,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			visited[task.ID] = true
+			slog.InfoContext(ctx, "cleared flags of dependent annotations", "count", unflagged, "image", cause.ImageSHA256, "task", task.ID)
+			// Tasks flagged because this one was can be cleared too
+			if err := unflagDependents(task.ID, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return unflagDependents(changedTaskID, cause.NewValue)
+}
+
 func (a *AnnotatorApp) GetTask(taskID string) *ConfigTask {
 	for _, currentTask := range a.Config.Tasks {
 		if currentTask.ID == taskID {
@@ -608,11 +1071,34 @@ func (a *AnnotatorApp) GetTask(taskID string) *ConfigTask {
 	return nil
 }
 
+const (
+	// gridDefaultPageSize is how many thumbnails a grid page shows by default
+	gridDefaultPageSize = 30
+	// gridMaxPageSize caps the thumbnails per grid page and per submission
+	gridMaxPageSize = 50
+	// gridCandidatePages is how many pages of candidates a grid page is picked from
+	gridCandidatePages = 4
+)
+
+// historyPageSize is how many annotations the history page lists at once
+const historyPageSize = 50
+
+// statsDefaultDays is how far back the stats page looks by default
+const statsDefaultDays = 30
+
+// HistoryEntry is an annotation listed in the history page
+type HistoryEntry struct {
+	*domain.AnnotationWithImage
+	Task *ConfigTask
+}
+
 // ClassButton represents a class button with keyboard shortcut
 type ClassButton struct {
-	ID   string
-	Name string
-	Key  string
+	ID    string
+	Name  string
+	Key   string
+	Color string
+	Icon  string
 }
 
 func (a *AnnotatorApp) GetHTTPHandler() http.Handler {
@@ -634,7 +1120,7 @@ func (a *AnnotatorApp) GetHTTPHandler() http.Handler {
 
 		err := RenderPageWithRequest(r, w, "home.html", data)
 		if err != nil {
-			log.Printf("error rendering home template: %s", err)
+			slog.ErrorContext(r.Context(), "rendering home template", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 	})
@@ -646,42 +1132,165 @@ func (a *AnnotatorApp) GetHTTPHandler() http.Handler {
 		w.Write([]byte(GetFavicon()))
 	})
 
-	// Help pages
-	mux.HandleFunc("/help/", func(w http.ResponseWriter, r *http.Request) {
-		itemPath := pathParts(r.URL.Path)
-		title := "Help"
-
-		var tasks []TaskWithCount = nil
-		var currentTask *ConfigTask = nil
-
-		if len(itemPath) == 1 {
-			// Only populate tasks for the timeline view (no markdown for tasks)
-			tasks = make([]TaskWithCount, 0, len(a.Config.Tasks))
-
-			for _, task := range a.Config.Tasks {
-				availableCount, err := a.CountAvailableImages(r.Context(), task.ID)
-				if err != nil {
-					log.Printf("error counting available images for task %s: %s", task.ID, err)
-					availableCount = 0
-				}
+	// Login page, starts a session stored in a cookie
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		next := safeRedirect(r.FormValue("next"))
+		data := map[string]interface{}{
+			"Title":         "Log in",
+			"Next":          next,
+			"PasswordLogin": a.hasPasswords(),
+		}
+		if a.Config.OIDC != nil {
+			data["OIDCName"] = a.Config.OIDC.Name
+		}
 
-				totalEligible, err := a.CountEligibleImages(r.Context(), task.ID)
+		switch r.Method {
+		case http.MethodGet:
+			if user := GetUserFromContext(r.Context()); user != nil && user.Session {
+				http.Redirect(w, r, next, http.StatusSeeOther)
+				return
+			}
+		case http.MethodPost:
+			username := strings.TrimSpace(r.FormValue("username"))
+			auth, wait := a.AttemptLogin(r, username, r.FormValue("password"))
+			if auth != nil {
+				cookie, err := a.Login(r.Context(), username, "", clientIP(r), r.TLS != nil)
 				if err != nil {
-					log.Printf("error counting eligible images for task %s: %s", task.ID, err)
-					totalEligible = availableCount // fallback to available
-				}
-
-				completedCount := totalEligible - availableCount
-				if completedCount < 0 {
-					completedCount = 0
+					slog.ErrorContext(r.Context(), "starting a session", "user", username, "error", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
 				}
+				http.SetCookie(w, cookie)
+				http.Redirect(w, r, next, http.StatusSeeOther)
+				return
+			}
+			data["Username"] = username
+			if wait > 0 {
+				data["Error"] = "Too many failed attempts, try again later"
+				retryAfter(w, wait)
+				w.WriteHeader(http.StatusTooManyRequests)
+				break
+			}
+			data["Error"] = "Wrong username or password"
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
 
-				// Get comprehensive phase progress stats
-				phaseProgress, err := a.GetPhaseProgressStats(r.Context(), task.ID)
-				if err != nil {
-					log.Printf("error getting phase progress for task %s: %s", task.ID, err)
-					phaseProgress = &PhaseProgress{}
-				}
+		renderLoginPage(w, r, data)
+	})
+
+	// Single sign-on, sends the user to the provider and starts a session when they come back
+	mux.HandleFunc("/oidc/", func(w http.ResponseWriter, r *http.Request) {
+		if a.Config.OIDC == nil {
+			http.NotFoundHandler().ServeHTTP(w, r)
+			return
+		}
+		switch r.URL.Path {
+		case "/oidc/login":
+			authURL, cookie, err := a.StartOIDCLogin(r, r.FormValue("next"))
+			if err != nil {
+				slog.ErrorContext(r.Context(), "oidc", "error", err)
+				w.WriteHeader(http.StatusBadGateway)
+				renderLoginPage(w, r, map[string]interface{}{
+					"Title": "Log in",
+					"Next":  safeRedirect(r.FormValue("next")),
+					"Error": "The identity provider is unavailable",
+				})
+				return
+			}
+			http.SetCookie(w, cookie)
+			http.Redirect(w, r, authURL, http.StatusSeeOther)
+		case oidcCallbackPath:
+			http.SetCookie(w, &http.Cookie{Name: oidcCookieName, Path: a.url("/oidc/"), MaxAge: -1})
+			username, role, next, err := a.FinishOIDCLogin(r)
+			if err != nil {
+				data := map[string]interface{}{
+					"Title":         "Log in",
+					"Next":          next,
+					"PasswordLogin": a.hasPasswords(),
+					"OIDCName":      a.Config.OIDC.Name,
+					"Error":         "Single sign-on failed",
+				}
+				if errors.Is(err, errOIDCNoRole) {
+					data["Error"] = "Your account has no access to this project"
+					w.WriteHeader(http.StatusForbidden)
+				} else {
+					slog.ErrorContext(r.Context(), "oidc", "error", err)
+					w.WriteHeader(http.StatusUnauthorized)
+				}
+				renderLoginPage(w, r, data)
+				return
+			}
+			cookie, err := a.Login(r.Context(), username, role, clientIP(r), r.TLS != nil)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "starting a session", "user", username, "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			http.SetCookie(w, cookie)
+			http.Redirect(w, r, next, http.StatusSeeOther)
+		default:
+			http.NotFoundHandler().ServeHTTP(w, r)
+		}
+	})
+
+	// Logout ends the session of the login page
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		cookie, err := a.Logout(r)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "ending a session", "error", err)
+		}
+		http.SetCookie(w, cookie)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	})
+
+	// Help pages
+	mux.HandleFunc("/help/", func(w http.ResponseWriter, r *http.Request) {
+		itemPath := pathParts(r.URL.Path)
+		title := "Help"
+
+		var tasks []TaskWithCount = nil
+		var currentTask *ConfigTask = nil
+		user := requestUser(r)
+
+		if len(itemPath) == 1 {
+			// Only populate tasks for the timeline view (no markdown for tasks)
+			tasks = make([]TaskWithCount, 0, len(a.Config.Tasks))
+
+			for _, task := range a.Config.Tasks {
+				// Tasks the user may not see are hidden from the timeline
+				if !user.CanSeeTask(task.ID) {
+					continue
+				}
+				availableCount, err := a.CountAvailableImages(r.Context(), task.ID)
+				if err != nil {
+					slog.ErrorContext(r.Context(), "counting available images", "task", task.ID, "error", err)
+					availableCount = 0
+				}
+
+				totalEligible, err := a.CountEligibleImages(r.Context(), task.ID)
+				if err != nil {
+					slog.ErrorContext(r.Context(), "counting eligible images", "task", task.ID, "error", err)
+					totalEligible = availableCount // fallback to available
+				}
+
+				completedCount := totalEligible - availableCount
+				if completedCount < 0 {
+					completedCount = 0
+				}
+
+				// Get comprehensive phase progress stats
+				phaseProgress, err := a.GetPhaseProgressStats(r.Context(), task.ID)
+				if err != nil {
+					slog.ErrorContext(r.Context(), "getting phase progress", "task", task.ID, "error", err)
+					phaseProgress = &PhaseProgress{}
+				}
 
 				tasks = append(tasks, TaskWithCount{
 					ConfigTask:     task,
@@ -694,7 +1303,7 @@ func (a *AnnotatorApp) GetHTTPHandler() http.Handler {
 		} else if len(itemPath) == 2 {
 			helpTask := itemPath[1]
 			task := a.GetTask(helpTask)
-			if task == nil {
+			if task == nil || !user.CanSeeTask(helpTask) {
 				http.NotFoundHandler().ServeHTTP(w, r)
 				return
 			}
@@ -703,14 +1312,14 @@ func (a *AnnotatorApp) GetHTTPHandler() http.Handler {
 			// Get progress stats for this specific task
 			phaseProgress, err := a.GetPhaseProgressStats(r.Context(), helpTask)
 			if err != nil {
-				log.Printf("error getting phase progress for task %s: %s", helpTask, err)
+				slog.ErrorContext(r.Context(), "getting phase progress", "task", helpTask, "error", err)
 				phaseProgress = &PhaseProgress{}
 			}
 
 			// Get available count to check if there are images to annotate
 			availableCount, err := a.CountAvailableImages(r.Context(), helpTask)
 			if err != nil {
-				log.Printf("error counting available images for task %s: %s", helpTask, err)
+				slog.ErrorContext(r.Context(), "counting available images", "task", helpTask, "error", err)
 				availableCount = 0
 			}
 
@@ -737,20 +1346,31 @@ func (a *AnnotatorApp) GetHTTPHandler() http.Handler {
 
 		err := RenderPageWithRequest(r, w, "help.html", data)
 		if err != nil {
-			log.Printf("error rendering help template: %s", err)
+			slog.ErrorContext(r.Context(), "rendering help template", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 	})
 
+	// Live progress and activity, as Server-Sent Events
+	mux.HandleFunc("/events", a.serveEvents)
+
+	// JSON API for scripts and notebooks
+	mux.Handle("/api/", a.apiHandler())
+
 	// Annotate pages
 	mux.HandleFunc("/annotate/", func(w http.ResponseWriter, r *http.Request) {
 		itemPath := pathParts(r.URL.Path)
+		user := requestUser(r)
+		if !user.CanAnnotate() {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 
 		if len(itemPath) != 3 {
 			taskID := r.URL.Query().Get("task")
-			step, err := a.NextAnnotationStep(r.Context(), taskID)
+			step, err := a.NextAnnotationStep(r.Context(), user, taskID)
 			if err != nil {
-				log.Printf("error in annotate when getting next step from scratch: %s", err)
+				slog.ErrorContext(r.Context(), "getting next step from scratch", "error", err)
 				w.WriteHeader(500)
 				return
 			}
@@ -760,7 +1380,7 @@ func (a *AnnotatorApp) GetHTTPHandler() http.Handler {
 				}
 				err := RenderPageWithRequest(r, w, "complete.html", data)
 				if err != nil {
-					log.Printf("error rendering complete template: %s", err)
+					slog.ErrorContext(r.Context(), "rendering complete template", "error", err)
 				}
 				return
 			}
@@ -775,10 +1395,13 @@ func (a *AnnotatorApp) GetHTTPHandler() http.Handler {
 			http.NotFoundHandler().ServeHTTP(w, r)
 			return
 		}
+		if !user.CanAnnotateTask(taskID) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 		imageFilename, _ := a.GetImageFilename(r.Context(), imageID)
 
 		if r.Method == http.MethodPost {
-			log.Printf("POST")
 			r.ParseForm()
 			if !(r.Form.Has("selectedClass") && r.Form.Has("sure")) {
 				w.WriteHeader(http.StatusBadRequest)
@@ -786,32 +1409,31 @@ func (a *AnnotatorApp) GetHTTPHandler() http.Handler {
 			}
 			selectedClass := r.FormValue("selectedClass")
 			_, isClassValid := task.Classes[selectedClass]
-			log.Printf("Selected class: %s empty=%v valid=%v", selectedClass, selectedClass == "", isClassValid)
 			sure := r.FormValue("sure") == "on"
-			log.Printf("Sure: %v", sure)
-			user, _, _ := r.BasicAuth()
+			slog.DebugContext(r.Context(), "annotate: answer received", "task", taskID, "image", imageID, "class", selectedClass, "valid", isClassValid, "sure", sure)
 			err := a.SubmitAnnotation(r.Context(), AnnotationResponse{
-				ImageID: imageID,
-				TaskID:  taskID,
-				User:    user,
-				Value:   selectedClass,
-				Sure:    sure,
+				ImageID:  imageID,
+				TaskID:   taskID,
+				User:     user.Name,
+				Value:    selectedClass,
+				Sure:     sure,
+				ClientIP: clientIP(r),
 			})
 			if err != nil {
-				log.Printf("error while submitting annotation: %s", err)
+				slog.ErrorContext(r.Context(), "submitting annotation", "error", err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-			step, err := a.NextAnnotationStep(r.Context(), taskID)
+			step, err := a.NextAnnotationStep(r.Context(), user, taskID)
 			if err != nil {
-				log.Printf("error while getting next step: %s", err)
+				slog.ErrorContext(r.Context(), "getting next step", "error", err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
 			if step == nil {
-				step, err = a.NextAnnotationStep(r.Context(), "")
+				step, err = a.NextAnnotationStep(r.Context(), user, "")
 				if err != nil {
-					log.Printf("error while getting next step at the end of task: %s", err)
+					slog.ErrorContext(r.Context(), "getting next step at the end of task", "error", err)
 					w.WriteHeader(http.StatusInternalServerError)
 					return
 				}
@@ -826,37 +1448,39 @@ func (a *AnnotatorApp) GetHTTPHandler() http.Handler {
 			return
 		}
 
-		// Build classes with keyboard shortcuts
-		classNames := make([]string, 0, len(task.Classes))
-		for class := range task.Classes {
-			classNames = append(classNames, class)
-		}
-		sort.Sort(sort.StringSlice(classNames))
-
+		// Build classes with keyboard shortcuts, in declaration order
 		classes := []ClassButton{}
-		keyIndex := 1
-		for _, className := range classNames {
-			classMeta := task.Classes[className]
-			key := ""
-			if keyIndex <= 9 {
-				key = fmt.Sprintf("%d", keyIndex)
-				keyIndex++
-			}
+		for _, classMeta := range task.OrderedClasses() {
 			classes = append(classes, ClassButton{
-				ID:   className,
-				Name: i(classMeta.Name),
-				Key:  key,
+				ID:    classMeta.ID,
+				Name:  i(classMeta.Name),
+				Key:   classMeta.Key,
+				Color: classMeta.Color,
+				Icon:  classMeta.Icon,
 			})
 		}
 
 		// Get comprehensive progress information
 		phaseProgress, err := a.GetPhaseProgressStats(r.Context(), taskID)
 		if err != nil {
-			log.Printf("error getting phase progress: %s", err)
+			slog.ErrorContext(r.Context(), "getting phase progress", "error", err)
 			// Fallback to empty progress
 			phaseProgress = &PhaseProgress{}
 		}
 
+		// When reopening an image (undo or history), show the previous answer
+		var currentClass *ConfigClass
+		previous, err := a.annotationRepo.Get(r.Context(), imageID, user.Name, a.taskStageIndex(taskID))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "getting previous annotation", "error", err)
+		} else if previous != nil {
+			currentClass = task.Classes[previous.OptionValue]
+		}
+
+		if err := a.RecordViews(r.Context(), taskID, user.Name, imageID); err != nil {
+			slog.ErrorContext(r.Context(), "recording image view", "error", err)
+		}
+
 		data := map[string]interface{}{
 			"Title":         "annotation",
 			"TaskID":        taskID,
@@ -864,6 +1488,7 @@ func (a *AnnotatorApp) GetHTTPHandler() http.Handler {
 			"ImageID":       imageID,
 			"ImageFilename": imageFilename,
 			"Classes":       classes,
+			"CurrentClass":  currentClass,
 			"PhaseProgress": phaseProgress,
 			// Keep old Progress for backward compatibility
 			"Progress": map[string]interface{}{
@@ -875,11 +1500,605 @@ func (a *AnnotatorApp) GetHTTPHandler() http.Handler {
 
 		err = RenderPageWithRequest(r, w, "annotate.html", data)
 		if err != nil {
-			log.Printf("error rendering annotate template: %s", err)
+			slog.ErrorContext(r.Context(), "rendering annotate template", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 	})
 
+	// Grid pages - batch annotation of binary tasks
+	mux.HandleFunc("/grid/", func(w http.ResponseWriter, r *http.Request) {
+		itemPath := pathParts(r.URL.Path)
+		if len(itemPath) != 2 {
+			http.NotFoundHandler().ServeHTTP(w, r)
+			return
+		}
+		taskID := itemPath[1]
+		task := a.GetTask(taskID)
+		if task == nil || !task.IsBinary() {
+			http.NotFoundHandler().ServeHTTP(w, r)
+			return
+		}
+		user := requestUser(r)
+		if !user.CanAnnotateTask(taskID) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		defaultClass, otherClass := task.GridClasses()
+
+		if r.Method == http.MethodPost {
+			stageIndex := a.taskStageIndex(taskID)
+			r.ParseForm()
+			images := r.Form["image"]
+			if len(images) == 0 || len(images) > gridMaxPageSize {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			toggled := make(map[string]bool)
+			for _, imageID := range r.Form["toggled"] {
+				toggled[imageID] = true
+			}
+			annotations := make([]AnnotationResponse, 0, len(images))
+			seen := make(map[string]bool, len(images))
+			for _, imageID := range images {
+				if seen[imageID] {
+					continue
+				}
+				seen[imageID] = true
+				if _, err := a.GetImageFilename(r.Context(), imageID); err != nil {
+					slog.WarnContext(r.Context(), "grid: rejecting unknown image", "image", imageID, "error", err)
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				// Only images the grid could have listed are answered, others may have
+				// been answered meanwhile, or the form crafted
+				eligible, err := a.isEligibleImage(r.Context(), stageIndex, imageID)
+				if err != nil {
+					slog.ErrorContext(r.Context(), "grid: checking image", "image", imageID, "error", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				if !eligible {
+					slog.WarnContext(r.Context(), "grid: skipping image not eligible for the task", "image", imageID, "task", taskID, "user", user.Name)
+					continue
+				}
+				value := defaultClass.ID
+				if toggled[imageID] {
+					value = otherClass.ID
+				}
+				annotations = append(annotations, AnnotationResponse{
+					ImageID:  imageID,
+					TaskID:   taskID,
+					User:     user.Name,
+					Value:    value,
+					Sure:     true,
+					ClientIP: clientIP(r),
+				})
+			}
+			if len(annotations) > 0 {
+				if err := a.SubmitAnnotationBatch(r.Context(), annotations); err != nil {
+					slog.ErrorContext(r.Context(), "submitting grid annotations", "error", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+			}
+			step, err := a.NextAnnotationStep(r.Context(), user, taskID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "getting next step", "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if step == nil {
+				w.Header().Add("HX-Redirect", fmt.Sprintf("/help/%s", taskID))
+			} else {
+				w.Header().Add("HX-Redirect", fmt.Sprintf("/grid/%s", taskID))
+			}
+			return
+		}
+
+		size := gridDefaultPageSize
+		if value, err := strconv.Atoi(r.URL.Query().Get("size")); err == nil && value > 0 {
+			size = min(value, gridMaxPageSize)
+		}
+		steps, err := a.NextAnnotationBatch(r.Context(), taskID, size)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "getting grid candidates", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(steps) == 0 {
+			http.Redirect(w, r, fmt.Sprintf("/help/%s", taskID), http.StatusSeeOther)
+			return
+		}
+
+		phaseProgress, err := a.GetPhaseProgressStats(r.Context(), taskID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "getting phase progress", "error", err)
+			phaseProgress = &PhaseProgress{}
+		}
+
+		imageIDs := make([]string, len(steps))
+		for idx, step := range steps {
+			imageIDs[idx] = step.ImageID
+		}
+		if err := a.RecordViews(r.Context(), taskID, user.Name, imageIDs...); err != nil {
+			slog.ErrorContext(r.Context(), "recording image views", "error", err)
+		}
+
+		data := map[string]interface{}{
+			"Title":         "annotation",
+			"TaskID":        taskID,
+			"TaskName":      task.Name,
+			"Steps":         steps,
+			"DefaultClass":  defaultClass,
+			"OtherClass":    otherClass,
+			"PhaseProgress": phaseProgress,
+		}
+
+		err = RenderPageWithRequest(r, w, "grid.html", data)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "rendering grid template", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	// Personal pages - undo and annotation history
+	mux.HandleFunc("/me/", func(w http.ResponseWriter, r *http.Request) {
+		itemPath := pathParts(r.URL.Path)
+		user := requestUser(r)
+
+		if len(itemPath) == 2 && itemPath[1] == "undo" {
+			last, err := a.annotationRepo.GetByUser(r.Context(), user.Name, 1, 0)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "getting last annotation", "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if len(last) == 0 || last[0].StageIndex >= len(a.Config.Tasks) {
+				http.Redirect(w, r, "/annotate", http.StatusSeeOther)
+				return
+			}
+			taskID := a.Config.Tasks[last[0].StageIndex].ID
+			http.Redirect(w, r, fmt.Sprintf("/annotate/%s/%s", taskID, last[0].ImageSHA256), http.StatusSeeOther)
+			return
+		}
+
+		if len(itemPath) == 3 && itemPath[1] == "history" && r.Method == http.MethodPost {
+			annotationID, err := strconv.ParseInt(itemPath[2], 10, 64)
+			if err != nil {
+				http.NotFoundHandler().ServeHTTP(w, r)
+				return
+			}
+			ann, err := a.annotationRepo.GetByID(r.Context(), annotationID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "getting annotation", "annotation", annotationID, "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if ann == nil || ann.Username != user.Name || ann.StageIndex >= len(a.Config.Tasks) {
+				http.NotFoundHandler().ServeHTTP(w, r)
+				return
+			}
+			task := a.Config.Tasks[ann.StageIndex]
+			if !user.CanAnnotateTask(task.ID) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			r.ParseForm()
+			selectedClass := r.FormValue("selectedClass")
+			if _, ok := task.Classes[selectedClass]; !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			err = a.SubmitAnnotation(r.Context(), AnnotationResponse{
+				ImageID:  ann.ImageSHA256,
+				TaskID:   task.ID,
+				User:     user.Name,
+				Value:    selectedClass,
+				Sure:     true,
+				ClientIP: clientIP(r),
+			})
+			if err != nil {
+				slog.ErrorContext(r.Context(), "relabelling annotation", "annotation", annotationID, "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Add("HX-Refresh", "true")
+			return
+		}
+
+		if len(itemPath) != 2 || itemPath[1] != "history" {
+			http.NotFoundHandler().ServeHTTP(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		filter := domain.AnnotationFilter{Username: user.Name}
+		filterTask := a.GetTask(query.Get("task"))
+		filterClass := query.Get("class")
+		if filterTask != nil {
+			stageIndex := a.taskStageIndex(filterTask.ID)
+			filter.StageIndex = &stageIndex
+		}
+		if filterClass != "" {
+			filter.OptionValue = &filterClass
+		}
+		page, err := strconv.Atoi(query.Get("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		total, err := a.annotationRepo.Count(r.Context(), filter)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "counting annotation history", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		annotations, err := a.annotationRepo.List(r.Context(), filter, historyPageSize, (page-1)*historyPageSize)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "listing annotation history", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		entries := make([]HistoryEntry, 0, len(annotations))
+		for _, ann := range annotations {
+			if ann.StageIndex >= len(a.Config.Tasks) {
+				continue
+			}
+			entries = append(entries, HistoryEntry{
+				AnnotationWithImage: ann,
+				Task:                a.Config.Tasks[ann.StageIndex],
+			})
+		}
+
+		pageQuery := func(page int) string {
+			values := url.Values{}
+			if filterTask != nil {
+				values.Set("task", filterTask.ID)
+			}
+			if filterClass != "" {
+				values.Set("class", filterClass)
+			}
+			values.Set("page", strconv.Itoa(page))
+			return "?" + values.Encode()
+		}
+		data := map[string]interface{}{
+			"Title":       "History",
+			"Entries":     entries,
+			"Tasks":       a.Config.Tasks,
+			"FilterTask":  filterTask,
+			"FilterClass": filterClass,
+			"Total":       total,
+			"Page":        page,
+		}
+		if page > 1 {
+			data["PrevPage"] = pageQuery(page - 1)
+		}
+		if int64(page*historyPageSize) < total {
+			data["NextPage"] = pageQuery(page + 1)
+		}
+
+		err = RenderPageWithRequest(r, w, "history.html", data)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "rendering history template", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	// Stats page - annotator productivity
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		if !requestUser(r).CanViewStats() {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		days := statsDefaultDays
+		if value, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && value > 0 {
+			days = value
+		}
+		stats, err := a.UserStats(r.Context(), StatsOptions{
+			Since: time.Now().AddDate(0, 0, -days),
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "computing user stats", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]interface{}{
+			"Title":         "Stats",
+			"Days":          days,
+			"DayOptions":    []int{1, 7, 30, 90, 365},
+			"Users":         stats,
+			"FastThreshold": StatsDefaultFastThreshold,
+			"MinStreak":     StatsDefaultMinStreak,
+		}
+		err = RenderPageWithRequest(r, w, "stats.html", data)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "rendering stats template", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	// API tokens of the user, or of everyone for admins
+	mux.HandleFunc("/tokens/", func(w http.ResponseWriter, r *http.Request) {
+		itemPath := pathParts(r.URL.Path)
+		user := requestUser(r)
+		if r.Method != http.MethodGet && !user.HasScope(ScopeAdmin) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		data := map[string]interface{}{
+			"Title":         "API tokens",
+			"Scopes":        validScopes,
+			"ExpiryOptions": []int{30, 90, 365},
+		}
+		switch {
+		case len(itemPath) == 1 && r.Method == http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			scopes, err := ParseScopes(strings.Join(r.PostForm["scope"], ","))
+			if err != nil || strings.TrimSpace(r.PostFormValue("name")) == "" {
+				data["Error"] = "Give the token a name and at least one scope"
+				w.WriteHeader(http.StatusBadRequest)
+				break
+			}
+			var expiresAt *time.Time
+			if days, err := strconv.Atoi(r.PostFormValue("expires_days")); err == nil && days > 0 {
+				expiry := time.Now().AddDate(0, 0, days)
+				expiresAt = &expiry
+			}
+			token, err := a.CreateAPIToken(r.Context(), user, r.PostFormValue("name"), scopes, expiresAt)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "creating API token", "user", user.Name, "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			data["NewToken"] = token
+		case len(itemPath) == 3 && itemPath[2] == "revoke" && r.Method == http.MethodPost:
+			tokenID, err := strconv.ParseInt(itemPath[1], 10, 64)
+			if err != nil {
+				http.NotFoundHandler().ServeHTTP(w, r)
+				return
+			}
+			token, err := a.GetAPIToken(r.Context(), tokenID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "getting API token", "token", tokenID, "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if token == nil || (token.Username != user.Name && !user.IsAdmin()) {
+				http.NotFoundHandler().ServeHTTP(w, r)
+				return
+			}
+			if _, err := a.RevokeAPIToken(r.Context(), tokenID); err != nil {
+				slog.ErrorContext(r.Context(), "revoking API token", "token", tokenID, "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			a.audit(r.Context(), "revoked API token", "user", user.Name, "token", tokenID, "owner", token.Username)
+			w.Header().Set("HX-Refresh", "true")
+			return
+		case len(itemPath) == 1 && r.Method == http.MethodGet:
+		default:
+			http.NotFoundHandler().ServeHTTP(w, r)
+			return
+		}
+
+		// Admins manage the tokens of everyone
+		owner := user.Name
+		if user.IsAdmin() {
+			owner = ""
+		}
+		tokens, err := a.ListAPITokens(r.Context(), owner)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "listing API tokens", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		data["Tokens"] = tokens
+		data["AllUsers"] = owner == ""
+		data["Now"] = time.Now()
+		err = RenderPageWithRequest(r, w, "tokens.html", data)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "rendering tokens template", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	// Admin overview of every task and user, with backups - admins only
+	mux.HandleFunc("/admin/", a.serveAdmin)
+
+	// Webhook delivery log - admins only
+	mux.HandleFunc("/webhooks/", func(w http.ResponseWriter, r *http.Request) {
+		itemPath := pathParts(r.URL.Path)
+		user := requestUser(r)
+		if !user.IsAdmin() || (r.Method != http.MethodGet && !user.HasScope(ScopeAdmin)) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch {
+		case len(itemPath) == 3 && itemPath[2] == "retry" && r.Method == http.MethodPost:
+			deliveryID, err := strconv.ParseInt(itemPath[1], 10, 64)
+			if err != nil {
+				http.NotFoundHandler().ServeHTTP(w, r)
+				return
+			}
+			retried, err := a.RetryWebhookDelivery(r.Context(), deliveryID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "retrying webhook delivery", "delivery", deliveryID, "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if !retried {
+				http.NotFoundHandler().ServeHTTP(w, r)
+				return
+			}
+			slog.InfoContext(r.Context(), "webhooks: queued delivery again", "user", user.Name, "delivery", deliveryID)
+			w.Header().Set("HX-Refresh", "true")
+			return
+		case len(itemPath) == 1 && r.Method == http.MethodGet:
+		default:
+			http.NotFoundHandler().ServeHTTP(w, r)
+			return
+		}
+
+		deliveries, err := a.ListWebhookDeliveries(r.Context(), webhookLogSize)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "listing webhook deliveries", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		data := map[string]interface{}{
+			"Title":      "Webhooks",
+			"Webhooks":   a.Config.Webhooks,
+			"Deliveries": deliveries,
+		}
+		err = RenderPageWithRequest(r, w, "webhooks.html", data)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "rendering webhooks template", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	// Image ingestion - its progress for everyone, and running it again for admins
+	mux.HandleFunc("/ingestion/", func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		switch {
+		case r.URL.Path != "/ingestion/":
+			http.NotFoundHandler().ServeHTTP(w, r)
+			return
+		case r.Method == http.MethodPost:
+			if !user.IsAdmin() || !user.HasScope(ScopeAdmin) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if a.StartIngestion(a.backgroundContext()) {
+				slog.InfoContext(r.Context(), "ingestion started", "user", user.Name, "dir", a.ImagesDir)
+			}
+			if r.Header.Get("HX-Request") == "true" {
+				w.Header().Set("HX-Refresh", "true")
+				return
+			}
+			http.Redirect(w, r, "/ingestion/", http.StatusSeeOther)
+			return
+		case r.Method != http.MethodGet:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		data := map[string]interface{}{
+			"Title":  "Image ingestion",
+			"Status": a.IngestionStatus(),
+			"Dir":    a.ImagesDir,
+		}
+		err := RenderPageWithRequest(r, w, "ingestion.html", data)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "rendering ingestion template", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	// Audit pages - change history of an image or user, and restoring previous states
+	mux.HandleFunc("/audit/", func(w http.ResponseWriter, r *http.Request) {
+		itemPath := pathParts(r.URL.Path)
+		if len(itemPath) != 3 {
+			http.NotFoundHandler().ServeHTTP(w, r)
+			return
+		}
+		user := requestUser(r)
+
+		if itemPath[1] == "restore" {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			eventID, err := strconv.ParseInt(itemPath[2], 10, 64)
+			if err != nil {
+				http.NotFoundHandler().ServeHTTP(w, r)
+				return
+			}
+			event, err := a.eventRepo.Get(r.Context(), eventID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "getting audit event", "event", eventID, "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if event == nil || !a.canSeeEvent(user, event) {
+				http.NotFoundHandler().ServeHTTP(w, r)
+				return
+			}
+			if !a.canRestoreEvent(user, event) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if _, err := a.RestoreAnnotationEvent(r.Context(), eventID, user.Name, clientIP(r)); err != nil {
+				slog.ErrorContext(r.Context(), "restoring audit event", "event", eventID, "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Add("HX-Refresh", "true")
+			return
+		}
+
+		var events []*domain.AnnotationEvent
+		var err error
+		switch itemPath[1] {
+		case "image":
+			events, err = a.ImageEvents(r.Context(), itemPath[2])
+		case "user":
+			if itemPath[2] != user.Name && !user.CanReview() {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			events, err = a.UserEvents(r.Context(), itemPath[2], auditPageSize, 0)
+		default:
+			http.NotFoundHandler().ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "listing audit events", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		entries := make([]AuditEntry, 0, len(events))
+		for _, event := range events {
+			if !a.canSeeEvent(user, event) {
+				continue
+			}
+			entries = append(entries, AuditEntry{
+				AnnotationEvent: event,
+				TaskID:          a.StageName(event.StageIndex),
+				CanRestore:      a.canRestoreEvent(user, event),
+			})
+		}
+
+		data := map[string]interface{}{
+			"Title":   "Audit",
+			"Kind":    itemPath[1],
+			"Subject": itemPath[2],
+			"Entries": entries,
+		}
+		err = RenderPageWithRequest(r, w, "audit.html", data)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "rendering audit template", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	// Prometheus metrics for admins, scrapers are let in by scraperMiddleware
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if !requestUser(r).IsAdmin() {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		a.serveMetrics(w, r)
+	})
+
 	// Asset handler - serves images by SHA256 hash
 	mux.HandleFunc("/asset/", func(w http.ResponseWriter, r *http.Request) {
 		itemPath := pathParts(r.URL.Path)
@@ -888,17 +2107,17 @@ func (a *AnnotatorApp) GetHTTPHandler() http.Handler {
 			return
 		}
 		sha256 := itemPath[1]
-		log.Printf("http: fetching asset %s", sha256)
+		slog.DebugContext(r.Context(), "http: fetching asset", "image", sha256)
 
 		// Get image filename from repository
 		filename, err := a.GetImageFilename(r.Context(), sha256)
 		if err != nil {
-			log.Printf("http: asset %s was not found: %s", sha256, err)
+			slog.DebugContext(r.Context(), "http: asset not found", "image", sha256, "error", err)
 			http.NotFoundHandler().ServeHTTP(w, r)
 			return
 		}
 
-		log.Printf("http: asset %s is %s!", sha256, filename)
+		slog.DebugContext(r.Context(), "http: serving asset", "image", sha256, "filename", filename)
 		fullPath := path.Join(a.ImagesDir, filename)
 		f, err := os.Open(fullPath)
 		if errors.Is(err, os.ErrNotExist) {
@@ -907,44 +2126,100 @@ func (a *AnnotatorApp) GetHTTPHandler() http.Handler {
 		}
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("error: http: while serving image asset: %s", err)
+			slog.ErrorContext(r.Context(), "http: serving image asset", "error", err)
 			return
 		}
 		defer f.Close()
 		io.Copy(w, f)
 	})
 
-	log.Printf("images dir: %s", a.ImagesDir)
+	slog.Debug("images dir", "path", a.ImagesDir)
 
 	var handler http.Handler = mux
+	handler = a.ingestionStatusMiddleware(handler)
 	handler = i18nMiddleware(handler)
 	handler = HTTPLogger(handler)
 	handler = a.authenticationMiddleware(handler)
+	handler = a.scraperMiddleware(handler)
+	handler = a.healthMiddleware(handler)
+	handler = a.crossOriginMiddleware(handler)
 	handler = requestCacheMiddleware(handler)
+	handler = a.metricsMiddleware(handler, mux)
+	handler = a.tracingMiddleware(handler, mux)
+	handler = requestIDMiddleware(handler)
+	handler = a.basePathMiddleware(handler)
 	return handler
 }
 
 func (a *AnnotatorApp) authenticationMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		username, password, ok := r.BasicAuth()
-		if ok {
-			var item *ConfigAuth = nil
-			item, ok = a.Config.Authentication[username]
-			if ok {
-				if password == item.Password {
-					log.Printf("auth for user %s: success", username)
-					handler.ServeHTTP(w, r)
-					return
-				}
-				log.Printf("auth for user %s: bad password", username)
-			} else {
+		// API tokens are checked on their own, a bad one is never retried as something else
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			user, err := a.tokenUser(r, strings.TrimSpace(token))
+			if err != nil {
+				slog.ErrorContext(r.Context(), "auth: loading API token", "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if user == nil {
+				a.audit(r.Context(), "invalid API token", "client_ip", clientIP(r))
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				denyRequest(w, r, http.StatusUnauthorized, "invalid API token")
+				return
+			}
+			if scope := requiredScope(r.Method); !user.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+				denyRequest(w, r, http.StatusForbidden, "the API token needs the "+scope+" scope")
+				return
+			}
+			handler.ServeHTTP(w, r.WithContext(WithRequestUser(r.Context(), user)))
+			return
+		}
 
-				log.Printf("auth for user %s: no such user", username)
+		user := a.proxyUser(r)
+		var err error
+		if user == nil {
+			user, err = a.sessionUser(r)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "auth: loading session", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// Basic auth is kept for scripts
+		if username, password, ok := r.BasicAuth(); user == nil && ok {
+			auth, wait := a.AttemptLogin(r, username, password)
+			if wait > 0 {
+				retryAfter(w, wait)
+				denyRequest(w, r, http.StatusTooManyRequests, "too many failed logins, try again later")
+				return
 			}
+			if auth != nil {
+				user = &RequestUser{Name: username, ConfigAuth: auth}
+			}
+		}
+		if user != nil {
+			r = r.WithContext(WithRequestUser(r.Context(), user))
+		}
+		if user != nil || publicPaths[r.URL.Path] {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		// Browsers are sent to the login page, anything else gets a Basic auth challenge
+		switch {
+		case r.Header.Get("HX-Request") == "true":
+			w.Header().Set("HX-Redirect", a.loginURL(r))
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html"):
+			http.Redirect(w, r, a.loginURL(r), http.StatusSeeOther)
+		case isAPIRequest(r):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="restricted"`)
+			writeAPIError(w, http.StatusUnauthorized, "authentication required")
+		default:
+			w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
+			w.WriteHeader(http.StatusUnauthorized)
 		}
-		log.Printf("auth: not ok")
-		w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
-		w.WriteHeader(http.StatusUnauthorized)
 	})
 }
 
@@ -958,7 +2233,7 @@ func (a *AnnotatorApp) PrepareDatabase(ctx context.Context) error {
 	if err := a.IngestImages(ctx); err != nil {
 		return err
 	}
-	log.Printf("PrepareDatabase: success! Database is ready")
+	slog.InfoContext(ctx, "database is ready")
 	return nil
 }
 
@@ -978,16 +2253,41 @@ func (a *AnnotatorApp) PrepareDatabaseMigrations(ctx context.Context) error {
 	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
 		return err
 	}
-	log.Printf("PrepareDatabaseMigrations: migrations completed successfully")
+	slog.InfoContext(ctx, "database migrations completed")
 	return nil
 }
 
 // IngestImages scans the images directory and loads all images into the database.
 // This can be called asynchronously after the HTTP server starts.
-func (a *AnnotatorApp) IngestImages(ctx context.Context) error {
-	log.Printf("IngestImages: starting image ingestion from directory: %s", a.ImagesDir)
+// Cancelling ctx stops it between two files, keeping a checkpoint the next
+// run resumes from without hashing again the files already stored.
+func (a *AnnotatorApp) IngestImages(ctx context.Context) (err error) {
+	ctx, span := a.tracer().Start(ctx, "IngestImages", trace.WithAttributes(attribute.String("dir", a.ImagesDir)))
+	defer func() { endSpan(span, err) }()
+	slog.InfoContext(ctx, "ingestion: starting", "dir", a.ImagesDir)
+
+	// The work on a file, and the records of the run, outlive a cancellation
+	uncancelled := context.WithoutCancel(ctx)
+	progress := &ingestionProgress{ctx: uncancelled, app: a}
+	total := 0
+	if entries, err := os.ReadDir(a.ImagesDir); err == nil {
+		total = len(entries)
+	}
+	progress.begin(total)
+	a.pruneViews(uncancelled, time.Now())
 
-	err := filepath.WalkDir(a.ImagesDir, func(fullPath string, info fs.DirEntry, err error) error {
+	resumeAfter, err := a.imageRepo.Checkpoint(ctx, a.ImagesDir)
+	if err != nil {
+		slog.WarnContext(ctx, "ingestion: reading the checkpoint, starting over", "error", err)
+		resumeAfter = ""
+	} else if resumeAfter != "" {
+		slog.InfoContext(ctx, "ingestion: resuming", "after", resumeAfter)
+	}
+
+	// A file that cannot be ingested does not stop the others
+	var firstFailure error
+	var lastFile string
+	err = filepath.WalkDir(a.ImagesDir, func(fullPath string, info fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -997,36 +2297,108 @@ func (a *AnnotatorApp) IngestImages(ctx context.Context) error {
 		if info.IsDir() {
 			return fmt.Errorf("while checking if item '%s' is a file: datasets must be organized in a flat folder structure. Hint: use the 'ingest' subcommand.", fullPath)
 		}
-
-		log.Printf("IngestImages: processing image: %s", fullPath)
-
-		// Verify it's an image
-		_, err = DecodeImage(fullPath)
-		if err != nil {
-			return fmt.Errorf("while checking if item '%s' is an image: %w", fullPath, err)
-		}
-
-		// Hash the file to get SHA256
-		fileHash, err := HashFile(fullPath)
-		if err != nil {
-			return fmt.Errorf("while hashing image '%s': %w", fullPath, err)
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		// Use repository to create image (with upsert behavior via ON CONFLICT)
-		_, err = a.imageRepo.Create(ctx, fileHash, info.Name())
+		a.ingestion.processing(info.Name())
+		// Files are walked in lexical order, those up to the checkpoint were stored by the interrupted run
+		result, err := a.ingestImage(uncancelled, fullPath, info.Name(), info.Name() <= resumeAfter)
 		if err != nil {
-			// Ignore duplicate errors (hash already exists)
-			if !strings.Contains(err.Error(), "UNIQUE constraint") {
-				return fmt.Errorf("while inserting image '%s': %w", fullPath, err)
+			slog.WarnContext(ctx, "ingestion: skipping file", "path", fullPath, "error", err)
+			if firstFailure == nil {
+				firstFailure = err
 			}
+			a.metrics.ingestionErrors.Inc()
+		} else {
+			a.metrics.imagesIngested.Inc()
+		}
+		progress.advance(info.Name(), result, err)
+		lastFile = info.Name()
+		if progress.progress.Processed%ingestionCheckpointInterval == 0 {
+			a.saveIngestionCheckpoint(uncancelled, lastFile)
 		}
-
 		return nil
 	})
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		if lastFile != "" {
+			a.saveIngestionCheckpoint(uncancelled, lastFile)
+		}
+		err = fmt.Errorf("interrupted after %q: %w", lastFile, err)
+	} else if err == nil {
+		if err := a.imageRepo.DeleteCheckpoint(uncancelled, a.ImagesDir); err != nil {
+			slog.WarnContext(ctx, "ingestion: clearing the checkpoint", "error", err)
+		}
+		if firstFailure != nil {
+			status := a.IngestionStatus()
+			err = fmt.Errorf("%d of %d files could not be ingested, the first %w", status.Failed, status.Seen, firstFailure)
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("while ingesting images: %w", err)
+		err = fmt.Errorf("while ingesting images: %w", err)
+		progress.finish(err)
+		a.metrics.ingestions.WithLabelValues("error").Inc()
+		return err
 	}
+	progress.finish(nil)
+	a.metrics.ingestions.WithLabelValues("success").Inc()
 
-	log.Printf("IngestImages: completed successfully!")
+	status := a.IngestionStatus()
+	slog.InfoContext(ctx, "ingestion: completed", "images", status.Seen, "ingested", status.Ingested, "skipped", status.Skipped)
 	return nil
 }
+
+// saveIngestionCheckpoint records the last file an ingestion got through
+func (a *AnnotatorApp) saveIngestionCheckpoint(ctx context.Context, file string) {
+	if err := a.imageRepo.SaveCheckpoint(ctx, a.ImagesDir, file); err != nil {
+		slog.WarnContext(ctx, "ingestion: saving the checkpoint", "file", file, "error", err)
+	}
+}
+
+// ingestImage checks that a file of the images directory is an image and
+// records it, returning whether it was ingestionIngested, ingestionSkipped
+// as it was known already, or ingestionFailed. Resumed files are those up to
+// the checkpoint of an interrupted run.
+func (a *AnnotatorApp) ingestImage(ctx context.Context, fullPath, name string, resumed bool) (_ string, err error) {
+	ctx, span := a.tracer().Start(ctx, "ingest image", trace.WithAttributes(attribute.String("file", name)))
+	defer func() { endSpan(span, err) }()
+	slog.DebugContext(ctx, "ingestion: processing image", "path", fullPath)
+
+	// Files before the checkpoint of an interrupted run are trusted by name
+	if resumed {
+		known, err := a.imageRepo.GetByFilename(ctx, name)
+		if err != nil {
+			return ingestionFailed, fmt.Errorf("while looking up image '%s': %w", fullPath, err)
+		}
+		if known != nil {
+			return ingestionSkipped, nil
+		}
+	}
+
+	// Verify it's an image
+	_, err = DecodeImage(fullPath)
+	if err != nil {
+		return ingestionFailed, fmt.Errorf("while checking if item '%s' is an image: %w", fullPath, err)
+	}
+
+	// Hash the file to get SHA256
+	fileHash, err := HashFile(fullPath)
+	if err != nil {
+		return ingestionFailed, fmt.Errorf("while hashing image '%s': %w", fullPath, err)
+	}
+
+	known, err := a.imageRepo.GetBySHA256(ctx, fileHash)
+	if err != nil {
+		return ingestionFailed, fmt.Errorf("while looking up image '%s': %w", fullPath, err)
+	}
+	if known != nil && known.Filename == name {
+		return ingestionSkipped, nil
+	}
+
+	// Use repository to create image, or rename it (upsert behavior via ON CONFLICT)
+	_, err = a.imageRepo.Create(ctx, fileHash, name)
+	if err != nil {
+		return ingestionFailed, fmt.Errorf("while inserting image '%s': %w", fullPath, err)
+	}
+	return ingestionIngested, nil
+}
@@ -0,0 +1,371 @@
+package annotation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lewtec/rotulador/internal/domain"
+)
+
+const testConfigTasks = `
+tasks:
+  - id: has_car
+    name: Has car?
+    type: boolean
+  - id: car_kind
+    name: Car kind
+    if:
+      has_car: "true"
+    classes:
+      real: { name: Real }
+      toy: { name: Toy }
+      drawing: { name: Drawing }
+`
+
+// newTestApp creates an app backed by a migrated temporary database holding the given number of images
+func newTestApp(t *testing.T, tasks string, images int) *AnnotatorApp {
+	t.Helper()
+	return newTestAppWithUsers(t, nil, tasks, images)
+}
+
+// newTestAppWithUsers is newTestApp with the additional users of writeTestConfigWithUsers
+func newTestAppWithUsers(t *testing.T, users map[string]string, tasks string, images int) *AnnotatorApp {
+	t.Helper()
+	config, err := LoadConfig(writeTestConfigWithUsers(t, users, tasks))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	db, err := GetDatabase(filepath.Join(t.TempDir(), "annotations.db"))
+	if err != nil {
+		t.Fatalf("GetDatabase() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	app := &AnnotatorApp{
+		ImagesDir: t.TempDir(),
+		Database:  db,
+		Config:    config,
+	}
+	if err := app.PrepareDatabaseMigrations(context.Background()); err != nil {
+		t.Fatalf("PrepareDatabaseMigrations() error = %v", err)
+	}
+	// Background checks of completed tasks finish before the database is closed
+	t.Cleanup(app.Wait)
+	for idx := 0; idx < images; idx++ {
+		if _, err := app.imageRepo.Create(context.Background(), testImageHash(idx), fmt.Sprintf("%d.png", idx)); err != nil {
+			t.Fatalf("failed to create image: %v", err)
+		}
+	}
+	return app
+}
+
+func testImageHash(idx int) string {
+	return fmt.Sprintf("%064x", idx+1)
+}
+
+// doRequest sends a request authenticated as the admin user of writeTestConfig
+func doRequest(t *testing.T, handler http.Handler, method, target string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	return doRequestAs(t, handler, "admin", method, target, form)
+}
+
+// doRequestAs sends a request authenticated as a user created by writeTestConfigWithUsers
+func doRequestAs(t *testing.T, handler http.Handler, username, method, target string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	req.SetBasicAuth(username, "changeme")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGridMode(t *testing.T) {
+	app := newTestApp(t, testConfigTasks, 5)
+	handler := app.GetHTTPHandler()
+	ctx := context.Background()
+
+	t.Run("renders thumbnails for binary tasks", func(t *testing.T) {
+		rec := doRequest(t, handler, http.MethodGet, "/grid/has_car?size=3", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		if got := strings.Count(rec.Body.String(), `name="toggled"`); got != 3 {
+			t.Errorf("rendered %d thumbnails, want 3", got)
+		}
+	})
+
+	t.Run("is not available for tasks with more than two classes", func(t *testing.T) {
+		rec := doRequest(t, handler, http.MethodGet, "/grid/car_kind", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", rec.Code)
+		}
+	})
+
+	t.Run("stores the default answer and the toggled exceptions", func(t *testing.T) {
+		form := url.Values{}
+		for idx := 0; idx < 4; idx++ {
+			form.Add("image", testImageHash(idx))
+		}
+		form.Add("toggled", testImageHash(1))
+		rec := doRequest(t, handler, http.MethodPost, "/grid/has_car", form)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		if got := rec.Header().Get("HX-Redirect"); got != "/grid/has_car" {
+			t.Errorf("HX-Redirect = %q, want /grid/has_car", got)
+		}
+		for idx, want := range []string{"false", "true", "false", "false"} {
+			ann, err := app.annotationRepo.Get(ctx, testImageHash(idx), "admin", 0)
+			if err != nil || ann == nil {
+				t.Fatalf("annotation for image %d not found: %v", idx, err)
+			}
+			if ann.OptionValue != want {
+				t.Errorf("image %d = %q, want %q", idx, ann.OptionValue, want)
+			}
+		}
+	})
+
+	t.Run("rejects the whole page when an image is unknown", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("image", testImageHash(4))
+		form.Add("image", testImageHash(99))
+		rec := doRequest(t, handler, http.MethodPost, "/grid/has_car", form)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400", rec.Code)
+		}
+		if ann, _ := app.annotationRepo.Get(ctx, testImageHash(4), "admin", 0); ann != nil {
+			t.Error("expected no annotation to be stored")
+		}
+	})
+}
+
+func TestGridSkipsImagesItWouldNotList(t *testing.T) {
+	app := newTestApp(t, `
+tasks:
+  - id: has_car
+    name: Has car?
+    type: boolean
+  - id: is_red
+    name: Is the car red?
+    type: boolean
+    if:
+      has_car: "true"
+`, 3)
+	handler := app.GetHTTPHandler()
+	ctx := context.Background()
+	for idx, value := range []string{"true", "false"} {
+		if err := app.SubmitAnnotation(ctx, AnnotationResponse{ImageID: testImageHash(idx), TaskID: "has_car", User: "admin", Value: value}); err != nil {
+			t.Fatalf("SubmitAnnotation() error = %v", err)
+		}
+	}
+
+	// Only image 0 has a car; image 2 was not answered in has_car yet
+	form := url.Values{"image": {testImageHash(0), testImageHash(1), testImageHash(2)}, "toggled": {testImageHash(0), testImageHash(1), testImageHash(2)}}
+	if rec := doRequest(t, handler, http.MethodPost, "/grid/is_red", form); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	for idx, want := range []bool{true, false, false} {
+		if ann, _ := app.annotationRepo.Get(ctx, testImageHash(idx), "admin", 1); (ann != nil) != want {
+			t.Errorf("image %d answered = %v, want %v", idx, ann != nil, want)
+		}
+	}
+
+	// Answers are not changed through the grid
+	form = url.Values{"image": {testImageHash(0)}}
+	if rec := doRequest(t, handler, http.MethodPost, "/grid/has_car", form); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ann, _ := app.annotationRepo.Get(ctx, testImageHash(0), "admin", 0); ann.OptionValue != "true" {
+		t.Errorf("has_car of image 0 = %q, want true", ann.OptionValue)
+	}
+
+	// Nor are images another annotator answered meanwhile
+	if err := app.SubmitAnnotation(ctx, AnnotationResponse{ImageID: testImageHash(2), TaskID: "has_car", User: "someone", Value: "false"}); err != nil {
+		t.Fatalf("SubmitAnnotation() error = %v", err)
+	}
+	form = url.Values{"image": {testImageHash(2)}}
+	if rec := doRequest(t, handler, http.MethodPost, "/grid/has_car", form); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ann, _ := app.annotationRepo.Get(ctx, testImageHash(2), "admin", 0); ann != nil {
+		t.Errorf("image 2 answered by admin too: %+v", ann)
+	}
+}
+
+func TestNextAnnotationBatch(t *testing.T) {
+	app := newTestApp(t, testConfigTasks, 10)
+	ctx := context.Background()
+
+	// Grid pages are picked among several pages of candidates, so concurrent annotators rarely clash
+	seen := map[string]bool{}
+	for range 20 {
+		steps, err := app.NextAnnotationBatch(ctx, "has_car", 2)
+		if err != nil {
+			t.Fatalf("NextAnnotationBatch() error = %v", err)
+		}
+		if len(steps) != 2 || steps[0].ImageID == steps[1].ImageID {
+			t.Fatalf("steps = %+v", steps)
+		}
+		for _, step := range steps {
+			seen[step.ImageID] = true
+		}
+	}
+	if len(seen) <= 2 {
+		t.Errorf("every page had the same images: %v", seen)
+	}
+	if seen[testImageHash(8)] || seen[testImageHash(9)] {
+		t.Error("images past the candidate pages should not be picked")
+	}
+}
+
+func TestUndoAndHistory(t *testing.T) {
+	app := newTestApp(t, testConfigTasks, 3)
+	handler := app.GetHTTPHandler()
+	ctx := context.Background()
+
+	submit := func(idx int, taskID, value string) {
+		t.Helper()
+		err := app.SubmitAnnotation(ctx, AnnotationResponse{ImageID: testImageHash(idx), TaskID: taskID, User: "admin", Value: value})
+		if err != nil {
+			t.Fatalf("SubmitAnnotation() error = %v", err)
+		}
+	}
+	submit(0, "has_car", "true")
+	submit(0, "car_kind", "toy")
+	submit(1, "has_car", "false")
+
+	t.Run("undo reopens the last annotated image", func(t *testing.T) {
+		rec := doRequest(t, handler, http.MethodGet, "/me/undo", nil)
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("status = %d, want 303", rec.Code)
+		}
+		want := "/annotate/has_car/" + testImageHash(1)
+		if got := rec.Header().Get("Location"); got != want {
+			t.Errorf("Location = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("history filters by task and class", func(t *testing.T) {
+		rec := doRequest(t, handler, http.MethodGet, "/me/history?task=has_car&class=false", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		body := rec.Body.String()
+		if !strings.Contains(body, "1.png") || strings.Contains(body, "0.png") {
+			t.Errorf("unexpected history entries: %s", body)
+		}
+	})
+
+	t.Run("relabelling flags dependent annotations", func(t *testing.T) {
+		ann, err := app.annotationRepo.Get(ctx, testImageHash(0), "admin", 0)
+		if err != nil || ann == nil {
+			t.Fatalf("annotation not found: %v", err)
+		}
+		rec := doRequest(t, handler, http.MethodPost, fmt.Sprintf("/me/history/%d", ann.ID), url.Values{"selectedClass": {"false"}})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		dependent, err := app.annotationRepo.Get(ctx, testImageHash(0), "admin", 1)
+		if err != nil || dependent == nil {
+			t.Fatalf("dependent annotation not found: %v", err)
+		}
+		if dependent.FlaggedAt == nil || !strings.Contains(dependent.FlagReason, "has_car") {
+			t.Errorf("expected dependent annotation to be flagged, got %+v", dependent)
+		}
+
+		// Answering the dependent task again clears the flag
+		submit(0, "car_kind", "real")
+		dependent, _ = app.annotationRepo.Get(ctx, testImageHash(0), "admin", 1)
+		if dependent.FlaggedAt != nil {
+			t.Error("expected flag to be cleared after relabelling")
+		}
+	})
+
+	t.Run("dependent annotations flagged already keep their flag", func(t *testing.T) {
+		countFlags := func() int {
+			t.Helper()
+			events, err := app.ImageEvents(ctx, testImageHash(0))
+			if err != nil {
+				t.Fatalf("ImageEvents() error = %v", err)
+			}
+			flags := 0
+			for _, event := range events {
+				if event.Type == domain.AnnotationEventFlag {
+					flags++
+				}
+			}
+			return flags
+		}
+		submitAs := func(user, value string) {
+			t.Helper()
+			if err := app.SubmitAnnotation(ctx, AnnotationResponse{ImageID: testImageHash(0), TaskID: "has_car", User: user, Value: value}); err != nil {
+				t.Fatalf("SubmitAnnotation() error = %v", err)
+			}
+		}
+		submit(0, "has_car", "true")
+		submitAs("bob", "true")
+		before := countFlags()
+
+		// Two upstream edits make the dependent task ineligible, once
+		submit(0, "has_car", "false")
+		first, _ := app.annotationRepo.Get(ctx, testImageHash(0), "admin", 1)
+		if first.FlaggedAt == nil {
+			t.Fatal("expected dependent annotation to be flagged")
+		}
+		time.Sleep(1100 * time.Millisecond) // flagged_at has a precision of seconds
+		submitAs("bob", "false")
+
+		dependent, _ := app.annotationRepo.Get(ctx, testImageHash(0), "admin", 1)
+		if dependent.FlaggedAt == nil || !dependent.FlaggedAt.Equal(*first.FlaggedAt) {
+			t.Errorf("flagged at %v, want the first flag at %v", dependent.FlaggedAt, first.FlaggedAt)
+		}
+		if flags := countFlags() - before; flags != 1 {
+			t.Errorf("flag events = %d, want 1", flags)
+		}
+	})
+
+	t.Run("relabelling back clears the flags of dependent annotations", func(t *testing.T) {
+		submit(0, "has_car", "true")
+		submit(0, "has_car", "false")
+		if dependent, _ := app.annotationRepo.Get(ctx, testImageHash(0), "admin", 1); dependent.FlaggedAt == nil {
+			t.Fatal("expected dependent annotation to be flagged")
+		}
+
+		submit(0, "has_car", "true")
+		dependent, _ := app.annotationRepo.Get(ctx, testImageHash(0), "admin", 1)
+		if dependent.FlaggedAt != nil || dependent.FlagReason != "" {
+			t.Errorf("expected flag to be cleared, got %+v", dependent)
+		}
+		events, err := app.ImageEvents(ctx, testImageHash(0))
+		if err != nil {
+			t.Fatalf("ImageEvents() error = %v", err)
+		}
+		if events[0].Type != domain.AnnotationEventUnflag || events[0].StageIndex != 1 || *events[0].NewValue != "real" {
+			t.Errorf("last event = %+v, want an unflag of car_kind", events[0])
+		}
+	})
+
+	t.Run("cannot relabel annotations of other users", func(t *testing.T) {
+		other, err := app.annotationRepo.Create(ctx, testImageHash(2), "someone", 0, "true")
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		rec := doRequest(t, handler, http.MethodPost, fmt.Sprintf("/me/history/%d", other.ID), url.Values{"selectedClass": {"false"}})
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+// Server-sent events for htmx, inlined in the pages instead of the sse
+// extension of a CDN. Elements with sse-connect open an EventSource, and each
+// event it receives, say annotation-created, triggers sse:annotation-created
+// on the elements inside them that list it in their hx-trigger.
+(function () {
+  function register(connected, root) {
+    var elements = [root].concat(Array.from(root.querySelectorAll('[hx-trigger]')));
+    elements.forEach(function (element) {
+      var trigger = (element.getAttribute && element.getAttribute('hx-trigger')) || '';
+      (trigger.match(/sse:[\w-]+/g) || []).forEach(function (event) {
+        var name = event.slice('sse:'.length);
+        if (connected.sseEvents[name]) {
+          return;
+        }
+        connected.sseEvents[name] = true;
+        connected.sseSource.addEventListener(name, function () {
+          // Looked up on every event, as swaps replace the listening elements
+          connected.querySelectorAll('[hx-trigger*="' + event + '"]').forEach(function (target) {
+            htmx.trigger(target, event);
+          });
+        });
+      });
+    });
+  }
+
+  htmx.onLoad(function (root) {
+    var sources = Array.from(root.querySelectorAll('[sse-connect]'));
+    if (root.matches && root.matches('[sse-connect]')) {
+      sources.push(root);
+    }
+    sources.forEach(function (connected) {
+      if (connected.sseSource) {
+        return;
+      }
+      connected.sseSource = new EventSource(connected.getAttribute('sse-connect'));
+      connected.sseEvents = {};
+      register(connected, connected);
+    });
+    // Content swapped into a connected element may listen to other events
+    var connected = root.closest && root.closest('[sse-connect]');
+    if (connected && connected.sseSource) {
+      register(connected, root);
+    }
+  });
+})();
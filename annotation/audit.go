@@ -0,0 +1,115 @@
+package annotation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lewtec/rotulador/internal/domain"
+)
+
+// auditPageSize is how many events the user audit view lists at once
+const auditPageSize = 100
+
+// AuditEntry is an event listed in the audit page
+type AuditEntry struct {
+	*domain.AnnotationEvent
+	TaskID     string
+	CanRestore bool
+}
+
+// ImageEvents returns the audit log of an image, newest first
+func (a *AnnotatorApp) ImageEvents(ctx context.Context, imageSHA256 string) ([]*domain.AnnotationEvent, error) {
+	return a.eventRepo.ListForImage(ctx, imageSHA256)
+}
+
+// UserEvents returns the audit log of the annotations owned or changed by a user, newest first
+func (a *AnnotatorApp) UserEvents(ctx context.Context, username string, limit, offset int) ([]*domain.AnnotationEvent, error) {
+	return a.eventRepo.ListByUser(ctx, username, limit, offset)
+}
+
+// RestoreAnnotationEvent puts the annotation touched by an event back into the
+// state it had right after that event. The restore itself is recorded as a new
+// event, a delete when it removes the annotation.
+func (a *AnnotatorApp) RestoreAnnotationEvent(ctx context.Context, eventID int64, actor, clientIP string) (*domain.AnnotationEvent, error) {
+	target, err := a.eventRepo.Get(ctx, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("while getting event %d: %w", eventID, err)
+	}
+	if target == nil {
+		return nil, fmt.Errorf("no such event: %d", eventID)
+	}
+
+	err = a.withAnnotationTx(ctx, func(w *annotationWriter) error {
+		current, err := w.annotations.Get(ctx, target.ImageSHA256, target.Username, target.StageIndex)
+		if err != nil {
+			return fmt.Errorf("while getting current annotation: %w", err)
+		}
+
+		event := domain.AnnotationEvent{
+			Type:        domain.AnnotationEventRestore,
+			ImageSHA256: target.ImageSHA256,
+			Username:    target.Username,
+			StageIndex:  target.StageIndex,
+			NewValue:    target.NewValue,
+			Actor:       actor,
+			ClientIP:    clientIP,
+		}
+		if current != nil {
+			event.OldValue = &current.OptionValue
+		}
+
+		switch {
+		case target.NewValue == nil && current != nil:
+			if err := w.annotations.Delete(ctx, current.ID); err != nil {
+				return fmt.Errorf("while deleting annotation: %w", err)
+			}
+			event.Type = domain.AnnotationEventDelete
+		case target.NewValue != nil:
+			if _, err := w.annotations.Create(ctx, target.ImageSHA256, target.Username, target.StageIndex, *target.NewValue); err != nil {
+				return fmt.Errorf("while restoring annotation: %w", err)
+			}
+		}
+		if err := w.record(ctx, event); err != nil {
+			return err
+		}
+
+		valueChanged := current != nil && (target.NewValue == nil || current.OptionValue != *target.NewValue)
+		if valueChanged && a.Config != nil && target.StageIndex < len(a.Config.Tasks) {
+			if err := a.flagDependentAnnotations(ctx, w, event); err != nil {
+				return fmt.Errorf("while flagging dependent annotations: %w", err)
+			}
+		}
+		if a.Config != nil && target.StageIndex < len(a.Config.Tasks) {
+			if err := a.unflagDependentAnnotations(ctx, w, event); err != nil {
+				return fmt.Errorf("while clearing flags of dependent annotations: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// canSeeEvent tells whether the user may see an event: reviewers see everything,
+// everyone else only the changes to or by themselves
+func (a *AnnotatorApp) canSeeEvent(user *RequestUser, event *domain.AnnotationEvent) bool {
+	return user.CanReview() || event.Username == user.Name || event.Actor == user.Name
+}
+
+// canRestoreEvent tells whether the user may restore the state recorded by an event
+func (a *AnnotatorApp) canRestoreEvent(user *RequestUser, event *domain.AnnotationEvent) bool {
+	if user.CanReview() {
+		return true
+	}
+	return event.Username == user.Name && user.CanAnnotateTask(a.StageName(event.StageIndex))
+}
+
+// StageName returns the ID of the task at a stage index, for display purposes
+func (a *AnnotatorApp) StageName(stageIndex int) string {
+	if a.Config != nil && stageIndex >= 0 && stageIndex < len(a.Config.Tasks) {
+		return a.Config.Tasks[stageIndex].ID
+	}
+	return fmt.Sprintf("stage %d", stageIndex)
+}
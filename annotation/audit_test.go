@@ -0,0 +1,133 @@
+package annotation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lewtec/rotulador/internal/domain"
+)
+
+func TestAuditLog(t *testing.T) {
+	app := newTestApp(t, testConfigTasks, 2)
+	handler := app.GetHTTPHandler()
+	ctx := context.Background()
+
+	submit := func(taskID, value string) {
+		t.Helper()
+		err := app.SubmitAnnotation(ctx, AnnotationResponse{ImageID: testImageHash(0), TaskID: taskID, User: "admin", Value: value, ClientIP: "10.0.0.1"})
+		if err != nil {
+			t.Fatalf("SubmitAnnotation() error = %v", err)
+		}
+	}
+	submit("has_car", "true")
+	submit("car_kind", "toy")
+	submit("has_car", "false")
+
+	events, err := app.ImageEvents(ctx, testImageHash(0))
+	if err != nil {
+		t.Fatalf("ImageEvents() error = %v", err)
+	}
+
+	t.Run("records every change", func(t *testing.T) {
+		var types []string
+		for _, event := range events {
+			types = append(types, event.Type)
+		}
+		want := "flag,update,create,create"
+		if got := strings.Join(types, ","); got != want {
+			t.Fatalf("events = %s, want %s", got, want)
+		}
+		update := events[1]
+		if update.OldValue == nil || *update.OldValue != "true" || update.NewValue == nil || *update.NewValue != "false" {
+			t.Errorf("unexpected update values: %+v", update)
+		}
+		if update.Actor != "admin" || update.ClientIP != "10.0.0.1" || update.ConfigVersion != app.Config.Version {
+			t.Errorf("unexpected update metadata: %+v", update)
+		}
+	})
+
+	t.Run("is append-only", func(t *testing.T) {
+		if _, err := app.Database.ExecContext(ctx, "UPDATE annotation_events SET new_value = 'x'"); err == nil {
+			t.Error("expected UPDATE to be rejected")
+		}
+		if _, err := app.Database.ExecContext(ctx, "DELETE FROM annotation_events"); err == nil {
+			t.Error("expected DELETE to be rejected")
+		}
+	})
+
+	t.Run("restores a previous value", func(t *testing.T) {
+		firstCreate := events[3]
+		if _, err := app.RestoreAnnotationEvent(ctx, firstCreate.ID, "reviewer", "10.0.0.2"); err != nil {
+			t.Fatalf("RestoreAnnotationEvent() error = %v", err)
+		}
+		ann, err := app.annotationRepo.Get(ctx, testImageHash(0), "admin", 0)
+		if err != nil || ann == nil {
+			t.Fatalf("annotation not found: %v", err)
+		}
+		if ann.OptionValue != "true" {
+			t.Errorf("value = %q, want true", ann.OptionValue)
+		}
+		// Restoring has_car to true clears the flag it left on car_kind
+		latest, _ := app.ImageEvents(ctx, testImageHash(0))
+		if latest[1].Type != domain.AnnotationEventRestore || latest[1].Actor != "reviewer" {
+			t.Errorf("expected a restore event, got %+v", latest[1])
+		}
+		if latest[0].Type != domain.AnnotationEventUnflag || latest[0].StageIndex != 1 || latest[0].Actor != "reviewer" {
+			t.Errorf("expected an unflag event, got %+v", latest[0])
+		}
+	})
+
+	t.Run("restores to a deleted state", func(t *testing.T) {
+		if _, err := app.annotationRepo.Create(ctx, testImageHash(1), "admin", 0, "true"); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		value := "true"
+		deleted, err := app.eventRepo.Create(ctx, domain.AnnotationEvent{
+			Type:        domain.AnnotationEventDelete,
+			ImageSHA256: testImageHash(1),
+			Username:    "admin",
+			OldValue:    &value,
+			Actor:       "admin",
+		})
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		if _, err := app.RestoreAnnotationEvent(ctx, deleted.ID, "admin", ""); err != nil {
+			t.Fatalf("RestoreAnnotationEvent() error = %v", err)
+		}
+		if ann, _ := app.annotationRepo.Get(ctx, testImageHash(1), "admin", 0); ann != nil {
+			t.Errorf("expected annotation to be deleted, got %+v", ann)
+		}
+		latest, _ := app.ImageEvents(ctx, testImageHash(1))
+		if latest[0].Type != domain.AnnotationEventDelete || latest[0].OldValue == nil || *latest[0].OldValue != "true" || latest[0].NewValue != nil {
+			t.Errorf("expected a delete event, got %+v", latest[0])
+		}
+	})
+
+	t.Run("web views list and restore events", func(t *testing.T) {
+		rec := doRequest(t, handler, http.MethodGet, "/audit/image/"+testImageHash(0), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), "10.0.0.1") {
+			t.Error("expected the client IP to be shown")
+		}
+
+		rec = doRequest(t, handler, http.MethodGet, "/audit/user/admin", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+
+		rec = doRequest(t, handler, http.MethodPost, fmt.Sprintf("/audit/restore/%d", events[1].ID), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		ann, _ := app.annotationRepo.Get(ctx, testImageHash(0), "admin", 0)
+		if ann == nil || ann.OptionValue != "false" {
+			t.Errorf("expected annotation restored to false, got %+v", ann)
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package annotation
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// User roles, from least to most privileged annotation rights
+const (
+	// RoleViewer can follow progress and stats but not annotate
+	RoleViewer = "viewer"
+	// RoleAnnotator can annotate and review their own work
+	RoleAnnotator = "annotator"
+	// RoleReviewer can also inspect and restore the work of everyone
+	RoleReviewer = "reviewer"
+	// RoleAdmin can do everything
+	RoleAdmin = "admin"
+)
+
+var validRoles = []string{RoleViewer, RoleAnnotator, RoleReviewer, RoleAdmin}
+
+// CanAnnotate tells whether the user may submit annotations at all
+func (c *ConfigAuth) CanAnnotate() bool {
+	return c.Role != RoleViewer
+}
+
+// CanReview tells whether the user may inspect and change the annotations of others
+func (c *ConfigAuth) CanReview() bool {
+	return c.Role == RoleReviewer || c.Role == RoleAdmin
+}
+
+// IsAdmin tells whether the user has the admin role
+func (c *ConfigAuth) IsAdmin() bool {
+	return c.Role == RoleAdmin
+}
+
+// CanViewStats tells whether the user may see the productivity of everyone
+func (c *ConfigAuth) CanViewStats() bool {
+	return c.Role != RoleAnnotator
+}
+
+// CanSeeTask tells whether the task passes the allow and deny lists of the user
+func (c *ConfigAuth) CanSeeTask(taskID string) bool {
+	if len(c.AllowTasks) > 0 && !slices.Contains(c.AllowTasks, taskID) {
+		return false
+	}
+	return !slices.Contains(c.DenyTasks, taskID)
+}
+
+// CanAnnotateTask tells whether the user may submit annotations for a task
+func (c *ConfigAuth) CanAnnotateTask(taskID string) bool {
+	return c.CanAnnotate() && c.CanSeeTask(taskID)
+}
+
+// validateAuth defaults and checks the role and task lists of a user
+func validateAuth(username string, auth *ConfigAuth, tasks []*ConfigTask) error {
+	if auth.Role == "" {
+		auth.Role = RoleAnnotator
+	}
+	if !slices.Contains(validRoles, auth.Role) {
+		return fmt.Errorf("user %s has an invalid role %q: must be one of %v", username, auth.Role, validRoles)
+	}
+	for _, taskID := range slices.Concat(auth.AllowTasks, auth.DenyTasks) {
+		if !slices.ContainsFunc(tasks, func(task *ConfigTask) bool { return task.ID == taskID }) {
+			return fmt.Errorf("user %s references task %s which does not exist", username, taskID)
+		}
+	}
+	return nil
+}
+
+// validate parses the trusted proxies and defaults the role of provisioned users
+func (p *ConfigProxyAuth) validate() error {
+	if p.Header == "" {
+		return fmt.Errorf("header is required")
+	}
+	if len(p.TrustedProxies) == 0 {
+		return fmt.Errorf("trusted_proxies is required, the header would be trusted from anyone otherwise")
+	}
+	var err error
+	if p.trustedPrefixes, err = parsePrefixes(p.TrustedProxies); err != nil {
+		return err
+	}
+	if p.DefaultRole == "" {
+		p.DefaultRole = RoleAnnotator
+	}
+	if !slices.Contains(validRoles, p.DefaultRole) {
+		return fmt.Errorf("invalid default_role %q: must be one of %v", p.DefaultRole, validRoles)
+	}
+	return nil
+}
+
+// trusts tells whether a request comes straight from a trusted proxy
+func (p *ConfigProxyAuth) trusts(r *http.Request) bool {
+	return prefixesContain(p.trustedPrefixes, r)
+}
+
+// parsePrefixes parses a list of CIDRs or single addresses
+func parsePrefixes(networks []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, network := range networks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(network)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid network %q: %w", network, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// prefixesContain tells whether a request comes straight from an address within prefixes
+func prefixesContain(prefixes []netip.Prefix, r *http.Request) bool {
+	addr, err := netip.ParseAddr(clientIP(r))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(prefixes, func(prefix netip.Prefix) bool {
+		return prefix.Contains(addr)
+	})
+}
+
+// proxyUser returns the user named by the proxy header, or nil when the
+// header is missing, comes from an untrusted address or names an unknown user
+func (a *AnnotatorApp) proxyUser(r *http.Request) *RequestUser {
+	proxy := a.Config.ProxyAuth
+	if proxy == nil {
+		return nil
+	}
+	username := strings.TrimSpace(r.Header.Get(proxy.Header))
+	if username == "" {
+		return nil
+	}
+	if !proxy.trusts(r) {
+		slog.WarnContext(r.Context(), "auth: ignoring proxy header from untrusted address", "header", proxy.Header, "client_ip", clientIP(r))
+		return nil
+	}
+	if auth, ok := a.Config.Authentication[username]; ok {
+		return &RequestUser{Name: username, ConfigAuth: auth}
+	}
+	if !proxy.AutoProvision {
+		slog.WarnContext(r.Context(), "auth: proxy user not listed in auth and auto_provision is off", "user", username)
+		return nil
+	}
+	return &RequestUser{Name: username, ConfigAuth: a.provisionedAuth(username)}
+}
+
+// provisionedAuth returns the settings of a user the proxy introduced, the same for every request
+func (a *AnnotatorApp) provisionedAuth(username string) *ConfigAuth {
+	auth, loaded := a.provisionedUsers.Load(username)
+	if !loaded {
+		auth, loaded = a.provisionedUsers.LoadOrStore(username, &ConfigAuth{Role: a.Config.ProxyAuth.DefaultRole})
+		if !loaded {
+			a.auditLogger().Info("provisioned proxy user", "user", username, "role", a.Config.ProxyAuth.DefaultRole)
+		}
+	}
+	return auth.(*ConfigAuth)
+}
+
+// RequestUser is the authenticated user of a request
+type RequestUser struct {
+	Name string
+	*ConfigAuth
+	// Session tells whether the user logged in from the login page rather than with Basic auth
+	Session bool
+	// Scopes of the API token the user authenticated with, nil otherwise
+	Scopes []string
+}
+
+type requestUserKey struct{}
+
+// WithRequestUser adds the authenticated user to the context
+func WithRequestUser(ctx context.Context, user *RequestUser) context.Context {
+	return context.WithValue(ctx, requestUserKey{}, user)
+}
+
+// GetUserFromContext returns the authenticated user, or nil outside of an authenticated request
+func GetUserFromContext(ctx context.Context) *RequestUser {
+	if ctx == nil {
+		return nil
+	}
+	user, _ := ctx.Value(requestUserKey{}).(*RequestUser)
+	return user
+}
+
+// requestUser returns the authenticated user of a request. The authentication
+// middleware guarantees there is one in every handler.
+func requestUser(r *http.Request) *RequestUser {
+	if user := GetUserFromContext(r.Context()); user != nil {
+		return user
+	}
+	return &RequestUser{ConfigAuth: &ConfigAuth{Role: RoleViewer}}
+}
@@ -0,0 +1,189 @@
+package annotation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	app := newTestAppWithUsers(t, map[string]string{
+		"alice":  "role: annotator, deny_tasks: [car_kind]",
+		"bob":    "allow_tasks: [car_kind]",
+		"victor": "role: viewer",
+		"rita":   "role: reviewer",
+	}, testConfigTasks, 3)
+	handler := app.GetHTTPHandler()
+	ctx := context.Background()
+
+	t.Run("next step only hands out allowed tasks", func(t *testing.T) {
+		if err := app.SubmitAnnotation(ctx, AnnotationResponse{ImageID: testImageHash(0), TaskID: "has_car", User: "admin", Value: "true"}); err != nil {
+			t.Fatalf("SubmitAnnotation() error = %v", err)
+		}
+		bob := &RequestUser{Name: "bob", ConfigAuth: app.Config.Authentication["bob"]}
+		step, err := app.NextAnnotationStep(ctx, bob, "")
+		if err != nil || step == nil || step.TaskID != "car_kind" {
+			t.Fatalf("NextAnnotationStep(bob) = %+v, %v, want a car_kind step", step, err)
+		}
+		alice := &RequestUser{Name: "alice", ConfigAuth: app.Config.Authentication["alice"]}
+		if step, _ := app.NextAnnotationStep(ctx, alice, "car_kind"); step != nil {
+			t.Errorf("alice got a denied task: %+v", step)
+		}
+	})
+
+	for _, tc := range []struct {
+		user, method, target string
+		want                 int
+	}{
+		{"alice", http.MethodGet, "/annotate/has_car/" + testImageHash(1), http.StatusOK},
+		{"alice", http.MethodGet, "/annotate/car_kind/" + testImageHash(0), http.StatusForbidden},
+		{"bob", http.MethodGet, "/grid/has_car", http.StatusForbidden},
+		{"victor", http.MethodGet, "/annotate/", http.StatusForbidden},
+		{"victor", http.MethodGet, "/stats", http.StatusOK},
+		{"alice", http.MethodGet, "/stats", http.StatusForbidden},
+		{"alice", http.MethodGet, "/help/car_kind", http.StatusNotFound},
+		{"alice", http.MethodGet, "/audit/user/rita", http.StatusForbidden},
+		{"alice", http.MethodGet, "/audit/user/alice", http.StatusOK},
+		{"rita", http.MethodGet, "/audit/user/alice", http.StatusOK},
+	} {
+		t.Run(fmt.Sprintf("%s %s %s", tc.user, tc.method, tc.target), func(t *testing.T) {
+			rec := doRequestAs(t, handler, tc.user, tc.method, tc.target, nil)
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+
+	t.Run("help timeline hides denied tasks", func(t *testing.T) {
+		body := doRequestAs(t, handler, "alice", http.MethodGet, "/help/", nil).Body.String()
+		if strings.Contains(body, "task=car_kind") || !strings.Contains(body, "task=has_car") {
+			t.Error("expected only has_car in the timeline")
+		}
+	})
+
+	t.Run("only reviewers restore the work of others", func(t *testing.T) {
+		events, err := app.ImageEvents(ctx, testImageHash(0))
+		if err != nil || len(events) == 0 {
+			t.Fatalf("ImageEvents() = %v, %v", events, err)
+		}
+		target := fmt.Sprintf("/audit/restore/%d", events[0].ID)
+		if rec := doRequestAs(t, handler, "alice", http.MethodPost, target, url.Values{}); rec.Code != http.StatusNotFound {
+			t.Errorf("alice: status = %d, want 404", rec.Code)
+		}
+		if rec := doRequestAs(t, handler, "rita", http.MethodPost, target, url.Values{}); rec.Code != http.StatusOK {
+			t.Errorf("rita: status = %d, want 200", rec.Code)
+		}
+	})
+}
+
+func TestLoadConfig_Roles(t *testing.T) {
+	for name, fields := range map[string]string{
+		"unknown role":         "role: superuser",
+		"unknown allowed task": "allow_tasks: [nope]",
+		"unknown denied task":  "deny_tasks: [nope]",
+	} {
+		t.Run("rejects "+name, func(t *testing.T) {
+			configPath := writeTestConfigWithUsers(t, map[string]string{"alice": fields}, testConfigTasks)
+			if _, err := LoadConfig(configPath); err == nil {
+				t.Error("expected an error, got none")
+			}
+		})
+	}
+
+	t.Run("defaults to annotator", func(t *testing.T) {
+		config, err := LoadConfig(writeTestConfigWithUsers(t, map[string]string{"alice": "deny_tasks: []"}, testConfigTasks))
+		if err != nil {
+			t.Fatalf("LoadConfig() error = %v", err)
+		}
+		if role := config.Authentication["alice"].Role; role != RoleAnnotator {
+			t.Errorf("role = %q, want %q", role, RoleAnnotator)
+		}
+	})
+}
+
+func TestProxyAuth(t *testing.T) {
+	proxyConfig := func(trusted string, autoProvision bool) string {
+		return fmt.Sprintf("proxy_auth:\n  header: X-Forwarded-User\n  trusted_proxies: [%s]\n  auto_provision: %t\n  default_role: viewer\n", trusted, autoProvision)
+	}
+	// httptest requests come from 192.0.2.1
+	asProxy := func(t *testing.T, handler http.Handler, username, method, target string, form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		var req *http.Request
+		if form != nil {
+			req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(method, target, nil)
+		}
+		req.Header.Set("X-Forwarded-User", username)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("trusted proxy", func(t *testing.T) {
+		app := newTestAppWithUsers(t, map[string]string{"alice": "role: annotator"}, proxyConfig("192.0.2.0/24", false)+testConfigTasks, 1)
+		handler := app.GetHTTPHandler()
+
+		rec := asProxy(t, handler, "alice", http.MethodPost, "/annotate/has_car/"+testImageHash(0), url.Values{"selectedClass": {"true"}, "sure": {"on"}})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		ann, err := app.annotationRepo.Get(context.Background(), testImageHash(0), "alice", 0)
+		if err != nil || ann == nil {
+			t.Fatalf("annotation of alice not found: %v", err)
+		}
+		if rec := asProxy(t, handler, "mallory", http.MethodGet, "/me/history", nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("user missing from auth: status = %d, want 401", rec.Code)
+		}
+	})
+
+	t.Run("untrusted address", func(t *testing.T) {
+		app := newTestAppWithUsers(t, nil, proxyConfig("10.0.0.0/8, 127.0.0.1", true)+testConfigTasks, 1)
+		if rec := asProxy(t, app.GetHTTPHandler(), "admin", http.MethodGet, "/stats", nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", rec.Code)
+		}
+	})
+
+	t.Run("auto provisioning", func(t *testing.T) {
+		app := newTestAppWithUsers(t, nil, proxyConfig("192.0.2.1", true)+testConfigTasks, 1)
+		handler := app.GetHTTPHandler()
+		if rec := asProxy(t, handler, "newcomer", http.MethodGet, "/stats", nil); rec.Code != http.StatusOK {
+			t.Errorf("stats: status = %d, want 200", rec.Code)
+		}
+		if rec := asProxy(t, handler, "newcomer", http.MethodGet, "/annotate/", nil); rec.Code != http.StatusForbidden {
+			t.Errorf("annotate as viewer: status = %d, want 403", rec.Code)
+		}
+	})
+}
+
+func TestLoadConfig_ProxyAuth(t *testing.T) {
+	for name, content := range map[string]string{
+		"no header":            "proxy_auth: { trusted_proxies: [10.0.0.1] }\nauth: { alice: {} }\ntasks: []\n",
+		"no trusted proxies":   "proxy_auth: { header: X-User }\nauth: { alice: {} }\ntasks: []\n",
+		"invalid proxy":        "proxy_auth: { header: X-User, trusted_proxies: [10.0.0.0/33] }\nauth: { alice: {} }\ntasks: []\n",
+		"invalid default role": "proxy_auth: { header: X-User, trusted_proxies: [10.0.0.1], auto_provision: true, default_role: boss }\ntasks: []\n",
+		"no users":             "proxy_auth: { header: X-User, trusted_proxies: [10.0.0.1] }\ntasks: []\n",
+	} {
+		t.Run("rejects "+name, func(t *testing.T) {
+			if _, err := LoadConfig(writeRawConfig(t, content)); err == nil {
+				t.Error("expected an error, got none")
+			}
+		})
+	}
+
+	t.Run("users of the proxy need no password", func(t *testing.T) {
+		config, err := LoadConfig(writeRawConfig(t, "proxy_auth: { header: X-User, trusted_proxies: [10.0.0.1] }\nauth: { alice: {} }\ntasks: []\n"))
+		if err != nil {
+			t.Fatalf("LoadConfig() error = %v", err)
+		}
+		app := &AnnotatorApp{Config: config}
+		if app.CheckCredentials("alice", "") != nil {
+			t.Error("empty password accepted")
+		}
+	})
+}
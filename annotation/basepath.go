@@ -0,0 +1,127 @@
+package annotation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Headers holding paths of the app, prefixed with the base path on the way out
+var redirectHeaders = []string{"Location", "HX-Redirect"}
+
+var goroutineBasePaths sync.Map // map[uint64]string
+
+type basePathKey struct{}
+
+// NormalizeBasePath checks a URL path prefix to serve the app under, such as
+// /rotulador, returning it with a leading slash and without a trailing one.
+// An empty path, or /, serves the app at the root.
+func NormalizeBasePath(basePath string) (string, error) {
+	basePath = strings.TrimSpace(basePath)
+	if basePath == "" || basePath == "/" {
+		return "", nil
+	}
+	if !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
+	}
+	basePath = strings.TrimRight(basePath, "/")
+	if strings.ContainsAny(basePath, "?#%\\ ") || path.Clean(basePath) != basePath {
+		return "", fmt.Errorf("invalid base path %q, use a plain path such as /rotulador", basePath)
+	}
+	return basePath, nil
+}
+
+// url returns the address of a path of the app, such as /help/, under the base path
+func (a *AnnotatorApp) url(path string) string {
+	return a.BasePath + path
+}
+
+// basePathMiddleware serves the app under BasePath. The prefix is removed from
+// the requests, so the mux routes them as usual, and added to the paths of
+// redirects, those made by the mux included. Pages link under it with the url
+// function of the templates. Requests outside of it are not found.
+func (a *AnnotatorApp) basePathMiddleware(handler http.Handler) http.Handler {
+	if a.BasePath == "" {
+		return handler
+	}
+	stripped := http.StripPrefix(a.BasePath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), basePathKey{}, a.BasePath)
+		bw := &basePathWriter{ResponseWriter: w, basePath: a.BasePath}
+		handler.ServeHTTP(bw, r.WithContext(ctx))
+		// Handlers that only set headers, such as HX-Redirect, leave the
+		// response to be written by the server, past this writer
+		bw.prefixRedirects()
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == a.BasePath {
+			target := a.BasePath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, a.BasePath+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		stripped.ServeHTTP(w, r)
+	})
+}
+
+// basePathFromContext returns the base path a request was served under
+func basePathFromContext(ctx context.Context) string {
+	basePath, _ := ctx.Value(basePathKey{}).(string)
+	return basePath
+}
+
+// templateURL is the url function of the templates, prefixing a path of the
+// app with the base path of the page being rendered
+func templateURL(path string) string {
+	if basePath, ok := goroutineBasePaths.Load(getGoroutineID()); ok {
+		return basePath.(string) + path
+	}
+	return path
+}
+
+// basePathWriter prefixes the paths of the app in redirect headers with the base path
+type basePathWriter struct {
+	http.ResponseWriter
+	basePath    string
+	wroteHeader bool
+}
+
+// prefixRedirects adds the base path to the redirect headers, once, before the headers are sent
+func (w *basePathWriter) prefixRedirects() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	header := w.Header()
+	for _, name := range redirectHeaders {
+		// Paths starting with // are addresses of other hosts
+		if value := header.Get(name); strings.HasPrefix(value, "/") && !strings.HasPrefix(value, "//") {
+			header.Set(name, w.basePath+value)
+		}
+	}
+}
+
+func (w *basePathWriter) WriteHeader(status int) {
+	w.prefixRedirects()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *basePathWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the original writer, to flush event streams
+func (w *basePathWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,114 @@
+package annotation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeBasePath(t *testing.T) {
+	for input, want := range map[string]string{
+		"":             "",
+		"/":            "",
+		"/rotulador":   "/rotulador",
+		"rotulador/":   "/rotulador",
+		"/tools/label": "/tools/label",
+	} {
+		if got, err := NormalizeBasePath(input); err != nil || got != want {
+			t.Errorf("NormalizeBasePath(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"/a/../b", "/a//b", "/a?b", "/a b", "/a%2Fb"} {
+		if _, err := NormalizeBasePath(input); err == nil {
+			t.Errorf("NormalizeBasePath(%q) should fail", input)
+		}
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	app := newTestApp(t, testConfigTasks, 2)
+	app.BasePath = "/rotulador"
+	handler := app.GetHTTPHandler()
+
+	t.Run("pages link under the base path", func(t *testing.T) {
+		rec := doRequest(t, handler, http.MethodGet, "/rotulador/help/has_car", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		body := rec.Body.String()
+		for _, link := range []string{`href="/rotulador/"`, `href="/rotulador/grid/has_car"`, `sse-connect="/rotulador/events"`, `href="/rotulador/annotate?task=has_car"`, `href="/rotulador/help"`} {
+			if !strings.Contains(body, link) {
+				t.Errorf("page does not link to %s", link)
+			}
+		}
+		if strings.Contains(body, `href="/help`) || strings.Contains(body, `hx-get="/help`) {
+			t.Error("page links outside of the base path")
+		}
+	})
+
+	t.Run("redirects stay under the base path", func(t *testing.T) {
+		for target, want := range map[string]string{
+			"/rotulador":           "/rotulador/",
+			"/rotulador/help":      "/rotulador/help/",
+			"/rotulador/annotate/": "/rotulador/annotate/has_car/",
+		} {
+			rec := doRequest(t, handler, http.MethodGet, target, nil)
+			if location := rec.Header().Get("Location"); !strings.HasPrefix(location, want) {
+				t.Errorf("GET %s redirects to %q, want %s", target, location, want)
+			}
+		}
+		req := httptest.NewRequest(http.MethodGet, "/rotulador/stats?days=7", nil)
+		req.Header.Set("Accept", "text/html")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if location := rec.Header().Get("Location"); location != "/rotulador/login?next="+url.QueryEscape("/stats?days=7") {
+			t.Errorf("anonymous request redirects to %q", location)
+		}
+	})
+
+	t.Run("answers go on to pages under the base path", func(t *testing.T) {
+		rec := doRequest(t, handler, http.MethodPost, "/rotulador/annotate/has_car/"+testImageHash(0), url.Values{"selectedClass": {"true"}, "sure": {"on"}})
+		if redirect := rec.Header().Get("HX-Redirect"); rec.Code != http.StatusOK || redirect != "/rotulador/annotate/has_car/"+testImageHash(1) {
+			t.Errorf("annotate: status = %d, HX-Redirect = %q", rec.Code, redirect)
+		}
+		rec = doRequest(t, handler, http.MethodPost, "/rotulador/grid/has_car", url.Values{"image": {testImageHash(1)}})
+		if redirect := rec.Header().Get("HX-Redirect"); rec.Code != http.StatusOK || !strings.HasPrefix(redirect, "/rotulador/") {
+			t.Errorf("grid: status = %d, HX-Redirect = %q", rec.Code, redirect)
+		}
+	})
+
+	t.Run("sessions are scoped to the base path", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/rotulador/login", strings.NewReader(url.Values{"username": {"admin"}, "password": {"changeme"}, "next": {"/help/"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if location := rec.Header().Get("Location"); location != "/rotulador/help/" {
+			t.Errorf("login redirects to %q", location)
+		}
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Path != "/rotulador/" {
+			t.Errorf("cookies = %v", cookies)
+		}
+	})
+
+	t.Run("paths outside of the base path are not found", func(t *testing.T) {
+		for _, target := range []string{"/help/", "/rotuladorx/help/", "/healthz"} {
+			if rec := doRequest(t, handler, http.MethodGet, target, nil); rec.Code != http.StatusNotFound {
+				t.Errorf("GET %s: status = %d, want 404", target, rec.Code)
+			}
+		}
+		if rec := doRequest(t, handler, http.MethodGet, "/rotulador/healthz", nil); rec.Code != http.StatusOK {
+			t.Errorf("health under the base path: status = %d", rec.Code)
+		}
+	})
+
+	t.Run("the API returns image addresses under the base path", func(t *testing.T) {
+		images, _ := app.imageRepo.List(t.Context())
+		rec := doRequest(t, handler, http.MethodGet, "/rotulador/api/v1/images/"+images[0].SHA256, nil)
+		if !strings.Contains(rec.Body.String(), `"url":"/rotulador/asset/`+images[0].SHA256) {
+			t.Errorf("image = %s", rec.Body.String())
+		}
+	})
+}
@@ -1,10 +1,17 @@
 package annotation
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net/netip"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -15,7 +22,26 @@ type Config struct {
 	} `yaml:"meta"`
 	Tasks          []*ConfigTask          `yaml:"tasks"`
 	Authentication map[string]*ConfigAuth `yaml:"auth"`
-	I18N           []ConfigI18N           `yaml:"i18n"`
+	// AllowPlaintextPasswords accepts passwords that are not hashed in the auth section
+	AllowPlaintextPasswords bool `yaml:"allow_plaintext_passwords"`
+	// ProxyAuth takes the username from a header set by an authenticating reverse proxy
+	ProxyAuth *ConfigProxyAuth `yaml:"proxy_auth"`
+	// OIDC signs users in with an OpenID Connect provider
+	OIDC *ConfigOIDC `yaml:"oidc"`
+	// SessionLifetime is how long a login from the login page lasts, 12h by default
+	SessionLifetime time.Duration `yaml:"session_lifetime"`
+	// LoginThrottle locks out clients and users after failed logins
+	LoginThrottle ConfigLoginThrottle `yaml:"login_throttle"`
+	// TrustedOrigins lists other origins, such as https://annotate.example.com,
+	// whose pages may send forms here, see crossOriginMiddleware
+	TrustedOrigins []string `yaml:"trusted_origins"`
+	// Webhooks are called when annotations are submitted, tasks completed or ingestions finished
+	Webhooks []*ConfigWebhook `yaml:"webhooks"`
+	// Metrics lets Prometheus scrape /metrics without a user account
+	Metrics ConfigMetrics `yaml:"metrics"`
+	I18N    []ConfigI18N  `yaml:"i18n"`
+	// Version identifies the config contents, recorded in the audit log
+	Version string `yaml:"-"`
 }
 
 type ConfigI18N struct {
@@ -24,7 +50,80 @@ type ConfigI18N struct {
 }
 
 type ConfigAuth struct {
+	// Password is an argon2id or bcrypt hash, or plaintext when allow_plaintext_passwords is set
 	Password string `yaml:"password"`
+	// Role is one of viewer, annotator (default), reviewer or admin
+	Role string `yaml:"role"`
+	// AllowTasks restricts the user to these task IDs when not empty
+	AllowTasks []string `yaml:"allow_tasks"`
+	// DenyTasks hides these task IDs from the user
+	DenyTasks []string `yaml:"deny_tasks"`
+
+	// Digest of the last password that matched, see CheckPassword
+	verifiedMutex sync.Mutex
+	verified      *[sha256.Size]byte
+}
+
+type ConfigProxyAuth struct {
+	// Header carrying the username, such as X-Forwarded-User
+	Header string `yaml:"header"`
+	// TrustedProxies lists the CIDRs or addresses allowed to set the header
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// AutoProvision accepts users missing from the auth section, with DefaultRole
+	AutoProvision bool `yaml:"auto_provision"`
+	// DefaultRole of auto provisioned users, annotator by default
+	DefaultRole string `yaml:"default_role"`
+
+	trustedPrefixes []netip.Prefix
+}
+
+type ConfigLoginThrottle struct {
+	// MaxFailures of a username within the window before it is locked out, 5 by default
+	MaxFailures int `yaml:"max_failures"`
+	// MaxFailuresPerIP of a client address within the window before it is locked out, 20 by default
+	MaxFailuresPerIP int `yaml:"max_failures_per_ip"`
+	// Window in which failures are counted, 15m by default
+	Window time.Duration `yaml:"window"`
+	// Lockout is how long logins are refused once locked out, 15m by default
+	Lockout time.Duration `yaml:"lockout"`
+}
+
+type ConfigWebhook struct {
+	// URL receiving a POST with a JSON payload for each event
+	URL string `yaml:"url"`
+	// Events to send, any of annotation-created, task-completed and ingestion-finished, all by default
+	Events []string `yaml:"events"`
+	// Secret signs the payloads with HMAC-SHA256 in the X-Rotulador-Signature header when set
+	Secret string `yaml:"secret"`
+}
+
+type ConfigMetrics struct {
+	// Token scrapers may send as "Authorization: Bearer <token>"
+	Token string `yaml:"token"`
+	// AllowNetworks lists the CIDRs or addresses that may scrape without a token
+	AllowNetworks []string `yaml:"allow_networks"`
+
+	allowedPrefixes []netip.Prefix
+}
+
+type ConfigOIDC struct {
+	// Name of the provider on the login button
+	Name string `yaml:"name"`
+	// Issuer URL, its configuration is discovered from /.well-known/openid-configuration
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// RedirectURL is the address of /oidc/callback, taken from the request when empty
+	RedirectURL string   `yaml:"redirect_url"`
+	Scopes      []string `yaml:"scopes"`
+	// UsernameClaim names the ID token claim used as username, preferred_username by default
+	UsernameClaim string `yaml:"username_claim"`
+	// GroupsClaim names the ID token claim listing the groups of the user, groups by default
+	GroupsClaim string `yaml:"groups_claim"`
+	// RoleMapping gives a role to the members of a group, the most privileged one wins
+	RoleMapping map[string]string `yaml:"role_mapping"`
+	// DefaultRole of users no group maps, who are refused when it is empty
+	DefaultRole string `yaml:"default_role"`
 }
 
 type ConfigTask struct {
@@ -34,15 +133,171 @@ type ConfigTask struct {
 	Type      string                  `yaml:"type"`
 	If        map[string]string       `yaml:"if"`
 	Classes   map[string]*ConfigClass `yaml:"classes"`
+	// GridDefault is the class every image starts with in the grid mode (binary tasks only)
+	GridDefault string `yaml:"grid_default"`
+	// ClassOrder keeps the class IDs in the order they were declared in the YAML
+	ClassOrder []string `yaml:"-"`
 }
 
 type ConfigClass struct {
+	ID          string   `yaml:"-"`
 	Name        string   `yaml:"name"`
 	Description string   `yaml:"description"`
 	Examples    []string `yaml:"examples"`
+	Key         string   `yaml:"key"`   // Keyboard shortcut, one or more characters typed in sequence
+	Color       string   `yaml:"color"` // CSS color for the class button
+	Icon        string   `yaml:"icon"`  // Short text or emoji shown in the class button
+}
+
+// UnmarshalYAML decodes a task while recording the declaration order of its classes
+func (t *ConfigTask) UnmarshalYAML(value *yaml.Node) error {
+	type rawConfigTask ConfigTask
+	var raw rawConfigTask
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	*t = ConfigTask(raw)
+	t.ClassOrder = nil
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		if value.Content[i].Value != "classes" || value.Content[i+1].Kind != yaml.MappingNode {
+			continue
+		}
+		classes := value.Content[i+1]
+		for j := 0; j+1 < len(classes.Content); j += 2 {
+			t.ClassOrder = append(t.ClassOrder, classes.Content[j].Value)
+		}
+	}
+	return nil
+}
+
+// OrderedClasses returns the task classes in declaration order. Classes
+// missing from ClassOrder are appended sorted by ID.
+func (t *ConfigTask) OrderedClasses() []*ConfigClass {
+	classes := make([]*ConfigClass, 0, len(t.Classes))
+	seen := make(map[string]bool, len(t.Classes))
+	for _, classID := range t.ClassOrder {
+		if class, ok := t.Classes[classID]; ok && !seen[classID] {
+			classes = append(classes, class)
+			seen[classID] = true
+		}
+	}
+	var rest []string
+	for classID := range t.Classes {
+		if !seen[classID] {
+			rest = append(rest, classID)
+		}
+	}
+	sort.Strings(rest)
+	for _, classID := range rest {
+		classes = append(classes, t.Classes[classID])
+	}
+	return classes
+}
+
+// IsBinary reports whether the task has exactly two classes, which enables the grid mode
+func (t *ConfigTask) IsBinary() bool {
+	return len(t.Classes) == 2
+}
+
+// GridClasses returns the class images start with in the grid mode and the
+// class they are toggled to. Both are nil when the task is not binary.
+func (t *ConfigTask) GridClasses() (defaultClass, otherClass *ConfigClass) {
+	if !t.IsBinary() {
+		return nil, nil
+	}
+	for _, class := range t.OrderedClasses() {
+		if class.ID == t.GridDefault {
+			defaultClass = class
+		} else {
+			otherClass = class
+		}
+	}
+	return defaultClass, otherClass
+}
+
+// reservedKeys are keyboard shortcuts used by the annotate page itself
+var reservedKeys = []string{"?"}
+
+// autoKeys is the pool of single-character shortcuts handed out to classes without an explicit key
+const autoKeys = "123456789abcdefghijklmnopqrstuvwxyz"
+
+// cssColorPattern accepts hex colors and named CSS colors
+var cssColorPattern = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+
+// keysConflict reports whether two shortcuts can't be told apart while typing
+// a sequence: either they are equal or one of them is a prefix of the other
+func keysConflict(a, b string) bool {
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// assignClassKeys validates explicit class shortcuts and assigns shortcuts to
+// the remaining classes. Digits and letters are used first; when the list is
+// too long for single keys, two-key chords are used instead.
+func assignClassKeys(task *ConfigTask) error {
+	used := append([]string{}, reservedKeys...)
+	isFree := func(key string) bool {
+		for _, other := range used {
+			if keysConflict(key, other) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var pending []*ConfigClass
+	for _, class := range task.OrderedClasses() {
+		if class.Key == "" {
+			pending = append(pending, class)
+			continue
+		}
+		key := strings.ToLower(class.Key)
+		if strings.ContainsAny(key, " \t") {
+			return fmt.Errorf("task %s: class %s: key %q must not contain spaces", task.ID, class.ID, class.Key)
+		}
+		for _, other := range used {
+			if keysConflict(key, other) {
+				return fmt.Errorf("task %s: class %s: key %q collides with key %q", task.ID, class.ID, class.Key, other)
+			}
+		}
+		class.Key = key
+		used = append(used, key)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	var candidates []string
+	for _, c := range autoKeys {
+		if isFree(string(c)) {
+			candidates = append(candidates, string(c))
+		}
+	}
+	if len(pending) > len(candidates) {
+		candidates = nil
+		for _, first := range autoKeys {
+			if !isFree(string(first)) {
+				continue
+			}
+			for _, second := range autoKeys {
+				candidates = append(candidates, string(first)+string(second))
+			}
+		}
+	}
+	if len(pending) > len(candidates) {
+		return fmt.Errorf("task %s: too many classes to assign keyboard shortcuts", task.ID)
+	}
+	for idx, class := range pending {
+		class.Key = candidates[idx]
+	}
+	return nil
 }
 
 func LoadConfig(filename string) (*Config, error) {
+	return loadConfig(filename, nil)
+}
+
+// loadConfig is LoadConfig, with addUsers changing the users of the config before they are checked
+func loadConfig(filename string, addUsers func(*Config) error) (*Config, error) {
 	var ret Config
 	f, err := os.Open(filename)
 	defer f.Close()
@@ -57,6 +312,12 @@ func LoadConfig(filename string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	ret.Version = fmt.Sprintf("%x", sha256.Sum256(data))[:12]
+	if addUsers != nil {
+		if err := addUsers(&ret); err != nil {
+			return nil, err
+		}
+	}
 	_taskDict := map[string]string{}
 	for _, task := range ret.Tasks {
 		taskName := task.ID
@@ -72,15 +333,78 @@ func LoadConfig(filename string) (*Config, error) {
 			task.ShortName = task.Name
 		}
 		if task.Classes == nil {
-			task.Classes = getClassesFromClassType(task.Type)
+			task.ClassOrder, task.Classes = getClassesFromClassType(task.Type)
 		}
 		if task.Classes == nil {
 			return nil, fmt.Errorf("task %s does not have any classes or a compatible type", taskName)
 		}
+		for classID, class := range task.Classes {
+			if class == nil {
+				class = &ConfigClass{}
+				task.Classes[classID] = class
+			}
+			class.ID = classID
+			if class.Color != "" && !cssColorPattern.MatchString(class.Color) {
+				return nil, fmt.Errorf("task %s: class %s: invalid color %q", taskName, classID, class.Color)
+			}
+		}
+		if err := assignClassKeys(task); err != nil {
+			return nil, err
+		}
+		if task.GridDefault != "" {
+			if _, ok := task.Classes[task.GridDefault]; !ok {
+				return nil, fmt.Errorf("task %s: grid_default %q is not one of its classes", taskName, task.GridDefault)
+			}
+		} else if task.IsBinary() {
+			if _, ok := task.Classes["false"]; ok {
+				task.GridDefault = "false"
+			} else {
+				task.GridDefault = task.OrderedClasses()[0].ID
+			}
+		}
+	}
+	if ret.ProxyAuth != nil {
+		if err := ret.ProxyAuth.validate(); err != nil {
+			return nil, fmt.Errorf("proxy_auth: %w", err)
+		}
+	}
+	if ret.OIDC != nil {
+		if err := ret.OIDC.validate(); err != nil {
+			return nil, fmt.Errorf("oidc: %w", err)
+		}
+	}
+	if err := ret.LoginThrottle.validate(); err != nil {
+		return nil, fmt.Errorf("login_throttle: %w", err)
+	}
+	for idx, origin := range ret.TrustedOrigins {
+		if err := validateOrigin(origin); err != nil {
+			return nil, fmt.Errorf("trusted_origins: %w", err)
+		}
+		ret.TrustedOrigins[idx] = strings.TrimSuffix(origin, "/")
+	}
+	webhookURLs := map[string]bool{}
+	for idx, webhook := range ret.Webhooks {
+		if err := webhook.validate(); err != nil {
+			return nil, fmt.Errorf("webhooks[%d]: %w", idx, err)
+		}
+		// Deliveries are queued by URL
+		if webhookURLs[webhook.URL] {
+			return nil, fmt.Errorf("webhooks[%d]: url %s is listed twice", idx, webhook.URL)
+		}
+		webhookURLs[webhook.URL] = true
+	}
+	if err := ret.Metrics.validate(); err != nil {
+		return nil, fmt.Errorf("metrics: %w", err)
 	}
-	if len(ret.Authentication) == 0 {
+	if len(ret.Authentication) == 0 && ret.OIDC == nil && (ret.ProxyAuth == nil || !ret.ProxyAuth.AutoProvision) {
 		return nil, fmt.Errorf("no users specified")
 	}
+	if ret.SessionLifetime == 0 {
+		ret.SessionLifetime = DefaultSessionLifetime
+	}
+	if ret.SessionLifetime < time.Minute {
+		return nil, fmt.Errorf("session_lifetime must be at least one minute")
+	}
 	// Load i18n strings from YAML config into default locale
 	if len(ret.I18N) > 0 {
 		for _, term := range ret.I18N {
@@ -92,23 +416,40 @@ func LoadConfig(filename string) (*Config, error) {
 			}
 			// Add to bundle as English messages
 			if err := AddMessage("en", term.Name, term.Value); err != nil {
-				log.Printf("Warning: failed to add i18n message %s: %v", term.Name, err)
+				slog.Warn("failed to add i18n message", "name", term.Name, "error", err)
 			}
 		}
-		log.Printf("Loaded %d i18n strings from YAML config", len(ret.I18N))
+		slog.Debug("loaded i18n strings from config", "count", len(ret.I18N))
 	}
 	for user := range ret.Authentication {
+		if ret.Authentication[user] == nil {
+			ret.Authentication[user] = &ConfigAuth{}
+		}
+		if err := validateAuth(user, ret.Authentication[user], ret.Tasks); err != nil {
+			return nil, err
+		}
 		if ret.Authentication[user].Password == "" {
-			return nil, fmt.Errorf("user %s has a null password", user)
+			// Users of the proxy or single sign-on need no password, they just cannot log in any other way
+			if ret.ProxyAuth == nil && ret.OIDC == nil {
+				return nil, fmt.Errorf("user %s has a null password", user)
+			}
+			continue
+		}
+		if IsPasswordHash(ret.Authentication[user].Password) {
+			continue
 		}
+		if !ret.AllowPlaintextPasswords {
+			return nil, fmt.Errorf("user %s has a plaintext password: hash it with 'rotulador passwd %s' or set allow_plaintext_passwords", user, user)
+		}
+		slog.Warn("user has a plaintext password, hash it with 'rotulador passwd <user>'", "user", user)
 	}
 	return &ret, nil
 }
 
-func getClassesFromClassType(classType string) map[string]*ConfigClass {
+func getClassesFromClassType(classType string) ([]string, map[string]*ConfigClass) {
 	switch classType {
 	case "boolean":
-		return map[string]*ConfigClass{
+		return []string{"true", "false"}, map[string]*ConfigClass{
 			"true": {
 				Name: "Yes",
 			},
@@ -117,7 +458,7 @@ func getClassesFromClassType(classType string) map[string]*ConfigClass {
 			},
 		}
 	case "rotation":
-		return map[string]*ConfigClass{
+		return []string{"ok", "h_inv", "v_inv", "+90", "-90", "180"}, map[string]*ConfigClass{
 			"ok": {
 				Name:        "OK",
 				Description: "Not rotated",
@@ -144,6 +485,6 @@ func getClassesFromClassType(classType string) map[string]*ConfigClass {
 			},
 		}
 	default:
-		return nil
+		return nil, nil
 	}
 }
@@ -0,0 +1,163 @@
+package annotation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testPasswordHash is the hash of "changeme", computed once as hashing is slow on purpose
+var testPasswordHash = sync.OnceValue(func() string {
+	hash, err := HashPassword("changeme", PasswordArgon2id)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// writeTestConfig writes a config file with the given tasks section and a default user
+// "admin" with password "changeme"
+func writeTestConfig(t *testing.T, tasks string) string {
+	t.Helper()
+	return writeTestConfigWithUsers(t, nil, tasks)
+}
+
+// writeRawConfig writes a config file with exactly the given content
+func writeRawConfig(t *testing.T, content string) string {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return configPath
+}
+
+// writeTestConfigWithUsers is writeTestConfig with additional users, given as
+// username to extra YAML fields. Every user has the password "changeme".
+func writeTestConfigWithUsers(t *testing.T, users map[string]string, tasks string) string {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := "auth:\n  admin: { password: \"" + testPasswordHash() + "\", role: admin }\n"
+	for username, fields := range users {
+		content += "  " + username + ": { password: \"" + testPasswordHash() + "\", " + fields + " }\n"
+	}
+	content += tasks
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return configPath
+}
+
+func TestLoadConfig_ClassOrderAndKeys(t *testing.T) {
+	t.Run("keeps declaration order and assigns keys", func(t *testing.T) {
+		configPath := writeTestConfig(t, `
+tasks:
+  - id: kind
+    name: Kind
+    classes:
+      zebra: { name: Zebra }
+      apple: { name: Apple, key: "x", color: "#ff0000", icon: "🍎" }
+      mango: { name: Mango }
+`)
+		config, err := LoadConfig(configPath)
+		if err != nil {
+			t.Fatalf("LoadConfig() error = %v", err)
+		}
+		classes := config.Tasks[0].OrderedClasses()
+		var ids, keys []string
+		for _, class := range classes {
+			ids = append(ids, class.ID)
+			keys = append(keys, class.Key)
+		}
+		if got := strings.Join(ids, ","); got != "zebra,apple,mango" {
+			t.Errorf("order = %s, want zebra,apple,mango", got)
+		}
+		if got := strings.Join(keys, ","); got != "1,x,2" {
+			t.Errorf("keys = %s, want 1,x,2", got)
+		}
+		if classes[1].Color != "#ff0000" || classes[1].Icon != "🍎" {
+			t.Errorf("color/icon not loaded: %+v", classes[1])
+		}
+	})
+
+	t.Run("built-in types have a stable order", func(t *testing.T) {
+		configPath := writeTestConfig(t, `
+tasks:
+  - id: has_car
+    name: Has car?
+    type: boolean
+`)
+		config, err := LoadConfig(configPath)
+		if err != nil {
+			t.Fatalf("LoadConfig() error = %v", err)
+		}
+		classes := config.Tasks[0].OrderedClasses()
+		if classes[0].ID != "true" || classes[0].Key != "1" || classes[1].ID != "false" || classes[1].Key != "2" {
+			t.Errorf("unexpected boolean classes: %+v %+v", classes[0], classes[1])
+		}
+	})
+
+	t.Run("uses letters and then chords for long class lists", func(t *testing.T) {
+		var b strings.Builder
+		b.WriteString("tasks:\n  - id: many\n    name: Many\n    classes:\n")
+		for idx := 0; idx < 40; idx++ {
+			b.WriteString("      c" + string(rune('a'+idx/26)) + string(rune('a'+idx%26)) + ": { name: C }\n")
+		}
+		config, err := LoadConfig(writeTestConfig(t, b.String()))
+		if err != nil {
+			t.Fatalf("LoadConfig() error = %v", err)
+		}
+		classes := config.Tasks[0].OrderedClasses()
+		if classes[0].Key != "11" || classes[39].Key != "25" {
+			t.Errorf("expected chords, got first=%q last=%q", classes[0].Key, classes[39].Key)
+		}
+		seen := map[string]bool{}
+		for _, class := range classes {
+			if seen[class.Key] {
+				t.Errorf("duplicate key %q", class.Key)
+			}
+			seen[class.Key] = true
+		}
+	})
+
+	for name, tasks := range map[string]string{
+		"duplicate keys": `
+tasks:
+  - id: kind
+    name: Kind
+    classes:
+      a: { name: A, key: "q" }
+      b: { name: B, key: "Q" }
+`,
+		"collision with not sure": `
+tasks:
+  - id: kind
+    name: Kind
+    classes:
+      a: { name: A, key: "?" }
+`,
+		"chord prefix collision": `
+tasks:
+  - id: kind
+    name: Kind
+    classes:
+      a: { name: A, key: "g" }
+      b: { name: B, key: "gh" }
+`,
+		"invalid color": `
+tasks:
+  - id: kind
+    name: Kind
+    classes:
+      a: { name: A, color: "red;}" }
+`,
+	} {
+		t.Run("rejects "+name, func(t *testing.T) {
+			if _, err := LoadConfig(writeTestConfig(t, tasks)); err == nil {
+				t.Error("expected an error, got none")
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package annotation
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+
 	_ "modernc.org/sqlite"
 )
 
@@ -30,3 +33,23 @@ func GetDatabase(filename string) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// CloseDatabase moves the write-ahead log into the database file and closes
+// it, so the file left behind is complete on its own, as for backups and
+// copies made while the server is down
+func CloseDatabase(db *sql.DB) error {
+	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		db.Close()
+		return fmt.Errorf("while checkpointing the write-ahead log: %w", err)
+	}
+	return db.Close()
+}
+
+// BackupDatabase writes a copy of the database to a new file while it is in
+// use. The copy is consistent and includes the write-ahead log.
+func BackupDatabase(ctx context.Context, db *sql.DB, filename string) error {
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", filename); err != nil {
+		return fmt.Errorf("while copying the database: %w", err)
+	}
+	return nil
+}
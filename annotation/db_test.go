@@ -0,0 +1,34 @@
+package annotation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCloseDatabase(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "annotations.db")
+	db, err := GetDatabase(filename)
+	if err != nil {
+		t.Fatalf("GetDatabase() error = %v", err)
+	}
+	// A reader left open, as by a crashed process, keeps SQLite from cleaning the log up by itself
+	reader, err := GetDatabase(filename)
+	if err != nil {
+		t.Fatalf("GetDatabase() error = %v", err)
+	}
+	defer reader.Close()
+	if _, err := db.Exec("CREATE TABLE notes (text TEXT); INSERT INTO notes VALUES ('kept')"); err != nil {
+		t.Fatalf("writing: %v", err)
+	}
+	if stat, err := os.Stat(filename + "-wal"); err != nil || stat.Size() == 0 {
+		t.Fatalf("the write should be in the write-ahead log, stat = %v, %v", stat, err)
+	}
+
+	if err := CloseDatabase(db); err != nil {
+		t.Fatalf("CloseDatabase() error = %v", err)
+	}
+	if stat, err := os.Stat(filename + "-wal"); err == nil && stat.Size() > 0 {
+		t.Errorf("write-ahead log left with %d bytes", stat.Size())
+	}
+}
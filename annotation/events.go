@@ -0,0 +1,346 @@
+package annotation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Types of the events streamed at /events
+const (
+	// EventAnnotationCreated is published for every annotation submitted, re-labels included
+	EventAnnotationCreated = "annotation-created"
+	// EventIngestionProgress is published while images are being ingested
+	EventIngestionProgress = "ingestion-progress"
+	// EventTaskCompleted is published when the last image of a task is annotated
+	EventTaskCompleted = "task-completed"
+)
+
+const (
+	// eventBufferSize is how many events a slow subscriber may fall behind before losing some
+	eventBufferSize = 64
+	// eventKeepAlive is how often an idle stream gets a comment, so proxies keep it open
+	eventKeepAlive = 30 * time.Second
+	// ingestionProgressInterval limits how often ingestion progress is published
+	ingestionProgressInterval = time.Second
+	// completionCheckDelay gathers the answers of a burst into a single check of the tasks they completed
+	completionCheckDelay = 250 * time.Millisecond
+)
+
+// Event is a change in the project pushed to subscribers
+type Event struct {
+	Type string
+	// TaskID is the task the event is about, if any, so it is only sent to those who can see it
+	TaskID string
+	Data   any
+}
+
+// EventAnnotation is the data of an annotation-created event
+type EventAnnotation struct {
+	Task  string `json:"task"`
+	Image string `json:"image"`
+	User  string `json:"user,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// EventTask is the data of a task-completed event
+type EventTask struct {
+	Task     string         `json:"task"`
+	Progress *PhaseProgress `json:"progress"`
+}
+
+// EventIngestion is the data of an ingestion-progress event
+type EventIngestion struct {
+	Processed int    `json:"processed"`
+	Total     int    `json:"total"`
+	Done      bool   `json:"done"`
+	Error     string `json:"error,omitempty"`
+}
+
+// eventBroker fans events out to subscribers without ever blocking the publisher
+type eventBroker struct {
+	mutex       sync.Mutex
+	subscribers map[chan Event]struct{}
+	// completed remembers the tasks already announced as completed
+	completed map[string]bool
+	// closed is set once the streams were ended by close
+	closed bool
+}
+
+// subscribe returns a channel receiving the events published from now on,
+// and a function to stop receiving them
+func (b *eventBroker) subscribe() (<-chan Event, func()) {
+	events := make(chan Event, eventBufferSize)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		close(events)
+		return events, func() {}
+	}
+	if b.subscribers == nil {
+		b.subscribers = map[chan Event]struct{}{}
+	}
+	b.subscribers[events] = struct{}{}
+	return events, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.subscribers, events)
+	}
+}
+
+// close ends the channels of every subscriber, present and future
+func (b *eventBroker) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	for events := range b.subscribers {
+		close(events)
+		delete(b.subscribers, events)
+	}
+}
+
+// hasSubscribers tells whether publishing is worth the work of building the event
+func (b *eventBroker) hasSubscribers() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.subscribers) > 0
+}
+
+func (b *eventBroker) publish(event Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+			slog.Warn("events: dropped event for a slow subscriber", "event", event.Type)
+		}
+	}
+}
+
+// markCompleted records whether a task is completed, returning true when it just became so
+func (b *eventBroker) markCompleted(taskID string, completed bool) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.completed == nil {
+		b.completed = map[string]bool{}
+	}
+	changed := completed && !b.completed[taskID]
+	b.completed[taskID] = completed
+	return changed
+}
+
+// completionCheck gathers the answers checked for completed tasks in the background, see scheduleCompletionCheck
+type completionCheck struct {
+	mutex sync.Mutex
+	// scheduled is set while a check waits for more answers
+	scheduled bool
+	// firstStage is the earliest stage answered since the last check
+	firstStage int
+}
+
+// forUser returns the event as a user may see it, or false if they may not see it at all
+func (e Event) forUser(user *RequestUser) (Event, bool) {
+	if e.TaskID != "" && !user.CanSeeTask(e.TaskID) {
+		return e, false
+	}
+	// Annotators only learn that others annotated something, not who nor what
+	if ann, ok := e.Data.(EventAnnotation); ok && !user.CanReview() && ann.User != user.Name {
+		ann.User, ann.Value = "", ""
+		e.Data = ann
+	}
+	return e, true
+}
+
+// publish sends events to the subscribers of /events and queues them for the webhooks that want them
+func (a *AnnotatorApp) publish(ctx context.Context, events ...Event) {
+	for _, event := range events {
+		a.events.publish(event)
+	}
+	a.queueWebhooks(ctx, events...)
+}
+
+// hasListeners tells whether publishing is worth the work of building the events
+func (a *AnnotatorApp) hasListeners() bool {
+	return a.events.hasSubscribers() || len(a.Config.Webhooks) > 0
+}
+
+// publishAnnotations announces stored annotations, and then, in the
+// background, the tasks they completed
+func (a *AnnotatorApp) publishAnnotations(ctx context.Context, annotations []AnnotationResponse) {
+	if !a.hasListeners() {
+		return
+	}
+	firstStage := len(a.Config.Tasks)
+	events := make([]Event, 0, len(annotations))
+	for _, annotation := range annotations {
+		events = append(events, Event{
+			Type:   EventAnnotationCreated,
+			TaskID: annotation.TaskID,
+			Data:   EventAnnotation{Task: annotation.TaskID, Image: annotation.ImageID, User: annotation.User, Value: annotation.Value},
+		})
+		firstStage = min(firstStage, a.taskStageIndex(annotation.TaskID))
+	}
+	a.publish(ctx, events...)
+	a.scheduleCompletionCheck(max(firstStage, 0))
+}
+
+// scheduleCompletionCheck checks the tasks from a stage on for completion
+// after completionCheckDelay, off the request that answered. The answers given
+// meanwhile are checked along, so a burst of them costs a single check.
+func (a *AnnotatorApp) scheduleCompletionCheck(firstStage int) {
+	a.completion.mutex.Lock()
+	defer a.completion.mutex.Unlock()
+	if a.completion.scheduled {
+		a.completion.firstStage = min(a.completion.firstStage, firstStage)
+		return
+	}
+	a.completion.scheduled, a.completion.firstStage = true, firstStage
+
+	ctx := a.backgroundContext()
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		timer := time.NewTimer(completionCheckDelay)
+		defer timer.Stop()
+		// On shutdown the check runs right away, so the events reach the webhook queue
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		a.completion.mutex.Lock()
+		firstStage := a.completion.firstStage
+		a.completion.scheduled = false
+		a.completion.mutex.Unlock()
+		a.publishCompleted(context.WithoutCancel(ctx), firstStage)
+	}()
+}
+
+// publishCompleted announces the tasks from a stage on that became completed,
+// answers may also complete later tasks by filtering out the images they were waiting for
+func (a *AnnotatorApp) publishCompleted(ctx context.Context, firstStage int) {
+	var events []Event
+	for _, task := range a.Config.Tasks[firstStage:] {
+		progress, err := a.GetPhaseProgressStats(ctx, task.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "events: getting progress", "task", task.ID, "error", err)
+			continue
+		}
+		if a.events.markCompleted(task.ID, progress.isCompleted()) {
+			events = append(events, Event{Type: EventTaskCompleted, TaskID: task.ID, Data: EventTask{Task: task.ID, Progress: progress}})
+		}
+	}
+	if len(events) > 0 {
+		a.publish(ctx, events...)
+	}
+}
+
+// recordCompleted records which tasks are completed without announcing them, so
+// that after a restart only the tasks completed from then on are. Ingestions
+// record them again, leaving out the tasks their new images are pending in.
+func (a *AnnotatorApp) recordCompleted(ctx context.Context) {
+	for _, task := range a.Config.Tasks {
+		progress, err := a.GetPhaseProgressStats(ctx, task.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "events: getting progress", "task", task.ID, "error", err)
+			continue
+		}
+		a.events.markCompleted(task.ID, progress.isCompleted())
+	}
+}
+
+// ingestionProgress records the progress of IngestImages in the ingestion
+// status, and publishes it at most once per ingestionProgressInterval
+type ingestionProgress struct {
+	ctx           context.Context
+	app           *AnnotatorApp
+	progress      EventIngestion
+	lastPublished time.Time
+}
+
+func (p *ingestionProgress) begin(total int) {
+	p.progress.Total = total
+	p.app.ingestion.begin(total)
+	p.app.recordCompleted(p.ctx)
+	p.publish()
+}
+
+func (p *ingestionProgress) advance(file, result string, err error) {
+	p.app.ingestion.record(file, result, err)
+	p.progress.Processed++
+	p.progress.Total = max(p.progress.Total, p.progress.Processed)
+	if time.Since(p.lastPublished) >= ingestionProgressInterval {
+		p.publish()
+	}
+}
+
+func (p *ingestionProgress) finish(err error) {
+	p.progress.Done = true
+	if err != nil {
+		p.progress.Error = err.Error()
+	}
+	p.app.ingestion.finish(err)
+	p.app.recordCompleted(p.ctx)
+	p.publish()
+}
+
+func (p *ingestionProgress) publish() {
+	p.lastPublished = time.Now()
+	p.app.publish(p.ctx, Event{Type: EventIngestionProgress, Data: p.progress})
+}
+
+// CloseEventStreams ends the streams of /events, which would otherwise keep a
+// server shutting down waiting. Clients reconnect to the next server.
+func (a *AnnotatorApp) CloseEventStreams() {
+	a.events.close()
+}
+
+// serveEvents streams the events a user may see as Server-Sent Events until the client leaves
+func (a *AnnotatorApp) serveEvents(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	events, unsubscribe := a.events.subscribe()
+	defer unsubscribe()
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Ask nginx not to buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		slog.ErrorContext(r.Context(), "events: streaming is not supported", "error", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, open := <-events:
+			if !open {
+				return
+			}
+			event, ok := event.forUser(user)
+			if !ok {
+				continue
+			}
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "events: encoding event", "event", event.Type, "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
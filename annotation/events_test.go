@@ -0,0 +1,266 @@
+package annotation
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testEvent struct {
+	name string
+	data string
+}
+
+// streamEvents subscribes to /events as a user, returning the events as they
+// arrive in a channel closed with the stream
+func streamEvents(t *testing.T, server *httptest.Server, username string) <-chan testEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events", nil)
+	req.SetBasicAuth(username, "changeme")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("subscribe: status = %d, Content-Type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	events := make(chan testEvent, 16)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		var event testEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			name, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch name {
+			case "event":
+				event.name = value
+			case "data":
+				event.data = value
+			case "":
+				if event.name != "" {
+					events <- event
+				}
+				event = testEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan testEvent) testEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event arrived")
+		return testEvent{}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	app := newTestAppWithUsers(t, map[string]string{
+		"alice": "role: annotator",
+		"bob":   "role: annotator, deny_tasks: [car_kind]",
+		"rita":  "role: reviewer",
+	}, testConfigTasks, 2)
+	server := httptest.NewServer(app.GetHTTPHandler())
+	t.Cleanup(server.Close)
+	reviewer := streamEvents(t, server, "rita")
+	annotator := streamEvents(t, server, "bob")
+
+	submit := func(taskID string, idx int, value string) {
+		t.Helper()
+		if err := app.SubmitAnnotation(context.Background(), AnnotationResponse{ImageID: testImageHash(idx), TaskID: taskID, User: "alice", Value: value}); err != nil {
+			t.Fatalf("SubmitAnnotation() error = %v", err)
+		}
+	}
+
+	submit("has_car", 0, "true")
+	if event := nextEvent(t, reviewer); event.name != EventAnnotationCreated || !strings.Contains(event.data, `"user":"alice","value":"true"`) {
+		t.Errorf("reviewer got %+v", event)
+	}
+	if event := nextEvent(t, annotator); event.name != EventAnnotationCreated || strings.Contains(event.data, "alice") || !strings.Contains(event.data, testImageHash(0)) {
+		t.Errorf("annotators should not learn who annotated what: %+v", event)
+	}
+
+	submit("has_car", 1, "false")
+	nextEvent(t, reviewer)
+	event := nextEvent(t, reviewer)
+	var completed EventTask
+	if err := json.Unmarshal([]byte(event.data), &completed); event.name != EventTaskCompleted || err != nil || completed.Task != "has_car" || completed.Progress.Completed != 2 {
+		t.Errorf("reviewer got %+v", event)
+	}
+	nextEvent(t, annotator)
+	if event := nextEvent(t, annotator); event.name != EventTaskCompleted {
+		t.Errorf("annotator got %+v", event)
+	}
+
+	// Re-labels do not complete the task again
+	submit("has_car", 1, "false")
+	submit("car_kind", 0, "toy")
+	for _, want := range []string{EventAnnotationCreated, EventAnnotationCreated, EventTaskCompleted} {
+		if event := nextEvent(t, reviewer); event.name != want || (want == EventTaskCompleted && !strings.Contains(event.data, "car_kind")) {
+			t.Errorf("reviewer got %+v, want %s", event, want)
+		}
+	}
+	nextEvent(t, annotator)
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	if err := os.WriteFile(filepath.Join(app.ImagesDir, "new.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	if err := app.IngestImages(context.Background()); err != nil {
+		t.Fatalf("IngestImages() error = %v", err)
+	}
+	// The events of car_kind were never sent to bob, who may not see the task
+	var progress EventIngestion
+	for !progress.Done {
+		event := nextEvent(t, annotator)
+		if event.name != EventIngestionProgress {
+			t.Fatalf("annotator got %+v", event)
+		}
+		json.Unmarshal([]byte(event.data), &progress)
+	}
+	if progress.Processed != 1 || progress.Total != 1 || progress.Error != "" {
+		t.Errorf("progress = %+v", progress)
+	}
+}
+
+func TestEvents_Pages(t *testing.T) {
+	app := newTestApp(t, testConfigTasks, 1)
+	handler := app.GetHTTPHandler()
+	for _, target := range []string{"/help/", "/help/has_car", "/annotate/has_car/" + testImageHash(0)} {
+		body := doRequest(t, handler, http.MethodGet, target, nil).Body.String()
+		if !strings.Contains(body, `sse-connect="/events"`) || !strings.Contains(body, `hx-trigger="sse:annotation-created"`) {
+			t.Errorf("%s does not subscribe to events", target)
+		}
+		// Scripts of other hosts are pinned, the one handling the events is inlined
+		for _, script := range strings.Split(body, "<script src=")[1:] {
+			if tag, _, _ := strings.Cut(script, ">"); !strings.Contains(tag, "integrity=") {
+				t.Errorf("%s loads a script without integrity: %s", target, tag)
+			}
+		}
+		if !strings.Contains(body, "new EventSource(") {
+			t.Errorf("%s does not connect to the events", target)
+		}
+	}
+	// The annotate page takes its progress bar from the help page
+	if body := doRequest(t, handler, http.MethodGet, "/help/has_car", nil).Body.String(); !strings.Contains(body, `id="progress-bar-has_car"`) {
+		t.Error("help page has no progress bar to refresh the annotate page with")
+	}
+}
+
+func TestEvents_Close(t *testing.T) {
+	app := newTestApp(t, testConfigTasks, 1)
+	server := httptest.NewServer(app.GetHTTPHandler())
+	t.Cleanup(server.Close)
+	events := streamEvents(t, server, "admin")
+
+	app.CloseEventStreams()
+	select {
+	case event, open := <-events:
+		if open {
+			t.Errorf("got %+v, want the stream to end", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream did not end")
+	}
+	if _, open := <-streamEvents(t, server, "admin"); open {
+		t.Error("new streams should end right away")
+	}
+}
+
+func TestEvents_CompletedTasksAreKnownAfterRestart(t *testing.T) {
+	app := newTestApp(t, testConfigTasks, 1)
+	ctx := context.Background()
+	// Completed while no one listens, as before a restart
+	if err := app.SubmitAnnotation(ctx, AnnotationResponse{ImageID: testImageHash(0), TaskID: "has_car", User: "admin", Value: "false"}); err != nil {
+		t.Fatalf("SubmitAnnotation() error = %v", err)
+	}
+	server := httptest.NewServer(app.GetHTTPHandler())
+	t.Cleanup(server.Close)
+	events := streamEvents(t, server, "admin")
+	ingest := func() {
+		t.Helper()
+		if err := app.IngestImages(ctx); err != nil {
+			t.Fatalf("IngestImages() error = %v", err)
+		}
+		for event := nextEvent(t, events); !strings.Contains(event.data, `"done":true`); event = nextEvent(t, events) {
+		}
+	}
+
+	ingest()
+	if err := app.SubmitAnnotation(ctx, AnnotationResponse{ImageID: testImageHash(0), TaskID: "has_car", User: "admin", Value: "false"}); err != nil {
+		t.Fatalf("SubmitAnnotation() error = %v", err)
+	}
+	if event := nextEvent(t, events); event.name != EventAnnotationCreated {
+		t.Errorf("got %+v", event)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("got %+v, the task was already completed", event)
+	case <-time.After(time.Second):
+	}
+
+	// New images make the task pending, so it is completed again once they are annotated
+	writeTestImage(t, app, "new.png", 2)
+	ingest()
+	images, _ := app.imageRepo.List(ctx)
+	for _, image := range images {
+		if image.Filename == "new.png" {
+			if err := app.SubmitAnnotation(ctx, AnnotationResponse{ImageID: image.SHA256, TaskID: "has_car", User: "admin", Value: "false"}); err != nil {
+				t.Fatalf("SubmitAnnotation() error = %v", err)
+			}
+		}
+	}
+	for _, want := range []string{EventAnnotationCreated, EventTaskCompleted} {
+		if event := nextEvent(t, events); event.name != want {
+			t.Errorf("got %+v, want %s", event, want)
+		}
+	}
+}
+
+func TestEvents_CompletionIsCheckedInTheBackground(t *testing.T) {
+	app := newTestApp(t, testConfigTasks, 2)
+	server := httptest.NewServer(app.GetHTTPHandler())
+	t.Cleanup(server.Close)
+	events := streamEvents(t, server, "admin")
+
+	// Both answers are given before the check, which announces the task once
+	for idx := range 2 {
+		if err := app.SubmitAnnotation(context.Background(), AnnotationResponse{ImageID: testImageHash(idx), TaskID: "has_car", User: "admin", Value: "false"}); err != nil {
+			t.Fatalf("SubmitAnnotation() error = %v", err)
+		}
+		app.completion.mutex.Lock()
+		scheduled := app.completion.scheduled
+		app.completion.mutex.Unlock()
+		if !scheduled {
+			t.Fatal("the check of completed tasks should wait for more answers")
+		}
+	}
+	for _, want := range []string{EventAnnotationCreated, EventAnnotationCreated, EventTaskCompleted, EventTaskCompleted} {
+		if event := nextEvent(t, events); event.name != want {
+			t.Errorf("got %+v, want %s", event, want)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Errorf("got %+v, each task is completed once", event)
+	case <-time.After(2 * completionCheckDelay):
+	}
+}
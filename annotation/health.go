@@ -0,0 +1,67 @@
+package annotation
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout bounds the database check of /readyz
+const readinessTimeout = 2 * time.Second
+
+// Health is the body of /healthz and /readyz
+type Health struct {
+	Status string `json:"status"`
+	// Checks are the states of the dependencies of readiness, by name
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// healthMiddleware answers the probes of orchestrators and load balancers
+// before authentication, without logging them. /healthz tells the process is
+// up, /readyz that the database can be queried. The state of the ingestion is
+// reported by /readyz but does not make it fail, as the server is useful while
+// images are loaded; see IngestionStatus for the details.
+func (a *AnnotatorApp) healthMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		switch r.URL.Path {
+		case "/healthz":
+			writeJSON(w, http.StatusOK, Health{Status: "ok"})
+		case "/readyz":
+			a.serveReadiness(w, r)
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (a *AnnotatorApp) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	health := Health{Status: "ready", Checks: map[string]string{"database": "ok", "ingestion": ingestionState(a.IngestionStatus())}}
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	if err := a.Database.PingContext(ctx); err != nil {
+		slog.WarnContext(r.Context(), "readiness: database unavailable", "error", err)
+		health.Status, health.Checks["database"] = "unavailable", "unavailable"
+		writeJSON(w, http.StatusServiceUnavailable, health)
+		return
+	}
+	writeJSON(w, http.StatusOK, health)
+}
+
+// ingestionState summarizes an ingestion status as not-started, running, failed or done
+func ingestionState(status IngestionStatus) string {
+	switch {
+	case status.Running:
+		return "running"
+	case status.StartedAt == nil:
+		return "not-started"
+	case status.Error != "":
+		return "failed"
+	default:
+		return "done"
+	}
+}
@@ -0,0 +1,36 @@
+package annotation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	app := newTestApp(t, testConfigTasks, 0)
+	handler := app.GetHTTPHandler()
+
+	probe := func(path string) (int, Health) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var health Health
+		if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+			t.Fatalf("%s: invalid body %q", path, rec.Body.String())
+		}
+		return rec.Code, health
+	}
+
+	if code, health := probe("/healthz"); code != http.StatusOK || health.Status != "ok" {
+		t.Errorf("/healthz = %d %+v", code, health)
+	}
+	if code, health := probe("/readyz"); code != http.StatusOK || health.Status != "ready" || health.Checks["ingestion"] != "not-started" {
+		t.Errorf("/readyz = %d %+v", code, health)
+	}
+
+	app.Database.Close()
+	if code, health := probe("/readyz"); code != http.StatusServiceUnavailable || health.Checks["database"] != "unavailable" {
+		t.Errorf("/readyz without database = %d %+v", code, health)
+	}
+}
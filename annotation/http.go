@@ -1,9 +1,13 @@
 package annotation
 
 import (
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // i18nMiddleware adds the appropriate localizer to the request context
@@ -15,16 +19,27 @@ func i18nMiddleware(handler http.Handler) http.Handler {
 	})
 }
 
+// clientIP returns the address of the client that made the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// HTTPLogger logs every request with its status, duration and user
 func HTTPLogger(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		initialTime := time.Now()
-		method := r.Method
-		path := r.URL.String()
 		wr := NewStatusCodeRecorderResponseWriter(w)
 		handler.ServeHTTP(wr, r)
-		finalTime := time.Now()
-		statusCode := wr.Status
-		log.Printf("http: time:%dms %d %s %s", finalTime.Sub(initialTime)/time.Millisecond, statusCode, method, path)
+		attrs := []any{"method", r.Method, "path", r.URL.String(), "status", wr.Status, "duration", time.Since(initialTime)}
+		if user := GetUserFromContext(r.Context()); user != nil {
+			attrs = append(attrs, "user", user.Name)
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("enduser.id", user.Name))
+		}
+		slog.InfoContext(r.Context(), "http request", attrs...)
 	})
 }
 
@@ -38,6 +53,11 @@ func (r *StatusCodeRecorderResponseWriter) WriteHeader(status int) {
 	r.ResponseWriter.WriteHeader(status)
 }
 
+// Unwrap lets http.ResponseController reach the original writer, to flush event streams
+func (r *StatusCodeRecorderResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 func NewStatusCodeRecorderResponseWriter(w http.ResponseWriter) *StatusCodeRecorderResponseWriter {
 	return &StatusCodeRecorderResponseWriter{ResponseWriter: w, Status: 200}
 }
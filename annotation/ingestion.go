@@ -0,0 +1,185 @@
+package annotation
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxIngestionFailures is how many failed files IngestionStatus lists, the count goes on
+	maxIngestionFailures = 20
+	// ingestionCheckpointInterval is how many files an ingestion goes through between checkpoints
+	ingestionCheckpointInterval = 100
+)
+
+// Results of the files of an ingestion
+const (
+	ingestionIngested = "ingested"
+	ingestionSkipped  = "skipped"
+	ingestionFailed   = "failed"
+)
+
+// IngestionStatus describes the running or last run of IngestImages
+type IngestionStatus struct {
+	Running    bool       `json:"running"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	// Total is the number of files in the images folder when the run started
+	Total int `json:"total"`
+	// Seen files, the sum of those ingested, skipped and failed
+	Seen int `json:"seen"`
+	// Ingested are the new images, and the known ones under a new filename
+	Ingested int `json:"ingested"`
+	// Skipped are the images already known under the same filename
+	Skipped int `json:"skipped"`
+	// Failed are the files that are not images or could not be read or stored
+	Failed int `json:"failed"`
+	// Failures explains the first failed files
+	Failures []IngestionFailure `json:"failures,omitempty"`
+	// CurrentFile is the file being processed while running
+	CurrentFile string `json:"current_file,omitempty"`
+	// ETA is when the run should finish, estimated from the pace so far
+	ETA *time.Time `json:"eta,omitempty"`
+}
+
+// IngestionFailure is a file IngestImages could not ingest
+type IngestionFailure struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// Pending is the number of files not seen yet
+func (s IngestionStatus) Pending() int {
+	return max(s.Total-s.Seen, 0)
+}
+
+// ingestionTracker keeps a single ingestion running at a time and follows its progress
+type ingestionTracker struct {
+	mutex  sync.Mutex
+	status IngestionStatus
+}
+
+// begin resets the status for a run over total files
+func (t *ingestionTracker) begin(total int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	startedAt := time.Now()
+	t.status = IngestionStatus{Running: true, StartedAt: &startedAt, Total: total}
+}
+
+// processing marks the file being processed
+func (t *ingestionTracker) processing(file string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.status.CurrentFile = file
+}
+
+// record counts a processed file by its result, ingestionIngested, ingestionSkipped or ingestionFailed
+func (t *ingestionTracker) record(file, result string, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.status.Seen++
+	switch result {
+	case ingestionIngested:
+		t.status.Ingested++
+	case ingestionSkipped:
+		t.status.Skipped++
+	case ingestionFailed:
+		t.status.Failed++
+		if len(t.status.Failures) < maxIngestionFailures {
+			t.status.Failures = append(t.status.Failures, IngestionFailure{File: file, Error: err.Error()})
+		}
+	}
+	// Files added during the run are seen too
+	t.status.Total = max(t.status.Total, t.status.Seen)
+}
+
+// finish ends the run, failed when err is not nil
+func (t *ingestionTracker) finish(err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	finishedAt := time.Now()
+	t.status.Running = false
+	t.status.FinishedAt = &finishedAt
+	t.status.CurrentFile = ""
+	if err != nil {
+		t.status.Error = err.Error()
+	}
+}
+
+// snapshot returns a copy of the status, with the ETA of a running ingestion
+func (t *ingestionTracker) snapshot() IngestionStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	status := t.status
+	status.Failures = append([]IngestionFailure(nil), t.status.Failures...)
+	if status.Running && status.StartedAt != nil && status.Seen > 0 {
+		elapsed := time.Since(*status.StartedAt)
+		eta := time.Now().Add(elapsed / time.Duration(status.Seen) * time.Duration(status.Pending()))
+		status.ETA = &eta
+	}
+	return status
+}
+
+// StartIngestion runs IngestImages in the background, unless it is already
+// running. It returns whether a new run was started.
+func (a *AnnotatorApp) StartIngestion(ctx context.Context) bool {
+	a.ingestion.mutex.Lock()
+	defer a.ingestion.mutex.Unlock()
+	if a.ingestion.status.Running {
+		return false
+	}
+	// The run is claimed here, IngestImages resets the status once it counted the files
+	startedAt := time.Now()
+	a.ingestion.status = IngestionStatus{Running: true, StartedAt: &startedAt}
+
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		if err := a.IngestImages(ctx); err != nil {
+			slog.ErrorContext(ctx, "ingestion failed", "error", err)
+		}
+	}()
+	return true
+}
+
+// Wait blocks until the work started by StartIngestion, StartWebhooks and
+// StartViewPruning returns, which it does soon after their context is
+// cancelled: the file or webhook call at hand is finished first. Pending
+// checks of completed tasks are run right away.
+func (a *AnnotatorApp) Wait() {
+	a.background.Wait()
+}
+
+// backgroundContext returns the context of the background work started by requests
+func (a *AnnotatorApp) backgroundContext() context.Context {
+	if a.BackgroundContext != nil {
+		return a.BackgroundContext
+	}
+	return context.Background()
+}
+
+// IngestionStatus returns the state of the running or last ingestion
+func (a *AnnotatorApp) IngestionStatus() IngestionStatus {
+	return a.ingestion.snapshot()
+}
+
+type ingestionStatusKey struct{}
+
+// ingestionStatusMiddleware hands the ingestion status to the pages, whose layout shows it in a banner
+func (a *AnnotatorApp) ingestionStatusMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := a.IngestionStatus()
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ingestionStatusKey{}, &status)))
+	})
+}
+
+// ingestionStatusFromContext returns the ingestion status of a request, or nil
+func ingestionStatusFromContext(ctx context.Context) *IngestionStatus {
+	status, _ := ctx.Value(ingestionStatusKey{}).(*IngestionStatus)
+	return status
+}
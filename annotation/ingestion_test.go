@@ -0,0 +1,165 @@
+package annotation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// writeTestImage writes a distinct PNG image to the images folder of an app
+func writeTestImage(t *testing.T, app *AnnotatorApp, name string, width int) {
+	t.Helper()
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, 1)))
+	if err := os.WriteFile(filepath.Join(app.ImagesDir, name), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+}
+
+func TestIngestionStatus(t *testing.T) {
+	app := newTestAppWithUsers(t, map[string]string{"alice": "role: annotator"}, testConfigTasks, 0)
+	handler := app.GetHTTPHandler()
+	writeTestImage(t, app, "a.png", 1)
+	writeTestImage(t, app, "b.png", 2)
+	if err := os.WriteFile(filepath.Join(app.ImagesDir, "notes.txt"), []byte("not an image"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	t.Run("a bad file does not stop the others", func(t *testing.T) {
+		err := app.IngestImages(context.Background())
+		if err == nil || !strings.Contains(err.Error(), "1 of 3 files could not be ingested") {
+			t.Fatalf("IngestImages() error = %v", err)
+		}
+		status := app.IngestionStatus()
+		if status.Running || status.Total != 3 || status.Seen != 3 || status.Ingested != 2 || status.Skipped != 0 || status.Failed != 1 || status.Error == "" {
+			t.Errorf("status = %+v", status)
+		}
+		if len(status.Failures) != 1 || status.Failures[0].File != "notes.txt" {
+			t.Errorf("failures = %+v", status.Failures)
+		}
+		if count, _ := app.imageRepo.Count(context.Background()); count != 2 {
+			t.Errorf("images = %d, want 2", count)
+		}
+	})
+
+	t.Run("failures are shown to admins only", func(t *testing.T) {
+		if body := doRequest(t, handler, http.MethodGet, "/help/", nil).Body.String(); !strings.Contains(body, `id="ingestion-banner"`) || !strings.Contains(body, "files could not be ingested") {
+			t.Error("admins should see the banner of a failed ingestion")
+		}
+		if body := doRequestAs(t, handler, "alice", http.MethodGet, "/help/", nil).Body.String(); strings.Contains(body, `id="ingestion-banner"`) {
+			t.Error("annotators should not see the banner of a finished ingestion")
+		}
+		body := doRequestAs(t, handler, "alice", http.MethodGet, "/ingestion/", nil).Body.String()
+		if !strings.Contains(body, "notes.txt") || strings.Contains(body, `hx-post="/ingestion/"`) {
+			t.Error("annotators should see the status page without the action to run it")
+		}
+	})
+
+	t.Run("admins run it again, known images are skipped", func(t *testing.T) {
+		os.Remove(filepath.Join(app.ImagesDir, "notes.txt"))
+		writeTestImage(t, app, "c.png", 3)
+		if rec := doRequestAs(t, handler, "alice", http.MethodPost, "/ingestion/", nil); rec.Code != http.StatusForbidden {
+			t.Errorf("annotator: status = %d, want 403", rec.Code)
+		}
+		if rec := doRequest(t, handler, http.MethodPost, "/ingestion/", nil); rec.Code != http.StatusSeeOther {
+			t.Fatalf("admin: status = %d, want 303", rec.Code)
+		}
+		status := app.IngestionStatus()
+		for deadline := time.Now().Add(5 * time.Second); status.Running && time.Now().Before(deadline); status = app.IngestionStatus() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if status.Running || status.Error != "" || status.Seen != 3 || status.Ingested != 1 || status.Skipped != 2 || status.Failed != 0 {
+			t.Errorf("status = %+v", status)
+		}
+		if body := doRequest(t, handler, http.MethodGet, "/help/", nil).Body.String(); strings.Contains(body, `id="ingestion-banner"`) {
+			t.Error("the banner should go away after a clean run")
+		}
+	})
+
+	t.Run("running ingestions show their progress and ETA", func(t *testing.T) {
+		startedAt := time.Now().Add(-10 * time.Second)
+		app.ingestion.status = IngestionStatus{Running: true, StartedAt: &startedAt, Total: 4}
+		app.ingestion.processing("a.png")
+		app.ingestion.record("a.png", ingestionIngested, nil)
+		status := app.IngestionStatus()
+		if status.CurrentFile != "a.png" || status.Pending() != 3 || status.ETA == nil || time.Until(*status.ETA) < 25*time.Second {
+			t.Errorf("status = %+v", status)
+		}
+		body := doRequestAs(t, handler, "alice", http.MethodGet, "/help/", nil).Body.String()
+		if !strings.Contains(body, `hx-trigger="every 2s"`) || !strings.Contains(body, "1/4") {
+			t.Error("everyone should see the progress of a running ingestion")
+		}
+	})
+}
+
+// cancelAfterFiles cancels an ingestion once it went through a number of files
+type cancelAfterFiles struct {
+	sdktrace.SpanProcessor
+	files  atomic.Int32
+	limit  int32
+	cancel context.CancelFunc
+}
+
+func (p *cancelAfterFiles) OnEnd(span sdktrace.ReadOnlySpan) {
+	if span.Name() == "ingest image" && p.files.Add(1) == p.limit {
+		p.cancel()
+	}
+}
+
+func TestIngestImages_Interrupted(t *testing.T) {
+	app := newTestApp(t, testConfigTasks, 0)
+	for idx, name := range []string{"a.png", "b.png", "c.png", "d.png"} {
+		writeTestImage(t, app, name, idx+1)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hook := &cancelAfterFiles{SpanProcessor: sdktrace.NewSimpleSpanProcessor(tracetest.NewInMemoryExporter()), limit: 2, cancel: cancel}
+	app.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(hook))
+	app.GetHTTPHandler()
+
+	err := app.IngestImages(ctx)
+	if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), `interrupted after "b.png"`) {
+		t.Fatalf("IngestImages() error = %v", err)
+	}
+	if status := app.IngestionStatus(); status.Running || status.Seen != 2 || status.Ingested != 2 {
+		t.Errorf("status = %+v", status)
+	}
+	if checkpoint, _ := app.imageRepo.Checkpoint(context.Background(), app.ImagesDir); checkpoint != "b.png" {
+		t.Errorf("checkpoint = %q, want b.png", checkpoint)
+	}
+
+	// Files up to the checkpoint are trusted by name, even when their content changed meanwhile
+	writeTestImage(t, app, "a.png", 10)
+	if err := app.IngestImages(context.Background()); err != nil {
+		t.Fatalf("IngestImages() after the interruption error = %v", err)
+	}
+	if status := app.IngestionStatus(); status.Seen != 4 || status.Skipped != 2 || status.Ingested != 2 {
+		t.Errorf("resumed status = %+v", status)
+	}
+	if checkpoint, _ := app.imageRepo.Checkpoint(context.Background(), app.ImagesDir); checkpoint != "" {
+		t.Errorf("checkpoint = %q after a complete run", checkpoint)
+	}
+	if count, _ := app.imageRepo.Count(context.Background()); count != 4 {
+		t.Errorf("images = %d, want 4", count)
+	}
+
+	// Complete runs look at every file again
+	if err := app.IngestImages(context.Background()); err != nil {
+		t.Fatalf("IngestImages() error = %v", err)
+	}
+	if status := app.IngestionStatus(); status.Ingested != 1 || status.Skipped != 3 {
+		t.Errorf("status of a complete run = %+v", status)
+	}
+}
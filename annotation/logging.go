@@ -0,0 +1,119 @@
+package annotation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Log formats of NewLogHandler
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// ParseLogLevel parses one of debug, info, warn or error
+func ParseLogLevel(level string) (slog.Level, error) {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: must be one of debug, info, warn or error", level)
+	}
+	return parsed, nil
+}
+
+// NewLogHandler returns a handler writing records from level up to w in
+// format, text or json, with the request ID of their context
+func NewLogHandler(w io.Writer, format string, level slog.Level) (slog.Handler, error) {
+	options := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(format) {
+	case LogFormatText:
+		return contextHandler{slog.NewTextHandler(w, options)}, nil
+	case LogFormatJSON:
+		return contextHandler{slog.NewJSONHandler(w, options)}, nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q: must be %s or %s", format, LogFormatText, LogFormatJSON)
+	}
+}
+
+// contextHandler adds the request ID, project and sampled trace of the context to the records logged with one
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if project := projectFromContext(ctx); project != nil {
+		record.AddAttrs(slog.String("project", project.Name))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsSampled() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying a request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request a context belongs to, or ""
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID matches the request IDs taken from clients and proxies
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestIDMiddleware gives every request an ID, the one of the X-Request-ID
+// header when a proxy already set it, and sends it back in the response
+func requestIDMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+		handler.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// auditLogger returns the logger of the audit stream
+func (a *AnnotatorApp) auditLogger() *slog.Logger {
+	if a.AuditLogger != nil {
+		return a.AuditLogger
+	}
+	return slog.Default().With("log", "audit")
+}
+
+// audit records a login or change in the audit stream
+func (a *AnnotatorApp) audit(ctx context.Context, msg string, args ...any) {
+	a.auditLogger().InfoContext(ctx, msg, args...)
+}
+
+// auditValue is the value of an annotation before or after a change, empty when it did not exist
+func auditValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,147 @@
+package annotation
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// decodeLogs parses the records written by a JSON log handler
+func decodeLogs(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// findLog returns the first record with a message, or nil
+func findLog(records []map[string]any, msg string) map[string]any {
+	for _, record := range records {
+		if record["msg"] == msg {
+			return record
+		}
+	}
+	return nil
+}
+
+func TestLogging(t *testing.T) {
+	var logs, audit bytes.Buffer
+	handler, err := NewLogHandler(&logs, LogFormatJSON, slog.LevelDebug)
+	if err != nil {
+		t.Fatalf("NewLogHandler() error = %v", err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	app := newTestApp(t, testConfigTasks, 1)
+	auditHandler, _ := NewLogHandler(&audit, LogFormatJSON, slog.LevelInfo)
+	app.AuditLogger = slog.New(auditHandler)
+	server := app.GetHTTPHandler()
+
+	send := func(target string, form url.Values, requestID string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		req.SetBasicAuth("admin", "changeme")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("requests get an ID, the proxy's when valid", func(t *testing.T) {
+		rec := send("/login", url.Values{"username": {"admin"}, "password": {"wrong"}}, "")
+		if len(rec.Header().Get("X-Request-ID")) != 36 {
+			t.Errorf("X-Request-ID = %q, want a generated UUID", rec.Header().Get("X-Request-ID"))
+		}
+		if rec := send("/login", url.Values{"username": {"admin"}, "password": {"wrong"}}, "lb-1234"); rec.Header().Get("X-Request-ID") != "lb-1234" {
+			t.Errorf("X-Request-ID = %q, want the one of the proxy", rec.Header().Get("X-Request-ID"))
+		}
+		if rec := send("/login", url.Values{"username": {"admin"}, "password": {"wrong"}}, "bad id\n"); rec.Header().Get("X-Request-ID") == "bad id\n" {
+			t.Error("invalid request IDs should be replaced")
+		}
+	})
+
+	logs.Reset()
+	audit.Reset()
+	rec := send("/annotate/has_car/"+testImageHash(0), url.Values{"selectedClass": {"true"}, "sure": {"on"}}, "req-annotate")
+	if rec.Code >= 400 {
+		t.Fatalf("annotate: status = %d", rec.Code)
+	}
+
+	t.Run("records carry the request ID down to database queries", func(t *testing.T) {
+		records := decodeLogs(t, &logs)
+		request := findLog(records, "http request")
+		if request == nil || request["request_id"] != "req-annotate" || request["user"] != "admin" || request["status"] != float64(rec.Code) {
+			t.Errorf("http request record = %v", request)
+		}
+		query := findLog(records, "db query")
+		if query == nil || query["request_id"] != "req-annotate" || query["query"] == "" {
+			t.Errorf("db query record = %v", query)
+		}
+		if findLog(records, "annotation create") != nil {
+			t.Error("annotation changes should only go to the audit stream")
+		}
+	})
+
+	t.Run("annotation changes and logins go to the audit stream", func(t *testing.T) {
+		created := findLog(decodeLogs(t, &audit), "annotation create")
+		if created == nil || created["request_id"] != "req-annotate" || created["user"] != "admin" || created["task"] != "has_car" || created["new"] != "true" || created["old"] != "" {
+			t.Errorf("audit record = %v", created)
+		}
+
+		audit.Reset()
+		send("/login", url.Values{"username": {"admin"}, "password": {"wrong"}}, "")
+		send("/login", url.Values{"username": {"admin"}, "password": {"changeme"}}, "")
+		records := decodeLogs(t, &audit)
+		if failed := findLog(records, "login failed"); failed == nil || failed["user"] != "admin" || failed["client_ip"] != "192.0.2.1" {
+			t.Errorf("failed login record = %v", failed)
+		}
+		if login := findLog(records, "login"); login == nil || login["user"] != "admin" {
+			t.Errorf("login record = %v", login)
+		}
+		if strings.Contains(audit.String(), "changeme") {
+			t.Error("passwords must never be logged")
+		}
+	})
+}
+
+func TestLogSettings(t *testing.T) {
+	for _, level := range []string{"debug", "INFO", "warn", "error"} {
+		if _, err := ParseLogLevel(level); err != nil {
+			t.Errorf("ParseLogLevel(%q) error = %v", level, err)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("ParseLogLevel(verbose) should fail")
+	}
+	if _, err := NewLogHandler(&bytes.Buffer{}, "xml", slog.LevelInfo); err == nil {
+		t.Error("NewLogHandler(xml) should fail")
+	}
+
+	var buf bytes.Buffer
+	handler, _ := NewLogHandler(&buf, LogFormatText, slog.LevelWarn)
+	logger := slog.New(handler).With("component", "test")
+	logger.Info("hidden")
+	logger.WarnContext(WithRequestID(t.Context(), "abc"), "shown")
+	if got := buf.String(); strings.Contains(got, "hidden") || !strings.Contains(got, "msg=shown component=test request_id=abc") {
+		t.Errorf("text log = %q", got)
+	}
+}
@@ -0,0 +1,211 @@
+package annotation
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lewtec/rotulador/internal/sqlc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// metricsDurationBuckets are the upper bounds, in seconds, of the duration histograms
+var metricsDurationBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// appMetrics are the metrics collected while the app runs, see serveMetrics
+type appMetrics struct {
+	registry        *prometheus.Registry
+	httpRequests    *prometheus.HistogramVec
+	annotations     *prometheus.CounterVec
+	ingestions      *prometheus.CounterVec
+	imagesIngested  prometheus.Counter
+	ingestionErrors prometheus.Counter
+	databaseQueries *prometheus.HistogramVec
+}
+
+func newAppMetrics() *appMetrics {
+	m := &appMetrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rotulador_http_request_duration_seconds",
+			Help:    "Time taken to answer HTTP requests, by route pattern, method and status.",
+			Buckets: metricsDurationBuckets,
+		}, []string{"route", "method", "status"}),
+		annotations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotulador_annotations_submitted_total",
+			Help: "Annotations submitted, re-labels included, by task and user.",
+		}, []string{"task", "user"}),
+		ingestions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotulador_ingestions_total",
+			Help: "Image ingestion runs finished, by result (success or error).",
+		}, []string{"result"}),
+		imagesIngested: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rotulador_images_ingested_total",
+			Help: "Images processed by ingestion runs, already known ones included.",
+		}),
+		ingestionErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rotulador_ingestion_errors_total",
+			Help: "Files that ingestion runs could not ingest.",
+		}),
+		databaseQueries: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rotulador_db_query_duration_seconds",
+			Help:    "Time taken by database queries, by query name.",
+			Buckets: metricsDurationBuckets,
+		}, []string{"query"}),
+	}
+	m.registry.MustRegister(m.httpRequests, m.annotations, m.ingestions, m.imagesIngested, m.ingestionErrors, m.databaseQueries)
+	return m
+}
+
+// metricsMiddleware records the duration of requests, labelled with the pattern
+// of the mux route that serves them so paths holding IDs do not make new series
+func (a *AnnotatorApp) metricsMiddleware(handler http.Handler, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startedAt := time.Now()
+		_, route := mux.Handler(r)
+		wr := NewStatusCodeRecorderResponseWriter(w)
+		handler.ServeHTTP(wr, r)
+		a.metrics.httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(wr.Status)).Observe(time.Since(startedAt).Seconds())
+	})
+}
+
+// scraperMiddleware serves /metrics to the scrapers allowed by the metrics
+// section of the config, before any user authentication is attempted.
+// Anyone else falls through to the admin-only /metrics route.
+func (a *AnnotatorApp) scraperMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metrics" && a.Config.Metrics.allows(r) {
+			a.serveMetrics(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// validate parses the networks allowed to scrape the metrics
+func (c *ConfigMetrics) validate() (err error) {
+	c.allowedPrefixes, err = parsePrefixes(c.AllowNetworks)
+	return err
+}
+
+// allows tells whether a request may read the metrics without being an admin
+func (c *ConfigMetrics) allows(r *http.Request) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && c.Token != "" {
+		return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(c.Token)) == 1
+	}
+	return prefixesContain(c.allowedPrefixes, r)
+}
+
+// serveMetrics writes the metrics in the Prometheus text format
+func (a *AnnotatorApp) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// Task progress is read when scraped, so it is never stale
+	scraped := prometheus.NewRegistry()
+	tasks := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rotulador_task_images",
+		Help: "Images of each task by state: completed, pending, filtered (by an earlier answer) or waiting (on an earlier task).",
+	}, []string{"task", "state"})
+	running := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rotulador_ingestion_running",
+		Help: "Whether an image ingestion is running.",
+	})
+	scraped.MustRegister(tasks, running)
+	for _, task := range a.Config.Tasks {
+		progress, err := a.GetPhaseProgressStats(r.Context(), task.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "metrics: getting progress", "task", task.ID, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		tasks.WithLabelValues(task.ID, "completed").Set(float64(progress.Completed))
+		tasks.WithLabelValues(task.ID, "pending").Set(float64(progress.Pending))
+		tasks.WithLabelValues(task.ID, "filtered").Set(float64(progress.FilteredWrongClass))
+		tasks.WithLabelValues(task.ID, "waiting").Set(float64(progress.NotYetAnnotated))
+	}
+	if a.IngestionStatus().Running {
+		running.Set(1)
+	}
+
+	gatherers := prometheus.Gatherers{a.metrics.registry, scraped}
+	promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelError)}).ServeHTTP(w, r)
+}
+
+// timedDB measures and traces the queries made through it, named after the
+// "-- name:" comment sqlc starts them with
+type timedDB struct {
+	db      sqlc.DBTX
+	queries *prometheus.HistogramVec
+	tracer  trace.Tracer
+}
+
+// start starts the span of a query, the returned function ends it and records its duration
+func (d *timedDB) start(ctx context.Context, query string) (context.Context, func(error)) {
+	name, startedAt := queryName(query), time.Now()
+	ctx, span := d.tracer.Start(ctx, "db "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", "sqlite"),
+			attribute.String("db.operation.name", name),
+			attribute.String("db.query.text", query),
+		))
+	return ctx, func(err error) {
+		duration := time.Since(startedAt)
+		d.queries.WithLabelValues(name).Observe(duration.Seconds())
+		slog.DebugContext(ctx, "db query", "query", name, "duration", duration)
+		endSpan(span, err)
+	}
+}
+
+func (d *timedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := d.start(ctx, query)
+	result, err := d.db.ExecContext(ctx, query, args...)
+	done(err)
+	return result, err
+}
+
+func (d *timedDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	ctx, done := d.start(ctx, query)
+	stmt, err := d.db.PrepareContext(ctx, query)
+	done(err)
+	return stmt, err
+}
+
+func (d *timedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, done := d.start(ctx, query)
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
+
+func (d *timedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, done := d.start(ctx, query)
+	row := d.db.QueryRowContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
+
+// timed wraps db so the queries of the repositories built on it are measured and traced
+func (a *AnnotatorApp) timed(db sqlc.DBTX) sqlc.DBTX {
+	return &timedDB{db: db, queries: a.metrics.databaseQueries, tracer: a.tracer()}
+}
+
+// queryName returns the name sqlc gives a query, or "other"
+func queryName(query string) string {
+	if rest, ok := strings.CutPrefix(query, "-- name: "); ok {
+		if name, _, ok := strings.Cut(rest, " "); ok {
+			return name
+		}
+	}
+	return "other"
+}
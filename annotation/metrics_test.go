@@ -0,0 +1,120 @@
+package annotation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	app := newTestAppWithUsers(t, map[string]string{"alice": "role: annotator"}, `
+metrics:
+  token: scrape-me
+  allow_networks: [10.0.0.0/8]
+`+testConfigTasks, 2)
+	handler := app.GetHTTPHandler()
+	if err := app.SubmitAnnotation(context.Background(), AnnotationResponse{ImageID: testImageHash(0), TaskID: "has_car", User: "alice", Value: "true"}); err != nil {
+		t.Fatalf("SubmitAnnotation() error = %v", err)
+	}
+	if err := app.IngestImages(context.Background()); err != nil {
+		t.Fatalf("IngestImages() error = %v", err)
+	}
+	doRequest(t, handler, http.MethodGet, "/annotate/has_car/"+testImageHash(1), nil)
+
+	scrape := func(remoteAddr, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := scrape("192.0.2.1:1234", "scrape-me")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("scrape with token: status = %d, Content-Type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE rotulador_http_request_duration_seconds histogram\n",
+		`rotulador_http_request_duration_seconds_count{method="GET",route="/annotate/",status="200"} 1` + "\n",
+		`rotulador_http_request_duration_seconds_bucket{method="GET",route="/annotate/",status="200",le="+Inf"} 1` + "\n",
+		`rotulador_annotations_submitted_total{task="has_car",user="alice"} 1` + "\n",
+		`rotulador_task_images{state="completed",task="has_car"} 1` + "\n",
+		`rotulador_task_images{state="pending",task="has_car"} 1` + "\n",
+		`rotulador_task_images{state="waiting",task="car_kind"} 1` + "\n",
+		`rotulador_ingestions_total{result="success"} 1` + "\n",
+		"rotulador_images_ingested_total 0\n",
+		"rotulador_ingestion_errors_total 0\n",
+		"rotulador_ingestion_running 0\n",
+		`rotulador_db_query_duration_seconds_count{query="CreateAnnotation"} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %q", want)
+		}
+	}
+	if strings.Contains(body, testImageHash(1)) {
+		t.Error("routes should be labelled by pattern, not path")
+	}
+
+	for name, test := range map[string]struct {
+		remoteAddr, token string
+		want              int
+	}{
+		"from an allowed network": {"10.1.2.3:1234", "", http.StatusOK},
+		"without credentials":     {"192.0.2.1:1234", "", http.StatusUnauthorized},
+		"with a wrong token":      {"10.1.2.3:1234", "wrong", http.StatusUnauthorized},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if rec := scrape(test.remoteAddr, test.token); rec.Code != test.want {
+				t.Errorf("status = %d, want %d", rec.Code, test.want)
+			}
+		})
+	}
+
+	t.Run("files that cannot be ingested are counted", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(app.ImagesDir, "broken.png"), []byte("not an image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := app.IngestImages(context.Background()); err == nil {
+			t.Fatal("IngestImages() error = nil, want the broken file")
+		}
+		body := scrape("10.1.2.3:1234", "").Body.String()
+		for _, want := range []string{"rotulador_ingestion_errors_total 1\n", `rotulador_ingestions_total{result="error"} 1` + "\n"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("metrics lack %q", want)
+			}
+		}
+	})
+
+	t.Run("admins only among users", func(t *testing.T) {
+		if rec := doRequest(t, handler, http.MethodGet, "/metrics", nil); rec.Code != http.StatusOK {
+			t.Errorf("admin: status = %d", rec.Code)
+		}
+		if rec := doRequestAs(t, handler, "alice", http.MethodGet, "/metrics", nil); rec.Code != http.StatusForbidden {
+			t.Errorf("annotator: status = %d, want 403", rec.Code)
+		}
+	})
+}
+
+func TestQueryName(t *testing.T) {
+	if got := queryName("-- name: GetImage :one\nSELECT 1"); got != "GetImage" {
+		t.Errorf("queryName() = %q", got)
+	}
+	if got := queryName("PRAGMA journal_mode=WAL"); got != "other" {
+		t.Errorf("queryName() = %q", got)
+	}
+}
+
+func TestLoadConfig_Metrics(t *testing.T) {
+	if _, err := LoadConfig(writeTestConfig(t, "metrics: { allow_networks: [not-a-network] }\n"+testConfigTasks)); err == nil {
+		t.Error("expected an error for an invalid network, got none")
+	}
+}
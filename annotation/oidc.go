@@ -0,0 +1,248 @@
+package annotation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcCookieName   = "rotulador_oidc"
+	oidcCallbackPath = "/oidc/callback"
+	// oidcLoginTimeout is how long the user has to sign in at the provider
+	oidcLoginTimeout = 10 * time.Minute
+)
+
+// errOIDCNoRole is returned for users of single sign-on that no group gives a role
+var errOIDCNoRole = errors.New("no role for this user")
+
+// oidcHTTPClient talks to the provider, which must not hang the login page
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcProvider is the discovered configuration of the provider
+type oidcProvider struct {
+	mutex    sync.Mutex
+	provider *oidc.Provider
+}
+
+// oidcLoginState is kept in a signed cookie between the redirect to the provider and the callback
+type oidcLoginState struct {
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	Next     string `json:"next"`
+	Expires  int64  `json:"expires"`
+}
+
+// validate checks the provider settings and fills in the defaults
+func (c *ConfigOIDC) validate() error {
+	if c.Issuer == "" {
+		return errors.New("issuer is required")
+	}
+	if c.ClientID == "" {
+		return errors.New("client_id is required")
+	}
+	if c.Name == "" {
+		c.Name = "Single sign-on"
+	}
+	if !slices.Contains(c.Scopes, oidc.ScopeOpenID) {
+		if len(c.Scopes) == 0 {
+			c.Scopes = []string{"profile", "email"}
+		}
+		c.Scopes = append([]string{oidc.ScopeOpenID}, c.Scopes...)
+	}
+	if c.UsernameClaim == "" {
+		c.UsernameClaim = "preferred_username"
+	}
+	if c.GroupsClaim == "" {
+		c.GroupsClaim = "groups"
+	}
+	for group, role := range c.RoleMapping {
+		if !slices.Contains(validRoles, role) {
+			return fmt.Errorf("group %s maps to an invalid role %q: must be one of %v", group, role, validRoles)
+		}
+	}
+	if c.DefaultRole != "" && !slices.Contains(validRoles, c.DefaultRole) {
+		return fmt.Errorf("invalid default_role %q: must be one of %v", c.DefaultRole, validRoles)
+	}
+	return nil
+}
+
+// roleFor returns the most privileged role the groups of a user map to, or
+// the default role. It is empty when the user should be refused.
+func (c *ConfigOIDC) roleFor(groups []string) string {
+	role := c.DefaultRole
+	for _, group := range groups {
+		if mapped, ok := c.RoleMapping[group]; ok && slices.Index(validRoles, mapped) > slices.Index(validRoles, role) {
+			role = mapped
+		}
+	}
+	return role
+}
+
+// oidcConfig discovers the provider on first use, so the server starts even
+// when it is down, and returns the OAuth2 settings for a request
+func (a *AnnotatorApp) oidcConfig(r *http.Request) (*oauth2.Config, *oidc.Provider, error) {
+	a.oidc.mutex.Lock()
+	defer a.oidc.mutex.Unlock()
+	if a.oidc.provider == nil {
+		// The context is kept to refresh the signing keys later on
+		ctx := oidc.ClientContext(context.Background(), oidcHTTPClient)
+		provider, err := oidc.NewProvider(ctx, a.Config.OIDC.Issuer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("while discovering %s: %w", a.Config.OIDC.Issuer, err)
+		}
+		a.oidc.provider = provider
+	}
+
+	redirectURL := a.Config.OIDC.RedirectURL
+	if redirectURL == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		redirectURL = scheme + "://" + r.Host + a.url(oidcCallbackPath)
+	}
+	return &oauth2.Config{
+		ClientID:     a.Config.OIDC.ClientID,
+		ClientSecret: a.Config.OIDC.ClientSecret,
+		Endpoint:     a.oidc.provider.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       a.Config.OIDC.Scopes,
+	}, a.oidc.provider, nil
+}
+
+// StartOIDCLogin returns the address of the provider to sign in at, and the
+// cookie that carries the PKCE verifier and state until the callback
+func (a *AnnotatorApp) StartOIDCLogin(r *http.Request, next string) (string, *http.Cookie, error) {
+	config, _, err := a.oidcConfig(r)
+	if err != nil {
+		return "", nil, err
+	}
+	key, err := a.sessionKey(r.Context())
+	if err != nil {
+		return "", nil, err
+	}
+
+	state := oidcLoginState{
+		State:    rand.Text(),
+		Verifier: oauth2.GenerateVerifier(),
+		Nonce:    rand.Text(),
+		Next:     safeRedirect(next),
+		Expires:  time.Now().Add(oidcLoginTimeout).Unix(),
+	}
+	value, err := json.Marshal(state)
+	if err != nil {
+		return "", nil, err
+	}
+	cookie := &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    signCookie(key, oidcCookieName, value),
+		Path:     a.url("/oidc/"),
+		MaxAge:   int(oidcLoginTimeout / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// The callback is a top level navigation from the provider, which Lax allows
+		SameSite: http.SameSiteLaxMode,
+	}
+	authURL := config.AuthCodeURL(state.State, oauth2.S256ChallengeOption(state.Verifier), oidc.Nonce(state.Nonce))
+	return authURL, cookie, nil
+}
+
+// FinishOIDCLogin checks the callback of the provider and returns the user that
+// signed in, their role when they are missing from the config, and where to go next
+func (a *AnnotatorApp) FinishOIDCLogin(r *http.Request) (username, role, next string, err error) {
+	config, provider, err := a.oidcConfig(r)
+	if err != nil {
+		return "", "", "", err
+	}
+	key, err := a.sessionKey(r.Context())
+	if err != nil {
+		return "", "", "", err
+	}
+
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		return "", "", "", errors.New("login state cookie is missing")
+	}
+	value, ok := verifyCookie(key, oidcCookieName, cookie.Value)
+	if !ok {
+		return "", "", "", errors.New("login state cookie has a bad signature")
+	}
+	var state oidcLoginState
+	if err := json.Unmarshal(value, &state); err != nil {
+		return "", "", "", err
+	}
+	if time.Now().Unix() > state.Expires {
+		return "", "", "", errors.New("login took too long")
+	}
+	if r.URL.Query().Get("state") != state.State {
+		return "", "", "", errors.New("state does not match")
+	}
+	if message := r.URL.Query().Get("error"); message != "" {
+		return "", "", "", fmt.Errorf("provider error: %s %s", message, r.URL.Query().Get("error_description"))
+	}
+
+	ctx := oidc.ClientContext(r.Context(), oidcHTTPClient)
+	token, err := config.Exchange(ctx, r.URL.Query().Get("code"), oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		return "", "", "", fmt.Errorf("while exchanging the code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", "", "", errors.New("token response has no id_token")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: config.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return "", "", "", fmt.Errorf("while verifying the id_token: %w", err)
+	}
+	if idToken.Nonce != state.Nonce {
+		return "", "", "", errors.New("nonce does not match")
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return "", "", "", err
+	}
+	username, _ = claims[a.Config.OIDC.UsernameClaim].(string)
+	if username == "" {
+		return "", "", "", fmt.Errorf("id_token has no %s claim", a.Config.OIDC.UsernameClaim)
+	}
+
+	// Users listed in the config keep their settings there
+	if _, ok := a.Config.Authentication[username]; ok {
+		return username, "", state.Next, nil
+	}
+	role = a.Config.OIDC.roleFor(claimStrings(claims[a.Config.OIDC.GroupsClaim]))
+	if role == "" {
+		a.audit(r.Context(), "login refused: no group gives a role", "user", username, "provider", a.Config.OIDC.Name)
+		return username, "", state.Next, errOIDCNoRole
+	}
+	return username, role, state.Next, nil
+}
+
+// claimStrings reads a claim holding either a list of strings or a single one
+func claimStrings(claim any) []string {
+	switch claim := claim.(type) {
+	case string:
+		return []string{claim}
+	case []any:
+		var values []string
+		for _, item := range claim {
+			if value, ok := item.(string); ok {
+				values = append(values, value)
+			}
+		}
+		return values
+	}
+	return nil
+}
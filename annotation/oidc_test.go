@@ -0,0 +1,270 @@
+package annotation
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// testIdentityProvider is a stand-in OpenID Connect provider that signs in
+// whoever is set as its current user
+type testIdentityProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mutex  sync.Mutex
+	claims map[string]any
+	codes  map[string]testAuthorization
+}
+
+type testAuthorization struct {
+	challenge string
+	nonce     string
+	claims    map[string]any
+}
+
+func newTestIdentityProvider(t *testing.T) *testIdentityProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	idp := &testIdentityProvider{key: key, codes: map[string]testAuthorization{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: string(jose.RS256), Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "rotulador" {
+			http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+			return
+		}
+		code := rand.Text()
+		idp.mutex.Lock()
+		idp.codes[code] = testAuthorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), claims: idp.claims}
+		idp.mutex.Unlock()
+		redirect, _ := url.Parse(query.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mutex.Lock()
+		authorization, ok := idp.codes[r.FormValue("code")]
+		delete(idp.codes, r.FormValue("code"))
+		idp.mutex.Unlock()
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		w.Header().Set("Content-Type", "application/json")
+		if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+		claims := map[string]any{
+			"iss":   idp.URL,
+			"sub":   "subject",
+			"aud":   "rotulador",
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": authorization.nonce,
+		}
+		for name, value := range authorization.claims {
+			claims[name] = value
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     idp.sign(t, claims),
+		})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *testIdentityProvider) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: idp.key}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test"))
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	payload, _ := json.Marshal(claims)
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	token, _ := signed.CompactSerialize()
+	return token
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newTestIdentityProvider(t)
+	app := newTestAppWithUsers(t, map[string]string{"carol": "role: viewer"}, `
+oidc:
+  name: Campus account
+  issuer: `+idp.URL+`
+  client_id: rotulador
+  client_secret: secret
+  username_claim: email
+  role_mapping:
+    staff: reviewer
+    students: annotator
+`+testConfigTasks, 1)
+	handler := app.GetHTTPHandler()
+	browser := http.Header{"Accept": {"text/html"}}
+
+	send := func(target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header = browser.Clone()
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	cookieNamed := func(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == name && cookie.MaxAge >= 0 {
+				return cookie
+			}
+		}
+		return nil
+	}
+	// signIn goes through the provider as a user, returning the callback response
+	signIn := func(t *testing.T, claims map[string]any, tamper func(callback *url.URL)) *httptest.ResponseRecorder {
+		t.Helper()
+		idp.mutex.Lock()
+		idp.claims = claims
+		idp.mutex.Unlock()
+
+		rec := send("/oidc/login?next=/stats")
+		stateCookie := cookieNamed(rec, oidcCookieName)
+		if rec.Code != http.StatusSeeOther || stateCookie == nil || !strings.HasPrefix(rec.Header().Get("Location"), idp.URL+"/authorize?") {
+			t.Fatalf("login: got %d to %q", rec.Code, rec.Header().Get("Location"))
+		}
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(rec.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("authorize: %v", err)
+		}
+		resp.Body.Close()
+		callback, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil || callback.Path != oidcCallbackPath {
+			t.Fatalf("authorize: got %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+		}
+		if tamper != nil {
+			tamper(callback)
+		}
+		return send(callback.RequestURI(), stateCookie)
+	}
+
+	t.Run("login page offers the provider", func(t *testing.T) {
+		body := send("/login").Body.String()
+		if !strings.Contains(body, "Sign in with Campus account") || !strings.Contains(body, `name="password"`) {
+			t.Error("expected both the provider and the password form")
+		}
+	})
+
+	t.Run("groups map to roles", func(t *testing.T) {
+		rec := signIn(t, map[string]any{"email": "dana@example.com", "groups": []string{"students", "staff"}}, nil)
+		session := cookieNamed(rec, sessionCookieName)
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/stats" || session == nil {
+			t.Fatalf("callback: got %d to %q", rec.Code, rec.Header().Get("Location"))
+		}
+		rec = send("/stats", session)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Logged in as <strong>dana@example.com</strong>") {
+			t.Errorf("stats as reviewer: status = %d", rec.Code)
+		}
+	})
+
+	t.Run("users in the config keep their settings", func(t *testing.T) {
+		session := cookieNamed(signIn(t, map[string]any{"email": "carol", "groups": []string{"staff"}}, nil), sessionCookieName)
+		if rec := send("/annotate/", session); rec.Code != http.StatusForbidden {
+			t.Errorf("annotate as viewer: status = %d, want 403", rec.Code)
+		}
+	})
+
+	t.Run("users without a role are refused", func(t *testing.T) {
+		rec := signIn(t, map[string]any{"email": "eve@example.com", "groups": []string{"guests"}}, nil)
+		if rec.Code != http.StatusForbidden || cookieNamed(rec, sessionCookieName) != nil {
+			t.Errorf("status = %d, want 403 without a session", rec.Code)
+		}
+	})
+
+	t.Run("state must match", func(t *testing.T) {
+		rec := signIn(t, map[string]any{"email": "dana@example.com", "groups": "staff"}, func(callback *url.URL) {
+			query := callback.Query()
+			query.Set("state", "forged")
+			callback.RawQuery = query.Encode()
+		})
+		if rec.Code != http.StatusUnauthorized || cookieNamed(rec, sessionCookieName) != nil {
+			t.Errorf("status = %d, want 401 without a session", rec.Code)
+		}
+	})
+
+	t.Run("code needs the PKCE verifier", func(t *testing.T) {
+		// A code stolen on its way back is useless without the verifier kept in the browser
+		rec := signIn(t, map[string]any{"email": "dana@example.com", "groups": "staff"}, func(callback *url.URL) {
+			idp.mutex.Lock()
+			defer idp.mutex.Unlock()
+			code := callback.Query().Get("code")
+			authorization := idp.codes[code]
+			authorization.challenge = "other"
+			idp.codes[code] = authorization
+		})
+		if rec.Code != http.StatusUnauthorized || cookieNamed(rec, sessionCookieName) != nil {
+			t.Errorf("status = %d, want 401 without a session", rec.Code)
+		}
+	})
+}
+
+func TestLoadConfig_OIDC(t *testing.T) {
+	for name, content := range map[string]string{
+		"no issuer":       "oidc: { client_id: x }\ntasks: []\n",
+		"no client":       "oidc: { issuer: https://idp.example.com }\ntasks: []\n",
+		"invalid role":    "oidc: { issuer: https://idp.example.com, client_id: x, role_mapping: { staff: boss } }\ntasks: []\n",
+		"invalid default": "oidc: { issuer: https://idp.example.com, client_id: x, default_role: boss }\ntasks: []\n",
+	} {
+		t.Run("rejects "+name, func(t *testing.T) {
+			if _, err := LoadConfig(writeRawConfig(t, content)); err == nil {
+				t.Error("expected an error, got none")
+			}
+		})
+	}
+
+	config, err := LoadConfig(writeRawConfig(t, "oidc: { issuer: https://idp.example.com, client_id: x, scopes: [groups] }\ntasks: []\n"))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if got := strings.Join(config.OIDC.Scopes, " "); got != "openid groups" {
+		t.Errorf("scopes = %q, want openid added", got)
+	}
+	if config.OIDC.roleFor([]string{"anyone"}) != "" {
+		t.Error("users without a mapped group should be refused without a default role")
+	}
+}
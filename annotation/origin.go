@@ -0,0 +1,76 @@
+package annotation
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// validateOrigin checks an origin such as https://annotate.example.com
+func validateOrigin(origin string) error {
+	parsed, err := url.Parse(origin)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid origin %q: must be a scheme and host such as https://annotate.example.com", origin)
+	}
+	if (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" || parsed.User != nil {
+		return fmt.Errorf("invalid origin %q: must not have a path, query or credentials", origin)
+	}
+	return nil
+}
+
+// checkSameOrigin returns an error for state changing requests that a browser
+// sends on behalf of another site. Browsers tell where a request comes from in
+// Sec-Fetch-Site, Origin or, for older ones, Referer; clients that send none of
+// them are not browsers and carry no ambient credentials.
+func (a *AnnotatorApp) checkSameOrigin(r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	// Browsers never attach API tokens on their own
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return nil
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin != "" && slices.Contains(a.Config.TrustedOrigins, strings.TrimSuffix(origin, "/")) {
+		return nil
+	}
+	switch site := r.Header.Get("Sec-Fetch-Site"); site {
+	case "same-origin", "none":
+		return nil
+	case "":
+	default:
+		return fmt.Errorf("Sec-Fetch-Site is %s", site)
+	}
+
+	source := origin
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return nil
+	}
+	parsed, err := url.Parse(source)
+	if err != nil || parsed.Host != r.Host {
+		return fmt.Errorf("sent from %s", source)
+	}
+	return nil
+}
+
+// crossOriginMiddleware refuses cross-site requests that change something,
+// which would otherwise be sent with the session cookie or cached Basic
+// credentials of the user
+func (a *AnnotatorApp) crossOriginMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := a.checkSameOrigin(r); err != nil {
+			slog.WarnContext(r.Context(), "auth: refused cross-origin request", "method", r.Method, "path", r.URL.Path, "client_ip", clientIP(r), "error", err)
+			denyRequest(w, r, http.StatusForbidden, "cross-origin request refused")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,118 @@
+package annotation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCrossOriginProtection(t *testing.T) {
+	app := newTestAppWithUsers(t, nil, "trusted_origins: [https://annotate.example.org]\n"+testConfigTasks, 1)
+	handler := app.GetHTTPHandler()
+	target := "/annotate/has_car/" + testImageHash(0)
+
+	// annotate posts an annotation with the cached Basic credentials of the admin, as browsers do
+	annotate := func(t *testing.T, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		form := url.Values{"selectedClass": {"true"}, "sure": {"on"}}
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		req.SetBasicAuth("admin", "changeme")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	annotated := func(t *testing.T) bool {
+		t.Helper()
+		ann, err := app.annotationRepo.Get(context.Background(), testImageHash(0), "admin", 0)
+		if err != nil {
+			t.Fatalf("annotationRepo.Get() error = %v", err)
+		}
+		return ann != nil
+	}
+
+	for name, header := range map[string]http.Header{
+		"form on another site":       {"Origin": {"https://evil.example.com"}, "Sec-Fetch-Site": {"cross-site"}},
+		"sibling subdomain":          {"Origin": {"https://evil.example.com"}, "Sec-Fetch-Site": {"same-site"}},
+		"sandboxed frame":            {"Origin": {"null"}},
+		"browser without fetch data": {"Origin": {"https://evil.example.com"}},
+		"old browser":                {"Referer": {"https://evil.example.com/attack.html"}},
+		"htmx header does not help":  {"Origin": {"https://evil.example.com"}, "Hx-Request": {"true"}},
+	} {
+		t.Run("refuses "+name, func(t *testing.T) {
+			if rec := annotate(t, header); rec.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", rec.Code)
+			}
+			if annotated(t) {
+				t.Fatal("annotation stored by a cross-site request")
+			}
+		})
+	}
+
+	t.Run("refuses logging in from another site", func(t *testing.T) {
+		form := url.Values{"username": {"admin"}, "password": {"changeme"}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://evil.example.com")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden || len(rec.Result().Cookies()) != 0 {
+			t.Errorf("status = %d, want 403 without a session", rec.Code)
+		}
+	})
+
+	for name, header := range map[string]http.Header{
+		"same origin":              {"Origin": {"http://example.com"}, "Sec-Fetch-Site": {"same-origin"}, "Hx-Request": {"true"}},
+		"same origin without data": {"Origin": {"http://example.com"}},
+		"same origin referer":      {"Referer": {"http://example.com/annotate/has_car/"}},
+		"trusted origin":           {"Origin": {"https://annotate.example.org"}, "Sec-Fetch-Site": {"cross-site"}},
+		"scripts":                  nil,
+	} {
+		t.Run("accepts "+name, func(t *testing.T) {
+			if rec := annotate(t, header); rec.Code != http.StatusOK {
+				t.Errorf("status = %d, want 200", rec.Code)
+			}
+		})
+	}
+
+	t.Run("reading is not affected", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/me/history", nil)
+		req.Header.Set("Sec-Fetch-Site", "cross-site")
+		req.SetBasicAuth("admin", "changeme")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("status = %d, want 200", rec.Code)
+		}
+	})
+
+	t.Run("API tokens are not ambient", func(t *testing.T) {
+		token, err := app.CreateAPIToken(context.Background(), &RequestUser{Name: "admin", ConfigAuth: app.Config.Authentication["admin"]}, "test", []string{ScopeAnnotate}, nil)
+		if err != nil {
+			t.Fatalf("CreateAPIToken() error = %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader("selectedClass=false&sure=on"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://tool.example.net")
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("status = %d, want 200", rec.Code)
+		}
+	})
+}
+
+func TestLoadConfig_TrustedOrigins(t *testing.T) {
+	for _, origin := range []string{"annotate.example.org", "https://annotate.example.org/path", "ftp://example.org"} {
+		if _, err := LoadConfig(writeTestConfig(t, "trusted_origins: ["+origin+"]\n"+testConfigTasks)); err == nil {
+			t.Errorf("origin %q: expected an error, got none", origin)
+		}
+	}
+}
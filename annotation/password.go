@@ -0,0 +1,325 @@
+package annotation
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Password hash algorithms accepted in `auth.<user>.password`
+const (
+	PasswordArgon2id = "argon2id"
+	PasswordBcrypt   = "bcrypt"
+)
+
+// argon2id parameters for new hashes, following the OWASP recommendation
+const (
+	argon2Memory  = 19 * 1024
+	argon2Time    = 2
+	argon2Threads = 1
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// plainYAMLKeyPattern matches usernames that can be written as YAML keys without quotes
+var plainYAMLKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.@-]*$`)
+
+// dummyAuth is checked against when a user does not exist, so failed logins
+// take the same time whether or not the user is known
+var dummyAuth = sync.OnceValue(func() *ConfigAuth {
+	hash, err := HashPassword(rand.Text(), PasswordArgon2id)
+	if err != nil {
+		panic(err)
+	}
+	return &ConfigAuth{Password: hash}
+})
+
+// IsPasswordHash tells whether a stored password is a hash this program knows how to check
+func IsPasswordHash(stored string) bool {
+	return strings.HasPrefix(stored, "$argon2id$") || isBcryptHash(stored)
+}
+
+func isBcryptHash(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
+
+// HashPassword hashes a password with the given algorithm, in the format accepted by the config
+func HashPassword(password, algorithm string) (string, error) {
+	switch algorithm {
+	case PasswordArgon2id, "":
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		), nil
+	case PasswordBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	default:
+		return "", fmt.Errorf("unknown password hash algorithm: %s", algorithm)
+	}
+}
+
+// CheckPassword tells whether password matches the stored one, in constant time.
+// Hashes are slow to check on purpose and browsers send Basic auth credentials
+// with every request, so the last password that matched is remembered as a digest.
+func (c *ConfigAuth) CheckPassword(password string) bool {
+	digest := sha256.Sum256([]byte(password))
+	c.verifiedMutex.Lock()
+	cached := c.verified
+	c.verifiedMutex.Unlock()
+	if cached != nil && subtle.ConstantTimeCompare(cached[:], digest[:]) == 1 {
+		return true
+	}
+
+	var ok bool
+	switch {
+	case strings.HasPrefix(c.Password, "$argon2id$"):
+		ok = checkArgon2id(c.Password, password)
+	case isBcryptHash(c.Password):
+		ok = bcrypt.CompareHashAndPassword([]byte(c.Password), []byte(password)) == nil
+	default:
+		stored := sha256.Sum256([]byte(c.Password))
+		ok = subtle.ConstantTimeCompare(stored[:], digest[:]) == 1
+	}
+	if ok {
+		c.verifiedMutex.Lock()
+		c.verified = &digest
+		c.verifiedMutex.Unlock()
+	}
+	return ok
+}
+
+func checkArgon2id(encoded, password string) bool {
+	// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	computed := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, computed) == 1
+}
+
+// SetConfigPassword stores a password hash for a user in a config file, adding the
+// user if needed. The rest of the file, including comments, is kept.
+func SetConfigPassword(configFile, username, hash string) error {
+	content, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return fmt.Errorf("while parsing %s: %w", configFile, err)
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return errors.New("config root is not a mapping")
+	}
+	info, err := os.Stat(configFile)
+	if err != nil {
+		return err
+	}
+
+	auth := mappingValue(document.Content[0], "auth")
+	if !hasKey(auth, username) {
+		if updated, ok := appendMappingEntry(content, auth, username, hash); ok {
+			return os.WriteFile(configFile, updated, info.Mode().Perm())
+		}
+	}
+	user := mappingValue(auth, username)
+	passwordNode := mappingValue(user, "password")
+
+	// Replacing just the value keeps the file byte for byte, blank lines included
+	if updated, ok := replaceScalar(content, passwordNode, user.Style == yaml.FlowStyle, hash); ok {
+		return os.WriteFile(configFile, updated, info.Mode().Perm())
+	}
+
+	passwordNode.Kind = yaml.ScalarNode
+	passwordNode.Tag = "!!str"
+	passwordNode.Style = yaml.DoubleQuotedStyle
+	passwordNode.Value = hash
+
+	var b strings.Builder
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(configFile, []byte(b.String()), info.Mode().Perm())
+}
+
+// replaceScalar replaces a single line scalar in the source it was parsed from
+// with a double quoted value. It is not ok for nodes it cannot locate safely.
+func replaceScalar(content []byte, node *yaml.Node, flow bool, value string) ([]byte, bool) {
+	if node.Kind != yaml.ScalarNode || node.Line == 0 {
+		return nil, false
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if node.Line > len(lines) {
+		return nil, false
+	}
+	line := lines[node.Line-1]
+	start := node.Column - 1
+	if start < 0 || start >= len(line) {
+		return nil, false
+	}
+
+	var end int
+	switch node.Style {
+	case yaml.DoubleQuotedStyle:
+		end = -1
+		for idx := start + 1; idx < len(line); idx++ {
+			if line[idx] == '\\' {
+				idx++
+			} else if line[idx] == '"' {
+				end = idx + 1
+				break
+			}
+		}
+	case yaml.SingleQuotedStyle:
+		end = -1
+		for idx := start + 1; idx < len(line); idx++ {
+			if line[idx] == '\'' {
+				if idx+1 < len(line) && line[idx+1] == '\'' {
+					idx++
+					continue
+				}
+				end = idx + 1
+				break
+			}
+		}
+	case 0:
+		end = len(strings.TrimRight(line, "\r\n"))
+		if comment := strings.Index(line[start:], " #"); comment != -1 {
+			end = start + comment
+		}
+		if flow {
+			if stop := strings.IndexAny(line[start:end], ",}"); stop != -1 {
+				end = start + stop
+			}
+		}
+		end = start + len(strings.TrimRight(line[start:end], " \t"))
+	default:
+		return nil, false
+	}
+	if end <= start {
+		return nil, false
+	}
+
+	lines[node.Line-1] = line[:start] + strconv.Quote(value) + line[end:]
+	return []byte(strings.Join(lines, "")), true
+}
+
+// appendMappingEntry adds a user with a password after the last line of a block
+// mapping in the source it was parsed from. It is not ok for mappings it cannot locate safely.
+func appendMappingEntry(content []byte, mapping *yaml.Node, username, hash string) ([]byte, bool) {
+	if mapping.Kind != yaml.MappingNode || mapping.Style == yaml.FlowStyle || len(mapping.Content) == 0 {
+		return nil, false
+	}
+	lastLine := 0
+	var walk func(node *yaml.Node) bool
+	walk = func(node *yaml.Node) bool {
+		if node.Style == yaml.LiteralStyle || node.Style == yaml.FoldedStyle {
+			return false
+		}
+		lastLine = max(lastLine, node.Line)
+		for _, child := range node.Content {
+			if !walk(child) {
+				return false
+			}
+		}
+		return true
+	}
+	if !walk(mapping) {
+		return nil, false
+	}
+
+	lines := strings.SplitAfter(string(content), "\n")
+	if lastLine == 0 || lastLine > len(lines) {
+		return nil, false
+	}
+	indent := strings.Repeat(" ", mapping.Content[0].Column-1)
+	nestedIndent := indent + "  "
+	if first := mapping.Content[1]; first.Kind == yaml.MappingNode && first.Style != yaml.FlowStyle && len(first.Content) > 0 {
+		nestedIndent = strings.Repeat(" ", first.Content[0].Column-1)
+	}
+	if !strings.HasSuffix(lines[lastLine-1], "\n") {
+		lines[lastLine-1] += "\n"
+	}
+	key := username
+	if !plainYAMLKeyPattern.MatchString(key) {
+		key = strconv.Quote(key)
+	}
+	entry := fmt.Sprintf("%s%s:\n%spassword: %s\n", indent, key, nestedIndent, strconv.Quote(hash))
+
+	updated := slices.Concat(lines[:lastLine], []string{entry}, lines[lastLine:])
+	return []byte(strings.Join(updated, "")), true
+}
+
+func hasKey(mapping *yaml.Node, key string) bool {
+	if mapping.Kind != yaml.MappingNode {
+		return false
+	}
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		if mapping.Content[idx].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
+// mappingValue returns the value of a key in a YAML mapping, creating an empty mapping for it if missing
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		// An empty value such as `auth:` parses as a null scalar
+		*mapping = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		if mapping.Content[idx].Value == key {
+			return mapping.Content[idx+1]
+		}
+	}
+	value := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	return value
+}
@@ -0,0 +1,123 @@
+package annotation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	for _, algorithm := range []string{PasswordArgon2id, PasswordBcrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			hash, err := HashPassword("s3cret", algorithm)
+			if err != nil {
+				t.Fatalf("HashPassword() error = %v", err)
+			}
+			if !IsPasswordHash(hash) {
+				t.Fatalf("hash %q not recognized", hash)
+			}
+			auth := &ConfigAuth{Password: hash}
+			if auth.CheckPassword("wrong") {
+				t.Error("wrong password accepted")
+			}
+			if !auth.CheckPassword("s3cret") {
+				t.Error("right password rejected")
+			}
+			// Second check hits the cache of the last verified password
+			if !auth.CheckPassword("s3cret") || auth.CheckPassword("s3cret ") {
+				t.Error("cached check gave a wrong answer")
+			}
+		})
+	}
+
+	t.Run("plaintext", func(t *testing.T) {
+		auth := &ConfigAuth{Password: "s3cret"}
+		if !auth.CheckPassword("s3cret") || auth.CheckPassword("s3cre") {
+			t.Error("plaintext comparison gave a wrong answer")
+		}
+	})
+
+	t.Run("malformed argon2id hash", func(t *testing.T) {
+		auth := &ConfigAuth{Password: "$argon2id$v=19$m=x$salt$key"}
+		if auth.CheckPassword("") {
+			t.Error("malformed hash accepted a password")
+		}
+	})
+}
+
+func TestLoadConfig_Passwords(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		configPath := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+		return configPath
+	}
+
+	t.Run("rejects plaintext passwords by default", func(t *testing.T) {
+		_, err := LoadConfig(write(t, "auth:\n  admin: { password: changeme }\ntasks: []\n"))
+		if err == nil || !strings.Contains(err.Error(), "plaintext") {
+			t.Errorf("expected a plaintext password error, got %v", err)
+		}
+	})
+
+	t.Run("accepts plaintext passwords when allowed", func(t *testing.T) {
+		_, err := LoadConfig(write(t, "allow_plaintext_passwords: true\nauth:\n  admin: { password: changeme }\ntasks: []\n"))
+		if err != nil {
+			t.Errorf("LoadConfig() error = %v", err)
+		}
+	})
+}
+
+func TestSetConfigPassword(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	original := `# Project config
+allow_plaintext_passwords: true
+auth:
+  admin:
+    password: "changeme" # to be replaced
+  carol: { password: changeme, }
+
+tasks:
+  - id: has_car
+    name: Has car?
+    type: boolean
+`
+	if err := os.WriteFile(configPath, []byte(original), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	for _, user := range []string{"admin", "bob", "carol"} {
+		hash, err := HashPassword(user+"-password", PasswordBcrypt)
+		if err != nil {
+			t.Fatalf("HashPassword() error = %v", err)
+		}
+		if err := SetConfigPassword(configPath, user, hash); err != nil {
+			t.Fatalf("SetConfigPassword() error = %v", err)
+		}
+	}
+
+	content, _ := os.ReadFile(configPath)
+	for _, kept := range []string{"# Project config", "# to be replaced", "carol: { password: \"$2a$", "\n\ntasks:"} {
+		if !strings.Contains(string(content), kept) {
+			t.Errorf("expected %q to be kept:\n%s", kept, content)
+		}
+	}
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	for _, user := range []string{"admin", "bob", "carol"} {
+		auth := config.Authentication[user]
+		if auth == nil || !IsPasswordHash(auth.Password) || !auth.CheckPassword(user+"-password") {
+			t.Errorf("password of %s not updated: %+v", user, auth)
+		}
+	}
+	if len(config.Tasks) != 1 || config.Tasks[0].ID != "has_car" {
+		t.Errorf("tasks were changed: %+v", config.Tasks)
+	}
+	if info, _ := os.Stat(configPath); info.Mode().Perm() != 0600 {
+		t.Errorf("file mode changed to %v", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,324 @@
+package annotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ProjectsManifestName is the manifest looked for in a folder of projects
+	ProjectsManifestName = "projects.yaml"
+	// projectRoleNone keeps a shared user out of a project
+	projectRoleNone = "none"
+)
+
+// projectNamePattern matches the names of projects, used in their URL
+var projectNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ProjectsManifest lists the projects served together and the users they share
+type ProjectsManifest struct {
+	// Authentication lists the users of every project, as the auth section of
+	// a config. Their role can be changed per project, their tasks are not
+	// restricted here as tasks are not shared.
+	Authentication map[string]*ConfigAuth `yaml:"auth"`
+	// AllowPlaintextPasswords accepts passwords that are not hashed in the auth section
+	AllowPlaintextPasswords bool `yaml:"allow_plaintext_passwords"`
+	// Projects are served in this order, every folder with a config.yaml next to the manifest when empty
+	Projects []*ConfigProject `yaml:"projects"`
+}
+
+// ConfigProject is a project of a ProjectsManifest
+type ConfigProject struct {
+	// Name of the project, in its URL /p/<name>/
+	Name string `yaml:"name"`
+	// Path of the project folder, laid out as 'rotulador <folder>' creates it,
+	// relative to the manifest. It is the name of the project by default.
+	Path string `yaml:"path"`
+	// Roles of the shared users in this project, none keeps one out of it
+	Roles map[string]string `yaml:"roles"`
+}
+
+// ConfigFile returns the config of the project
+func (p *ConfigProject) ConfigFile() string {
+	return filepath.Join(p.Path, "config.yaml")
+}
+
+// DatabaseFile returns the database of the project
+func (p *ConfigProject) DatabaseFile() string {
+	return filepath.Join(p.Path, "annotations.db")
+}
+
+// ImagesDir returns the images folder of the project
+func (p *ConfigProject) ImagesDir() string {
+	return filepath.Join(p.Path, "images")
+}
+
+// LoadProjectsManifest reads a projects manifest, or a folder of project
+// folders whose projects.yaml, if any, is the manifest
+func LoadProjectsManifest(path string) (*ProjectsManifest, error) {
+	dir, filename := filepath.Dir(path), path
+	if stat, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if stat.IsDir() {
+		dir, filename = path, filepath.Join(path, ProjectsManifestName)
+	}
+
+	var manifest ProjectsManifest
+	data, err := os.ReadFile(filename)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && filename != path:
+		// A folder of projects without users in common
+	case err != nil:
+		return nil, err
+	default:
+		if err := yaml.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+
+	if len(manifest.Projects) == 0 {
+		if manifest.Projects, err = findProjects(dir); err != nil {
+			return nil, err
+		}
+		if len(manifest.Projects) == 0 {
+			return nil, fmt.Errorf("no projects in %s: list them in %s or add folders with a config.yaml", dir, ProjectsManifestName)
+		}
+	}
+	if err := manifest.validate(dir); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return &manifest, nil
+}
+
+// findProjects returns the folders of dir holding a config.yaml, by name
+func findProjects(dir string) ([]*ConfigProject, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var projects []*ConfigProject
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		project := &ConfigProject{Name: entry.Name(), Path: entry.Name()}
+		if _, err := os.Stat(filepath.Join(dir, project.ConfigFile())); err == nil {
+			projects = append(projects, project)
+		}
+	}
+	return projects, nil
+}
+
+// validate checks the manifest and resolves the paths of the projects from dir
+func (m *ProjectsManifest) validate(dir string) error {
+	for username, auth := range m.Authentication {
+		if auth == nil || auth.Password == "" {
+			return fmt.Errorf("user %s has a null password", username)
+		}
+		if auth.Role != "" && !slices.Contains(validRoles, auth.Role) {
+			return fmt.Errorf("user %s has an invalid role %q: must be one of %v", username, auth.Role, validRoles)
+		}
+		if len(auth.AllowTasks) > 0 || len(auth.DenyTasks) > 0 {
+			return fmt.Errorf("user %s: allow_tasks and deny_tasks are set in the config of each project", username)
+		}
+	}
+	names := map[string]bool{}
+	for idx, project := range m.Projects {
+		if !projectNamePattern.MatchString(project.Name) {
+			return fmt.Errorf("projects[%d]: invalid name %q: use lowercase letters, digits, - and _", idx, project.Name)
+		}
+		if names[project.Name] {
+			return fmt.Errorf("project %s is listed twice", project.Name)
+		}
+		names[project.Name] = true
+		for username, role := range project.Roles {
+			if _, ok := m.Authentication[username]; !ok {
+				return fmt.Errorf("project %s: roles: user %s is not in the auth section", project.Name, username)
+			}
+			if role != projectRoleNone && !slices.Contains(validRoles, role) {
+				return fmt.Errorf("project %s: user %s has an invalid role %q: must be one of %v or %s", project.Name, username, role, validRoles, projectRoleNone)
+			}
+		}
+		if project.Path == "" {
+			project.Path = project.Name
+		}
+		if !filepath.IsAbs(project.Path) {
+			project.Path = filepath.Join(dir, project.Path)
+		}
+	}
+	return nil
+}
+
+// LoadConfig loads the config of a project, with the shared users in the roles they have there
+func (m *ProjectsManifest) LoadConfig(project *ConfigProject) (*Config, error) {
+	config, err := loadConfig(project.ConfigFile(), func(config *Config) error {
+		if config.Authentication == nil {
+			config.Authentication = map[string]*ConfigAuth{}
+		}
+		for username, auth := range m.Authentication {
+			role := project.Roles[username]
+			if role == projectRoleNone {
+				continue
+			}
+			if _, ok := config.Authentication[username]; ok {
+				return fmt.Errorf("user %s is both in %s and in the auth section of the project, set its role with the roles of the project instead", username, ProjectsManifestName)
+			}
+			if role == "" {
+				role = auth.Role
+			}
+			// Each project gets its own copy, as ConfigAuth caches the password it verified
+			config.Authentication[username] = &ConfigAuth{Password: auth.Password, Role: role}
+		}
+		config.AllowPlaintextPasswords = config.AllowPlaintextPasswords || m.AllowPlaintextPasswords
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("project %s: %w", project.Name, err)
+	}
+	return config, nil
+}
+
+// Project is an app served by a ProjectServer
+type Project struct {
+	Name string
+	App  *AnnotatorApp
+}
+
+// ProjectServer serves several projects, each under /p/<name>/ of BasePath,
+// with a page to pick one at BasePath
+type ProjectServer struct {
+	BasePath string
+	Projects []*Project
+}
+
+// ProjectPath returns the path a project is served under
+func (s *ProjectServer) ProjectPath(name string) string {
+	return s.BasePath + "/p/" + name
+}
+
+// Handler sets the apps of the projects up under their ProjectPath and returns the handler serving them
+func (s *ProjectServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, project := range s.Projects {
+		project.App.BasePath = s.ProjectPath(project.Name)
+		handler := project.App.GetHTTPHandler()
+		mux.Handle(project.App.BasePath, handler)
+		mux.Handle(project.App.BasePath+"/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.ServeHTTP(w, r.WithContext(s.withProject(r.Context(), project)))
+		}))
+	}
+	mux.Handle("GET "+s.BasePath+"/{$}", i18nMiddleware(http.HandlerFunc(s.servePicker)))
+	mux.HandleFunc("GET "+s.BasePath+"/favicon.svg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(GetFavicon()))
+	})
+	mux.HandleFunc("GET "+s.BasePath+"/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Health{Status: "ok"})
+	})
+	mux.HandleFunc("GET "+s.BasePath+"/readyz", s.serveReadiness)
+	if s.BasePath != "" {
+		mux.Handle("GET "+s.BasePath, http.RedirectHandler(s.BasePath+"/", http.StatusMovedPermanently))
+	}
+	return mux
+}
+
+// servePicker lists the projects. It is served without authentication, as
+// each project has its own login, which only lets its users in.
+func (s *ProjectServer) servePicker(w http.ResponseWriter, r *http.Request) {
+	type projectItem struct {
+		Name        string
+		URL         string
+		Description string
+		Tasks       int
+	}
+	var items []projectItem
+	for _, project := range s.Projects {
+		items = append(items, projectItem{
+			Name:        project.Name,
+			URL:         project.App.url("/"),
+			Description: project.App.Config.Meta.Description,
+			Tasks:       len(project.App.Config.Tasks),
+		})
+	}
+	ctx := context.WithValue(r.Context(), basePathKey{}, s.BasePath)
+	if err := RenderPageWithContext(ctx, w, "projects.html", map[string]any{"Title": "Projects", "Projects": items}); err != nil {
+		slog.ErrorContext(ctx, "rendering projects template", "error", err)
+	}
+}
+
+// serveReadiness is ready when the databases of every project can be queried
+func (s *ProjectServer) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	health := Health{Status: "ready", Checks: map[string]string{}}
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	status := http.StatusOK
+	for _, project := range s.Projects {
+		health.Checks[project.Name] = "ok"
+		if err := project.App.Database.PingContext(ctx); err != nil {
+			slog.WarnContext(r.Context(), "readiness: database unavailable", "project", project.Name, "error", err)
+			health.Status, health.Checks[project.Name], status = "unavailable", "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, health)
+}
+
+// Start runs the ingestion, webhook deliveries and view pruning of every project in the background, until ctx is done
+func (s *ProjectServer) Start(ctx context.Context) {
+	for _, project := range s.Projects {
+		projectCtx := s.withProject(ctx, project)
+		project.App.BackgroundContext = projectCtx
+		project.App.StartIngestion(projectCtx)
+		project.App.StartWebhooks(projectCtx)
+		project.App.StartViewPruning(projectCtx)
+	}
+}
+
+// Wait blocks until the background work of every project returns, see AnnotatorApp.Wait
+func (s *ProjectServer) Wait() {
+	var wg sync.WaitGroup
+	for _, project := range s.Projects {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			project.App.Wait()
+		}()
+	}
+	wg.Wait()
+}
+
+// CloseEventStreams ends the live event streams of every project, see AnnotatorApp.CloseEventStreams
+func (s *ProjectServer) CloseEventStreams() {
+	for _, project := range s.Projects {
+		project.App.CloseEventStreams()
+	}
+}
+
+// projectPage is the project a page belongs to, linking back to the picker of its server
+type projectPage struct {
+	Name      string
+	PickerURL string
+}
+
+type projectKey struct{}
+
+// withProject returns a context logged and rendered as belonging to a project
+func (s *ProjectServer) withProject(ctx context.Context, project *Project) context.Context {
+	return context.WithValue(ctx, projectKey{}, &projectPage{Name: project.Name, PickerURL: s.BasePath + "/"})
+}
+
+// projectFromContext returns the project of a context served by a ProjectServer, or nil
+func projectFromContext(ctx context.Context) *projectPage {
+	project, _ := ctx.Value(projectKey{}).(*projectPage)
+	return project
+}
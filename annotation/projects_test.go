@@ -0,0 +1,187 @@
+package annotation
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestProjects writes a folder of project folders, with a manifest when one is given
+func writeTestProjects(t *testing.T, manifest string, configs map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, config := range configs {
+		if err := os.MkdirAll(filepath.Join(dir, name, "images"), 0755); err != nil {
+			t.Fatalf("failed to create project: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, "config.yaml"), []byte(config), 0644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+	}
+	if manifest != "" {
+		if err := os.WriteFile(filepath.Join(dir, ProjectsManifestName), []byte(manifest), 0644); err != nil {
+			t.Fatalf("failed to write manifest: %v", err)
+		}
+	}
+	return dir
+}
+
+// testProjectsManifest shares alice, an admin of cars only, and bob, kept out of cars
+func testProjectsManifest() string {
+	return `
+auth:
+  alice: { password: "` + testPasswordHash() + `", role: viewer }
+  bob: { password: "` + testPasswordHash() + `" }
+projects:
+  - name: cars
+    roles: { alice: admin, bob: none }
+  - name: birds
+    path: wildlife
+`
+}
+
+func TestLoadProjectsManifest(t *testing.T) {
+	birds := "meta:\n  description: Birds of the coast\n" + testConfigTasks
+	dir := writeTestProjects(t, testProjectsManifest(), map[string]string{"cars": testConfigTasks, "wildlife": birds, "unlisted": testConfigTasks})
+
+	manifest, err := LoadProjectsManifest(filepath.Join(dir, ProjectsManifestName))
+	if err != nil {
+		t.Fatalf("LoadProjectsManifest() error = %v", err)
+	}
+	if len(manifest.Projects) != 2 || manifest.Projects[1].Name != "birds" || manifest.Projects[1].ImagesDir() != filepath.Join(dir, "wildlife", "images") {
+		t.Fatalf("projects = %+v", manifest.Projects)
+	}
+
+	t.Run("shared users have their role in each project", func(t *testing.T) {
+		cars, err := manifest.LoadConfig(manifest.Projects[0])
+		if err != nil {
+			t.Fatalf("LoadConfig(cars) error = %v", err)
+		}
+		if cars.Authentication["alice"].Role != RoleAdmin || cars.Authentication["bob"] != nil {
+			t.Errorf("cars users = %v", cars.Authentication)
+		}
+		birds, err := manifest.LoadConfig(manifest.Projects[1])
+		if err != nil {
+			t.Fatalf("LoadConfig(birds) error = %v", err)
+		}
+		if birds.Authentication["alice"].Role != RoleViewer || birds.Authentication["bob"].Role != RoleAnnotator {
+			t.Errorf("birds users = %v", birds.Authentication)
+		}
+	})
+
+	t.Run("a folder without a manifest serves every project in it", func(t *testing.T) {
+		dir := writeTestProjects(t, "", map[string]string{"b": testConfigTasks, "a": testConfigTasks})
+		os.Mkdir(filepath.Join(dir, "notes"), 0755)
+		manifest, err := LoadProjectsManifest(dir)
+		if err != nil {
+			t.Fatalf("LoadProjectsManifest() error = %v", err)
+		}
+		if len(manifest.Projects) != 2 || manifest.Projects[0].Name != "a" || manifest.Projects[1].Name != "b" {
+			t.Errorf("projects = %+v", manifest.Projects)
+		}
+		// Without shared users, each project needs its own
+		if _, err := manifest.LoadConfig(manifest.Projects[0]); err == nil || !strings.Contains(err.Error(), "no users specified") {
+			t.Errorf("LoadConfig() error = %v", err)
+		}
+	})
+
+	for name, test := range map[string]struct{ manifest, config, err string }{
+		"invalid name":      {"projects: [{ name: Cars }]", testConfigTasks, "invalid name"},
+		"listed twice":      {"projects: [{ name: cars }, { name: cars }]", testConfigTasks, "listed twice"},
+		"unknown user role": {"projects: [{ name: cars, roles: { carol: admin } }]", testConfigTasks, "carol is not in the auth section"},
+		"invalid role":      {"auth: { alice: { password: x, role: owner } }\nprojects: [{ name: cars }]", testConfigTasks, "invalid role"},
+		"shared tasks":      {"auth: { alice: { password: x, allow_tasks: [has_car] } }\nprojects: [{ name: cars }]", testConfigTasks, "allow_tasks"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeTestProjects(t, test.manifest, map[string]string{"cars": test.config})
+			if _, err := LoadProjectsManifest(dir); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("LoadProjectsManifest() error = %v, want %q", err, test.err)
+			}
+		})
+	}
+
+	t.Run("users are either shared or of the project", func(t *testing.T) {
+		dir := writeTestProjects(t, testProjectsManifest(), map[string]string{"cars": "", "wildlife": ""})
+		config := "auth:\n  alice: { password: \"" + testPasswordHash() + "\" }\n" + testConfigTasks
+		os.WriteFile(filepath.Join(dir, "cars", "config.yaml"), []byte(config), 0644)
+		manifest, err := LoadProjectsManifest(dir)
+		if err != nil {
+			t.Fatalf("LoadProjectsManifest() error = %v", err)
+		}
+		if _, err := manifest.LoadConfig(manifest.Projects[0]); err == nil || !strings.Contains(err.Error(), "user alice is both") {
+			t.Errorf("LoadConfig() error = %v", err)
+		}
+	})
+}
+
+func TestProjectServer(t *testing.T) {
+	cars := newTestAppWithUsers(t, map[string]string{"alice": "role: annotator"}, testConfigTasks, 1)
+	birds := newTestAppWithUsers(t, map[string]string{"bob": "role: annotator"}, testConfigTasks, 1)
+	birds.Config.Meta.Description = "Birds of the coast"
+	server := &ProjectServer{BasePath: "/tools", Projects: []*Project{{Name: "cars", App: cars}, {Name: "birds", App: birds}}}
+	handler := server.Handler()
+
+	t.Run("the picker links to every project", func(t *testing.T) {
+		rec := doRequestAs(t, handler, "nobody", http.MethodGet, "/tools/", nil)
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || !strings.Contains(body, `href="/tools/p/cars/"`) || !strings.Contains(body, `href="/tools/p/birds/"`) || !strings.Contains(body, "Birds of the coast") {
+			t.Errorf("picker: status = %d, body = %s", rec.Code, body)
+		}
+		if location := doRequest(t, handler, http.MethodGet, "/tools", nil).Header().Get("Location"); location != "/tools/" {
+			t.Errorf("base path redirects to %q", location)
+		}
+	})
+
+	t.Run("projects are served under their path", func(t *testing.T) {
+		rec := doRequestAs(t, handler, "alice", http.MethodGet, "/tools/p/cars/help/", nil)
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || !strings.Contains(body, `href="/tools/p/cars/help/has_car"`) || !strings.Contains(body, `href="/tools/"`) {
+			t.Errorf("cars: status = %d", rec.Code)
+		}
+		if location := doRequest(t, handler, http.MethodGet, "/tools/p/cars", nil).Header().Get("Location"); location != "/tools/p/cars/" {
+			t.Errorf("project path redirects to %q", location)
+		}
+	})
+
+	t.Run("users of a project cannot use another", func(t *testing.T) {
+		if rec := doRequestAs(t, handler, "alice", http.MethodGet, "/tools/p/birds/help/", nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("alice in birds: status = %d, want 401", rec.Code)
+		}
+		if rec := doRequestAs(t, handler, "bob", http.MethodGet, "/tools/p/birds/help/", nil); rec.Code != http.StatusOK {
+			t.Errorf("bob in birds: status = %d", rec.Code)
+		}
+	})
+
+	t.Run("unknown paths are not found", func(t *testing.T) {
+		for _, target := range []string{"/tools/p/boats/", "/help/", "/tools/help/"} {
+			if rec := doRequest(t, handler, http.MethodGet, target, nil); rec.Code != http.StatusNotFound {
+				t.Errorf("GET %s: status = %d, want 404", target, rec.Code)
+			}
+		}
+	})
+
+	t.Run("answers go on to pages of the project", func(t *testing.T) {
+		rec := doRequestAs(t, handler, "alice", http.MethodPost, "/tools/p/cars/annotate/has_car/"+testImageHash(0), url.Values{"selectedClass": {"true"}, "sure": {"on"}})
+		redirect := rec.Header().Get("HX-Redirect")
+		if rec.Code != http.StatusOK || !strings.HasPrefix(redirect, "/tools/p/cars/") {
+			t.Fatalf("status = %d, HX-Redirect = %q", rec.Code, redirect)
+		}
+		if rec := doRequestAs(t, handler, "alice", http.MethodGet, redirect, nil); rec.Code != http.StatusOK {
+			t.Errorf("GET %s: status = %d", redirect, rec.Code)
+		}
+	})
+
+	t.Run("readiness covers every project", func(t *testing.T) {
+		rec := doRequest(t, handler, http.MethodGet, "/tools/readyz", nil)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"birds":"ok"`) {
+			t.Errorf("readyz: status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		birds.Database.Close()
+		if rec := doRequest(t, handler, http.MethodGet, "/tools/readyz", nil); rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("readyz with a closed database: status = %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,252 @@
+package annotation
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lewtec/rotulador/internal/domain"
+)
+
+// DefaultSessionLifetime is used when the config has no session_lifetime
+const DefaultSessionLifetime = 12 * time.Hour
+
+const (
+	sessionCookieName = "rotulador_session"
+	sessionSecretName = "session_cookie_key"
+	sessionTokenLen   = 32
+)
+
+// publicPaths are served without authentication
+var publicPaths = map[string]bool{
+	"/login":         true,
+	"/logout":        true,
+	"/favicon.svg":   true,
+	"/oidc/login":    true,
+	oidcCallbackPath: true,
+	// The API is documented in the open
+	"/api/openapi.json": true,
+}
+
+// sessionKey returns the key signing session cookies. It is generated on first
+// use and kept in the database, so sessions survive restarts.
+func (a *AnnotatorApp) sessionKey(ctx context.Context) ([]byte, error) {
+	a.sessionKeyMutex.Lock()
+	defer a.sessionKeyMutex.Unlock()
+	if a.sessionKeyValue != nil {
+		return a.sessionKeyValue, nil
+	}
+	key, err := a.sessionRepo.Secret(ctx, sessionSecretName, func() ([]byte, error) {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		return key, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.sessionKeyValue = key
+	return key, nil
+}
+
+// signCookie encodes a value with a signature tied to the cookie name
+func signCookie(key []byte, name string, value []byte) string {
+	return base64.RawURLEncoding.EncodeToString(value) + "." + base64.RawURLEncoding.EncodeToString(cookieMAC(key, name, value))
+}
+
+// verifyCookie returns the value of a cookie signed by signCookie, if its signature is valid
+func verifyCookie(key []byte, name, cookie string) ([]byte, bool) {
+	encodedValue, encodedSignature, ok := strings.Cut(cookie, ".")
+	if !ok {
+		return nil, false
+	}
+	value, err := base64.RawURLEncoding.DecodeString(encodedValue)
+	if err != nil {
+		return nil, false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, false
+	}
+	return value, hmac.Equal(signature, cookieMAC(key, name, value))
+}
+
+func cookieMAC(key []byte, name string, value []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "\x00"))
+	mac.Write(value)
+	return mac.Sum(nil)
+}
+
+func sessionTokenHash(token []byte) string {
+	sum := sha256.Sum256(token)
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckCredentials returns the user matching a username and password, or nil
+func (a *AnnotatorApp) CheckCredentials(username, password string) *ConfigAuth {
+	auth, ok := a.Config.Authentication[username]
+	if !ok || auth.Password == "" {
+		// Spend the same time as a wrong password so users cannot be enumerated
+		dummyAuth().CheckPassword(password)
+		slog.Debug("auth: no such user", "user", username)
+		return nil
+	}
+	if !auth.CheckPassword(password) {
+		slog.Debug("auth: bad password", "user", username)
+		return nil
+	}
+	return auth
+}
+
+// Login starts a session for a user and returns the cookie that carries it. The
+// role is only given for users missing from the config, such as those of single sign-on.
+func (a *AnnotatorApp) Login(ctx context.Context, username, role, clientIP string, secure bool) (*http.Cookie, error) {
+	key, err := a.sessionKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token := make([]byte, sessionTokenLen)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if _, err := a.sessionRepo.DeleteExpired(ctx, now); err != nil {
+		slog.ErrorContext(ctx, "deleting expired sessions", "error", err)
+	}
+	expiresAt := now.Add(a.Config.SessionLifetime)
+	session := domain.Session{
+		TokenHash: sessionTokenHash(token),
+		Username:  username,
+		ClientIP:  clientIP,
+		ExpiresAt: expiresAt,
+	}
+	if role != "" {
+		session.Role = &role
+	}
+	if err := a.sessionRepo.Create(ctx, session); err != nil {
+		return nil, err
+	}
+	a.audit(ctx, "login", "user", username, "client_ip", clientIP)
+
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    signCookie(key, sessionCookieName, token),
+		Path:     a.url("/"),
+		Expires:  expiresAt,
+		MaxAge:   int(a.Config.SessionLifetime / time.Second),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}, nil
+}
+
+// Logout ends the session of a request, if any, and returns the cookie that clears it
+func (a *AnnotatorApp) Logout(r *http.Request) (*http.Cookie, error) {
+	expired := &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     a.url("/"),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	token, err := a.sessionToken(r)
+	if err != nil || token == nil {
+		return expired, err
+	}
+	if user := GetUserFromContext(r.Context()); user != nil {
+		a.audit(r.Context(), "logout", "user", user.Name, "client_ip", clientIP(r))
+	}
+	return expired, a.sessionRepo.Delete(r.Context(), sessionTokenHash(token))
+}
+
+// sessionToken returns the token of the session cookie of a request, or nil if
+// there is none or it was not signed by this server
+func (a *AnnotatorApp) sessionToken(r *http.Request) ([]byte, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, nil
+	}
+	key, err := a.sessionKey(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	token, ok := verifyCookie(key, sessionCookieName, cookie.Value)
+	if !ok || len(token) != sessionTokenLen {
+		return nil, nil
+	}
+	return token, nil
+}
+
+// sessionUser returns the user of a valid and unexpired session cookie, or nil
+func (a *AnnotatorApp) sessionUser(r *http.Request) (*RequestUser, error) {
+	token, err := a.sessionToken(r)
+	if err != nil || token == nil {
+		return nil, err
+	}
+	session, err := a.sessionRepo.Get(r.Context(), sessionTokenHash(token), time.Now())
+	if err != nil || session == nil {
+		return nil, err
+	}
+	if auth, ok := a.Config.Authentication[session.Username]; ok {
+		return &RequestUser{Name: session.Username, ConfigAuth: auth, Session: true}, nil
+	}
+	// Users of single sign-on keep the role they logged in with, others
+	// removed from the config lose their sessions
+	if session.Role == nil || a.Config.OIDC == nil {
+		return nil, nil
+	}
+	return &RequestUser{Name: session.Username, ConfigAuth: &ConfigAuth{Role: *session.Role}, Session: true}, nil
+}
+
+// hasPasswords tells whether any user can log in with a password
+func (a *AnnotatorApp) hasPasswords() bool {
+	for _, auth := range a.Config.Authentication {
+		if auth.Password != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// renderLoginPage renders the login page, without the menu of whoever is logged in
+func renderLoginPage(w http.ResponseWriter, r *http.Request, data map[string]any) {
+	r = r.WithContext(WithRequestUser(r.Context(), nil))
+	if err := RenderPageWithRequest(r, w, "login.html", data); err != nil {
+		slog.ErrorContext(r.Context(), "rendering login template", "error", err)
+	}
+}
+
+// loginURL returns the login page that comes back to the current page afterwards
+func (a *AnnotatorApp) loginURL(r *http.Request) string {
+	next := r.URL.RequestURI()
+	if r.Header.Get("HX-Request") == "true" {
+		// HTMX requests are made from a page, that is where to come back to
+		current, err := url.Parse(r.Header.Get("HX-Current-URL"))
+		if err != nil {
+			return "/login"
+		}
+		next = strings.TrimPrefix(current.RequestURI(), a.BasePath)
+	}
+	if next == "/" || next == "" {
+		return "/login"
+	}
+	return "/login?next=" + url.QueryEscape(next)
+}
+
+// safeRedirect keeps redirects after login inside this site
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
@@ -0,0 +1,144 @@
+package annotation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	app := newTestApp(t, testConfigTasks, 1)
+	handler := app.GetHTTPHandler()
+
+	send := func(method, target string, form url.Values, header http.Header, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		t.Helper()
+		var req *http.Request
+		if form != nil {
+			req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(method, target, nil)
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	browser := http.Header{"Accept": {"text/html,application/xhtml+xml"}}
+	login := func(t *testing.T, password, next string) *httptest.ResponseRecorder {
+		t.Helper()
+		return send(http.MethodPost, "/login", url.Values{"username": {"admin"}, "password": {password}, "next": {next}}, browser)
+	}
+	sessionCookie := func(rec *httptest.ResponseRecorder) *http.Cookie {
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == sessionCookieName {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	t.Run("browsers are sent to the login page", func(t *testing.T) {
+		rec := send(http.MethodGet, "/stats?days=7", nil, browser)
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login?next=%2Fstats%3Fdays%3D7" {
+			t.Errorf("got %d to %q", rec.Code, rec.Header().Get("Location"))
+		}
+		rec = send(http.MethodPost, "/grid/has_car", url.Values{}, http.Header{"Hx-Request": {"true"}, "Hx-Current-Url": {"http://example.com/grid/has_car"}})
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("HX-Redirect") != "/login?next=%2Fgrid%2Fhas_car" {
+			t.Errorf("htmx: got %d to %q", rec.Code, rec.Header().Get("HX-Redirect"))
+		}
+	})
+
+	t.Run("scripts get a Basic auth challenge", func(t *testing.T) {
+		rec := send(http.MethodGet, "/stats", nil, nil)
+		if rec.Code != http.StatusUnauthorized || !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Basic") {
+			t.Errorf("got %d with challenge %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+		}
+		if rec := doRequest(t, handler, http.MethodGet, "/stats", nil); rec.Code != http.StatusOK {
+			t.Errorf("Basic auth: status = %d, want 200", rec.Code)
+		}
+	})
+
+	t.Run("login page", func(t *testing.T) {
+		rec := send(http.MethodGet, "/login", nil, browser)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `name="password"`) {
+			t.Errorf("status = %d, want the login form", rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "/me/history") {
+			t.Error("menu shown before logging in")
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		rec := login(t, "wrong", "/")
+		if rec.Code != http.StatusUnauthorized || sessionCookie(rec) != nil {
+			t.Errorf("status = %d, cookie = %v", rec.Code, sessionCookie(rec))
+		}
+		if !strings.Contains(rec.Body.String(), "Wrong username or password") {
+			t.Error("expected an error message")
+		}
+	})
+
+	t.Run("login, use and logout", func(t *testing.T) {
+		rec := login(t, "changeme", "/stats")
+		cookie := sessionCookie(rec)
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/stats" || cookie == nil {
+			t.Fatalf("got %d to %q with cookie %v", rec.Code, rec.Header().Get("Location"), cookie)
+		}
+		if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge <= 0 {
+			t.Errorf("unsafe cookie: %+v", cookie)
+		}
+
+		rec = send(http.MethodGet, "/", nil, browser, cookie)
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || !strings.Contains(body, "Logged in as <strong>admin</strong>") || !strings.Contains(body, `action="/logout"`) {
+			t.Errorf("status = %d, want the home page of admin with a logout button", rec.Code)
+		}
+
+		rec = send(http.MethodPost, "/logout", url.Values{}, browser, cookie)
+		if cleared := sessionCookie(rec); rec.Code != http.StatusSeeOther || cleared == nil || cleared.MaxAge >= 0 {
+			t.Errorf("logout: got %d with cookie %v", rec.Code, cleared)
+		}
+		if rec := send(http.MethodGet, "/", nil, browser, cookie); rec.Code != http.StatusSeeOther {
+			t.Errorf("session still valid after logout: status = %d", rec.Code)
+		}
+	})
+
+	t.Run("tampered cookie", func(t *testing.T) {
+		cookie := sessionCookie(login(t, "changeme", "/"))
+		token, _, _ := strings.Cut(cookie.Value, ".")
+		forged := &http.Cookie{Name: sessionCookieName, Value: token + ".AAAA"}
+		if rec := send(http.MethodGet, "/", nil, browser, forged); rec.Code != http.StatusSeeOther {
+			t.Errorf("status = %d, want a redirect to login", rec.Code)
+		}
+	})
+
+	t.Run("expired session", func(t *testing.T) {
+		app.Config.SessionLifetime = -time.Minute
+		defer func() { app.Config.SessionLifetime = DefaultSessionLifetime }()
+		cookie, err := app.Login(context.Background(), "admin", "", "127.0.0.1", false)
+		if err != nil {
+			t.Fatalf("Login() error = %v", err)
+		}
+		if rec := send(http.MethodGet, "/", nil, browser, cookie); rec.Code != http.StatusSeeOther {
+			t.Errorf("status = %d, want a redirect to login", rec.Code)
+		}
+	})
+
+	t.Run("redirects stay on this site", func(t *testing.T) {
+		for _, next := range []string{"//evil.example.com/", "https://evil.example.com/", `/\evil.example.com`} {
+			if location := login(t, "changeme", next).Header().Get("Location"); location != "/" {
+				t.Errorf("next %q redirected to %q", next, location)
+			}
+		}
+	})
+}
@@ -0,0 +1,204 @@
+package annotation
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/lewtec/rotulador/internal/domain"
+)
+
+// Defaults for the productivity analytics
+const (
+	// StatsIdleCap is the most an answer counts towards active time. Longer
+	// answers mean the annotator walked away, not that they worked that long.
+	StatsIdleCap = 5 * time.Minute
+	// StatsDefaultFastThreshold is the answer time under which an answer is too fast to be a real look at the image
+	StatsDefaultFastThreshold = 800 * time.Millisecond
+	// StatsDefaultMinStreak is how many consecutive fast answers are reported as a streak
+	StatsDefaultMinStreak = 10
+)
+
+// StatsOptions tunes the productivity analytics
+type StatsOptions struct {
+	Since         time.Time     // Only consider images served after this time
+	FastThreshold time.Duration // Answers faster than this are suspicious
+	MinStreak     int           // Consecutive suspicious answers needed to report a streak
+}
+
+// UserStats summarizes the work of an annotator
+type UserStats struct {
+	Username    string
+	Annotations int
+	ActiveTime  time.Duration // Sum of answer times, each capped at StatsIdleCap
+	PerHour     float64       // Annotations per hour of active time
+	Median      time.Duration
+	Tasks       []TaskTiming
+	Daily       []DailyActivity
+	FastStreaks []FastStreak
+}
+
+// TaskTiming is the answer time of a user in a task
+type TaskTiming struct {
+	TaskID      string
+	Annotations int
+	Median      time.Duration
+}
+
+// DailyActivity is the work of a user in a day
+type DailyActivity struct {
+	Day         string // YYYY-MM-DD, local time
+	Annotations int
+	ActiveTime  time.Duration
+}
+
+// FastStreak is a run of consecutive answers faster than the threshold, which
+// usually means the annotator is mashing keys instead of looking at the images
+type FastStreak struct {
+	TaskID  string // Empty when the streak spans several tasks
+	Start   time.Time
+	End     time.Time
+	Answers int
+	Median  time.Duration
+}
+
+// UserStats computes the productivity analytics of every annotator with timed annotations
+func (a *AnnotatorApp) UserStats(ctx context.Context, opts StatsOptions) ([]*UserStats, error) {
+	if opts.FastThreshold <= 0 {
+		opts.FastThreshold = StatsDefaultFastThreshold
+	}
+	if opts.MinStreak <= 0 {
+		opts.MinStreak = StatsDefaultMinStreak
+	}
+	timings, err := a.annotationRepo.ListTimings(ctx, opts.Since)
+	if err != nil {
+		return nil, fmt.Errorf("while listing annotation timings: %w", err)
+	}
+
+	var result []*UserStats
+	for start := 0; start < len(timings); {
+		end := start
+		for end < len(timings) && timings[end].Username == timings[start].Username {
+			end++
+		}
+		result = append(result, a.computeUserStats(timings[start:end], opts))
+		start = end
+	}
+	return result, nil
+}
+
+// computeUserStats summarizes the timings of a single user, ordered by the time they were served
+func (a *AnnotatorApp) computeUserStats(timings []*domain.AnnotationTiming, opts StatsOptions) *UserStats {
+	stats := &UserStats{
+		Username:    timings[0].Username,
+		Annotations: len(timings),
+	}
+
+	all := make([]time.Duration, len(timings))
+	byTask := map[int][]time.Duration{}
+	daily := map[string]*DailyActivity{}
+	for idx, timing := range timings {
+		all[idx] = timing.Duration
+		byTask[timing.StageIndex] = append(byTask[timing.StageIndex], timing.Duration)
+
+		active := min(timing.Duration, StatsIdleCap)
+		stats.ActiveTime += active
+		day := timing.ServedAt.Local().Format("2006-01-02")
+		if daily[day] == nil {
+			daily[day] = &DailyActivity{Day: day}
+		}
+		daily[day].Annotations++
+		daily[day].ActiveTime += active
+	}
+	stats.Median = medianDuration(all)
+	if stats.ActiveTime > 0 {
+		stats.PerHour = float64(stats.Annotations) / stats.ActiveTime.Hours()
+	}
+
+	stageIndexes := make([]int, 0, len(byTask))
+	for stageIndex := range byTask {
+		stageIndexes = append(stageIndexes, stageIndex)
+	}
+	sort.Ints(stageIndexes)
+	for _, stageIndex := range stageIndexes {
+		stats.Tasks = append(stats.Tasks, TaskTiming{
+			TaskID:      a.StageName(stageIndex),
+			Annotations: len(byTask[stageIndex]),
+			Median:      medianDuration(byTask[stageIndex]),
+		})
+	}
+
+	for _, activity := range daily {
+		stats.Daily = append(stats.Daily, *activity)
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool { return stats.Daily[i].Day < stats.Daily[j].Day })
+
+	stats.FastStreaks = a.findFastStreaks(timings, opts)
+	return stats
+}
+
+// findFastStreaks looks for runs of fast answers. Images served together (a grid
+// page) count as a single answer taking the whole page time, as clicking through
+// a grid is expected to be quick.
+func (a *AnnotatorApp) findFastStreaks(timings []*domain.AnnotationTiming, opts StatsOptions) []FastStreak {
+	type answer struct {
+		stageIndex int
+		servedAt   time.Time
+		duration   time.Duration
+	}
+	var answers []answer
+	for _, timing := range timings {
+		if n := len(answers); n > 0 && answers[n-1].servedAt.Equal(timing.ServedAt) && answers[n-1].stageIndex == timing.StageIndex {
+			answers[n-1].duration += timing.Duration
+			continue
+		}
+		answers = append(answers, answer{timing.StageIndex, timing.ServedAt, timing.Duration})
+	}
+
+	var streaks []FastStreak
+	var run []answer
+	flush := func() {
+		if len(run) >= opts.MinStreak {
+			streak := FastStreak{
+				TaskID:  a.StageName(run[0].stageIndex),
+				Start:   run[0].servedAt,
+				End:     run[len(run)-1].servedAt.Add(run[len(run)-1].duration),
+				Answers: len(run),
+			}
+			durations := make([]time.Duration, len(run))
+			for idx, ans := range run {
+				durations[idx] = ans.duration
+				if ans.stageIndex != run[0].stageIndex {
+					streak.TaskID = ""
+				}
+			}
+			streak.Median = medianDuration(durations)
+			streaks = append(streaks, streak)
+		}
+		run = nil
+	}
+	for _, ans := range answers {
+		if ans.duration < opts.FastThreshold {
+			run = append(run, ans)
+			continue
+		}
+		flush()
+	}
+	flush()
+	return streaks
+}
+
+func medianDuration(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
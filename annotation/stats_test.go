@@ -0,0 +1,159 @@
+package annotation
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnnotationTiming(t *testing.T) {
+	app := newTestApp(t, testConfigTasks, 4)
+	handler := app.GetHTTPHandler()
+	ctx := context.Background()
+
+	t.Run("measures the time between serving and answering", func(t *testing.T) {
+		rec := doRequest(t, handler, http.MethodGet, "/annotate/has_car/"+testImageHash(0), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		time.Sleep(20 * time.Millisecond)
+		rec = doRequest(t, handler, http.MethodPost, "/annotate/has_car/"+testImageHash(0), url.Values{"selectedClass": {"true"}, "sure": {"on"}})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		ann, err := app.annotationRepo.Get(ctx, testImageHash(0), "admin", 0)
+		if err != nil || ann == nil {
+			t.Fatalf("annotation not found: %v", err)
+		}
+		if ann.ServedAt == nil || ann.Duration == nil || *ann.Duration < 20*time.Millisecond || *ann.Duration > time.Minute {
+			t.Errorf("unexpected timing: served=%v duration=%v", ann.ServedAt, ann.Duration)
+		}
+	})
+
+	t.Run("answers without a view are not timed", func(t *testing.T) {
+		if err := app.SubmitAnnotation(ctx, AnnotationResponse{ImageID: testImageHash(1), TaskID: "has_car", User: "admin", Value: "false"}); err != nil {
+			t.Fatalf("SubmitAnnotation() error = %v", err)
+		}
+		ann, _ := app.annotationRepo.Get(ctx, testImageHash(1), "admin", 0)
+		if ann.Duration != nil {
+			t.Errorf("expected no timing, got %v", *ann.Duration)
+		}
+	})
+
+	t.Run("grid pages split the page time", func(t *testing.T) {
+		servedAt := time.Now().Add(-10 * time.Second)
+		for _, idx := range []int{2, 3} {
+			if err := app.annotationRepo.RecordView(ctx, testImageHash(idx), "admin", 0, servedAt); err != nil {
+				t.Fatalf("RecordView() error = %v", err)
+			}
+		}
+		form := url.Values{"image": {testImageHash(2), testImageHash(3)}}
+		if rec := doRequest(t, handler, http.MethodPost, "/grid/has_car", form); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		for _, idx := range []int{2, 3} {
+			ann, _ := app.annotationRepo.Get(ctx, testImageHash(idx), "admin", 0)
+			if ann.Duration == nil || *ann.Duration < 4*time.Second || *ann.Duration > 6*time.Second {
+				t.Errorf("image %d: expected about 5s, got %v", idx, ann.Duration)
+			}
+		}
+	})
+
+	t.Run("views never answered are pruned", func(t *testing.T) {
+		now := time.Now()
+		for user, servedAt := range map[string]time.Time{"alice": now.Add(-viewRetention - time.Hour), "bob": now.Add(-time.Hour)} {
+			if err := app.annotationRepo.RecordView(ctx, testImageHash(0), user, 0, servedAt); err != nil {
+				t.Fatalf("RecordView() error = %v", err)
+			}
+		}
+		// Reading the stats leaves the database alone
+		if _, err := app.UserStats(ctx, StatsOptions{}); err != nil {
+			t.Fatalf("UserStats() error = %v", err)
+		}
+		var views int
+		if err := app.Database.QueryRow("SELECT COUNT(*) FROM annotation_views").Scan(&views); err != nil || views != 2 {
+			t.Fatalf("views after reading the stats = %d, %v", views, err)
+		}
+
+		if err := app.IngestImages(ctx); err != nil {
+			t.Fatalf("IngestImages() error = %v", err)
+		}
+		if servedAt, err := app.annotationRepo.TakeView(ctx, testImageHash(0), "alice", 0); err != nil || servedAt != nil {
+			t.Errorf("old view = %v, %v, want pruned", servedAt, err)
+		}
+		if servedAt, err := app.annotationRepo.TakeView(ctx, testImageHash(0), "bob", 0); err != nil || servedAt == nil {
+			t.Errorf("recent view = %v, %v, want kept", servedAt, err)
+		}
+	})
+}
+
+func TestUserStats(t *testing.T) {
+	app := newTestApp(t, testConfigTasks, 30)
+	ctx := context.Background()
+
+	// A careful annotator followed by a key masher
+	start := time.Now().Add(-time.Hour)
+	timed := func(idx int, user string, servedAt time.Time, duration time.Duration) {
+		t.Helper()
+		ann, err := app.annotationRepo.Create(ctx, testImageHash(idx), user, 0, "true")
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		if err := app.annotationRepo.SetTiming(ctx, ann.ID, servedAt, duration); err != nil {
+			t.Fatalf("SetTiming() error = %v", err)
+		}
+	}
+	for idx := 0; idx < 5; idx++ {
+		timed(idx, "careful", start.Add(time.Duration(idx)*time.Minute), time.Duration(idx+2)*time.Second)
+	}
+	// A long break only counts as five minutes of work
+	timed(5, "careful", start.Add(10*time.Minute), time.Hour)
+	for idx := 0; idx < 15; idx++ {
+		timed(idx, "masher", start.Add(time.Duration(idx)*time.Second), 300*time.Millisecond)
+	}
+
+	stats, err := app.UserStats(ctx, StatsOptions{Since: start.Add(-time.Minute)})
+	if err != nil {
+		t.Fatalf("UserStats() error = %v", err)
+	}
+	if len(stats) != 2 || stats[0].Username != "careful" || stats[1].Username != "masher" {
+		t.Fatalf("unexpected users: %+v", stats)
+	}
+
+	careful, masher := stats[0], stats[1]
+	if careful.Annotations != 6 || careful.Tasks[0].TaskID != "has_car" {
+		t.Errorf("unexpected careful stats: %+v", careful)
+	}
+	if want := 4*time.Second + 500*time.Millisecond; careful.Median != want {
+		t.Errorf("median = %v, want %v", careful.Median, want)
+	}
+	if want := 20*time.Second + StatsIdleCap; careful.ActiveTime != want {
+		t.Errorf("active time = %v, want %v", careful.ActiveTime, want)
+	}
+	if len(careful.FastStreaks) != 0 {
+		t.Errorf("careful annotator flagged: %+v", careful.FastStreaks)
+	}
+	if len(careful.Daily) == 0 || careful.Daily[len(careful.Daily)-1].Day != start.Local().Add(10*time.Minute).Format("2006-01-02") {
+		t.Errorf("unexpected daily activity: %+v", careful.Daily)
+	}
+
+	if len(masher.FastStreaks) != 1 || masher.FastStreaks[0].Answers != 15 {
+		t.Fatalf("expected one streak of 15 answers, got %+v", masher.FastStreaks)
+	}
+	if masher.PerHour < 10000 {
+		t.Errorf("per hour = %v, want the pace of 300ms answers", masher.PerHour)
+	}
+
+	t.Run("stats page lists users", func(t *testing.T) {
+		rec := doRequest(t, app.GetHTTPHandler(), http.MethodGet, "/stats", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, "careful") || !strings.Contains(body, "masher") {
+			t.Error("expected both users in the stats page")
+		}
+	})
+}
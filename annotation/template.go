@@ -6,6 +6,7 @@ import (
 	"html/template"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/russross/blackfriday/v2"
 )
@@ -20,6 +21,9 @@ var (
 	//go:embed assets/favicon.svg
 	faviconContent string
 
+	//go:embed assets/sse.js
+	sseScript string
+
 	// Template manager with mold for layout support
 	templateManager *TemplateManager = nil
 
@@ -28,6 +32,14 @@ var (
 		"add": func(a, b int) int { return a + b },
 		"sub": func(a, b int) int { return a - b },
 		"i":   i, // Internationalization function (uses goroutine-local localizer)
+		"duration": func(d time.Duration) string {
+			if d < time.Minute {
+				return d.Round(100 * time.Millisecond).String()
+			}
+			return d.Round(time.Second).String()
+		},
+		"until": time.Until,
+		"url":   templateURL, // Paths of the app under its base path (uses goroutine-local base path)
 		"markdown": func(text string) template.HTML {
 			// Convert markdown to HTML using blackfriday v2
 			return template.HTML(blackfriday.Run([]byte(text)))
@@ -52,12 +64,20 @@ func RenderPageWithContext(ctx context.Context, w io.Writer, pageName string, da
 		data = make(map[string]any)
 	}
 	data["CSS"] = template.CSS(cssContent)
+	data["SSEScript"] = template.JS(sseScript)
+	data["CurrentUser"] = GetUserFromContext(ctx)
+	data["Ingestion"] = ingestionStatusFromContext(ctx)
+	data["Project"] = projectFromContext(ctx)
 
 	// Set goroutine-local localizer for the i18n function in templates
 	localizer := GetLocalizerFromContext(ctx)
 	gid := getGoroutineID()
 	goroutineLocalizers.Store(gid, localizer)
 	defer goroutineLocalizers.Delete(gid) // Clean up after rendering
+	if basePath := basePathFromContext(ctx); basePath != "" {
+		goroutineBasePaths.Store(gid, basePath)
+		defer goroutineBasePaths.Delete(gid)
+	}
 
 	return templateManager.Render(w, "pages/"+pageName, data)
 }
@@ -0,0 +1,172 @@
+package annotation
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the login_throttle section
+const (
+	DefaultMaxFailures      = 5
+	DefaultMaxFailuresPerIP = 20
+	DefaultFailureWindow    = 15 * time.Minute
+	DefaultLockout          = 15 * time.Minute
+)
+
+// loginThrottle counts the failed logins of each client address and username,
+// locking them out for a while once there are too many
+type loginThrottle struct {
+	mutex    sync.Mutex
+	failures map[string]*loginFailures
+	// now is replaced in tests
+	now func() time.Time
+}
+
+type loginFailures struct {
+	count       int
+	since       time.Time
+	lockedUntil time.Time
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{failures: map[string]*loginFailures{}, now: time.Now}
+}
+
+func throttleIPKey(ip string) string         { return "ip " + ip }
+func throttleUserKey(username string) string { return "user " + username }
+
+// lockedOut returns how long until none of the keys is locked out anymore
+func (t *loginThrottle) lockedOut(keys ...string) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.now()
+	var wait time.Duration
+	for _, key := range keys {
+		if failures, ok := t.failures[key]; ok && failures.lockedUntil.After(now) {
+			wait = max(wait, failures.lockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+// fail records a failed login under a key, locking it out when it reaches the limit.
+// It returns whether the key was locked out.
+func (t *loginThrottle) fail(key string, limit int, config *ConfigLoginThrottle) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.now()
+	t.prune(now, config.Window)
+
+	failures, ok := t.failures[key]
+	if !ok || now.Sub(failures.since) > config.Window {
+		failures = &loginFailures{since: now}
+		t.failures[key] = failures
+	}
+	failures.count++
+	if failures.count < limit {
+		return false
+	}
+	failures.count = 0
+	failures.since = now
+	failures.lockedUntil = now.Add(config.Lockout)
+	return true
+}
+
+// succeed forgets the failures under a key
+func (t *loginThrottle) succeed(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if failures, ok := t.failures[key]; ok && !failures.lockedUntil.After(t.now()) {
+		delete(t.failures, key)
+	}
+}
+
+// prune drops the entries that no longer count, so guessing many usernames does not grow the map forever
+func (t *loginThrottle) prune(now time.Time, window time.Duration) {
+	for key, failures := range t.failures {
+		if now.Sub(failures.since) > window && !failures.lockedUntil.After(now) {
+			delete(t.failures, key)
+		}
+	}
+}
+
+// AttemptLogin checks a username and password unless the client address or
+// the username is locked out by too many failed attempts, in which case it
+// returns how long until they may try again. Failed attempts are recorded.
+func (a *AnnotatorApp) AttemptLogin(r *http.Request, username, password string) (*ConfigAuth, time.Duration) {
+	// Copied so configs not read by LoadConfig get the defaults too
+	config := a.Config.LoginThrottle
+	config.validate()
+	ip := a.remoteIP(r)
+	ipKey, userKey := throttleIPKey(ip), throttleUserKey(username)
+	if wait := a.throttle.lockedOut(ipKey, userKey); wait > 0 {
+		a.audit(r.Context(), "login refused: locked out", "user", username, "client_ip", ip, "wait", wait.Round(time.Second).String())
+		return nil, wait
+	}
+
+	auth := a.CheckCredentials(username, password)
+	if auth != nil {
+		a.throttle.succeed(userKey)
+		return auth, 0
+	}
+	a.audit(r.Context(), "login failed", "user", username, "client_ip", ip)
+	if a.throttle.fail(ipKey, config.MaxFailuresPerIP, &config) {
+		a.audit(r.Context(), "locking out client", "client_ip", ip, "lockout", config.Lockout.String(), "failures", config.MaxFailuresPerIP)
+	}
+	if a.throttle.fail(userKey, config.MaxFailures, &config) {
+		a.audit(r.Context(), "locking out user", "user", username, "lockout", config.Lockout.String(), "failures", config.MaxFailures)
+	}
+	return nil, 0
+}
+
+// retryAfter sets the Retry-After header of a locked out client, in whole seconds
+func retryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+}
+
+// remoteIP is the address of the client, taken from X-Forwarded-For when the
+// request comes through one of the trusted proxies of proxy_auth
+func (a *AnnotatorApp) remoteIP(r *http.Request) string {
+	proxy := a.Config.ProxyAuth
+	if proxy == nil || !proxy.trusts(r) {
+		return clientIP(r)
+	}
+	// The last address is the one the proxy saw, anything before it may be forged
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		return clientIP(r)
+	}
+	hops := strings.Split(forwarded[len(forwarded)-1], ",")
+	if addr, err := netip.ParseAddr(strings.TrimSpace(hops[len(hops)-1])); err == nil {
+		return addr.Unmap().String()
+	}
+	return clientIP(r)
+}
+
+// validate checks the login throttling settings and fills in the defaults
+func (c *ConfigLoginThrottle) validate() error {
+	if c.MaxFailures == 0 {
+		c.MaxFailures = DefaultMaxFailures
+	}
+	if c.MaxFailuresPerIP == 0 {
+		c.MaxFailuresPerIP = DefaultMaxFailuresPerIP
+	}
+	if c.Window == 0 {
+		c.Window = DefaultFailureWindow
+	}
+	if c.Lockout == 0 {
+		c.Lockout = DefaultLockout
+	}
+	if c.MaxFailures < 1 || c.MaxFailuresPerIP < 1 {
+		return errors.New("max_failures and max_failures_per_ip must be positive")
+	}
+	if c.Window < time.Second || c.Lockout < time.Second {
+		return errors.New("window and lockout must be at least one second")
+	}
+	return nil
+}
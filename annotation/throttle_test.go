@@ -0,0 +1,109 @@
+package annotation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	app := newTestAppWithUsers(t, map[string]string{"alice": "role: annotator"}, "login_throttle: { max_failures: 3, max_failures_per_ip: 5, lockout: 10m }\n"+testConfigTasks, 1)
+	handler := app.GetHTTPHandler()
+	clock := time.Now()
+	app.throttle.now = func() time.Time { return clock }
+
+	login := func(t *testing.T, ip, username, password string) *httptest.ResponseRecorder {
+		t.Helper()
+		form := url.Values{"username": {username}, "password": {password}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	basic := func(t *testing.T, ip, username, password string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/me/history", nil)
+		req.SetBasicAuth(username, password)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("guessing the password of a user", func(t *testing.T) {
+		for attempt := 0; attempt < 3; attempt++ {
+			if rec := login(t, "192.0.2.1", "alice", "guess"); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("attempt %d: status = %d, want 401", attempt, rec.Code)
+			}
+		}
+		// Locked out even with the right password, from any address and over Basic auth
+		rec := login(t, "192.0.2.2", "alice", "changeme")
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "600" {
+			t.Errorf("locked out: got %d with Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+		}
+		if !strings.Contains(rec.Body.String(), "Too many failed attempts") {
+			t.Error("expected a lockout message")
+		}
+		if rec := basic(t, "192.0.2.2", "alice", "changeme"); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("Basic auth: status = %d, want 429", rec.Code)
+		}
+		if rec := basic(t, "192.0.2.2", "admin", "changeme"); rec.Code != http.StatusOK {
+			t.Errorf("other users: status = %d, want 200", rec.Code)
+		}
+
+		clock = clock.Add(11 * time.Minute)
+		if rec := login(t, "192.0.2.2", "alice", "changeme"); rec.Code != http.StatusSeeOther {
+			t.Errorf("after the lockout: status = %d, want 303", rec.Code)
+		}
+	})
+
+	t.Run("guessing many usernames from one address", func(t *testing.T) {
+		for attempt := 0; attempt < 5; attempt++ {
+			basic(t, "198.51.100.7", "user"+string(rune('a'+attempt)), "guess")
+		}
+		if rec := basic(t, "198.51.100.7", "admin", "changeme"); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("locked out address: status = %d, want 429", rec.Code)
+		}
+		if rec := basic(t, "198.51.100.8", "admin", "changeme"); rec.Code != http.StatusOK {
+			t.Errorf("other address: status = %d, want 200", rec.Code)
+		}
+	})
+
+	t.Run("failures outside the window are forgotten", func(t *testing.T) {
+		clock = clock.Add(time.Hour)
+		for attempt := 0; attempt < 4; attempt++ {
+			login(t, "203.0.113.1", "alice", "guess")
+			if attempt == 1 {
+				clock = clock.Add(DefaultFailureWindow + time.Minute)
+			}
+		}
+		if rec := login(t, "203.0.113.1", "alice", "changeme"); rec.Code != http.StatusSeeOther {
+			t.Errorf("status = %d, want 303", rec.Code)
+		}
+	})
+}
+
+func TestRemoteIP(t *testing.T) {
+	app := newTestAppWithUsers(t, nil, "proxy_auth: { header: X-Forwarded-User, trusted_proxies: [10.0.0.1] }\n"+testConfigTasks, 0)
+	for _, tt := range []struct {
+		remote, forwarded, want string
+	}{
+		{"10.0.0.1", "192.0.2.9, 198.51.100.1", "198.51.100.1"},
+		{"10.0.0.1", "", "10.0.0.1"},
+		{"192.0.2.50", "198.51.100.1", "192.0.2.50"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remote + ":1234"
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := app.remoteIP(req); got != tt.want {
+			t.Errorf("remoteIP(%s, %q) = %s, want %s", tt.remote, tt.forwarded, got, tt.want)
+		}
+	}
+}
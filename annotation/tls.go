@@ -0,0 +1,352 @@
+package annotation
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// selfSignedLifetime is how long generated certificates are valid
+	selfSignedLifetime = 2 * 365 * 24 * time.Hour
+	// selfSignedRenewal is how long before expiring a generated certificate is replaced
+	selfSignedRenewal = 30 * 24 * time.Hour
+	// certificateCheckInterval limits how often the certificate files are looked at for changes
+	certificateCheckInterval = 5 * time.Second
+	// tlsSniffTimeout is how long a new connection may take to send its first byte
+	tlsSniffTimeout = 10 * time.Second
+)
+
+// SelfSignedHosts returns the names and addresses a self-signed certificate
+// is made for: localhost, the hostname and the addresses of the network
+// interfaces, so the server can be reached across a LAN
+func SelfSignedHosts() []string {
+	hosts := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		hosts = append(hosts, hostname)
+		if !strings.Contains(hostname, ".") {
+			hosts = append(hosts, hostname+".local")
+		}
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		slog.Warn("tls: listing network addresses", "error", err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			hosts = append(hosts, ipNet.IP.String())
+		}
+	}
+	return hosts
+}
+
+// EnsureSelfSignedCertificate keeps a self-signed certificate for hosts in
+// certFile and keyFile, generating it when missing, about to expire or not
+// valid for one of the hosts, as after the laptop joined another network.
+// It returns whether a new certificate was generated.
+func EnsureSelfSignedCertificate(certFile, keyFile string, hosts []string) (bool, error) {
+	if certificate, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && certificateCovers(certificate.Leaf, hosts) {
+		return false, nil
+	}
+	if err := generateSelfSignedCertificate(certFile, keyFile, hosts); err != nil {
+		return false, fmt.Errorf("while generating a self-signed certificate: %w", err)
+	}
+	return true, nil
+}
+
+// certificateCovers tells whether a certificate is valid for every host for a while longer
+func certificateCovers(certificate *x509.Certificate, hosts []string) bool {
+	if certificate == nil || time.Now().Add(selfSignedRenewal).After(certificate.NotAfter) {
+		return false
+	}
+	for _, host := range hosts {
+		if certificate.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func generateSelfSignedCertificate(certFile, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Rotulador"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	// The key is written first, a certificate is never left without its key
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of a certificate,
+// as browsers show it, for users to check the one they are warned about
+func CertificateFingerprint(certificate *tls.Certificate) string {
+	sum := sha256.Sum256(certificate.Certificate[0])
+	pairs := make([]string, len(sum))
+	for i, b := range sum {
+		pairs[i] = strings.ToUpper(hex.EncodeToString([]byte{b}))
+	}
+	return strings.Join(pairs, ":")
+}
+
+// CertificateReloader serves the certificate of a pair of files, loading it
+// again when they change, so renewed certificates are used without a restart
+type CertificateReloader struct {
+	certFile, keyFile string
+	checkInterval     time.Duration
+
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	modTimes    [2]time.Time
+	checkedAt   time.Time
+}
+
+// NewCertificateReloader loads the certificate of certFile and keyFile
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{certFile: certFile, keyFile: keyFile, checkInterval: certificateCheckInterval}
+	modTimes, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTimes); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertificateReloader) stat() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, file := range []string{r.certFile, r.keyFile} {
+		stat, err := os.Stat(file)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = stat.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (r *CertificateReloader) load(modTimes [2]time.Time) error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("while loading the TLS certificate: %w", err)
+	}
+	r.certificate, r.modTimes = &certificate, modTimes
+	return nil
+}
+
+// Certificate returns the current certificate, loading it again if its files changed.
+// A change that cannot be loaded, as while only one of the files was replaced, keeps the previous one.
+func (r *CertificateReloader) Certificate() *tls.Certificate {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if time.Since(r.checkedAt) < r.checkInterval {
+		return r.certificate
+	}
+	r.checkedAt = time.Now()
+	modTimes, err := r.stat()
+	if err != nil || modTimes == r.modTimes {
+		return r.certificate
+	}
+	if err := r.load(modTimes); err != nil {
+		slog.Warn("tls: keeping the previous certificate", "error", err)
+		return r.certificate
+	}
+	slog.Info("tls: certificate reloaded", "file", r.certFile, "fingerprint", CertificateFingerprint(r.certificate))
+	return r.certificate
+}
+
+// GetCertificate is the tls.Config callback serving Certificate
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+// NewTLSListener returns a listener serving TLS connections accepted by
+// inner, with the certificates of reloader. Plain HTTP requests made to the
+// same address are redirected to https, so old links and typed addresses work.
+func NewTLSListener(inner net.Listener, reloader *CertificateReloader) net.Listener {
+	l := &tlsListener{
+		Listener: inner,
+		config: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+			NextProtos:     []string{"h2", "http/1.1"},
+		},
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+		plain:  &connListener{addr: inner.Addr(), conns: make(chan net.Conn), closed: make(chan struct{})},
+	}
+	l.redirect = &http.Server{Handler: http.HandlerFunc(redirectToHTTPS), ReadHeaderTimeout: tlsSniffTimeout}
+	go l.redirect.Serve(l.plain)
+	go l.acceptLoop()
+	return l
+}
+
+// tlsListener tells TLS connections from plain ones by their first byte,
+// the 0x16 starting every TLS handshake
+type tlsListener struct {
+	net.Listener
+	config   *tls.Config
+	conns    chan net.Conn
+	plain    *connListener
+	redirect *http.Server
+
+	err       error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *tlsListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			l.close(err)
+			return
+		}
+		go l.sniff(conn)
+	}
+}
+
+func (l *tlsListener) sniff(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(tlsSniffTimeout))
+	first, err := reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+	conn = &peekedConn{Conn: conn, reader: reader}
+	target := l.plain.conns
+	if first[0] == 0x16 {
+		conn, target = tls.Server(conn, l.config), l.conns
+	}
+	select {
+	case target <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *tlsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		if l.err != nil {
+			return nil, l.err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *tlsListener) Close() error {
+	return l.close(nil)
+}
+
+// close stops accepting connections, Accept then fails with acceptErr or net.ErrClosed
+func (l *tlsListener) close(acceptErr error) error {
+	var err error
+	l.closeOnce.Do(func() {
+		l.err = acceptErr
+		close(l.closed)
+		err = l.Listener.Close()
+		l.plain.Close()
+		l.redirect.Close()
+	})
+	return err
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same address over https,
+// keeping their method and body
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+}
+
+// peekedConn is a connection whose first bytes were read ahead
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// connListener hands connections accepted elsewhere to a server
+type connListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
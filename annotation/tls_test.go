@@ -0,0 +1,138 @@
+package annotation
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate generates a self-signed certificate for localhost in a temporary folder
+func testCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "tls-cert.pem"), filepath.Join(dir, "tls-key.pem")
+	if _, err := EnsureSelfSignedCertificate(certFile, keyFile, []string{"localhost", "127.0.0.1"}); err != nil {
+		t.Fatalf("EnsureSelfSignedCertificate() error = %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestEnsureSelfSignedCertificate(t *testing.T) {
+	certFile, keyFile := testCertificate(t)
+	if stat, err := os.Stat(keyFile); err != nil || stat.Mode().Perm() != 0600 {
+		t.Errorf("key file should be private: %v, %v", stat.Mode(), err)
+	}
+	first, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("generated certificate does not load: %v", err)
+	}
+	if err := first.Leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Errorf("certificate is not valid for its hosts: %v", err)
+	}
+
+	t.Run("is kept while it covers the hosts", func(t *testing.T) {
+		generated, err := EnsureSelfSignedCertificate(certFile, keyFile, []string{"localhost"})
+		if err != nil || generated {
+			t.Errorf("EnsureSelfSignedCertificate() = %v, %v, want the existing one", generated, err)
+		}
+	})
+
+	t.Run("is replaced for new hosts", func(t *testing.T) {
+		generated, err := EnsureSelfSignedCertificate(certFile, keyFile, []string{"localhost", "192.168.0.10"})
+		if err != nil || !generated {
+			t.Fatalf("EnsureSelfSignedCertificate() = %v, %v, want a new one", generated, err)
+		}
+		second, _ := tls.LoadX509KeyPair(certFile, keyFile)
+		if CertificateFingerprint(&second) == CertificateFingerprint(&first) || second.Leaf.VerifyHostname("192.168.0.10") != nil {
+			t.Error("certificate was not replaced for the new host")
+		}
+	})
+}
+
+func TestCertificateReloader(t *testing.T) {
+	certFile, keyFile := testCertificate(t)
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertificateReloader() error = %v", err)
+	}
+	reloader.checkInterval = 0
+	first := CertificateFingerprint(reloader.Certificate())
+
+	// A half written certificate keeps the previous one
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if got := CertificateFingerprint(reloader.Certificate()); got != first {
+		t.Error("an invalid certificate should keep the previous one")
+	}
+
+	os.Remove(certFile)
+	if _, err := EnsureSelfSignedCertificate(certFile, keyFile, []string{"localhost"}); err != nil {
+		t.Fatalf("EnsureSelfSignedCertificate() error = %v", err)
+	}
+	// Some filesystems keep modification times in seconds
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if got := CertificateFingerprint(reloader.Certificate()); got == first {
+		t.Error("the new certificate was not loaded")
+	}
+}
+
+func TestTLSListener(t *testing.T) {
+	certFile, keyFile := testCertificate(t)
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertificateReloader() error = %v", err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	listener := NewTLSListener(inner, reloader)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			t.Error("request was not made over TLS")
+		}
+		io.WriteString(w, "secure")
+	})}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	addr := listener.Addr().String()
+
+	t.Run("serves https", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+		resp, err := client.Get("https://" + addr + "/help/")
+		if err != nil {
+			t.Fatalf("https request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "secure" {
+			t.Errorf("body = %q", body)
+		}
+	})
+
+	t.Run("redirects plain HTTP", func(t *testing.T) {
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Post("http://"+addr+"/annotate/?page=2", "text/plain", nil)
+		if err != nil {
+			t.Fatalf("http request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTemporaryRedirect || resp.Header.Get("Location") != "https://"+addr+"/annotate/?page=2" {
+			t.Errorf("response = %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+		}
+	})
+
+	t.Run("closes", func(t *testing.T) {
+		server.Close()
+		if _, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			t.Error("the listener should no longer accept connections")
+		}
+	})
+}
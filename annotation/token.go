@@ -0,0 +1,168 @@
+package annotation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lewtec/rotulador/internal/domain"
+)
+
+// API token scopes, each one including the previous
+const (
+	// ScopeRead allows reading only
+	ScopeRead = "read"
+	// ScopeAnnotate also allows submitting annotations and other changes
+	ScopeAnnotate = "annotate"
+	// ScopeAdmin allows everything the role of the user does, managing tokens included
+	ScopeAdmin = "admin"
+)
+
+var validScopes = []string{ScopeRead, ScopeAnnotate, ScopeAdmin}
+
+const (
+	apiTokenPrefix = "rtl_"
+	// apiTokenTouchInterval limits how often the last use of a token is written
+	apiTokenTouchInterval = time.Minute
+)
+
+// NewAPIToken is a token just created, the only time it is available in full
+type NewAPIToken struct {
+	*domain.APIToken
+	Token string
+}
+
+// ParseScopes reads a list of scopes separated by commas or spaces
+func ParseScopes(value string) ([]string, error) {
+	var scopes []string
+	for _, scope := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if !slices.Contains(validScopes, scope) {
+			return nil, fmt.Errorf("invalid scope %q: must be one of %v", scope, validScopes)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	slices.SortFunc(scopes, func(a, b string) int {
+		return slices.Index(validScopes, a) - slices.Index(validScopes, b)
+	})
+	return scopes, nil
+}
+
+// HasScope tells whether the scopes of the user allow an action. Users that
+// did not authenticate with an API token have every scope.
+func (u *RequestUser) HasScope(scope string) bool {
+	if u.Scopes == nil {
+		return true
+	}
+	required := slices.Index(validScopes, scope)
+	return slices.ContainsFunc(u.Scopes, func(granted string) bool {
+		return slices.Index(validScopes, granted) >= required
+	})
+}
+
+// CanAnnotate tells whether the user may submit annotations, within the scopes of their token
+func (u *RequestUser) CanAnnotate() bool {
+	return u.ConfigAuth.CanAnnotate() && u.HasScope(ScopeAnnotate)
+}
+
+// CanAnnotateTask tells whether the user may submit annotations for a task, within the scopes of their token
+func (u *RequestUser) CanAnnotateTask(taskID string) bool {
+	return u.CanAnnotate() && u.CanSeeTask(taskID)
+}
+
+func apiTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken creates a token for a user, which can do no more than both its scopes and the role of the user allow
+func (a *AnnotatorApp) CreateAPIToken(ctx context.Context, user *RequestUser, name string, scopes []string, expiresAt *time.Time) (*NewAPIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("token name is required")
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	token := apiTokenPrefix + strings.ToLower(rand.Text())
+	stored := domain.APIToken{
+		TokenHash: apiTokenHash(token),
+		Prefix:    token[:len(apiTokenPrefix)+6],
+		Username:  user.Name,
+		Name:      name,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	// Users missing from the config, such as those of single sign-on, keep their current role
+	if _, ok := a.Config.Authentication[user.Name]; !ok {
+		stored.Role = &user.Role
+	}
+	created, err := a.apiTokenRepo.Create(ctx, stored)
+	if err != nil {
+		return nil, err
+	}
+	a.audit(ctx, "created API token", "user", user.Name, "token", created.ID, "name", name, "scopes", strings.Join(scopes, ","))
+	return &NewAPIToken{APIToken: created, Token: token}, nil
+}
+
+// ListAPITokens lists the tokens of a user, or of everyone when username is empty
+func (a *AnnotatorApp) ListAPITokens(ctx context.Context, username string) ([]*domain.APIToken, error) {
+	return a.apiTokenRepo.List(ctx, username)
+}
+
+// GetAPIToken returns a token by ID, or nil
+func (a *AnnotatorApp) GetAPIToken(ctx context.Context, id int64) (*domain.APIToken, error) {
+	return a.apiTokenRepo.Get(ctx, id)
+}
+
+// RevokeAPIToken revokes a token, returning false if it was already revoked or does not exist
+func (a *AnnotatorApp) RevokeAPIToken(ctx context.Context, id int64) (bool, error) {
+	return a.apiTokenRepo.Revoke(ctx, id, time.Now())
+}
+
+// tokenUser returns the user of an active API token, or nil
+func (a *AnnotatorApp) tokenUser(r *http.Request, token string) (*RequestUser, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, nil
+	}
+	now := time.Now()
+	stored, err := a.apiTokenRepo.GetActive(r.Context(), apiTokenHash(token), now)
+	if err != nil || stored == nil {
+		return nil, err
+	}
+
+	auth, ok := a.Config.Authentication[stored.Username]
+	if !ok {
+		if stored.Role == nil {
+			// The user was removed from the config
+			return nil, nil
+		}
+		auth = &ConfigAuth{Role: *stored.Role}
+	}
+	if err := a.apiTokenRepo.Touch(r.Context(), stored.ID, now, apiTokenTouchInterval); err != nil {
+		slog.ErrorContext(r.Context(), "recording the use of API token", "token", stored.ID, "error", err)
+	}
+	return &RequestUser{Name: stored.Username, ConfigAuth: auth, Scopes: stored.Scopes}, nil
+}
+
+// requiredScope returns the scope a token needs for a request method
+func requiredScope(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ScopeRead
+	default:
+		return ScopeAnnotate
+	}
+}
@@ -0,0 +1,161 @@
+package annotation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPITokens(t *testing.T) {
+	app := newTestAppWithUsers(t, map[string]string{"alice": "role: annotator", "victor": "role: viewer"}, testConfigTasks, 1)
+	handler := app.GetHTTPHandler()
+	ctx := context.Background()
+
+	withToken := func(t *testing.T, token, method, target string, form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		var req *http.Request
+		if form != nil {
+			req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(method, target, nil)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	create := func(t *testing.T, username string, scopes []string, expiresAt *time.Time) *NewAPIToken {
+		t.Helper()
+		user := &RequestUser{Name: username, ConfigAuth: app.Config.Authentication[username]}
+		token, err := app.CreateAPIToken(ctx, user, "test", scopes, expiresAt)
+		if err != nil {
+			t.Fatalf("CreateAPIToken() error = %v", err)
+		}
+		return token
+	}
+	annotation := url.Values{"selectedClass": {"true"}, "sure": {"on"}}
+
+	t.Run("read scope", func(t *testing.T) {
+		token := create(t, "alice", []string{ScopeRead}, nil)
+		if rec := withToken(t, token.Token, http.MethodGet, "/me/history", nil); rec.Code != http.StatusOK {
+			t.Errorf("GET: status = %d, want 200", rec.Code)
+		}
+		if rec := withToken(t, token.Token, http.MethodPost, "/annotate/has_car/"+testImageHash(0), annotation); rec.Code != http.StatusForbidden {
+			t.Errorf("POST: status = %d, want 403", rec.Code)
+		}
+	})
+
+	t.Run("annotate scope", func(t *testing.T) {
+		token := create(t, "alice", []string{ScopeAnnotate}, nil)
+		if rec := withToken(t, token.Token, http.MethodPost, "/annotate/has_car/"+testImageHash(0), annotation); rec.Code != http.StatusOK {
+			t.Fatalf("POST: status = %d, want 200", rec.Code)
+		}
+		if ann, err := app.annotationRepo.Get(ctx, testImageHash(0), "alice", 0); err != nil || ann == nil {
+			t.Errorf("annotation of alice not found: %v", err)
+		}
+		stored, err := app.GetAPIToken(ctx, token.ID)
+		if err != nil || stored.LastUsedAt == nil {
+			t.Errorf("last use not recorded: %+v, %v", stored, err)
+		}
+	})
+
+	t.Run("scopes do not exceed the role", func(t *testing.T) {
+		token := create(t, "victor", []string{ScopeAdmin}, nil)
+		if rec := withToken(t, token.Token, http.MethodPost, "/annotate/has_car/"+testImageHash(0), annotation); rec.Code != http.StatusForbidden {
+			t.Errorf("viewer annotating: status = %d, want 403", rec.Code)
+		}
+	})
+
+	t.Run("only the hash is stored", func(t *testing.T) {
+		token := create(t, "alice", []string{ScopeRead}, nil)
+		var count int
+		if err := app.Database.QueryRow("SELECT count(*) FROM api_tokens WHERE token_hash = ? OR token_hash = ?", token.Token, apiTokenHash(token.Token)).Scan(&count); err != nil {
+			t.Fatalf("query error = %v", err)
+		}
+		if count != 1 || token.TokenHash == token.Token || !strings.HasPrefix(token.Token, token.Prefix) {
+			t.Errorf("count = %d, token = %+v", count, token.APIToken)
+		}
+	})
+
+	t.Run("rejected tokens", func(t *testing.T) {
+		past := time.Now().Add(-time.Hour)
+		expired := create(t, "alice", []string{ScopeRead}, &past)
+		revoked := create(t, "alice", []string{ScopeRead}, nil)
+		if ok, err := app.RevokeAPIToken(ctx, revoked.ID); !ok || err != nil {
+			t.Fatalf("RevokeAPIToken() = %v, %v", ok, err)
+		}
+		for name, token := range map[string]string{
+			"unknown": apiTokenPrefix + "nosuchtoken",
+			"expired": expired.Token,
+			"revoked": revoked.Token,
+			"garbage": "x",
+		} {
+			rec := withToken(t, token, http.MethodGet, "/me/history", nil)
+			if rec.Code != http.StatusUnauthorized || !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Bearer") {
+				t.Errorf("%s: got %d with challenge %q", name, rec.Code, rec.Header().Get("WWW-Authenticate"))
+			}
+		}
+	})
+
+	t.Run("web management", func(t *testing.T) {
+		rec := doRequestAs(t, handler, "alice", http.MethodPost, "/tokens/", url.Values{"name": {"export"}, "scope": {"read", "annotate"}, "expires_days": {"30"}})
+		match := regexp.MustCompile(apiTokenPrefix + `[a-z0-9]+`).FindString(rec.Body.String())
+		if rec.Code != http.StatusOK || match == "" {
+			t.Fatalf("create: status = %d, token shown = %q", rec.Code, match)
+		}
+		if rec := withToken(t, match, http.MethodGet, "/me/history", nil); rec.Code != http.StatusOK {
+			t.Errorf("new token: status = %d, want 200", rec.Code)
+		}
+		if rec := doRequestAs(t, handler, "alice", http.MethodGet, "/tokens/", nil); strings.Contains(rec.Body.String(), match) || !strings.Contains(rec.Body.String(), "export") {
+			t.Error("list should show the token by name without its secret")
+		}
+		if rec := doRequestAs(t, handler, "alice", http.MethodPost, "/tokens/", url.Values{"name": {"empty"}}); rec.Code != http.StatusBadRequest {
+			t.Errorf("no scope: status = %d, want 400", rec.Code)
+		}
+
+		tokens, err := app.ListAPITokens(ctx, "alice")
+		if err != nil || len(tokens) == 0 {
+			t.Fatalf("ListAPITokens() = %v, %v", tokens, err)
+		}
+		created := tokens[len(tokens)-1]
+		revoke := "/tokens/" + strconv.FormatInt(created.ID, 10) + "/revoke"
+		if rec := doRequestAs(t, handler, "victor", http.MethodPost, revoke, url.Values{}); rec.Code != http.StatusNotFound {
+			t.Errorf("revoke by another user: status = %d, want 404", rec.Code)
+		}
+		if rec := doRequestAs(t, handler, "alice", http.MethodPost, revoke, url.Values{}); rec.Code != http.StatusOK || rec.Header().Get("HX-Refresh") != "true" {
+			t.Errorf("revoke: status = %d", rec.Code)
+		}
+		if rec := withToken(t, match, http.MethodGet, "/me/history", nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("revoked token: status = %d, want 401", rec.Code)
+		}
+		if rec := doRequest(t, handler, http.MethodGet, "/tokens/", nil); !strings.Contains(rec.Body.String(), "<td>alice</td>") {
+			t.Error("admins should see the tokens of everyone")
+		}
+	})
+
+	t.Run("tokens cannot manage tokens without the admin scope", func(t *testing.T) {
+		token := create(t, "alice", []string{ScopeAnnotate}, nil)
+		if rec := withToken(t, token.Token, http.MethodPost, "/tokens/", url.Values{"name": {"x"}, "scope": {"admin"}}); rec.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want 403", rec.Code)
+		}
+	})
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes("admin, read read")
+	if err != nil || strings.Join(scopes, ",") != "read,admin" {
+		t.Errorf("ParseScopes() = %v, %v", scopes, err)
+	}
+	for _, value := range []string{"", " , ", "write"} {
+		if _, err := ParseScopes(value); err == nil {
+			t.Errorf("ParseScopes(%q): expected an error", value)
+		}
+	}
+}
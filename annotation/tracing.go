@@ -0,0 +1,106 @@
+package annotation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans of the app
+const tracerName = "github.com/lewtec/rotulador/annotation"
+
+// NewTracerProvider returns a provider exporting spans over OTLP/HTTP to
+// endpoint, such as http://localhost:4318. When endpoint is empty the
+// standard OTEL_EXPORTER_OTLP_* environment variables configure the exporter.
+// Only sampleRatio of the traces started here are kept, those continued from
+// a caller follow its decision. The caller must Shutdown the provider to
+// flush the last spans.
+func NewTracerProvider(ctx context.Context, endpoint string, sampleRatio float64) (*sdktrace.TracerProvider, error) {
+	if sampleRatio < 0 || sampleRatio > 1 {
+		return nil, fmt.Errorf("invalid trace sample ratio %v: must be between 0 and 1", sampleRatio)
+	}
+	var options []otlptracehttp.Option
+	if endpoint != "" {
+		endpointURL, err := url.Parse(endpoint)
+		if err != nil || endpointURL.Host == "" || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") {
+			return nil, fmt.Errorf("invalid OTLP endpoint %q: must be an http or https URL", endpoint)
+		}
+		if endpointURL.Path == "" || endpointURL.Path == "/" {
+			endpointURL.Path = "/v1/traces"
+		}
+		options = append(options, otlptracehttp.WithEndpointURL(endpointURL.String()))
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("while creating the OTLP exporter: %w", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "rotulador")),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("while describing the service: %w", err)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	), nil
+}
+
+// tracer returns the tracer of the app, from the global provider when none was set
+func (a *AnnotatorApp) tracer() trace.Tracer {
+	if a.TracerProvider != nil {
+		return a.TracerProvider.Tracer(tracerName)
+	}
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// tracingMiddleware starts a span for every request, named after the pattern
+// of the mux route that serves it, continuing the trace of the caller when
+// it sent a W3C traceparent header
+func (a *AnnotatorApp) tracingMiddleware(handler http.Handler, mux *http.ServeMux) http.Handler {
+	propagator := propagation.TraceContext{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := a.tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("request_id", RequestID(ctx)),
+			))
+		defer span.End()
+
+		wr := NewStatusCodeRecorderResponseWriter(w)
+		handler.ServeHTTP(wr, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.response.status_code", wr.Status))
+		if wr.Status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, strconv.Itoa(wr.Status))
+		}
+	})
+}
+
+// endSpan ends a span, marking it failed when err is not nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,188 @@
+package annotation
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// testCollector stands in for an OTLP/HTTP collector, keeping the spans it receives
+type testCollector struct {
+	*httptest.Server
+	mutex sync.Mutex
+	spans []*tracepb.Span
+}
+
+func newTestCollector(t *testing.T) *testCollector {
+	t.Helper()
+	collector := &testCollector{}
+	collector.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var request collectortrace.ExportTraceServiceRequest
+		if r.URL.Path != "/v1/traces" || proto.Unmarshal(body, &request) != nil {
+			t.Errorf("collector got an invalid export to %s", r.URL.Path)
+			http.Error(w, "invalid export", http.StatusBadRequest)
+			return
+		}
+		collector.mutex.Lock()
+		defer collector.mutex.Unlock()
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				collector.spans = append(collector.spans, scopeSpans.Spans...)
+			}
+		}
+		response, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(response)
+	}))
+	t.Cleanup(collector.Close)
+	return collector
+}
+
+// find returns the first span with a name, or nil
+func (c *testCollector) find(name string) *tracepb.Span {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, span := range c.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+// children returns the spans whose parent is span
+func (c *testCollector) children(span *tracepb.Span) []*tracepb.Span {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var children []*tracepb.Span
+	for _, child := range c.spans {
+		if bytes.Equal(child.ParentSpanId, span.SpanId) {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// spanAttribute returns the value of a string attribute of a span
+func spanAttribute(span *tracepb.Span, key string) string {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value.GetStringValue()
+		}
+	}
+	return ""
+}
+
+func TestTracing(t *testing.T) {
+	collector := newTestCollector(t)
+	provider, err := NewTracerProvider(context.Background(), collector.URL, 1)
+	if err != nil {
+		t.Fatalf("NewTracerProvider() error = %v", err)
+	}
+
+	app := newTestApp(t, testConfigTasks, 2)
+	app.TracerProvider = provider
+	handler := app.GetHTTPHandler()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/help/has_car", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	req.Header.Set("X-Request-ID", "req-help")
+	req.SetBasicAuth("admin", "changeme")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("help: status = %d", rec.Code)
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	if err := os.WriteFile(filepath.Join(app.ImagesDir, "new.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	if err := app.IngestImages(context.Background()); err != nil {
+		t.Fatalf("IngestImages() error = %v", err)
+	}
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	t.Run("requests continue the trace of the caller", func(t *testing.T) {
+		request := collector.find("GET /help/")
+		if request == nil {
+			t.Fatal("no span for the request")
+		}
+		if hex.EncodeToString(request.TraceId) != traceID || hex.EncodeToString(request.ParentSpanId) != "00f067aa0ba902b7" {
+			t.Errorf("request span is in trace %x under %x", request.TraceId, request.ParentSpanId)
+		}
+		if request.Kind != tracepb.Span_SPAN_KIND_SERVER || spanAttribute(request, "request_id") != "req-help" || spanAttribute(request, "enduser.id") != "admin" {
+			t.Errorf("request span = %v", request)
+		}
+	})
+
+	t.Run("queries are children of the work that made them", func(t *testing.T) {
+		progress := collector.find("GetPhaseProgressStats")
+		if progress == nil || !bytes.Equal(progress.ParentSpanId, collector.find("GET /help/").SpanId) {
+			t.Fatalf("progress span = %v", progress)
+		}
+		queries := collector.children(progress)
+		if len(queries) == 0 {
+			t.Fatal("no query spans under the progress span")
+		}
+		for _, query := range queries {
+			if query.Kind != tracepb.Span_SPAN_KIND_CLIENT || spanAttribute(query, "db.system.name") != "sqlite" || query.Name != "db "+spanAttribute(query, "db.operation.name") {
+				t.Errorf("query span = %v", query)
+			}
+		}
+	})
+
+	t.Run("ingestion has a span per file", func(t *testing.T) {
+		ingestion := collector.find("IngestImages")
+		if ingestion == nil {
+			t.Fatal("no span for the ingestion")
+		}
+		var files []*tracepb.Span
+		for _, child := range collector.children(ingestion) {
+			// Besides the files, an ingestion reads its checkpoint, prunes unanswered views and records which tasks are completed
+			if !slices.Contains([]string{"db GetIngestionCheckpoint", "db DeleteIngestionCheckpoint", "db DeleteAnnotationViewsBefore", "GetPhaseProgressStats"}, child.Name) {
+				files = append(files, child)
+			}
+		}
+		if len(files) != 1 || files[0].Name != "ingest image" || spanAttribute(files[0], "file") != "new.png" {
+			t.Fatalf("ingestion children = %v", files)
+		}
+		if queries := collector.children(files[0]); len(queries) != 2 || queries[0].Name != "db GetImage" || queries[1].Name != "db CreateImage" {
+			t.Errorf("file children = %v", queries)
+		}
+	})
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	for _, test := range []struct {
+		endpoint string
+		ratio    float64
+	}{
+		{"localhost:4318", 1},
+		{"ftp://localhost:4318", 1},
+		{"http://localhost:4318", 2},
+	} {
+		if _, err := NewTracerProvider(context.Background(), test.endpoint, test.ratio); err == nil {
+			t.Errorf("NewTracerProvider(%q, %v) should fail", test.endpoint, test.ratio)
+		}
+	}
+}
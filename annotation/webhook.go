@@ -0,0 +1,291 @@
+package annotation
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/lewtec/rotulador/internal/domain"
+	"github.com/lewtec/rotulador/internal/repository"
+)
+
+// EventIngestionFinished is sent to webhooks when an ingestion ends, in place of its progress
+const EventIngestionFinished = "ingestion-finished"
+
+var webhookEvents = []string{EventAnnotationCreated, EventTaskCompleted, EventIngestionFinished}
+
+const (
+	// webhookMaxAttempts is how many times a delivery is tried before giving up on it
+	webhookMaxAttempts = 10
+	// webhookBackoff is the wait before the first retry, doubled after each failure
+	webhookBackoff = 30 * time.Second
+	// webhookMaxBackoff caps the wait between retries
+	webhookMaxBackoff = 6 * time.Hour
+	// webhookPollInterval is how often the queue is checked for retries that are due
+	webhookPollInterval = time.Second
+	// webhookBatchSize is how many due deliveries are read from the queue at once
+	webhookBatchSize = 50
+	// webhookLogSize is how many deliveries the delivery log page shows
+	webhookLogSize = 200
+)
+
+// WebhookPayload is the JSON body POSTed to webhooks
+type WebhookPayload struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// webhookDispatcher sends the queued deliveries in the background
+type webhookDispatcher struct {
+	client       *http.Client
+	maxAttempts  int
+	backoff      time.Duration
+	pollInterval time.Duration
+	// wake makes the dispatcher look at the queue right after events are queued
+	wake chan struct{}
+}
+
+func newWebhookDispatcher() *webhookDispatcher {
+	return &webhookDispatcher{
+		client:       &http.Client{Timeout: 10 * time.Second},
+		maxAttempts:  webhookMaxAttempts,
+		backoff:      webhookBackoff,
+		pollInterval: webhookPollInterval,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// retryDelay is the wait before retrying a delivery that failed attempts times
+func (d *webhookDispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.backoff
+	for range attempts - 1 {
+		delay *= 2
+		if delay >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+	return delay
+}
+
+// validate checks the settings of a webhook and fills in the defaults
+func (c *ConfigWebhook) validate() error {
+	parsed, err := url.Parse(c.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid url %q: must be an absolute http or https URL", c.URL)
+	}
+	if len(c.Events) == 0 {
+		c.Events = webhookEvents
+	}
+	for _, event := range c.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("invalid event %q: must be one of %v", event, webhookEvents)
+		}
+	}
+	return nil
+}
+
+// webhook returns the configured webhook with a URL, or nil
+func (a *AnnotatorApp) webhook(url string) *ConfigWebhook {
+	for _, webhook := range a.Config.Webhooks {
+		if webhook.URL == url {
+			return webhook
+		}
+	}
+	return nil
+}
+
+// queueWebhooks stores the events wanted by each webhook in the delivery queue.
+// It runs after the changes the events are about were committed, so it must
+// not fail because the client that made them went away.
+func (a *AnnotatorApp) queueWebhooks(ctx context.Context, events ...Event) {
+	if len(a.Config.Webhooks) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	queued, err := a.queueWebhookDeliveries(ctx, events)
+	if err != nil {
+		slog.ErrorContext(ctx, "webhooks: queueing deliveries", "error", err)
+		return
+	}
+	if queued > 0 {
+		select {
+		case a.webhooks.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// queueWebhookDeliveries stores the deliveries of events in a single transaction, returning how many
+func (a *AnnotatorApp) queueWebhookDeliveries(ctx context.Context, events []Event) (int, error) {
+	tx, err := a.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	deliveries := repository.NewWebhookDeliveryRepositoryWithTx(a.timed(tx))
+	now := time.Now()
+	queued := 0
+	for _, event := range events {
+		eventType := event.Type
+		if event.Type == EventIngestionProgress {
+			if progress, ok := event.Data.(EventIngestion); !ok || !progress.Done {
+				continue
+			}
+			eventType = EventIngestionFinished
+		}
+		payload, err := json.Marshal(WebhookPayload{Event: eventType, CreatedAt: now.UTC(), Data: event.Data})
+		if err != nil {
+			return 0, err
+		}
+		for _, webhook := range a.Config.Webhooks {
+			if !slices.Contains(webhook.Events, eventType) {
+				continue
+			}
+			if _, err := deliveries.Create(ctx, webhook.URL, eventType, string(payload), now); err != nil {
+				return 0, err
+			}
+			queued++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("while committing deliveries: %w", err)
+	}
+	return queued, nil
+}
+
+// StartWebhooks delivers the queued webhook calls in the background until ctx is done
+func (a *AnnotatorApp) StartWebhooks(ctx context.Context) {
+	if len(a.Config.Webhooks) == 0 {
+		return
+	}
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		ticker := time.NewTicker(a.webhooks.pollInterval)
+		defer ticker.Stop()
+		for {
+			a.deliverDueWebhooks(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-a.webhooks.wake:
+			}
+		}
+	}()
+}
+
+// deliverDueWebhooks attempts every delivery that is due, oldest first
+func (a *AnnotatorApp) deliverDueWebhooks(ctx context.Context) {
+	for ctx.Err() == nil {
+		due, err := a.webhookRepo.ListDue(ctx, time.Now(), webhookBatchSize)
+		if err != nil {
+			slog.ErrorContext(ctx, "webhooks: reading the queue", "error", err)
+			return
+		}
+		for _, delivery := range due {
+			if ctx.Err() != nil {
+				return
+			}
+			// A call under way is let finish, within the timeout of the client, so it is not counted as failed
+			a.deliverWebhook(context.WithoutCancel(ctx), delivery)
+		}
+		if len(due) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// deliverWebhook makes one attempt at a delivery, recording its outcome
+func (a *AnnotatorApp) deliverWebhook(ctx context.Context, delivery *domain.WebhookDelivery) {
+	status, err := a.sendWebhook(ctx, delivery)
+	now := time.Now()
+	if err == nil {
+		if err := a.webhookRepo.MarkDelivered(ctx, delivery.ID, *status, now); err != nil {
+			slog.ErrorContext(ctx, "webhooks: recording delivery", "delivery", delivery.ID, "error", err)
+		}
+		return
+	}
+
+	var retryAt *time.Time
+	if attempts := delivery.Attempts + 1; attempts < a.webhooks.maxAttempts && a.webhook(delivery.URL) != nil {
+		next := now.Add(a.webhooks.retryDelay(attempts))
+		retryAt = &next
+		slog.WarnContext(ctx, "webhooks: delivery failed, retrying", "delivery", delivery.ID, "event", delivery.EventType, "url", delivery.URL, "retry_at", next, "error", err)
+	} else {
+		slog.ErrorContext(ctx, "webhooks: giving up on delivery", "delivery", delivery.ID, "event", delivery.EventType, "url", delivery.URL, "error", err)
+	}
+	if err := a.webhookRepo.MarkAttemptFailed(ctx, delivery.ID, status, err.Error(), retryAt, now); err != nil {
+		slog.ErrorContext(ctx, "webhooks: recording delivery", "delivery", delivery.ID, "error", err)
+	}
+}
+
+// sendWebhook POSTs a delivery, returning the status the receiver answered with if any
+func (a *AnnotatorApp) sendWebhook(ctx context.Context, delivery *domain.WebhookDelivery) (*int, error) {
+	webhook := a.webhook(delivery.URL)
+	if webhook == nil {
+		return nil, errors.New("webhook is no longer configured")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "rotulador-webhooks")
+	req.Header.Set("X-Rotulador-Event", delivery.EventType)
+	req.Header.Set("X-Rotulador-Delivery", strconv.FormatInt(delivery.ID, 10))
+	if webhook.Secret != "" {
+		req.Header.Set("X-Rotulador-Signature", WebhookSignature(webhook.Secret, []byte(delivery.Payload)))
+	}
+
+	resp, err := a.webhooks.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return &resp.StatusCode, nil
+}
+
+// WebhookSignature returns the X-Rotulador-Signature header of a payload,
+// which receivers compute the same way to check it came from this server
+func WebhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ListWebhookDeliveries returns the latest webhook deliveries, newest first
+func (a *AnnotatorApp) ListWebhookDeliveries(ctx context.Context, limit int) ([]*domain.WebhookDelivery, error) {
+	return a.webhookRepo.List(ctx, limit)
+}
+
+// RetryWebhookDelivery queues a delivery that was given up on again, returning false if it was not given up on
+func (a *AnnotatorApp) RetryWebhookDelivery(ctx context.Context, id int64) (bool, error) {
+	retried, err := a.webhookRepo.Retry(ctx, id, time.Now())
+	if retried {
+		select {
+		case a.webhooks.wake <- struct{}{}:
+		default:
+		}
+	}
+	return retried, err
+}
@@ -0,0 +1,195 @@
+package annotation
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testWebhookReceiver is a stand-in for the service a webhook calls, failing
+// the first requests it gets
+type testWebhookReceiver struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	failures int
+	received []*http.Request
+	payloads []WebhookPayload
+	bodies   [][]byte
+}
+
+func newTestWebhookReceiver(t *testing.T, failures int) *testWebhookReceiver {
+	t.Helper()
+	receiver := &testWebhookReceiver{failures: failures}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mutex.Lock()
+		defer receiver.mutex.Unlock()
+		if receiver.failures != 0 {
+			receiver.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("invalid payload %q: %v", body, err)
+		}
+		receiver.received = append(receiver.received, r)
+		receiver.payloads = append(receiver.payloads, payload)
+		receiver.bodies = append(receiver.bodies, body)
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+// waitFor polls until the receiver accepted count payloads
+func (receiver *testWebhookReceiver) waitFor(t *testing.T, count int) []WebhookPayload {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		receiver.mutex.Lock()
+		payloads := receiver.payloads
+		receiver.mutex.Unlock()
+		if len(payloads) >= count {
+			return payloads
+		}
+	}
+	t.Fatalf("receiver did not get %d payloads", count)
+	return nil
+}
+
+func TestWebhooks(t *testing.T) {
+	retrain := newTestWebhookReceiver(t, 1)
+	broken := newTestWebhookReceiver(t, -1)
+	app := newTestApp(t, `
+webhooks:
+  - url: `+retrain.URL+`/hook
+    events: [task-completed, annotation-created]
+    secret: s3cret
+  - url: `+broken.URL+`
+    events: [ingestion-finished]
+`+testConfigTasks, 1)
+	app.webhooks.backoff = 10 * time.Millisecond
+	app.webhooks.pollInterval = 10 * time.Millisecond
+	app.webhooks.maxAttempts = 3
+	handler := app.GetHTTPHandler()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	app.StartWebhooks(ctx)
+
+	t.Run("events are delivered after retries", func(t *testing.T) {
+		if err := app.SubmitAnnotation(context.Background(), AnnotationResponse{ImageID: testImageHash(0), TaskID: "has_car", User: "admin", Value: "false"}); err != nil {
+			t.Fatalf("SubmitAnnotation() error = %v", err)
+		}
+		// The annotation arrives first, retried after its first attempt failed,
+		// then the tasks it completed: car_kind too, as its only image is filtered out
+		payloads := retrain.waitFor(t, 3)
+		if payloads[0].Event != EventAnnotationCreated || payloads[1].Event != EventTaskCompleted || payloads[2].Event != EventTaskCompleted {
+			t.Fatalf("payloads = %+v", payloads)
+		}
+		if data := payloads[0].Data.(map[string]any); data["user"] != "admin" || data["value"] != "false" {
+			t.Errorf("webhooks should get the whole annotation: %+v", data)
+		}
+		req := retrain.received[0]
+		if req.URL.Path != "/hook" || req.Header.Get("X-Rotulador-Event") != EventAnnotationCreated || req.Header.Get("X-Rotulador-Delivery") == "" {
+			t.Errorf("request = %s %v", req.URL, req.Header)
+		}
+		if got, want := req.Header.Get("X-Rotulador-Signature"), WebhookSignature("s3cret", retrain.bodies[0]); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+		if !strings.HasPrefix(WebhookSignature("s3cret", nil), "sha256=") {
+			t.Error("signature should name its algorithm")
+		}
+
+		deliveries, err := app.ListWebhookDeliveries(context.Background(), 10)
+		if err != nil {
+			t.Fatalf("ListWebhookDeliveries() error = %v", err)
+		}
+		first := deliveries[len(deliveries)-1]
+		if first.DeliveredAt == nil || first.Attempts != 2 || *first.LastStatus != http.StatusOK || first.LastError != nil {
+			t.Errorf("first delivery = %+v", first)
+		}
+	})
+
+	t.Run("deliveries are given up on and retried by hand", func(t *testing.T) {
+		if err := app.IngestImages(context.Background()); err != nil {
+			t.Fatalf("IngestImages() error = %v", err)
+		}
+		var failed int64
+		for deadline := time.Now().Add(5 * time.Second); failed == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			deliveries, _ := app.ListWebhookDeliveries(context.Background(), 10)
+			for _, delivery := range deliveries {
+				if delivery.FailedAt != nil {
+					if delivery.EventType != EventIngestionFinished || delivery.Attempts != 3 || *delivery.LastStatus != http.StatusServiceUnavailable {
+						t.Errorf("failed delivery = %+v", delivery)
+					}
+					failed = delivery.ID
+				}
+			}
+		}
+		if failed == 0 {
+			t.Fatal("delivery to the broken receiver was never given up on")
+		}
+
+		body := doRequest(t, handler, http.MethodGet, "/webhooks/", nil).Body.String()
+		if !strings.Contains(body, "Gave up") || !strings.Contains(body, broken.URL) {
+			t.Error("delivery log should show the failed delivery")
+		}
+		if rec := doRequest(t, handler, http.MethodPost, "/webhooks/"+strconv.FormatInt(failed, 10)+"/retry", nil); rec.Code != http.StatusOK {
+			t.Errorf("retry: status = %d", rec.Code)
+		}
+		broken.mutex.Lock()
+		broken.failures = 0
+		broken.mutex.Unlock()
+		if payloads := broken.waitFor(t, 1); payloads[0].Event != EventIngestionFinished {
+			t.Errorf("payload = %+v", payloads[0])
+		}
+		if rec := doRequest(t, handler, http.MethodPost, "/webhooks/"+strconv.FormatInt(failed, 10)+"/retry", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("retry of a delivered call: status = %d, want 404", rec.Code)
+		}
+	})
+}
+
+func TestWebhooks_AdminOnly(t *testing.T) {
+	app := newTestAppWithUsers(t, map[string]string{"rita": "role: reviewer"}, testConfigTasks, 0)
+	if rec := doRequestAs(t, app.GetHTTPHandler(), "rita", http.MethodGet, "/webhooks/", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("reviewer: status = %d, want 403", rec.Code)
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	dispatcher := newWebhookDispatcher()
+	for attempts, want := range map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 4: 4 * time.Minute, 20: webhookMaxBackoff} {
+		if got := dispatcher.retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestLoadConfig_Webhooks(t *testing.T) {
+	for name, content := range map[string]string{
+		"relative url":  "webhooks: [{ url: /hook }]\n",
+		"invalid event": "webhooks: [{ url: http://localhost/hook, events: [image-deleted] }]\n",
+		"duplicate url": "webhooks: [{ url: http://localhost/hook }, { url: http://localhost/hook }]\n",
+	} {
+		t.Run("rejects "+name, func(t *testing.T) {
+			if _, err := LoadConfig(writeTestConfigWithUsers(t, nil, content+testConfigTasks)); err == nil {
+				t.Error("expected an error, got none")
+			}
+		})
+	}
+
+	config, err := LoadConfig(writeTestConfigWithUsers(t, nil, "webhooks: [{ url: https://ci.example.com/retrain }]\n"+testConfigTasks))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if got := strings.Join(config.Webhooks[0].Events, ","); got != "annotation-created,task-completed,ingestion-finished" {
+		t.Errorf("events = %s, want all by default", got)
+	}
+}
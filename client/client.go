@@ -0,0 +1,358 @@
+// Package client talks to the JSON API of a rotulador server. Its methods
+// mirror the OpenAPI document served at /api/openapi.json.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the API of a server. Requests are authenticated with Token when
+// set, or with Username and Password otherwise.
+type Client struct {
+	// BaseURL of the server, such as https://annotate.example.com, with its --base-path if any
+	BaseURL    string
+	Token      string
+	Username   string
+	Password   string
+	HTTPClient *http.Client
+}
+
+// New returns a client authenticated with an API token
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: baseURL, Token: token}
+}
+
+// Error is returned for failed requests
+type Error struct {
+	StatusCode int
+	// Code is a short identifier derived from the status, such as not_found
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rotulador: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsNotFound tells whether an error is a 404 from the server
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Task is an annotation task along with its progress
+type Task struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	ShortName string            `json:"short_name,omitempty"`
+	Type      string            `json:"type,omitempty"`
+	If        map[string]string `json:"if,omitempty"`
+	Classes   []Class           `json:"classes"`
+	Progress  Progress          `json:"progress"`
+}
+
+// Class is a possible answer of a task
+type Class struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Key         string `json:"key,omitempty"`
+	Color       string `json:"color,omitempty"`
+	Icon        string `json:"icon,omitempty"`
+}
+
+// Progress counts the images of a task
+type Progress struct {
+	Completed              int     `json:"completed"`
+	Pending                int     `json:"pending"`
+	FilteredWrongClass     int     `json:"filtered_wrong_class"`
+	NotYetAnnotated        int     `json:"not_yet_annotated"`
+	Total                  int     `json:"total"`
+	CompletedPercent       float64 `json:"completed_percent"`
+	PendingPercent         float64 `json:"pending_percent"`
+	FilteredPercent        float64 `json:"filtered_percent"`
+	NotYetAnnotatedPercent float64 `json:"not_yet_annotated_percent"`
+}
+
+// Step is an image waiting for an annotation
+type Step struct {
+	Task     string `json:"task"`
+	Image    string `json:"image"`
+	Filename string `json:"filename"`
+}
+
+// Annotation is a stored annotation
+type Annotation struct {
+	ID          int64      `json:"id"`
+	Task        string     `json:"task"`
+	Image       string     `json:"image"`
+	Filename    string     `json:"filename,omitempty"`
+	User        string     `json:"user"`
+	Value       string     `json:"value"`
+	AnnotatedAt time.Time  `json:"annotated_at"`
+	FlaggedAt   *time.Time `json:"flagged_at,omitempty"`
+	FlagReason  string     `json:"flag_reason,omitempty"`
+}
+
+// AnnotationInput is an annotation to submit
+type AnnotationInput struct {
+	Task string `json:"task"`
+	// Image is the SHA256 of the image
+	Image string `json:"image"`
+	// Value is the ID of the chosen class
+	Value string `json:"value"`
+	Sure  bool   `json:"sure"`
+}
+
+// AnnotationPage is a page of ListAnnotations
+type AnnotationPage struct {
+	Annotations []Annotation `json:"annotations"`
+	// NextCursor is passed as ListOptions.Cursor to get the next page, empty on the last one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ListOptions filters ListAnnotations, empty fields match anything
+type ListOptions struct {
+	Task   string
+	User   string
+	Image  string
+	Value  string
+	Limit  int
+	Cursor string
+}
+
+// ExportOptions filters Export, empty fields match anything
+type ExportOptions struct {
+	Task string
+}
+
+// Image is an image along with the annotations the user may see
+type Image struct {
+	SHA256      string       `json:"sha256"`
+	Filename    string       `json:"filename"`
+	IngestedAt  time.Time    `json:"ingested_at"`
+	URL         string       `json:"url"`
+	Annotations []Annotation `json:"annotations"`
+}
+
+// IngestionStatus is the state of the running or last image ingestion
+type IngestionStatus struct {
+	Running     bool               `json:"running"`
+	StartedAt   *time.Time         `json:"started_at,omitempty"`
+	FinishedAt  *time.Time         `json:"finished_at,omitempty"`
+	Error       string             `json:"error,omitempty"`
+	Total       int                `json:"total"`
+	Seen        int                `json:"seen"`
+	Ingested    int                `json:"ingested"`
+	Skipped     int                `json:"skipped"`
+	Failed      int                `json:"failed"`
+	Failures    []IngestionFailure `json:"failures,omitempty"`
+	CurrentFile string             `json:"current_file,omitempty"`
+	ETA         *time.Time         `json:"eta,omitempty"`
+}
+
+// IngestionFailure is a file the ingestion could not load
+type IngestionFailure struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// Tasks lists the tasks the user can see
+func (c *Client) Tasks(ctx context.Context) ([]Task, error) {
+	var body struct {
+		Tasks []Task `json:"tasks"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/v1/tasks", nil, nil, &body)
+	return body.Tasks, err
+}
+
+// Task gets a task by ID
+func (c *Client) Task(ctx context.Context, taskID string) (*Task, error) {
+	var task Task
+	if err := c.do(ctx, http.MethodGet, "/api/v1/tasks/"+url.PathEscape(taskID), nil, nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// NextStep picks the next image to annotate in a task, or in any task when
+// taskID is empty. It returns nil when there is nothing left.
+func (c *Client) NextStep(ctx context.Context, taskID string) (*Step, error) {
+	query := url.Values{}
+	if taskID != "" {
+		query.Set("task", taskID)
+	}
+	var body struct {
+		Step *Step `json:"step"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/v1/next", query, nil, &body)
+	return body.Step, err
+}
+
+// Submit stores annotations, either all or none of them
+func (c *Client) Submit(ctx context.Context, annotations ...AnnotationInput) ([]Annotation, error) {
+	request := struct {
+		Annotations []AnnotationInput `json:"annotations"`
+	}{annotations}
+	var body struct {
+		Annotations []Annotation `json:"annotations"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/v1/annotations", nil, request, &body)
+	return body.Annotations, err
+}
+
+// ListAnnotations lists a page of annotations, newest first
+func (c *Client) ListAnnotations(ctx context.Context, opts ListOptions) (*AnnotationPage, error) {
+	query := url.Values{}
+	setQuery(query, "task", opts.Task)
+	setQuery(query, "user", opts.User)
+	setQuery(query, "image", opts.Image)
+	setQuery(query, "value", opts.Value)
+	setQuery(query, "cursor", opts.Cursor)
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	var page AnnotationPage
+	if err := c.do(ctx, http.MethodGet, "/api/v1/annotations", query, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// Image gets an image by SHA256
+func (c *Client) Image(ctx context.Context, sha256 string) (*Image, error) {
+	var img Image
+	if err := c.do(ctx, http.MethodGet, "/api/v1/images/"+url.PathEscape(sha256), nil, nil, &img); err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+// Ingestion gets the state of the last image ingestion
+func (c *Client) Ingestion(ctx context.Context) (*IngestionStatus, error) {
+	var status IngestionStatus
+	if err := c.do(ctx, http.MethodGet, "/api/v1/ingestion", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// StartIngestion scans the images folder of the server again, in the background
+func (c *Client) StartIngestion(ctx context.Context) (*IngestionStatus, error) {
+	var status IngestionStatus
+	if err := c.do(ctx, http.MethodPost, "/api/v1/ingestion", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Export calls fn with every annotation the user may see, newest first, as
+// they arrive. It stops at the first error of fn and returns it.
+func (c *Client) Export(ctx context.Context, opts ExportOptions, fn func(Annotation) error) error {
+	query := url.Values{"format": {"jsonl"}}
+	setQuery(query, "task", opts.Task)
+	resp, err := c.send(ctx, http.MethodGet, "/api/v1/export", query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var ann Annotation
+		if err := json.Unmarshal(scanner.Bytes(), &ann); err != nil {
+			return fmt.Errorf("rotulador: invalid export line: %w", err)
+		}
+		if err := fn(ann); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func setQuery(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
+
+// send makes a request, returning an *Error for responses other than 2xx
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	target := strings.TrimSuffix(c.BaseURL, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	var errorBody struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&errorBody); err == nil {
+		apiErr.Code = errorBody.Error.Code
+		apiErr.Message = errorBody.Error.Message
+	} else {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return nil, apiErr
+}
+
+// do makes a request and decodes its JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	resp, err := c.send(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("rotulador: invalid response to %s %s: %w", method, path, err)
+	}
+	return nil
+}
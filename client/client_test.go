@@ -0,0 +1,385 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lewtec/rotulador/annotation"
+	"github.com/lewtec/rotulador/client"
+)
+
+const testConfig = `
+allow_plaintext_passwords: true
+auth:
+  admin: { password: secret, role: admin }
+  alice: { password: secret, role: annotator }
+tasks:
+  - id: has_car
+    name: Has car?
+    type: boolean
+  - id: car_kind
+    name: Car kind
+    if:
+      has_car: "true"
+    classes:
+      real: { name: Real }
+      toy: { name: Toy }
+`
+
+// newTestServer serves a migrated app holding a few images, returning its address
+func newTestServer(t *testing.T, images int) (*annotation.AnnotatorApp, *httptest.Server) {
+	t.Helper()
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(testConfig), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	config, err := annotation.LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	imagesDir := filepath.Join(dir, "images")
+	os.Mkdir(imagesDir, 0755)
+	for idx := 0; idx < images; idx++ {
+		var buf bytes.Buffer
+		png.Encode(&buf, image.NewGray(image.Rect(0, 0, idx+1, 1)))
+		if err := os.WriteFile(filepath.Join(imagesDir, fmt.Sprintf("%d.png", idx)), buf.Bytes(), 0644); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+	}
+	db, err := annotation.GetDatabase(filepath.Join(dir, "annotations.db"))
+	if err != nil {
+		t.Fatalf("GetDatabase() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	app := &annotation.AnnotatorApp{ImagesDir: imagesDir, Database: db, Config: config}
+	if err := app.PrepareDatabase(context.Background()); err != nil {
+		t.Fatalf("PrepareDatabase() error = %v", err)
+	}
+	server := httptest.NewServer(app.GetHTTPHandler())
+	t.Cleanup(server.Close)
+	return app, server
+}
+
+func newToken(t *testing.T, app *annotation.AnnotatorApp, username string, scopes ...string) string {
+	t.Helper()
+	user := &annotation.RequestUser{Name: username, ConfigAuth: app.Config.Authentication[username]}
+	token, err := app.CreateAPIToken(context.Background(), user, "test", scopes, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken() error = %v", err)
+	}
+	return token.Token
+}
+
+func TestClient(t *testing.T) {
+	app, server := newTestServer(t, 3)
+	contract := newContractChecker(t, server.URL)
+	ctx := context.Background()
+
+	alice := client.New(server.URL, newToken(t, app, "alice", annotation.ScopeAnnotate))
+	alice.HTTPClient = &http.Client{Transport: contract}
+	admin := &client.Client{BaseURL: server.URL, Username: "admin", Password: "secret", HTTPClient: &http.Client{Transport: contract}}
+
+	tasks, err := alice.Tasks(ctx)
+	if err != nil || len(tasks) != 2 || tasks[0].Progress.Pending != 3 || tasks[1].Classes[1].ID != "toy" {
+		t.Fatalf("Tasks() = %+v, %v", tasks, err)
+	}
+	if task, err := alice.Task(ctx, "car_kind"); err != nil || task.If["has_car"] != "true" {
+		t.Errorf("Task() = %+v, %v", task, err)
+	}
+	if _, err := alice.Task(ctx, "nope"); !client.IsNotFound(err) {
+		t.Errorf("Task() of a missing task: error = %v, want not found", err)
+	}
+
+	// Annotate everything through the client
+	var submitted int
+	for {
+		step, err := alice.NextStep(ctx, "")
+		if err != nil {
+			t.Fatalf("NextStep() error = %v", err)
+		}
+		if step == nil {
+			break
+		}
+		value := "true"
+		if step.Task == "car_kind" {
+			value = "toy"
+		}
+		annotations, err := alice.Submit(ctx, client.AnnotationInput{Task: step.Task, Image: step.Image, Value: value, Sure: true})
+		if err != nil || len(annotations) != 1 || annotations[0].Value != value {
+			t.Fatalf("Submit() = %+v, %v", annotations, err)
+		}
+		if submitted++; submitted > 10 {
+			t.Fatal("NextStep() never ran out of steps")
+		}
+	}
+	if submitted != 6 {
+		t.Errorf("submitted %d annotations, want 6", submitted)
+	}
+
+	var err2 *client.Error
+	_, err = alice.Submit(ctx, client.AnnotationInput{Task: "has_car", Image: "missing", Value: "true"})
+	if !client.IsNotFound(err) || !strings.Contains(err.Error(), "annotations[0]") {
+		t.Errorf("Submit() of a missing image: error = %v", err)
+	}
+	if _, err := alice.StartIngestion(ctx); err == nil || !asError(err, &err2) || err2.StatusCode != http.StatusForbidden {
+		t.Errorf("StartIngestion() as annotator: error = %v, want 403", err)
+	}
+
+	var listed []client.Annotation
+	opts := client.ListOptions{Task: "has_car", Limit: 2}
+	for {
+		page, err := admin.ListAnnotations(ctx, opts)
+		if err != nil {
+			t.Fatalf("ListAnnotations() error = %v", err)
+		}
+		listed = append(listed, page.Annotations...)
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	if len(listed) != 3 {
+		t.Errorf("listed %d annotations of has_car, want 3", len(listed))
+	}
+
+	img, err := alice.Image(ctx, listed[0].Image)
+	if err != nil || len(img.Annotations) != 2 || img.URL == "" {
+		t.Errorf("Image() = %+v, %v", img, err)
+	}
+
+	var exported []client.Annotation
+	err = alice.Export(ctx, client.ExportOptions{}, func(ann client.Annotation) error {
+		exported = append(exported, ann)
+		return nil
+	})
+	if err != nil || len(exported) != 6 {
+		t.Errorf("Export() = %d annotations, %v", len(exported), err)
+	}
+
+	status, err := admin.StartIngestion(ctx)
+	if err != nil || status.StartedAt == nil {
+		t.Fatalf("StartIngestion() = %+v, %v", status, err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); status.Running && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		if status, err = alice.Ingestion(ctx); err != nil {
+			t.Fatalf("Ingestion() error = %v", err)
+		}
+	}
+	if status.Running || status.Error != "" {
+		t.Errorf("ingestion = %+v", status)
+	}
+
+	contract.checkCoverage(t)
+}
+
+func TestClient_Unauthorized(t *testing.T) {
+	_, server := newTestServer(t, 0)
+	c := client.New(server.URL, "rtl_wrong")
+	c.HTTPClient = &http.Client{Transport: newContractChecker(t, server.URL)}
+	_, err := c.Tasks(context.Background())
+	var apiErr *client.Error
+	if !asError(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Code != "unauthorized" {
+		t.Errorf("Tasks() error = %v, want 401", err)
+	}
+}
+
+func asError(err error, target **client.Error) bool {
+	apiErr, ok := err.(*client.Error)
+	*target = apiErr
+	return ok
+}
+
+// contractChecker validates every response the client gets against the OpenAPI document
+type contractChecker struct {
+	t          *testing.T
+	doc        map[string]any
+	operations map[string]*regexp.Regexp
+	mutex      sync.Mutex
+	seen       map[string]bool
+}
+
+func newContractChecker(t *testing.T, serverURL string) *contractChecker {
+	t.Helper()
+	resp, err := http.Get(serverURL + "/api/openapi.json")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get the OpenAPI document: %v", err)
+	}
+	defer resp.Body.Close()
+	checker := &contractChecker{t: t, operations: map[string]*regexp.Regexp{}, seen: map[string]bool{}}
+	if err := json.NewDecoder(resp.Body).Decode(&checker.doc); err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+	if checker.doc["openapi"] != "3.0.3" {
+		t.Errorf("openapi = %v", checker.doc["openapi"])
+	}
+	for path, item := range checker.doc["paths"].(map[string]any) {
+		pattern := regexp.MustCompile(`\\\{[^}]+\\\}`).ReplaceAllString(regexp.QuoteMeta(path), `[^/]+`)
+		for method := range item.(map[string]any) {
+			checker.operations[strings.ToUpper(method)+" "+path] = regexp.MustCompile("^" + pattern + "$")
+		}
+	}
+	return checker
+}
+
+func (c *contractChecker) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var operation map[string]any
+	for key, pattern := range c.operations {
+		method, path, _ := strings.Cut(key, " ")
+		if method == req.Method && pattern.MatchString(req.URL.Path) {
+			operation = c.doc["paths"].(map[string]any)[path].(map[string]any)[strings.ToLower(method)].(map[string]any)
+			c.mutex.Lock()
+			c.seen[key] = true
+			c.mutex.Unlock()
+		}
+	}
+	name := req.Method + " " + req.URL.Path
+	if operation == nil {
+		c.t.Errorf("%s is not in the OpenAPI document", name)
+		return resp, nil
+	}
+	response, ok := operation["responses"].(map[string]any)[strconv.Itoa(resp.StatusCode)].(map[string]any)
+	if !ok {
+		c.t.Errorf("%s: status %d is not documented", name, resp.StatusCode)
+		return resp, nil
+	}
+	response = c.resolve(response)
+	contentType := resp.Header.Get("Content-Type")
+	media, ok := response["content"].(map[string]any)[contentType].(map[string]any)
+	if !ok {
+		c.t.Errorf("%s: content type %q of status %d is not documented", name, contentType, resp.StatusCode)
+		return resp, nil
+	}
+	lines := [][]byte{body}
+	if contentType == "application/x-ndjson" {
+		lines = bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	}
+	for _, line := range lines {
+		var value any
+		if err := json.Unmarshal(line, &value); err != nil {
+			c.t.Errorf("%s: invalid JSON %q", name, line)
+			continue
+		}
+		for _, problem := range c.validate(media["schema"].(map[string]any), value, "body") {
+			c.t.Errorf("%s: %s", name, problem)
+		}
+	}
+	return resp, nil
+}
+
+// checkCoverage fails for operations of the document that no request exercised
+func (c *contractChecker) checkCoverage(t *testing.T) {
+	t.Helper()
+	for key := range c.operations {
+		if !c.seen[key] {
+			t.Errorf("%s was never exercised", key)
+		}
+	}
+}
+
+func (c *contractChecker) resolve(object map[string]any) map[string]any {
+	for {
+		ref, ok := object["$ref"].(string)
+		if !ok {
+			return object
+		}
+		object = c.doc
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			object = object[part].(map[string]any)
+		}
+	}
+}
+
+// validate checks a value against the subset of JSON schema the document uses
+func (c *contractChecker) validate(schema map[string]any, value any, at string) []string {
+	schema = c.resolve(schema)
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return []string{at + " is null"}
+	}
+	var problems []string
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s is %T, want an object", at, value)}
+		}
+		for _, name := range asSlice(schema["required"]) {
+			if _, ok := object[name.(string)]; !ok {
+				problems = append(problems, at+"."+name.(string)+" is missing")
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for name, item := range object {
+			if property, ok := properties[name].(map[string]any); ok {
+				problems = append(problems, c.validate(property, item, at+"."+name)...)
+			} else if additional, ok := schema["additionalProperties"].(map[string]any); ok {
+				problems = append(problems, c.validate(additional, item, at+"."+name)...)
+			} else {
+				problems = append(problems, at+"."+name+" is not documented")
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s is %T, want an array", at, value)}
+		}
+		for idx, item := range array {
+			problems = append(problems, c.validate(schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", at, idx))...)
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s is %T, want a string", at, value)}
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, text); err != nil {
+				problems = append(problems, at+" is not a date-time")
+			}
+		}
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok || (schema["type"] == "integer" && number != float64(int64(number))) {
+			return []string{fmt.Sprintf("%s is %v, want %s", at, value, schema["type"])}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s is %T, want a boolean", at, value)}
+		}
+	}
+	return problems
+}
+
+func asSlice(value any) []any {
+	slice, _ := value.([]any)
+	return slice
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lewtec/rotulador/annotation"
+	"github.com/lewtec/rotulador/internal/domain"
+	"github.com/spf13/cobra"
+)
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspects and restores the annotation change history",
+	Long: `Every change to an annotation is recorded in an append-only log, with who made
+it, from where and under which config version. This command lists that log
+and allows putting an annotation back to a previous state.
+
+Examples:
+  # Full history of an image
+  rotulador audit image annotations.db 9f86d081884c7d65...
+
+  # Changes made by or to annotations of a user
+  rotulador audit user annotations.db alice
+
+  # Restore the state recorded by event 42
+  rotulador audit restore annotations.db 42 --actor admin`,
+}
+
+var auditImageCmd = &cobra.Command{
+	Use:   "image [flags] database sha256",
+	Short: "Shows the change history of an image",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		app, db, err := openDatabaseApp(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		defer annotation.CloseDatabase(db)
+
+		events, err := app.ImageEvents(cmd.Context(), args[1])
+		if err != nil {
+			return err
+		}
+		printAuditEvents(cmd.OutOrStdout(), app, events)
+		return nil
+	},
+}
+
+var auditUserCmd = &cobra.Command{
+	Use:   "user [flags] database username",
+	Short: "Shows the changes made by or to the annotations of a user",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		limit, err := cmd.Flags().GetInt("limit")
+		if err != nil {
+			return err
+		}
+		app, db, err := openDatabaseApp(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		defer annotation.CloseDatabase(db)
+
+		events, err := app.UserEvents(cmd.Context(), args[1], limit, 0)
+		if err != nil {
+			return err
+		}
+		printAuditEvents(cmd.OutOrStdout(), app, events)
+		return nil
+	},
+}
+
+var auditRestoreCmd = &cobra.Command{
+	Use:   "restore [flags] database event_id",
+	Short: "Puts an annotation back into the state recorded by an event",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		eventID, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid event id %q: %w", args[1], err)
+		}
+		actor, err := cmd.Flags().GetString("actor")
+		if err != nil {
+			return err
+		}
+		app, db, err := openDatabaseApp(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		defer annotation.CloseDatabase(db)
+
+		event, err := app.RestoreAnnotationEvent(cmd.Context(), eventID, actor, "")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "restored %s of %s for %s to %s\n", app.StageName(event.StageIndex), event.ImageSHA256, event.Username, auditValue(event.NewValue))
+		return nil
+	},
+}
+
+// openDatabaseApp opens the database along with the config from the --config flag, if any, used to name tasks and stamp new events
+func openDatabaseApp(cmd *cobra.Command, databaseFile string) (*annotation.AnnotatorApp, *sql.DB, error) {
+	config := &annotation.Config{}
+	if configFile, _ := cmd.Flags().GetString("config"); configFile != "" {
+		var err error
+		config, err = annotation.LoadConfig(configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	db, err := annotation.GetDatabase(databaseFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	app := &annotation.AnnotatorApp{
+		Database: db,
+		Config:   config,
+	}
+	if err := app.PrepareDatabaseMigrations(cmd.Context()); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return app, db, nil
+}
+
+func printAuditEvents(out io.Writer, app *annotation.AnnotatorApp, events []*domain.AnnotationEvent) {
+	fmt.Fprintln(out, strings.Join([]string{"id", "time", "type", "task", "image", "user", "old", "new", "actor", "client_ip", "config_version"}, "\t"))
+	for _, event := range events {
+		fmt.Fprintln(out, strings.Join([]string{
+			strconv.FormatInt(event.ID, 10),
+			event.CreatedAt.Format("2006-01-02 15:04:05"),
+			event.Type,
+			app.StageName(event.StageIndex),
+			event.ImageSHA256,
+			event.Username,
+			auditValue(event.OldValue),
+			auditValue(event.NewValue),
+			event.Actor,
+			event.ClientIP,
+			event.ConfigVersion,
+		}, "\t"))
+	}
+}
+
+func auditValue(value *string) string {
+	if value == nil {
+		return "-"
+	}
+	return *value
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditImageCmd, auditUserCmd, auditRestoreCmd)
+
+	auditCmd.PersistentFlags().StringP("config", "c", "", "Config file, used to show task names and stamp the config version of restores")
+	auditUserCmd.Flags().IntP("limit", "n", 100, "Maximum amount of events to show")
+	defaultActor := os.Getenv("USER")
+	if defaultActor == "" {
+		defaultActor = "cli"
+	}
+	auditRestoreCmd.Flags().String("actor", defaultActor, "Name recorded as the author of the restore")
+}
@@ -6,7 +6,7 @@ package main
 import (
 	"fmt"
 	"os"
-    "log"
+    "log/slog"
     "io/fs"
     "sync"
 
@@ -51,7 +51,7 @@ var ingestCmd = &cobra.Command{
             for image := range queue {
                 err := annotation.IngestImage(image, output)
                 if err != nil {
-                    log.Printf("Ingesting image error: %s", err)
+                    slog.Error("Ingesting image", "error", err)
                 }
             }
         }
@@ -73,7 +73,7 @@ var ingestCmd = &cobra.Command{
                 if err != nil {
                     return nil
                 }
-                log.Printf("found image '%s'", path)
+                slog.Info("Found image", "path", path)
                 crawledFilepaths <- img
                 return nil
             })
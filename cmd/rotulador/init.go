@@ -49,7 +49,7 @@ Example:
 		if err != nil {
 			return fmt.Errorf("failed to create database: %w", err)
 		}
-		defer db.Close()
+		defer annotation.CloseDatabase(db)
 
 		// Initialize database if images directory is provided
 		if imagesDir != "" {
@@ -109,11 +109,13 @@ meta:
     Edit this description to explain what you're annotating.
 
 # Authentication - users who can access the annotation tool
+# Both passwords are "changeme": set new ones with 'rotulador passwd <user>'
 auth:
   admin:
-    password: "changeme"
+    password: "%[1]s"
+    role: admin
   annotator:
-    password: "changeme"
+    password: "%[1]s"
 
 # Tasks - define the annotation workflow
 tasks:
@@ -162,5 +164,9 @@ tasks:
 #     value: "Ajuda"
 `
 
-	return os.WriteFile(filename, []byte(sampleConfig), 0644)
+	passwordHash, err := annotation.HashPassword("changeme", annotation.PasswordArgon2id)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, []byte(fmt.Sprintf(sampleConfig, passwordHash)), 0644)
 }
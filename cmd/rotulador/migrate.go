@@ -7,7 +7,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 
@@ -52,10 +52,7 @@ Example: rotulador migrate-legacy-db old.db new.db config.yaml`,
 			return fmt.Errorf("new database already exists: %s (delete it first if you want to recreate)", newDBPath)
 		}
 
-		log.Printf("Starting database migration...")
-		log.Printf("  Old DB: %s", oldDBPath)
-		log.Printf("  New DB: %s", newDBPath)
-		log.Printf("  Config: %s", configPath)
+		slog.Info("Starting database migration", "old", oldDBPath, "new", newDBPath, "config", configPath)
 
 		return migrateLegacyDatabase(cmd.Context(), oldDBPath, newDBPath, configPath)
 	},
@@ -116,21 +113,21 @@ func migrateLegacyDatabase(ctx context.Context, oldDBPath, newDBPath, configPath
 	defer tx.Rollback()
 
 	// Step 1: Migrate images
-	log.Printf("Migrating images...")
+	slog.Info("Migrating images")
 	imageMapping, err := migrateImages(ctx, oldDB, tx)
 	if err != nil {
 		return fmt.Errorf("failed to migrate images: %w", err)
 	}
-	log.Printf("  ✓ Migrated %d images", len(imageMapping))
+	slog.Info("✓ Migrated images", "count", len(imageMapping))
 
 	// Step 2: Migrate annotations for each task
 	for stageIndex, task := range config.Tasks {
-		log.Printf("Migrating task '%s' (stage %d)...", task.ID, stageIndex)
+		slog.Info("Migrating task", "task", task.ID, "stage", stageIndex)
 		count, err := migrateTaskAnnotations(ctx, oldDB, tx, task.ID, stageIndex, imageMapping)
 		if err != nil {
 			return fmt.Errorf("failed to migrate task %s: %w", task.ID, err)
 		}
-		log.Printf("  ✓ Migrated %d annotations", count)
+		slog.Info("✓ Migrated annotations", "task", task.ID, "count", count)
 	}
 
 	// Commit transaction
@@ -138,8 +135,8 @@ func migrateLegacyDatabase(ctx context.Context, oldDBPath, newDBPath, configPath
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	log.Printf("✓ Migration completed successfully!")
-	log.Printf("You can now use the new database with: rotulador %s", newDBPath)
+	slog.Info("✓ Migration completed")
+	slog.Info("You can now use the new database with rotulador", "database", newDBPath)
 	return nil
 }
 
@@ -162,7 +159,7 @@ func verifyLegacySchema(ctx context.Context, db *sql.DB, tasks []ConfigTask) err
 		tableName := fmt.Sprintf("task_%s", task.ID)
 		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?", tableName).Scan(&count)
 		if err != nil || count == 0 {
-			log.Printf("  Warning: task table '%s' not found, skipping", tableName)
+			slog.Warn("Task table not found, skipping", "table", tableName)
 		}
 	}
 
@@ -256,7 +253,7 @@ func migrateTaskAnnotations(ctx context.Context, oldDB *sql.DB, newTx *sql.Tx, t
 		// Get new image ID
 		newImageID, ok := imageMapping[ann.Image]
 		if !ok {
-			log.Printf("  Warning: annotation references unknown image %s, skipping", ann.Image)
+			slog.Warn("Annotation references unknown image, skipping", "image", ann.Image)
 			continue
 		}
 
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lewtec/rotulador/annotation"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// passwdCmd represents the passwd command
+var passwdCmd = &cobra.Command{
+	Use:   "passwd [flags] user",
+	Short: "Sets the password of a user in the config file",
+	Long: `Prompts for a password and stores its hash in the auth section of the config
+file, adding the user if it does not exist yet. The rest of the file is kept.
+
+When stdin is not a terminal the password is read from its first line, for scripts:
+  echo "$PASSWORD" | rotulador passwd -c config.yaml alice`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configFile, _ := cmd.Flags().GetString("config")
+		algorithm, _ := cmd.Flags().GetString("algorithm")
+		username := args[0]
+
+		password, err := readNewPassword(cmd.InOrStdin(), cmd.ErrOrStderr())
+		if err != nil {
+			return err
+		}
+		hash, err := annotation.HashPassword(password, algorithm)
+		if err != nil {
+			return err
+		}
+		if err := annotation.SetConfigPassword(configFile, username, hash); err != nil {
+			return fmt.Errorf("failed to update %s: %w", configFile, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Password of %s updated in %s\n", username, configFile)
+		return nil
+	},
+}
+
+// readNewPassword prompts twice without echo on a terminal, or reads a line otherwise
+func readNewPassword(in io.Reader, prompt io.Writer) (string, error) {
+	if file, ok := in.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+		fmt.Fprint(prompt, "New password: ")
+		password, err := term.ReadPassword(int(file.Fd()))
+		fmt.Fprintln(prompt)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprint(prompt, "Repeat password: ")
+		repeated, err := term.ReadPassword(int(file.Fd()))
+		fmt.Fprintln(prompt)
+		if err != nil {
+			return "", err
+		}
+		if string(password) != string(repeated) {
+			return "", errors.New("passwords do not match")
+		}
+		if len(password) == 0 {
+			return "", errors.New("empty password")
+		}
+		return string(password), nil
+	}
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("empty password")
+	}
+	return password, nil
+}
+
+func init() {
+	rootCmd.AddCommand(passwdCmd)
+
+	passwdCmd.Flags().StringP("config", "c", "config.yaml", "Config file to update")
+	passwdCmd.Flags().String("algorithm", annotation.PasswordArgon2id, "Hash algorithm: argon2id or bcrypt")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/lewtec/rotulador/annotation"
+	"github.com/spf13/cobra"
+)
+
+// serveProjects serves the projects of a manifest, or of a folder of project
+// folders, each under /p/<name>/, see annotation.LoadProjectsManifest
+func serveProjects(cmd *cobra.Command, path string) error {
+	manifest, err := annotation.LoadProjectsManifest(path)
+	if err != nil {
+		return fmt.Errorf("failed to load projects: %w", err)
+	}
+	dir := path
+	if stat, err := os.Stat(path); err == nil && !stat.IsDir() {
+		dir = filepath.Dir(path)
+	}
+
+	basePath, _ := cmd.Flags().GetString("base-path")
+	basePath, err = annotation.NormalizeBasePath(basePath)
+	if err != nil {
+		return err
+	}
+	auditLogger, auditFile, err := auditLogger(cmd)
+	if err != nil {
+		return err
+	}
+	if auditFile != nil {
+		defer auditFile.Close()
+	}
+	tracerProvider, err := tracerProvider(cmd)
+	if err != nil {
+		return err
+	}
+	if tracerProvider != nil {
+		defer tracerProvider.Shutdown(context.Background())
+	}
+
+	server := &annotation.ProjectServer{BasePath: basePath}
+	for _, project := range manifest.Projects {
+		config, err := manifest.LoadConfig(project)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		db, err := annotation.GetDatabase(project.DatabaseFile())
+		if err != nil {
+			return fmt.Errorf("project %s: failed to open database: %w", project.Name, err)
+		}
+		defer func() {
+			if err := annotation.CloseDatabase(db); err != nil {
+				slog.Error("Failed to close the database", "project", project.Name, "error", err)
+			}
+		}()
+
+		app := &annotation.AnnotatorApp{
+			ImagesDir:   project.ImagesDir(),
+			Database:    db,
+			Config:      config,
+			AuditLogger: auditLogger,
+		}
+		if tracerProvider != nil {
+			app.TracerProvider = tracerProvider
+		}
+		if err := app.PrepareDatabaseMigrations(cmd.Context()); err != nil {
+			return fmt.Errorf("project %s: failed to prepare database: %w", project.Name, err)
+		}
+		server.Projects = append(server.Projects, &annotation.Project{Name: project.Name, App: app})
+		slog.Info("Project loaded", "project", project.Name, "path", project.Path, "tasks", len(config.Tasks), "users", len(config.Authentication), "url", server.ProjectPath(project.Name)+"/")
+	}
+
+	// The handler sets the apps up, so build it before the background work starts
+	handler := server.Handler()
+	return runServer(cmd, dir, handler, server, server.Start)
+}
@@ -72,7 +72,7 @@ Examples:
 		if err != nil {
 			return err
 		}
-		defer db.Close()
+		defer annotation.CloseDatabase(db)
 
 		tx, err := db.BeginTx(cmd.Context(), &sql.TxOptions{
 			Isolation: sql.LevelReadUncommitted,
@@ -6,14 +6,20 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/lewtec/rotulador/annotation"
 	"github.com/spf13/cobra"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -24,52 +30,68 @@ var rootCmd = &cobra.Command{
 With a set of trivial choices scale the classification of a set of images to many people to build datasets to train classifiers.
     `),
 	Args: cobra.MaximumNArgs(1),
+	// Every subcommand logs as the --log-format and --log-level flags say
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		handler, err := logHandler(cmd, cmd.ErrOrStderr())
+		if err != nil {
+			return err
+		}
+		slog.SetDefault(slog.New(handler))
+		return nil
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if projects, _ := cmd.Flags().GetString("projects"); projects != "" {
+			if len(args) > 0 || cmd.Flags().Changed("config") || cmd.Flags().Changed("database") || cmd.Flags().Changed("images") {
+				return fmt.Errorf("--projects cannot be used with a project folder, --config, --database or --images")
+			}
+			return serveProjects(cmd, projects)
+		}
+
 		// 1. Handle directory argument and exit
 		if len(args) == 1 {
 			arg := args[0]
 			if stat, err := os.Stat(arg); err == nil && stat.IsDir() {
 				// It's a folder. Check for config, create if needed, then exit.
-				log.Printf("Detected folder argument: %s", arg)
+				slog.Info("Detected folder argument", "path", arg)
 				configFile := filepath.Join(arg, "config.yaml")
 				databaseFile := filepath.Join(arg, "annotations.db")
 				imagesDir := filepath.Join(arg, "images")
 
 				if _, err := os.Stat(configFile); os.IsNotExist(err) {
-					log.Printf("Creating default config: %s", configFile)
+					slog.Info("Creating default config", "path", configFile)
 					if err := createSampleConfig(configFile, arg); err != nil {
 						return fmt.Errorf("failed to create config: %w", err)
 					}
-					log.Printf("✓ Config file created.")
+					slog.Info("✓ Config file created")
 				} else {
-					log.Printf("✓ Config file already exists: %s.", configFile)
+					slog.Info("✓ Config file already exists", "path", configFile)
 				}
 
 				// Create empty database file
 				if _, err := os.Stat(databaseFile); os.IsNotExist(err) {
-					log.Printf("Creating empty database: %s", databaseFile)
+					slog.Info("Creating empty database", "path", databaseFile)
 					file, err := os.Create(databaseFile)
 					if err != nil {
 						return fmt.Errorf("failed to create database file: %w", err)
 					}
 					file.Close()
-					log.Printf("✓ Database file created.")
+					slog.Info("✓ Database file created")
 				} else {
-					log.Printf("✓ Database file already exists: %s.", databaseFile)
+					slog.Info("✓ Database file already exists", "path", databaseFile)
 				}
 
 				// Create images directory
 				if _, err := os.Stat(imagesDir); os.IsNotExist(err) {
-					log.Printf("Creating images directory: %s", imagesDir)
+					slog.Info("Creating images directory", "path", imagesDir)
 					if err := os.MkdirAll(imagesDir, 0755); err != nil {
 						return fmt.Errorf("failed to create images directory: %w", err)
 					}
-					log.Printf("✓ Images directory created.")
+					slog.Info("✓ Images directory created")
 				} else {
-					log.Printf("✓ Images directory already exists: %s.", imagesDir)
+					slog.Info("✓ Images directory already exists", "path", imagesDir)
 				}
 
-				log.Printf("You can now run 'rotulador %s' to start the server.", arg)
+				slog.Info("You can now run 'rotulador " + arg + "' to start the server")
 				return nil // Always exit after handling a directory argument
 			}
 		}
@@ -100,7 +122,7 @@ With a set of trivial choices scale the classification of a set of images to man
 		}
 
 		// 5. Server startup logic
-		log.Printf("Initializing project...")
+		slog.Info("Initializing project")
 
 		config, err := annotation.LoadConfig(configFile)
 		if err != nil {
@@ -111,12 +133,38 @@ With a set of trivial choices scale the classification of a set of images to man
 		if err != nil {
 			return fmt.Errorf("failed to open database: %w", err)
 		}
-		defer db.Close()
+		defer func() {
+			if err := annotation.CloseDatabase(db); err != nil {
+				slog.Error("Failed to close the database", "error", err)
+			}
+		}()
+
+		basePath, _ := cmd.Flags().GetString("base-path")
+		basePath, err = annotation.NormalizeBasePath(basePath)
+		if err != nil {
+			return err
+		}
 
 		app := &annotation.AnnotatorApp{
 			ImagesDir: imagesDir,
 			Database:  db,
 			Config:    config,
+			BasePath:  basePath,
+		}
+		auditLogger, auditFile, err := auditLogger(cmd)
+		if err != nil {
+			return err
+		}
+		if auditFile != nil {
+			defer auditFile.Close()
+		}
+		app.AuditLogger = auditLogger
+
+		if tracerProvider, err := tracerProvider(cmd); err != nil {
+			return err
+		} else if tracerProvider != nil {
+			defer tracerProvider.Shutdown(context.Background())
+			app.TracerProvider = tracerProvider
 		}
 
 		// Run database migrations synchronously before starting the server
@@ -124,42 +172,233 @@ With a set of trivial choices scale the classification of a set of images to man
 			return fmt.Errorf("failed to prepare database: %w", err)
 		}
 
-		addr, _ := cmd.Flags().GetString("addr")
-
-		log.Printf("Configuration: %s", configFile)
-		log.Printf("Database: %s", databaseFile)
-		log.Printf("Images: %s", imagesDir)
-		log.Printf("Tasks configured: %d", len(config.Tasks))
+		slog.Info("Project loaded", "config", configFile, "database", databaseFile, "images", imagesDir, "tasks", len(config.Tasks), "base_path", basePath)
 		for _, task := range config.Tasks {
-			log.Printf("  - %s: %s", task.ID, task.Name)
+			slog.Info("Task configured", "id", task.ID, "name", task.Name)
 		}
 
-		// Start image ingestion in background (non-blocking)
-		go func() {
-			if err := app.IngestImages(context.Background()); err != nil {
-				log.Printf("Error during background image ingestion: %v", err)
+		// The handler sets the app up, so build it before the background work starts
+		handler := app.GetHTTPHandler()
+		return runServer(cmd, filepath.Dir(configFile), handler, app, func(ctx context.Context) {
+			app.BackgroundContext = ctx
+			// Start image ingestion in background (non-blocking)
+			app.StartIngestion(ctx)
+			// Deliver queued webhook calls, those left from a previous run included
+			app.StartWebhooks(ctx)
+			// Forget the images served long ago and never answered
+			app.StartViewPruning(ctx)
+		})
+	},
+}
+
+// backgroundWork is the work the apps served do besides answering requests
+type backgroundWork interface {
+	// Wait blocks until the work returns, soon after its context is cancelled
+	Wait()
+	// CloseEventStreams ends the live event streams, which would hold the shutdown up
+	CloseEventStreams()
+}
+
+// runServer serves handler as the --addr, --listen and TLS flags say, keeping
+// a self-signed certificate in dir, with the background work of the apps
+// started by start, until SIGINT or SIGTERM
+func runServer(cmd *cobra.Command, dir string, handler http.Handler, work backgroundWork, start func(ctx context.Context)) error {
+	addr, _ := cmd.Flags().GetString("addr")
+	if listenAddr, _ := cmd.Flags().GetString("listen"); listenAddr != "" {
+		addr = listenAddr
+	}
+	listener, scheme, err := listen(cmd, addr, dir)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	// SIGINT and SIGTERM, as sent by docker stop, stop the server and the background work
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	start(ctx)
+
+	slog.Info("Server is ready, images are being loaded in the background", "addr", addr, "scheme", scheme)
+
+	shutdownTimeout, _ := cmd.Flags().GetDuration("shutdown-timeout")
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	server.RegisterOnShutdown(work.CloseEventStreams)
+	return serve(ctx, stop, server, listener, work, shutdownTimeout)
+}
+
+// listen opens the listener of the server on addr, a TCP address or
+// unix:/path/to.sock for a reverse proxy on the same machine, serving https as the
+// --tls-cert, --tls-key and --tls-self-signed flags say, in which case plain
+// HTTP requests are redirected to https. It returns the scheme served.
+func listen(cmd *cobra.Command, addr, projectDir string) (net.Listener, string, error) {
+	certFile, _ := cmd.Flags().GetString("tls-cert")
+	keyFile, _ := cmd.Flags().GetString("tls-key")
+	selfSigned, _ := cmd.Flags().GetBool("tls-self-signed")
+	if (certFile == "") != (keyFile == "") {
+		return nil, "", fmt.Errorf("--tls-cert and --tls-key must be given together")
+	}
+	if selfSigned && certFile != "" {
+		return nil, "", fmt.Errorf("--tls-self-signed cannot be used with --tls-cert and --tls-key")
+	}
+	if selfSigned {
+		certFile, keyFile = filepath.Join(projectDir, "tls-cert.pem"), filepath.Join(projectDir, "tls-key.pem")
+		generated, err := annotation.EnsureSelfSignedCertificate(certFile, keyFile, annotation.SelfSignedHosts())
+		if err != nil {
+			return nil, "", err
+		}
+		if generated {
+			slog.Info("Self-signed certificate generated", "cert", certFile, "key", keyFile)
+		}
+	}
+
+	var reloader *annotation.CertificateReloader
+	if certFile != "" {
+		var err error
+		if reloader, err = annotation.NewCertificateReloader(certFile, keyFile); err != nil {
+			return nil, "", err
+		}
+		// Browsers warn about self-signed certificates, users compare this with the fingerprint they show
+		slog.Info("TLS enabled", "cert", certFile, "fingerprint", annotation.CertificateFingerprint(reloader.Certificate()))
+	}
+	network := "tcp"
+	if socket, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", socket
+		// A socket left by a server that did not stop cleanly would fail the listen,
+		// one still answering is left for the listen to report
+		if stat, err := os.Stat(addr); err == nil && stat.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", addr); err == nil {
+				conn.Close()
+			} else {
+				os.Remove(addr)
 			}
-		}()
+		}
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to listen: %w", err)
+	}
+	if reloader == nil {
+		return listener, "http", nil
+	}
+	return annotation.NewTLSListener(listener, reloader), "https", nil
+}
 
-		log.Printf("Server is ready and listening on: %s", addr)
-		log.Printf("Images are being loaded in the background...")
+// serve runs server on listener until ctx is done, then stops taking requests
+// and lets those under way and the background work finish, for up to timeout
+func serve(ctx context.Context, stop context.CancelFunc, server *http.Server, listener net.Listener, work backgroundWork, timeout time.Duration) error {
+	listening := make(chan error, 1)
+	go func() {
+		listening <- server.Serve(listener)
+	}()
+	select {
+	case err := <-listening:
+		stop()
+		work.Wait()
+		return err
+	case <-ctx.Done():
+	}
+	// A second signal kills the process right away
+	stop()
+	slog.Info("Shutting down", "timeout", timeout)
 
-		return http.ListenAndServe(addr, app.GetHTTPHandler())
-	},
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		err = fmt.Errorf("failed to finish the requests under way: %w", err)
+	}
+
+	background := make(chan struct{})
+	go func() {
+		work.Wait()
+		close(background)
+	}()
+	select {
+	case <-background:
+	case <-shutdownCtx.Done():
+		slog.Warn("Background work still running at the end of the shutdown timeout")
+	}
+	if err == nil {
+		slog.Info("Server stopped")
+	}
+	return err
 }
 
 func main() {
 	err := rootCmd.Execute()
 	if err != nil {
-		log.Fatalf("Error executing command: %v", err)
+		slog.Error("Error executing command", "error", err)
 		os.Exit(1)
 	}
 }
 
+// logHandler returns a handler writing to w as the --log-format and --log-level flags say
+func logHandler(cmd *cobra.Command, w io.Writer) (slog.Handler, error) {
+	format, _ := cmd.Flags().GetString("log-format")
+	levelName, _ := cmd.Flags().GetString("log-level")
+	level, err := annotation.ParseLogLevel(levelName)
+	if err != nil {
+		return nil, err
+	}
+	return annotation.NewLogHandler(w, format, level)
+}
+
+// auditLogger returns the logger of the --audit-log file, with the file to
+// close once done, or nil to audit to the main log
+func auditLogger(cmd *cobra.Command) (*slog.Logger, io.Closer, error) {
+	auditLog, _ := cmd.Flags().GetString("audit-log")
+	if auditLog == "" {
+		return nil, nil, nil
+	}
+	file, err := os.OpenFile(auditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	handler, err := logHandler(cmd, file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return slog.New(handler), file, nil
+}
+
+// tracerProvider returns the provider exporting spans as the --otlp-endpoint and
+// --trace-sample-ratio flags say, or the OTEL_EXPORTER_OTLP_* environment
+// variables when the flag is not set. Tracing is off, nil, when neither is.
+func tracerProvider(cmd *cobra.Command) (*sdktrace.TracerProvider, error) {
+	endpoint, _ := cmd.Flags().GetString("otlp-endpoint")
+	if endpoint == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return nil, nil
+	}
+	sampleRatio, _ := cmd.Flags().GetFloat64("trace-sample-ratio")
+	provider, err := annotation.NewTracerProvider(cmd.Context(), endpoint, sampleRatio)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	slog.Info("Tracing enabled", "endpoint", endpoint, "sample_ratio", sampleRatio)
+	return provider, nil
+}
+
 func init() {
 	// Optional flags (only used when not providing a folder argument)
 	rootCmd.Flags().StringP("config", "c", "", "Config file for the annotation")
 	rootCmd.Flags().StringP("database", "d", "", "Database file path (defaults to annotations.db in config file's directory)")
 	rootCmd.Flags().StringP("images", "i", "", "Images directory path (defaults to 'images' in config file's directory)")
+	rootCmd.Flags().String("projects", "", "Serve the projects of a folder of project folders, or of a projects.yaml manifest, each under /p/<name>/")
 	rootCmd.Flags().StringP("addr", "a", ":8080", "Address to bind the webserver")
+	rootCmd.Flags().String("listen", "", "Address to listen on, host:port or unix:/path/to.sock (overrides --addr)")
+	rootCmd.Flags().String("base-path", "", "URL path prefix to serve under, such as /rotulador behind a reverse proxy")
+	rootCmd.Flags().String("tls-cert", "", "Certificate file to serve https, plain HTTP requests are redirected to it (requires --tls-key)")
+	rootCmd.Flags().String("tls-key", "", "Private key file of --tls-cert")
+	rootCmd.Flags().Bool("tls-self-signed", false, "Serve https with a self-signed certificate kept in the project folder, generated when missing")
+	rootCmd.Flags().String("audit-log", "", "File receiving logins and annotation changes (defaults to the main log)")
+	rootCmd.Flags().Duration("shutdown-timeout", 30*time.Second, "Time given to requests and background work under way to finish on SIGINT or SIGTERM")
+	rootCmd.Flags().String("otlp-endpoint", "", "OTLP/HTTP collector receiving traces, such as http://localhost:4318 (defaults to OTEL_EXPORTER_OTLP_ENDPOINT, tracing is off without either)")
+	rootCmd.Flags().Float64("trace-sample-ratio", 1, "Share of the requests traced, from 0 to 1")
+
+	rootCmd.PersistentFlags().String("log-format", annotation.LogFormatText, "Log format, text or json")
+	rootCmd.PersistentFlags().String("log-level", "info", "Minimum level logged: debug, info, warn or error")
 }
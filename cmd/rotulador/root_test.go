@@ -100,6 +100,7 @@ func TestRootCmd_SingleArgument(t *testing.T) {
 		validConfig := `
 meta:
   description: "Sample annotation project."
+allow_plaintext_passwords: true
 auth:
   admin: { password: "changeme" }
   annotator: { password: "changeme" }
@@ -128,16 +129,16 @@ tasks:
 
 		// The error might be a bind error if another test is running, which is fine.
 		// The main thing is to check that it *tried* to start.
-		if !strings.Contains(errOut, "Starting server on: :8082") && !strings.Contains(errOut, "bind: address already in use") {
+		if !strings.Contains(errOut, "addr=:8082") && !strings.Contains(errOut, "bind: address already in use") {
 			t.Errorf("expected log to show server starting, but it didn't. Got: %s", errOut)
 		}
 
-		expectedDbLog := fmt.Sprintf("Database: %s", dbPath)
+		expectedDbLog := fmt.Sprintf("database=%s", dbPath)
 		if !strings.Contains(errOut, expectedDbLog) {
 			t.Errorf("expected log to show default database path '%s', but it didn't. Got: %s", expectedDbLog, errOut)
 		}
 
-		expectedImagesLog := fmt.Sprintf("Images: %s", imagesPath)
+		expectedImagesLog := fmt.Sprintf("images=%s", imagesPath)
 		if !strings.Contains(errOut, expectedImagesLog) {
 			t.Errorf("expected log to show default images path '%s', but it didn't. Got: %s", expectedImagesLog, errOut)
 		}
@@ -157,3 +158,41 @@ tasks:
 		}
 	})
 }
+
+func TestRootCmd_LogFlags(t *testing.T) {
+	// Flag values outlive a command execution
+	t.Cleanup(func() {
+		rootCmd.PersistentFlags().Set("log-format", "text")
+		rootCmd.PersistentFlags().Set("log-level", "info")
+	})
+
+	t.Run("logs JSON when asked to", func(t *testing.T) {
+		_, errOut, err := executeCommand(t.TempDir(), "--log-format", "json")
+		if err != nil {
+			t.Fatalf("command execution failed: %v, output: %s", err, errOut)
+		}
+		if !strings.Contains(errOut, `"level":"INFO","msg":"Creating default config"`) {
+			t.Errorf("expected JSON logs, got: %s", errOut)
+		}
+	})
+
+	t.Run("leaves out records below the level", func(t *testing.T) {
+		_, errOut, err := executeCommand(t.TempDir(), "--log-format", "text", "--log-level", "warn")
+		if err != nil {
+			t.Fatalf("command execution failed: %v, output: %s", err, errOut)
+		}
+		if strings.Contains(errOut, "Creating default config") {
+			t.Errorf("expected info records to be left out, got: %s", errOut)
+		}
+	})
+
+	t.Run("rejects unknown values", func(t *testing.T) {
+		if _, _, err := executeCommand(t.TempDir(), "--log-level", "verbose"); err == nil || !strings.Contains(err.Error(), "invalid log level") {
+			t.Errorf("expected an invalid log level error, got: %v", err)
+		}
+		rootCmd.PersistentFlags().Set("log-level", "info")
+		if _, _, err := executeCommand(t.TempDir(), "--log-format", "xml"); err == nil || !strings.Contains(err.Error(), "invalid log format") {
+			t.Errorf("expected an invalid log format error, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lewtec/rotulador/annotation"
+)
+
+// localListener listens on a free local port
+func localListener(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	return listener
+}
+
+// getWhenListening sends a request as soon as the server listens
+func getWhenListening(addr string) (*http.Response, error) {
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var resp *http.Response
+		if resp, err = http.Get("http://" + addr + "/"); err == nil {
+			return resp, nil
+		}
+	}
+	return nil, err
+}
+
+func TestServe_Shutdown(t *testing.T) {
+	listener := localListener(t)
+	addr := listener.Addr().String()
+	started, release := make(chan struct{}), make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "saved")
+	})}
+	ctx, stop := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, stop, server, listener, &annotation.AnnotatorApp{}, 5*time.Second)
+	}()
+
+	response := make(chan string, 1)
+	go func() {
+		resp, err := getWhenListening(addr)
+		if err != nil {
+			response <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		response <- string(body)
+	}()
+
+	<-started
+	stop()
+	select {
+	case err := <-served:
+		t.Fatalf("serve() returned with a request under way: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if got := <-response; got != "saved" {
+		t.Errorf("request under way got %q", got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve() error = %v", err)
+	}
+	if _, err := http.Get("http://" + addr + "/"); err == nil {
+		t.Error("the server should no longer accept connections")
+	}
+}
+
+func TestServe_ShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	listener := localListener(t)
+	addr := listener.Addr().String()
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	ctx, stop := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, stop, server, listener, &annotation.AnnotatorApp{}, 50*time.Millisecond)
+	}()
+	go func() {
+		if resp, err := getWhenListening(addr); err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	<-started
+	stop()
+	select {
+	case err := <-served:
+		if err == nil {
+			t.Error("serve() should report the requests it gave up on")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve() did not give up after the timeout")
+	}
+}
+
+func TestListen_UnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "rotulador.sock")
+	// A socket left by a server that did not stop cleanly
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, scheme, err := listen(rootCmd, "unix:"+socket, t.TempDir())
+	if err != nil || scheme != "http" {
+		t.Fatalf("listen() = %v, %v", scheme, err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+	}}}
+	resp, err := client.Get("http://rotulador/rotulador/help/")
+	if err != nil {
+		t.Fatalf("request over the socket failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "/rotulador/help/" {
+		t.Errorf("body = %q", body)
+	}
+
+	if _, _, err := listen(rootCmd, "unix:"+socket, t.TempDir()); err == nil {
+		t.Error("listen() should not take over the socket of a running server")
+	}
+}
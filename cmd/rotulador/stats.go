@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lewtec/rotulador/annotation"
+	"github.com/spf13/cobra"
+)
+
+// statsCmd represents the stats command
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Shows annotation analytics",
+}
+
+var statsUsersCmd = &cobra.Command{
+	Use:   "users [flags] database",
+	Short: "Shows annotator productivity: throughput, answer times, daily activity and fast streaks",
+	Long: `Shows how much and how fast each annotator works, based on the time between an
+image being served and its answer arriving.
+
+Active time caps each answer at five minutes so breaks do not count as work.
+Fast streaks are runs of consecutive answers quicker than --fast, which usually
+mean someone is mashing keys instead of looking at the images.
+
+Examples:
+  rotulador stats users annotations.db -c config.yaml
+  rotulador stats users annotations.db --days 7 --fast 500ms --streak 20`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		days, err := cmd.Flags().GetInt("days")
+		if err != nil {
+			return err
+		}
+		fast, err := cmd.Flags().GetDuration("fast")
+		if err != nil {
+			return err
+		}
+		streak, err := cmd.Flags().GetInt("streak")
+		if err != nil {
+			return err
+		}
+		app, db, err := openDatabaseApp(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		defer annotation.CloseDatabase(db)
+
+		stats, err := app.UserStats(cmd.Context(), annotation.StatsOptions{
+			Since:         time.Now().AddDate(0, 0, -days),
+			FastThreshold: fast,
+			MinStreak:     streak,
+		})
+		if err != nil {
+			return err
+		}
+		printUserStats(cmd.OutOrStdout(), stats)
+		return nil
+	},
+}
+
+func printUserStats(out io.Writer, stats []*annotation.UserStats) {
+	fmt.Fprintln(out, strings.Join([]string{"user", "annotations", "active_time", "per_hour", "median", "task_medians", "fast_streaks"}, "\t"))
+	for _, user := range stats {
+		var taskMedians []string
+		for _, task := range user.Tasks {
+			taskMedians = append(taskMedians, fmt.Sprintf("%s=%s", task.TaskID, formatStatsDuration(task.Median)))
+		}
+		fmt.Fprintln(out, strings.Join([]string{
+			user.Username,
+			strconv.Itoa(user.Annotations),
+			formatStatsDuration(user.ActiveTime),
+			fmt.Sprintf("%.1f", user.PerHour),
+			formatStatsDuration(user.Median),
+			strings.Join(taskMedians, ","),
+			strconv.Itoa(len(user.FastStreaks)),
+		}, "\t"))
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, strings.Join([]string{"user", "day", "annotations", "active_time"}, "\t"))
+	for _, user := range stats {
+		for _, day := range user.Daily {
+			fmt.Fprintln(out, strings.Join([]string{user.Username, day.Day, strconv.Itoa(day.Annotations), formatStatsDuration(day.ActiveTime)}, "\t"))
+		}
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, strings.Join([]string{"user", "task", "start", "end", "answers", "median"}, "\t"))
+	for _, user := range stats {
+		for _, streak := range user.FastStreaks {
+			fmt.Fprintln(out, strings.Join([]string{
+				user.Username,
+				streak.TaskID,
+				streak.Start.Local().Format(time.DateTime),
+				streak.End.Local().Format(time.DateTime),
+				strconv.Itoa(streak.Answers),
+				formatStatsDuration(streak.Median),
+			}, "\t"))
+		}
+	}
+}
+
+func formatStatsDuration(d time.Duration) string {
+	return d.Round(10 * time.Millisecond).String()
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+	statsCmd.AddCommand(statsUsersCmd)
+
+	statsCmd.PersistentFlags().StringP("config", "c", "", "Config file, used to show task names")
+	statsUsersCmd.Flags().Int("days", 30, "How many days back to look")
+	statsUsersCmd.Flags().Duration("fast", annotation.StatsDefaultFastThreshold, "Answers faster than this are suspicious")
+	statsUsersCmd.Flags().Int("streak", annotation.StatsDefaultMinStreak, "Consecutive fast answers needed to report a streak")
+}
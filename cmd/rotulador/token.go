@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lewtec/rotulador/annotation"
+	"github.com/lewtec/rotulador/internal/domain"
+	"github.com/spf13/cobra"
+)
+
+// tokenCmd represents the token command
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manages API tokens for scripts",
+	Long: `API tokens let scripts use the server without a password, sending
+"Authorization: Bearer <token>". Each token has scopes limiting what it can do:
+read only reads, annotate also submits annotations, and admin allows everything
+the role of the user does. Only a hash of the token is stored, so it is shown
+once when created.
+
+Examples:
+  # Create a token for alice that expires in 90 days
+  rotulador token create -c config.yaml annotations.db alice --name export --scope read --expires 90d
+
+  # List the tokens of everyone, or of a user
+  rotulador token list annotations.db --user alice
+
+  # Revoke token 3
+  rotulador token revoke annotations.db 3`,
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create [flags] database username",
+	Short: "Creates an API token for a user of the config",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, _ := cmd.Flags().GetString("name")
+		scopeList, _ := cmd.Flags().GetString("scope")
+		expires, _ := cmd.Flags().GetString("expires")
+		scopes, err := annotation.ParseScopes(scopeList)
+		if err != nil {
+			return err
+		}
+		var expiresAt *time.Time
+		if expires != "" {
+			lifetime, err := parseLifetime(expires)
+			if err != nil {
+				return err
+			}
+			expiry := time.Now().Add(lifetime)
+			expiresAt = &expiry
+		}
+
+		app, db, err := openDatabaseApp(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		defer annotation.CloseDatabase(db)
+
+		username := args[1]
+		auth, ok := app.Config.Authentication[username]
+		if !ok {
+			return fmt.Errorf("user %s is not in the config", username)
+		}
+		token, err := app.CreateAPIToken(cmd.Context(), &annotation.RequestUser{Name: username, ConfigAuth: auth}, name, scopes, expiresAt)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "Created token %d for %s with scopes %s. It will not be shown again:\n", token.ID, username, strings.Join(scopes, ","))
+		fmt.Fprintln(cmd.OutOrStdout(), token.Token)
+		return nil
+	},
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list [flags] database",
+	Short: "Lists API tokens",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		username, _ := cmd.Flags().GetString("user")
+		app, db, err := openDatabaseApp(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		defer annotation.CloseDatabase(db)
+
+		tokens, err := app.ListAPITokens(cmd.Context(), username)
+		if err != nil {
+			return err
+		}
+		printAPITokens(cmd.OutOrStdout(), tokens)
+		return nil
+	},
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke [flags] database token_id",
+	Short: "Revokes an API token",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tokenID, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid token id %q: %w", args[1], err)
+		}
+		app, db, err := openDatabaseApp(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		defer annotation.CloseDatabase(db)
+
+		revoked, err := app.RevokeAPIToken(cmd.Context(), tokenID)
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return fmt.Errorf("token %d does not exist or is already revoked", tokenID)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "revoked token %d\n", tokenID)
+		return nil
+	},
+}
+
+// parseLifetime reads a duration in days such as "90d", or any Go duration
+func parseLifetime(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid expiry %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	lifetime, err := time.ParseDuration(value)
+	if err != nil || lifetime <= 0 {
+		return 0, fmt.Errorf("invalid expiry %q: use days such as 90d or a duration such as 12h", value)
+	}
+	return lifetime, nil
+}
+
+func printAPITokens(out io.Writer, tokens []*domain.APIToken) {
+	fmt.Fprintln(out, strings.Join([]string{"id", "user", "name", "prefix", "scopes", "created", "expires", "last_used", "revoked"}, "\t"))
+	for _, token := range tokens {
+		fmt.Fprintln(out, strings.Join([]string{
+			strconv.FormatInt(token.ID, 10),
+			token.Username,
+			token.Name,
+			token.Prefix,
+			strings.Join(token.Scopes, ","),
+			token.CreatedAt.Format("2006-01-02 15:04:05"),
+			tokenTime(token.ExpiresAt),
+			tokenTime(token.LastUsedAt),
+			tokenTime(token.RevokedAt),
+		}, "\t"))
+	}
+}
+
+func tokenTime(value *time.Time) string {
+	if value == nil {
+		return "-"
+	}
+	return value.Format("2006-01-02 15:04:05")
+}
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenCreateCmd, tokenListCmd, tokenRevokeCmd)
+
+	tokenCreateCmd.Flags().StringP("config", "c", "", "Config file, where the user must be listed")
+	tokenCreateCmd.MarkFlagRequired("config")
+	tokenCreateCmd.Flags().String("name", "", "Name to recognize the token by")
+	tokenCreateCmd.MarkFlagRequired("name")
+	tokenCreateCmd.Flags().String("scope", annotation.ScopeRead, "Scopes of the token, separated by commas: read, annotate or admin")
+	tokenCreateCmd.Flags().String("expires", "", "Lifetime of the token, such as 90d or 12h; it never expires when empty")
+	tokenListCmd.Flags().String("user", "", "Only list the tokens of this user")
+}
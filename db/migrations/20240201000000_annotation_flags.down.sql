@@ -0,0 +1,2 @@
+ALTER TABLE annotations DROP COLUMN flag_reason;
+ALTER TABLE annotations DROP COLUMN flagged_at;
@@ -0,0 +1,4 @@
+-- Annotations can be flagged for review when an upstream annotation they
+-- depend on (through the task `if` field) is changed
+ALTER TABLE annotations ADD COLUMN flagged_at TIMESTAMP;
+ALTER TABLE annotations ADD COLUMN flag_reason TEXT;
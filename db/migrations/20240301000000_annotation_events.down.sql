@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS annotation_events_no_delete;
+DROP TRIGGER IF EXISTS annotation_events_no_update;
+DROP INDEX IF EXISTS idx_annotation_events_actor;
+DROP INDEX IF EXISTS idx_annotation_events_username;
+DROP INDEX IF EXISTS idx_annotation_events_image_sha256;
+DROP TABLE IF EXISTS annotation_events;
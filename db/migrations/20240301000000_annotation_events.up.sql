@@ -0,0 +1,31 @@
+-- Append-only log of every change made to the annotations table.
+-- old_value/new_value are NULL when the annotation did not exist before/after the event.
+CREATE TABLE annotation_events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  event_type TEXT NOT NULL,
+  image_sha256 TEXT NOT NULL,
+  username TEXT NOT NULL,
+  stage_index INTEGER NOT NULL,
+  old_value TEXT,
+  new_value TEXT,
+  actor TEXT NOT NULL,
+  client_ip TEXT NOT NULL DEFAULT '',
+  config_version TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_annotation_events_image_sha256 ON annotation_events(image_sha256);
+CREATE INDEX idx_annotation_events_username ON annotation_events(username);
+CREATE INDEX idx_annotation_events_actor ON annotation_events(actor);
+
+CREATE TRIGGER annotation_events_no_update
+BEFORE UPDATE ON annotation_events
+BEGIN
+  SELECT RAISE(ABORT, 'annotation_events is append-only');
+END;
+
+CREATE TRIGGER annotation_events_no_delete
+BEFORE DELETE ON annotation_events
+BEGIN
+  SELECT RAISE(ABORT, 'annotation_events is append-only');
+END;
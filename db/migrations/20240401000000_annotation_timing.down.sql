@@ -0,0 +1,4 @@
+ALTER TABLE annotations DROP COLUMN duration_ms;
+ALTER TABLE annotations DROP COLUMN served_at;
+
+DROP TABLE annotation_views;
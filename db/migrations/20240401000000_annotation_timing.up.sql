@@ -0,0 +1,12 @@
+-- Time-on-item tracking: when an image is served to a user for a task a view
+-- is recorded, and when the answer arrives the elapsed time is stored on the annotation
+CREATE TABLE annotation_views (
+  image_sha256 TEXT NOT NULL,
+  username TEXT NOT NULL,
+  stage_index INTEGER NOT NULL,
+  served_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (image_sha256, username, stage_index)
+);
+
+ALTER TABLE annotations ADD COLUMN served_at TIMESTAMP;
+ALTER TABLE annotations ADD COLUMN duration_ms INTEGER;
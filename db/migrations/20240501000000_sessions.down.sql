@@ -0,0 +1,5 @@
+DROP TABLE app_secrets;
+
+DROP INDEX idx_sessions_expires_at;
+DROP INDEX idx_sessions_username;
+DROP TABLE sessions;
@@ -0,0 +1,17 @@
+-- Login sessions: the cookie carries a random token, only its SHA-256 is stored
+CREATE TABLE sessions (
+  token_hash TEXT PRIMARY KEY,
+  username TEXT NOT NULL,
+  client_ip TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_sessions_username ON sessions(username);
+CREATE INDEX idx_sessions_expires_at ON sessions(expires_at);
+
+-- Keys generated on first use, such as the one signing session cookies
+CREATE TABLE app_secrets (
+  name TEXT PRIMARY KEY,
+  value BLOB NOT NULL
+);
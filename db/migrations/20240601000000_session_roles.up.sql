@@ -0,0 +1,3 @@
+-- Role of users that come from single sign-on and are not listed in the config,
+-- decided when they logged in
+ALTER TABLE sessions ADD COLUMN role TEXT;
@@ -0,0 +1,2 @@
+DROP INDEX idx_api_tokens_username;
+DROP TABLE api_tokens;
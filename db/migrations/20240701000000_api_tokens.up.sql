@@ -0,0 +1,17 @@
+-- API tokens for scripts, sent as "Authorization: Bearer <token>". Only the
+-- SHA-256 of a token is stored, the token itself is shown once when created
+CREATE TABLE api_tokens (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  token_hash TEXT NOT NULL UNIQUE,
+  prefix TEXT NOT NULL,
+  username TEXT NOT NULL,
+  name TEXT NOT NULL,
+  scopes TEXT NOT NULL,
+  role TEXT,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at TIMESTAMP,
+  last_used_at TIMESTAMP,
+  revoked_at TIMESTAMP
+);
+
+CREATE INDEX idx_api_tokens_username ON api_tokens(username);
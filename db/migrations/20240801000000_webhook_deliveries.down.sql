@@ -0,0 +1,2 @@
+DROP INDEX idx_webhook_deliveries_pending;
+DROP TABLE webhook_deliveries;
@@ -0,0 +1,18 @@
+-- Queue of webhook calls, kept until they are delivered or given up on so
+-- events survive restarts and receivers that are down for a while
+CREATE TABLE webhook_deliveries (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  url TEXT NOT NULL,
+  event_type TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMP NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  delivered_at TIMESTAMP,
+  failed_at TIMESTAMP,
+  last_status INTEGER,
+  last_error TEXT
+);
+
+CREATE INDEX idx_webhook_deliveries_pending ON webhook_deliveries(next_attempt_at)
+WHERE delivered_at IS NULL AND failed_at IS NULL;
@@ -0,0 +1 @@
+DROP TABLE ingestion_checkpoints;
@@ -0,0 +1,7 @@
+-- Last file an ingestion got through, by images folder, so a run stopped by
+-- a shutdown resumes without hashing again the files it already stored
+CREATE TABLE ingestion_checkpoints (
+  dir TEXT PRIMARY KEY,
+  last_file TEXT NOT NULL,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,19 @@
+-- name: CreateAnnotationEvent :one
+INSERT INTO annotation_events (event_type, image_sha256, username, stage_index, old_value, new_value, actor, client_ip, config_version)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: GetAnnotationEvent :one
+SELECT * FROM annotation_events
+WHERE id = ?;
+
+-- name: ListAnnotationEventsForImage :many
+SELECT * FROM annotation_events
+WHERE image_sha256 = ?
+ORDER BY id DESC;
+
+-- name: ListAnnotationEventsByUser :many
+SELECT * FROM annotation_events
+WHERE username = @username OR actor = @username
+ORDER BY id DESC
+LIMIT @limit OFFSET @offset;
@@ -0,0 +1,26 @@
+-- name: RecordAnnotationView :exec
+INSERT INTO annotation_views (image_sha256, username, stage_index, served_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(image_sha256, username, stage_index)
+DO UPDATE SET served_at = excluded.served_at;
+
+-- name: TakeAnnotationView :one
+DELETE FROM annotation_views
+WHERE image_sha256 = ? AND username = ? AND stage_index = ?
+RETURNING served_at;
+
+-- name: SetAnnotationTiming :exec
+UPDATE annotations
+SET served_at = ?, duration_ms = ?
+WHERE id = ?;
+
+-- name: ListAnnotationTimings :many
+SELECT username, stage_index, served_at, duration_ms
+FROM annotations
+WHERE served_at IS NOT NULL AND duration_ms IS NOT NULL
+  AND served_at >= @since
+ORDER BY username, served_at, id;
+
+-- name: DeleteAnnotationViewsBefore :execrows
+DELETE FROM annotation_views
+WHERE served_at < @before;
@@ -4,13 +4,19 @@ VALUES (?, ?, ?, ?)
 ON CONFLICT(image_sha256, username, stage_index)
 DO UPDATE SET
   option_value = excluded.option_value,
-  annotated_at = CURRENT_TIMESTAMP
+  annotated_at = CURRENT_TIMESTAMP,
+  flagged_at = NULL,
+  flag_reason = NULL
 RETURNING *;
 
 -- name: GetAnnotation :one
 SELECT * FROM annotations
 WHERE image_sha256 = ? AND username = ? AND stage_index = ?;
 
+-- name: GetAnnotationByID :one
+SELECT * FROM annotations
+WHERE id = ?;
+
 -- name: GetAnnotationsForImage :many
 SELECT * FROM annotations
 WHERE image_sha256 = ?
@@ -21,9 +27,37 @@ SELECT a.*, i.filename
 FROM annotations a
 JOIN images i ON a.image_sha256 = i.sha256
 WHERE a.username = ?
-ORDER BY a.annotated_at DESC
+ORDER BY a.annotated_at DESC, a.id DESC
 LIMIT ? OFFSET ?;
 
+-- name: ListAnnotationsByUserFiltered :many
+SELECT a.*, i.filename
+FROM annotations a
+JOIN images i ON a.image_sha256 = i.sha256
+WHERE a.username = @username
+  AND (sqlc.narg('stage_index') IS NULL OR a.stage_index = sqlc.narg('stage_index'))
+  AND (sqlc.narg('option_value') IS NULL OR a.option_value = sqlc.narg('option_value'))
+ORDER BY a.annotated_at DESC, a.id DESC
+LIMIT @limit OFFSET @offset;
+
+-- name: ListAnnotationsPage :many
+SELECT a.*, i.filename
+FROM annotations a
+JOIN images i ON a.image_sha256 = i.sha256
+WHERE (sqlc.narg('username') IS NULL OR a.username = sqlc.narg('username'))
+  AND (sqlc.narg('image_sha256') IS NULL OR a.image_sha256 = sqlc.narg('image_sha256'))
+  AND (sqlc.narg('stage_index') IS NULL OR a.stage_index = sqlc.narg('stage_index'))
+  AND (sqlc.narg('option_value') IS NULL OR a.option_value = sqlc.narg('option_value'))
+  AND (sqlc.narg('before_id') IS NULL OR a.id < sqlc.narg('before_id'))
+ORDER BY a.id DESC
+LIMIT @limit;
+
+-- name: CountAnnotationsByUserFiltered :one
+SELECT COUNT(*) FROM annotations
+WHERE username = @username
+  AND (sqlc.narg('stage_index') IS NULL OR stage_index = sqlc.narg('stage_index'))
+  AND (sqlc.narg('option_value') IS NULL OR option_value = sqlc.narg('option_value'));
+
 -- name: GetAnnotationsByImageAndUser :many
 SELECT * FROM annotations
 WHERE image_sha256 = ? AND username = ?
@@ -51,6 +85,16 @@ SELECT EXISTS (
     WHERE image_sha256 = ? AND username = ? AND stage_index = ?
 );
 
+-- name: FlagAnnotationsForImageStage :execrows
+UPDATE annotations
+SET flagged_at = CURRENT_TIMESTAMP, flag_reason = ?
+WHERE image_sha256 = ? AND stage_index = ? AND flagged_at IS NULL;
+
+-- name: UnflagAnnotationsForImageStage :execrows
+UPDATE annotations
+SET flagged_at = NULL, flag_reason = NULL
+WHERE image_sha256 = ? AND stage_index = ? AND flagged_at IS NOT NULL;
+
 -- name: DeleteAnnotation :exec
 DELETE FROM annotations
 WHERE id = ?;
@@ -66,6 +110,24 @@ SELECT
   COUNT(DISTINCT username) as total_users
 FROM annotations;
 
+-- name: CountAnnotationsByClass :many
+SELECT stage_index, option_value, COUNT(*) AS annotations
+FROM annotations
+GROUP BY stage_index, option_value
+ORDER BY stage_index, option_value;
+
+-- name: CountAnnotationsByDay :many
+SELECT CAST(date(annotated_at, 'localtime') AS TEXT) AS day, COUNT(*) AS annotations
+FROM annotations
+WHERE date(annotated_at, 'localtime') >= CAST(@since AS TEXT)
+GROUP BY day
+ORDER BY day;
+
+-- name: ListAnnotators :many
+SELECT DISTINCT username
+FROM annotations
+ORDER BY username;
+
 -- name: CountPendingImagesForUserAndStage :one
 WITH annotated_images AS (
   SELECT image_sha256 FROM annotations WHERE username = ? AND stage_index = ?
@@ -0,0 +1,36 @@
+-- name: CreateAPIToken :one
+INSERT INTO api_tokens (token_hash, prefix, username, name, scopes, role, expires_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+RETURNING id, token_hash, prefix, username, name, scopes, role, created_at, expires_at, last_used_at, revoked_at;
+
+-- name: GetActiveAPITokenByHash :one
+SELECT id, token_hash, prefix, username, name, scopes, role, created_at, expires_at, last_used_at, revoked_at
+FROM api_tokens
+WHERE token_hash = ? AND revoked_at IS NULL
+  AND (expires_at IS NULL OR expires_at > @now);
+
+-- name: GetAPIToken :one
+SELECT id, token_hash, prefix, username, name, scopes, role, created_at, expires_at, last_used_at, revoked_at
+FROM api_tokens
+WHERE id = ?;
+
+-- name: ListAPITokens :many
+SELECT id, token_hash, prefix, username, name, scopes, role, created_at, expires_at, last_used_at, revoked_at
+FROM api_tokens
+ORDER BY username, id;
+
+-- name: ListAPITokensByUser :many
+SELECT id, token_hash, prefix, username, name, scopes, role, created_at, expires_at, last_used_at, revoked_at
+FROM api_tokens
+WHERE username = ?
+ORDER BY id;
+
+-- name: TouchAPIToken :exec
+UPDATE api_tokens
+SET last_used_at = @now
+WHERE id = @id AND (last_used_at IS NULL OR last_used_at < @stale_before);
+
+-- name: RevokeAPIToken :execrows
+UPDATE api_tokens
+SET revoked_at = @now
+WHERE id = @id AND revoked_at IS NULL;
@@ -27,3 +27,16 @@ SELECT COUNT(*) FROM images;
 -- name: DeleteImage :exec
 DELETE FROM images
 WHERE sha256 = ?;
+
+-- name: GetIngestionCheckpoint :one
+SELECT last_file FROM ingestion_checkpoints
+WHERE dir = ?;
+
+-- name: SaveIngestionCheckpoint :exec
+INSERT INTO ingestion_checkpoints (dir, last_file, updated_at)
+VALUES (?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT(dir) DO UPDATE SET last_file = excluded.last_file, updated_at = excluded.updated_at;
+
+-- name: DeleteIngestionCheckpoint :exec
+DELETE FROM ingestion_checkpoints
+WHERE dir = ?;
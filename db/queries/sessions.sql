@@ -0,0 +1,30 @@
+-- name: CreateSession :exec
+INSERT INTO sessions (token_hash, username, client_ip, expires_at, role)
+VALUES (?, ?, ?, ?, ?);
+
+-- name: GetSession :one
+SELECT token_hash, username, client_ip, created_at, expires_at, role
+FROM sessions
+WHERE token_hash = ? AND expires_at > @now;
+
+-- name: DeleteSession :exec
+DELETE FROM sessions
+WHERE token_hash = ?;
+
+-- name: DeleteUserSessions :execrows
+DELETE FROM sessions
+WHERE username = ?;
+
+-- name: DeleteExpiredSessions :execrows
+DELETE FROM sessions
+WHERE expires_at <= @now;
+
+-- name: CreateAppSecret :exec
+INSERT INTO app_secrets (name, value)
+VALUES (?, ?)
+ON CONFLICT(name) DO NOTHING;
+
+-- name: GetAppSecret :one
+SELECT value
+FROM app_secrets
+WHERE name = ?;
@@ -0,0 +1,33 @@
+-- name: CreateWebhookDelivery :one
+INSERT INTO webhook_deliveries (url, event_type, payload, next_attempt_at)
+VALUES (?, ?, ?, ?)
+RETURNING id, url, event_type, payload, attempts, next_attempt_at, created_at, delivered_at, failed_at, last_status, last_error;
+
+-- name: ListDueWebhookDeliveries :many
+SELECT id, url, event_type, payload, attempts, next_attempt_at, created_at, delivered_at, failed_at, last_status, last_error
+FROM webhook_deliveries
+WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= @now
+ORDER BY next_attempt_at, id
+LIMIT @limit;
+
+-- name: ListWebhookDeliveries :many
+SELECT id, url, event_type, payload, attempts, next_attempt_at, created_at, delivered_at, failed_at, last_status, last_error
+FROM webhook_deliveries
+ORDER BY id DESC
+LIMIT ?;
+
+-- name: MarkWebhookDelivered :exec
+UPDATE webhook_deliveries
+SET delivered_at = @now, attempts = attempts + 1, last_status = @last_status, last_error = NULL
+WHERE id = @id;
+
+-- name: MarkWebhookAttemptFailed :exec
+UPDATE webhook_deliveries
+SET attempts = attempts + 1, last_status = @last_status, last_error = @last_error,
+    next_attempt_at = @next_attempt_at, failed_at = @failed_at
+WHERE id = @id;
+
+-- name: RetryWebhookDelivery :execrows
+UPDATE webhook_deliveries
+SET failed_at = NULL, next_attempt_at = @now
+WHERE id = @id AND failed_at IS NOT NULL;
@@ -13,6 +13,27 @@ type Annotation struct {
 	StageIndex  int
 	OptionValue string
 	AnnotatedAt time.Time
+	FlaggedAt   *time.Time // Set when an upstream annotation this one depends on was changed
+	FlagReason  string
+	ServedAt    *time.Time     // When the image was shown to the user before answering
+	Duration    *time.Duration // Time between ServedAt and the answer
+}
+
+// AnnotationTiming is the time a user spent on an annotation
+type AnnotationTiming struct {
+	Username   string
+	StageIndex int
+	ServedAt   time.Time
+	Duration   time.Duration
+}
+
+// AnnotationFilter narrows down annotation listings. Nil fields match anything,
+// and so do empty strings in ListPage.
+type AnnotationFilter struct {
+	Username    string
+	ImageSHA256 string
+	StageIndex  *int
+	OptionValue *string
 }
 
 // AnnotationWithImage extends Annotation with image information
@@ -28,6 +49,19 @@ type AnnotationStats struct {
 	TotalUsers       int64
 }
 
+// ClassCount is the number of annotations of a class in a stage
+type ClassCount struct {
+	StageIndex  int
+	OptionValue string
+	Annotations int64
+}
+
+// DailyCount is the number of annotations made in a day
+type DailyCount struct {
+	Day         string // YYYY-MM-DD, local time
+	Annotations int64
+}
+
 // AnnotationRepository defines the interface for annotation storage operations
 type AnnotationRepository interface {
 	// Create creates or updates an annotation (upsert)
@@ -42,6 +76,40 @@ type AnnotationRepository interface {
 	// GetByUser retrieves annotations by a specific user (paginated)
 	GetByUser(ctx context.Context, username string, limit, offset int) ([]*AnnotationWithImage, error)
 
+	// GetByID retrieves an annotation by its ID
+	GetByID(ctx context.Context, id int64) (*Annotation, error)
+
+	// List retrieves the annotations of a user matching the filter (paginated, newest first)
+	List(ctx context.Context, filter AnnotationFilter, limit, offset int) ([]*AnnotationWithImage, error)
+
+	// ListPage retrieves annotations matching the filter with an ID below beforeID,
+	// or from the newest when beforeID is 0 (newest first)
+	ListPage(ctx context.Context, filter AnnotationFilter, beforeID int64, limit int) ([]*AnnotationWithImage, error)
+
+	// Count returns the number of annotations of a user matching the filter
+	Count(ctx context.Context, filter AnnotationFilter) (int64, error)
+
+	// FlagForImageStage flags the annotations of an image at a stage that are not flagged yet, returning how many
+	FlagForImageStage(ctx context.Context, imageSHA256 string, stageIndex int, reason string) (int64, error)
+
+	// UnflagForImageStage clears the flags of all annotations of an image at a stage, returning how many were flagged
+	UnflagForImageStage(ctx context.Context, imageSHA256 string, stageIndex int) (int64, error)
+
+	// RecordView stores when an image was served to a user for a stage, replacing older views
+	RecordView(ctx context.Context, imageSHA256 string, username string, stageIndex int, servedAt time.Time) error
+
+	// TakeView removes and returns the pending view of an image, or nil if there is none
+	TakeView(ctx context.Context, imageSHA256 string, username string, stageIndex int) (*time.Time, error)
+
+	// PruneViews deletes the views served before a time that were never answered, returning how many
+	PruneViews(ctx context.Context, before time.Time) (int64, error)
+
+	// SetTiming stores the time-on-item of an annotation
+	SetTiming(ctx context.Context, id int64, servedAt time.Time, duration time.Duration) error
+
+	// ListTimings retrieves the timings of annotations served since a given time, ordered by user and time
+	ListTimings(ctx context.Context, since time.Time) ([]*AnnotationTiming, error)
+
 	// GetByImageAndUser retrieves all annotations for an image by a specific user
 	GetByImageAndUser(ctx context.Context, imageSHA256 string, username string) ([]*Annotation, error)
 
@@ -62,4 +130,13 @@ type AnnotationRepository interface {
 
 	// GetStats returns overall annotation statistics
 	GetStats(ctx context.Context) (*AnnotationStats, error)
+
+	// CountByClass returns the number of annotations of each class of each stage, ordered by stage and class
+	CountByClass(ctx context.Context) ([]*ClassCount, error)
+
+	// CountByDay returns the number of annotations of each day since a given time, ordered by day. Days without annotations are left out.
+	CountByDay(ctx context.Context, since time.Time) ([]*DailyCount, error)
+
+	// ListUsers returns the users with annotations, by name
+	ListUsers(ctx context.Context) ([]string, error)
 }
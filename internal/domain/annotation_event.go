@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// Annotation event types recorded in the audit log
+const (
+	AnnotationEventCreate  = "create"
+	AnnotationEventUpdate  = "update"
+	AnnotationEventDelete  = "delete"
+	AnnotationEventFlag    = "flag"
+	AnnotationEventUnflag  = "unflag"
+	AnnotationEventRestore = "restore"
+)
+
+// AnnotationEvent is an append-only record of a change to an annotation
+type AnnotationEvent struct {
+	ID            int64
+	Type          string
+	ImageSHA256   string
+	Username      string // Owner of the annotation
+	StageIndex    int
+	OldValue      *string // nil when the annotation did not exist before the event
+	NewValue      *string // nil when the annotation does not exist after the event
+	Actor         string  // Who made the change
+	ClientIP      string
+	ConfigVersion string
+	CreatedAt     time.Time
+}
+
+// AnnotationEventRepository defines the interface for the annotation audit log
+type AnnotationEventRepository interface {
+	// Create appends an event to the log
+	Create(ctx context.Context, event AnnotationEvent) (*AnnotationEvent, error)
+
+	// Get retrieves an event by ID
+	Get(ctx context.Context, id int64) (*AnnotationEvent, error)
+
+	// ListForImage retrieves all events of an image, newest first
+	ListForImage(ctx context.Context, imageSHA256 string) ([]*AnnotationEvent, error)
+
+	// ListByUser retrieves events owned or made by a user, newest first (paginated)
+	ListByUser(ctx context.Context, username string, limit, offset int) ([]*AnnotationEvent, error)
+}
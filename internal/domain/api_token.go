@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// APIToken is a token scripts authenticate with, identified by its hash
+type APIToken struct {
+	ID         int64
+	TokenHash  string
+	Prefix     string // Start of the token, to tell tokens apart without revealing them
+	Username   string
+	Name       string
+	Scopes     []string
+	Role       *string // Set for users missing from the config, such as those of single sign-on
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// APITokenRepository defines the interface for API token storage
+type APITokenRepository interface {
+	// Create stores a new token
+	Create(ctx context.Context, token APIToken) (*APIToken, error)
+
+	// Get retrieves a token by ID, or nil
+	Get(ctx context.Context, id int64) (*APIToken, error)
+
+	// GetActive retrieves a token by hash if it is neither revoked nor expired by then, or nil
+	GetActive(ctx context.Context, tokenHash string, now time.Time) (*APIToken, error)
+
+	// List retrieves the tokens of a user, or of everyone when username is empty
+	List(ctx context.Context, username string) ([]*APIToken, error)
+
+	// Touch records a use of a token, at most once per interval
+	Touch(ctx context.Context, id int64, now time.Time, interval time.Duration) error
+
+	// Revoke revokes a token, returning false if it was already revoked or does not exist
+	Revoke(ctx context.Context, id int64, now time.Time) (bool, error)
+}
@@ -31,4 +31,13 @@ type ImageRepository interface {
 
 	// Delete removes an image by SHA256
 	Delete(ctx context.Context, sha256 string) error
+
+	// Checkpoint returns the last file an interrupted ingestion of dir got through, or ""
+	Checkpoint(ctx context.Context, dir string) (string, error)
+
+	// SaveCheckpoint records the last file an ingestion of dir got through
+	SaveCheckpoint(ctx context.Context, dir, file string) error
+
+	// DeleteCheckpoint forgets the checkpoint of dir
+	DeleteCheckpoint(ctx context.Context, dir string) error
 }
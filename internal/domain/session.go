@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// Session is a login session, identified by the hash of the token in its cookie
+type Session struct {
+	TokenHash string
+	Username  string
+	ClientIP  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Role      *string // Set for users missing from the config, such as those of single sign-on
+}
+
+// SessionRepository defines the interface for login session storage
+type SessionRepository interface {
+	// Create stores a new session
+	Create(ctx context.Context, session Session) error
+
+	// Get retrieves a session that has not expired by then, or nil
+	Get(ctx context.Context, tokenHash string, now time.Time) (*Session, error)
+
+	// Delete removes a session
+	Delete(ctx context.Context, tokenHash string) error
+
+	// DeleteUser removes all sessions of a user, returning how many there were
+	DeleteUser(ctx context.Context, username string) (int64, error)
+
+	// DeleteExpired removes the sessions expired by then, returning how many there were
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+
+	// Secret returns the named secret, storing the generated one if there is none yet
+	Secret(ctx context.Context, name string, generate func() ([]byte, error)) ([]byte, error)
+}
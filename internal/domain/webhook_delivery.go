@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// WebhookDelivery is a call to a webhook, queued until it succeeds or is given up on
+type WebhookDelivery struct {
+	ID            int64
+	URL           string
+	EventType     string
+	Payload       string
+	Attempts      int
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	DeliveredAt   *time.Time
+	FailedAt      *time.Time // Set once no more attempts will be made
+	LastStatus    *int       // HTTP status of the last attempt, if the receiver answered
+	LastError     *string
+}
+
+// WebhookDeliveryRepository defines the interface for the webhook delivery queue
+type WebhookDeliveryRepository interface {
+	// Create queues a delivery, to be attempted from nextAttemptAt on
+	Create(ctx context.Context, url, eventType, payload string, nextAttemptAt time.Time) (*WebhookDelivery, error)
+
+	// ListDue retrieves up to limit deliveries waiting to be attempted by now, oldest first
+	ListDue(ctx context.Context, now time.Time, limit int) ([]*WebhookDelivery, error)
+
+	// List retrieves the latest deliveries, newest first
+	List(ctx context.Context, limit int) ([]*WebhookDelivery, error)
+
+	// MarkDelivered records a successful attempt
+	MarkDelivered(ctx context.Context, id int64, status int, now time.Time) error
+
+	// MarkAttemptFailed records a failed attempt, to be retried at retryAt, or given up on when it is nil
+	MarkAttemptFailed(ctx context.Context, id int64, status *int, message string, retryAt *time.Time, now time.Time) error
+
+	// Retry queues a delivery that was given up on again, returning false if it was not given up on
+	Retry(ctx context.Context, id int64, now time.Time) (bool, error)
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lewtec/rotulador/internal/domain"
+	"github.com/lewtec/rotulador/internal/sqlc"
+)
+
+// AnnotationEventRepository implements domain.AnnotationEventRepository using SQLC
+type AnnotationEventRepository struct {
+	queries *sqlc.Queries
+}
+
+// NewAnnotationEventRepository creates a new AnnotationEventRepository
+func NewAnnotationEventRepository(db sqlc.DBTX) *AnnotationEventRepository {
+	return &AnnotationEventRepository{
+		queries: sqlc.New(db),
+	}
+}
+
+// NewAnnotationEventRepositoryWithTx creates a new AnnotationEventRepository with a transaction
+func NewAnnotationEventRepositoryWithTx(tx sqlc.DBTX) *AnnotationEventRepository {
+	return &AnnotationEventRepository{
+		queries: sqlc.New(tx),
+	}
+}
+
+// Create appends an event to the log
+func (r *AnnotationEventRepository) Create(ctx context.Context, event domain.AnnotationEvent) (*domain.AnnotationEvent, error) {
+	params := sqlc.CreateAnnotationEventParams{
+		EventType:     event.Type,
+		ImageSha256:   event.ImageSHA256,
+		Username:      event.Username,
+		StageIndex:    int64(event.StageIndex),
+		OldValue:      event.OldValue,
+		NewValue:      event.NewValue,
+		Actor:         event.Actor,
+		ClientIp:      event.ClientIP,
+		ConfigVersion: event.ConfigVersion,
+	}
+
+	row, err := r.queries.CreateAnnotationEvent(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return toDomainAnnotationEvent(row), nil
+}
+
+// Get retrieves an event by ID
+func (r *AnnotationEventRepository) Get(ctx context.Context, id int64) (*domain.AnnotationEvent, error) {
+	row, err := r.queries.GetAnnotationEvent(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return toDomainAnnotationEvent(row), nil
+}
+
+// ListForImage retrieves all events of an image, newest first
+func (r *AnnotationEventRepository) ListForImage(ctx context.Context, imageSHA256 string) ([]*domain.AnnotationEvent, error) {
+	rows, err := r.queries.ListAnnotationEventsForImage(ctx, imageSHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*domain.AnnotationEvent, len(rows))
+	for i, row := range rows {
+		result[i] = toDomainAnnotationEvent(row)
+	}
+
+	return result, nil
+}
+
+// ListByUser retrieves events owned or made by a user, newest first (paginated)
+func (r *AnnotationEventRepository) ListByUser(ctx context.Context, username string, limit, offset int) ([]*domain.AnnotationEvent, error) {
+	params := sqlc.ListAnnotationEventsByUserParams{
+		Username: username,
+		Limit:    int64(limit),
+		Offset:   int64(offset),
+	}
+
+	rows, err := r.queries.ListAnnotationEventsByUser(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*domain.AnnotationEvent, len(rows))
+	for i, row := range rows {
+		result[i] = toDomainAnnotationEvent(row)
+	}
+
+	return result, nil
+}
+
+// toDomainAnnotationEvent converts a sqlc.AnnotationEvent to domain.AnnotationEvent
+func toDomainAnnotationEvent(row sqlc.AnnotationEvent) *domain.AnnotationEvent {
+	d := &domain.AnnotationEvent{
+		ID:            row.ID,
+		Type:          row.EventType,
+		ImageSHA256:   row.ImageSha256,
+		Username:      row.Username,
+		StageIndex:    int(row.StageIndex),
+		OldValue:      row.OldValue,
+		NewValue:      row.NewValue,
+		Actor:         row.Actor,
+		ClientIP:      row.ClientIp,
+		ConfigVersion: row.ConfigVersion,
+	}
+	if row.CreatedAt != nil {
+		d.CreatedAt = *row.CreatedAt
+	}
+	return d
+}
+
+// Verify that AnnotationEventRepository implements domain.AnnotationEventRepository
+var _ domain.AnnotationEventRepository = (*AnnotationEventRepository)(nil)
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/lewtec/rotulador/internal/domain"
 	"github.com/lewtec/rotulador/internal/sqlc"
@@ -14,14 +15,14 @@ type AnnotationRepository struct {
 }
 
 // NewAnnotationRepository creates a new AnnotationRepository
-func NewAnnotationRepository(db *sql.DB) *AnnotationRepository {
+func NewAnnotationRepository(db sqlc.DBTX) *AnnotationRepository {
 	return &AnnotationRepository{
 		queries: sqlc.New(db),
 	}
 }
 
 // NewAnnotationRepositoryWithTx creates a new AnnotationRepository with a transaction
-func NewAnnotationRepositoryWithTx(tx *sql.Tx) *AnnotationRepository {
+func NewAnnotationRepositoryWithTx(tx sqlc.DBTX) *AnnotationRepository {
 	return &AnnotationRepository{
 		queries: sqlc.New(tx),
 	}
@@ -106,12 +107,227 @@ func (r *AnnotationRepository) GetByUser(ctx context.Context, username string, l
 		if row.AnnotatedAt != nil {
 			ann.Annotation.AnnotatedAt = *row.AnnotatedAt
 		}
+		ann.Annotation.FlaggedAt = row.FlaggedAt
+		if row.FlagReason != nil {
+			ann.Annotation.FlagReason = *row.FlagReason
+		}
+		result[i] = &ann
+	}
+
+	return result, nil
+}
+
+// GetByID retrieves an annotation by its ID
+func (r *AnnotationRepository) GetByID(ctx context.Context, id int64) (*domain.Annotation, error) {
+	ann, err := r.queries.GetAnnotationByID(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return toDomainAnnotation(ann), nil
+}
+
+// List retrieves the annotations of a user matching the filter (paginated, newest first)
+func (r *AnnotationRepository) List(ctx context.Context, filter domain.AnnotationFilter, limit, offset int) ([]*domain.AnnotationWithImage, error) {
+	params := sqlc.ListAnnotationsByUserFilteredParams{
+		Username: filter.Username,
+		Limit:    int64(limit),
+		Offset:   int64(offset),
+	}
+	if filter.StageIndex != nil {
+		params.StageIndex = int64(*filter.StageIndex)
+	}
+	if filter.OptionValue != nil {
+		params.OptionValue = *filter.OptionValue
+	}
+
+	rows, err := r.queries.ListAnnotationsByUserFiltered(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*domain.AnnotationWithImage, len(rows))
+	for i, row := range rows {
+		ann := domain.AnnotationWithImage{
+			Annotation: domain.Annotation{
+				ID:          row.ID,
+				ImageSHA256: row.ImageSha256,
+				Username:    row.Username,
+				StageIndex:  int(row.StageIndex),
+				OptionValue: row.OptionValue,
+				FlaggedAt:   row.FlaggedAt,
+			},
+			ImageFilename: row.Filename,
+		}
+		if row.AnnotatedAt != nil {
+			ann.Annotation.AnnotatedAt = *row.AnnotatedAt
+		}
+		if row.FlagReason != nil {
+			ann.Annotation.FlagReason = *row.FlagReason
+		}
+		result[i] = &ann
+	}
+
+	return result, nil
+}
+
+// ListPage retrieves annotations matching the filter with an ID below beforeID,
+// or from the newest when beforeID is 0 (newest first)
+func (r *AnnotationRepository) ListPage(ctx context.Context, filter domain.AnnotationFilter, beforeID int64, limit int) ([]*domain.AnnotationWithImage, error) {
+	params := sqlc.ListAnnotationsPageParams{
+		Limit: int64(limit),
+	}
+	if filter.Username != "" {
+		params.Username = filter.Username
+	}
+	if filter.ImageSHA256 != "" {
+		params.ImageSha256 = filter.ImageSHA256
+	}
+	if filter.StageIndex != nil {
+		params.StageIndex = int64(*filter.StageIndex)
+	}
+	if filter.OptionValue != nil {
+		params.OptionValue = *filter.OptionValue
+	}
+	if beforeID > 0 {
+		params.BeforeID = beforeID
+	}
+
+	rows, err := r.queries.ListAnnotationsPage(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*domain.AnnotationWithImage, len(rows))
+	for i, row := range rows {
+		ann := domain.AnnotationWithImage{
+			Annotation: domain.Annotation{
+				ID:          row.ID,
+				ImageSHA256: row.ImageSha256,
+				Username:    row.Username,
+				StageIndex:  int(row.StageIndex),
+				OptionValue: row.OptionValue,
+				FlaggedAt:   row.FlaggedAt,
+			},
+			ImageFilename: row.Filename,
+		}
+		if row.AnnotatedAt != nil {
+			ann.Annotation.AnnotatedAt = *row.AnnotatedAt
+		}
+		if row.FlagReason != nil {
+			ann.Annotation.FlagReason = *row.FlagReason
+		}
 		result[i] = &ann
 	}
 
 	return result, nil
 }
 
+// Count returns the number of annotations of a user matching the filter
+func (r *AnnotationRepository) Count(ctx context.Context, filter domain.AnnotationFilter) (int64, error) {
+	params := sqlc.CountAnnotationsByUserFilteredParams{
+		Username: filter.Username,
+	}
+	if filter.StageIndex != nil {
+		params.StageIndex = int64(*filter.StageIndex)
+	}
+	if filter.OptionValue != nil {
+		params.OptionValue = *filter.OptionValue
+	}
+	return r.queries.CountAnnotationsByUserFiltered(ctx, params)
+}
+
+// FlagForImageStage flags the annotations of an image at a stage that are not flagged yet, returning how many
+func (r *AnnotationRepository) FlagForImageStage(ctx context.Context, imageSHA256 string, stageIndex int, reason string) (int64, error) {
+	params := sqlc.FlagAnnotationsForImageStageParams{
+		FlagReason:  &reason,
+		ImageSha256: imageSHA256,
+		StageIndex:  int64(stageIndex),
+	}
+	return r.queries.FlagAnnotationsForImageStage(ctx, params)
+}
+
+// UnflagForImageStage clears the flags of all annotations of an image at a stage, returning how many were flagged
+func (r *AnnotationRepository) UnflagForImageStage(ctx context.Context, imageSHA256 string, stageIndex int) (int64, error) {
+	params := sqlc.UnflagAnnotationsForImageStageParams{
+		ImageSha256: imageSHA256,
+		StageIndex:  int64(stageIndex),
+	}
+	return r.queries.UnflagAnnotationsForImageStage(ctx, params)
+}
+
+// RecordView stores when an image was served to a user for a stage, replacing older views
+func (r *AnnotationRepository) RecordView(ctx context.Context, imageSHA256 string, username string, stageIndex int, servedAt time.Time) error {
+	params := sqlc.RecordAnnotationViewParams{
+		ImageSha256: imageSHA256,
+		Username:    username,
+		StageIndex:  int64(stageIndex),
+		ServedAt:    servedAt.UTC(),
+	}
+	return r.queries.RecordAnnotationView(ctx, params)
+}
+
+// TakeView removes and returns the pending view of an image, or nil if there is none
+func (r *AnnotationRepository) TakeView(ctx context.Context, imageSHA256 string, username string, stageIndex int) (*time.Time, error) {
+	params := sqlc.TakeAnnotationViewParams{
+		ImageSha256: imageSHA256,
+		Username:    username,
+		StageIndex:  int64(stageIndex),
+	}
+
+	servedAt, err := r.queries.TakeAnnotationView(ctx, params)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &servedAt, nil
+}
+
+// PruneViews deletes the views served before a time that were never answered, returning how many
+func (r *AnnotationRepository) PruneViews(ctx context.Context, before time.Time) (int64, error) {
+	return r.queries.DeleteAnnotationViewsBefore(ctx, before.UTC())
+}
+
+// SetTiming stores the time-on-item of an annotation
+func (r *AnnotationRepository) SetTiming(ctx context.Context, id int64, servedAt time.Time, duration time.Duration) error {
+	servedAt = servedAt.UTC()
+	durationMs := duration.Milliseconds()
+	params := sqlc.SetAnnotationTimingParams{
+		ServedAt:   &servedAt,
+		DurationMs: &durationMs,
+		ID:         id,
+	}
+	return r.queries.SetAnnotationTiming(ctx, params)
+}
+
+// ListTimings retrieves the timings of annotations served since a given time, ordered by user and time
+func (r *AnnotationRepository) ListTimings(ctx context.Context, since time.Time) ([]*domain.AnnotationTiming, error) {
+	since = since.UTC()
+	rows, err := r.queries.ListAnnotationTimings(ctx, &since)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*domain.AnnotationTiming, 0, len(rows))
+	for _, row := range rows {
+		if row.ServedAt == nil || row.DurationMs == nil {
+			continue
+		}
+		result = append(result, &domain.AnnotationTiming{
+			Username:   row.Username,
+			StageIndex: int(row.StageIndex),
+			ServedAt:   *row.ServedAt,
+			Duration:   time.Duration(*row.DurationMs) * time.Millisecond,
+		})
+	}
+	return result, nil
+}
+
 // GetByImageAndUser retrieves all annotations for an image by a specific user
 func (r *AnnotationRepository) GetByImageAndUser(ctx context.Context, imageSHA256 string, username string) ([]*domain.Annotation, error) {
 	params := sqlc.GetAnnotationsByImageAndUserParams{
@@ -198,6 +414,46 @@ func (r *AnnotationRepository) GetStats(ctx context.Context) (*domain.Annotation
 	}, nil
 }
 
+// CountByClass returns the number of annotations of each class of each stage, ordered by stage and class
+func (r *AnnotationRepository) CountByClass(ctx context.Context) ([]*domain.ClassCount, error) {
+	rows, err := r.queries.CountAnnotationsByClass(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*domain.ClassCount, len(rows))
+	for i, row := range rows {
+		result[i] = &domain.ClassCount{
+			StageIndex:  int(row.StageIndex),
+			OptionValue: row.OptionValue,
+			Annotations: row.Annotations,
+		}
+	}
+	return result, nil
+}
+
+// CountByDay returns the number of annotations of each day since a given time, ordered by day. Days without annotations are left out.
+func (r *AnnotationRepository) CountByDay(ctx context.Context, since time.Time) ([]*domain.DailyCount, error) {
+	rows, err := r.queries.CountAnnotationsByDay(ctx, since.Local().Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*domain.DailyCount, len(rows))
+	for i, row := range rows {
+		result[i] = &domain.DailyCount{
+			Day:         row.Day,
+			Annotations: row.Annotations,
+		}
+	}
+	return result, nil
+}
+
+// ListUsers returns the users with annotations, by name
+func (r *AnnotationRepository) ListUsers(ctx context.Context) ([]string, error) {
+	return r.queries.ListAnnotators(ctx)
+}
+
 // toDomainAnnotation converts a sqlc.Annotation to domain.Annotation
 func toDomainAnnotation(ann sqlc.Annotation) *domain.Annotation {
 	d := &domain.Annotation{
@@ -206,10 +462,19 @@ func toDomainAnnotation(ann sqlc.Annotation) *domain.Annotation {
 		Username:    ann.Username,
 		StageIndex:  int(ann.StageIndex),
 		OptionValue: ann.OptionValue,
+		FlaggedAt:   ann.FlaggedAt,
+		ServedAt:    ann.ServedAt,
 	}
 	if ann.AnnotatedAt != nil {
 		d.AnnotatedAt = *ann.AnnotatedAt
 	}
+	if ann.FlagReason != nil {
+		d.FlagReason = *ann.FlagReason
+	}
+	if ann.DurationMs != nil {
+		duration := time.Duration(*ann.DurationMs) * time.Millisecond
+		d.Duration = &duration
+	}
 	return d
 }
 
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lewtec/rotulador/internal/domain"
+	"github.com/lewtec/rotulador/internal/sqlc"
+)
+
+// APITokenRepository implements domain.APITokenRepository using SQLC
+type APITokenRepository struct {
+	queries *sqlc.Queries
+}
+
+// NewAPITokenRepository creates a new APITokenRepository
+func NewAPITokenRepository(db sqlc.DBTX) *APITokenRepository {
+	return &APITokenRepository{
+		queries: sqlc.New(db),
+	}
+}
+
+// NewAPITokenRepositoryWithTx creates a new APITokenRepository with a transaction
+func NewAPITokenRepositoryWithTx(tx sqlc.DBTX) *APITokenRepository {
+	return &APITokenRepository{
+		queries: sqlc.New(tx),
+	}
+}
+
+// Create stores a new token
+func (r *APITokenRepository) Create(ctx context.Context, token domain.APIToken) (*domain.APIToken, error) {
+	params := sqlc.CreateAPITokenParams{
+		TokenHash: token.TokenHash,
+		Prefix:    token.Prefix,
+		Username:  token.Username,
+		Name:      token.Name,
+		Scopes:    strings.Join(token.Scopes, " "),
+		Role:      token.Role,
+	}
+	if token.ExpiresAt != nil {
+		expiresAt := token.ExpiresAt.UTC()
+		params.ExpiresAt = &expiresAt
+	}
+
+	row, err := r.queries.CreateAPIToken(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return toDomainAPIToken(row), nil
+}
+
+// Get retrieves a token by ID, or nil
+func (r *APITokenRepository) Get(ctx context.Context, id int64) (*domain.APIToken, error) {
+	row, err := r.queries.GetAPIToken(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return toDomainAPIToken(row), nil
+}
+
+// GetActive retrieves a token by hash if it is neither revoked nor expired by then, or nil
+func (r *APITokenRepository) GetActive(ctx context.Context, tokenHash string, now time.Time) (*domain.APIToken, error) {
+	now = now.UTC()
+	params := sqlc.GetActiveAPITokenByHashParams{
+		TokenHash: tokenHash,
+		Now:       &now,
+	}
+	row, err := r.queries.GetActiveAPITokenByHash(ctx, params)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return toDomainAPIToken(row), nil
+}
+
+// List retrieves the tokens of a user, or of everyone when username is empty
+func (r *APITokenRepository) List(ctx context.Context, username string) ([]*domain.APIToken, error) {
+	var rows []sqlc.ApiToken
+	var err error
+	if username == "" {
+		rows, err = r.queries.ListAPITokens(ctx)
+	} else {
+		rows, err = r.queries.ListAPITokensByUser(ctx, username)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*domain.APIToken, len(rows))
+	for i, row := range rows {
+		result[i] = toDomainAPIToken(row)
+	}
+
+	return result, nil
+}
+
+// Touch records a use of a token, at most once per interval
+func (r *APITokenRepository) Touch(ctx context.Context, id int64, now time.Time, interval time.Duration) error {
+	now = now.UTC()
+	staleBefore := now.Add(-interval)
+	params := sqlc.TouchAPITokenParams{
+		Now:         &now,
+		ID:          id,
+		StaleBefore: &staleBefore,
+	}
+	return r.queries.TouchAPIToken(ctx, params)
+}
+
+// Revoke revokes a token, returning false if it was already revoked or does not exist
+func (r *APITokenRepository) Revoke(ctx context.Context, id int64, now time.Time) (bool, error) {
+	now = now.UTC()
+	params := sqlc.RevokeAPITokenParams{
+		Now: &now,
+		ID:  id,
+	}
+	count, err := r.queries.RevokeAPIToken(ctx, params)
+	return count > 0, err
+}
+
+func toDomainAPIToken(row sqlc.ApiToken) *domain.APIToken {
+	return &domain.APIToken{
+		ID:         row.ID,
+		TokenHash:  row.TokenHash,
+		Prefix:     row.Prefix,
+		Username:   row.Username,
+		Name:       row.Name,
+		Scopes:     strings.Fields(row.Scopes),
+		Role:       row.Role,
+		CreatedAt:  row.CreatedAt,
+		ExpiresAt:  row.ExpiresAt,
+		LastUsedAt: row.LastUsedAt,
+		RevokedAt:  row.RevokedAt,
+	}
+}
+
+// Verify that APITokenRepository implements domain.APITokenRepository
+var _ domain.APITokenRepository = (*APITokenRepository)(nil)
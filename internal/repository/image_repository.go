@@ -14,14 +14,14 @@ type ImageRepository struct {
 }
 
 // NewImageRepository creates a new ImageRepository
-func NewImageRepository(db *sql.DB) *ImageRepository {
+func NewImageRepository(db sqlc.DBTX) *ImageRepository {
 	return &ImageRepository{
 		queries: sqlc.New(db),
 	}
 }
 
 // NewImageRepositoryWithTx creates a new ImageRepository with a transaction
-func NewImageRepositoryWithTx(tx *sql.Tx) *ImageRepository {
+func NewImageRepositoryWithTx(tx sqlc.DBTX) *ImageRepository {
 	return &ImageRepository{
 		queries: sqlc.New(tx),
 	}
@@ -93,6 +93,31 @@ func (r *ImageRepository) Delete(ctx context.Context, sha256 string) error {
 	return r.queries.DeleteImage(ctx, sha256)
 }
 
+// Checkpoint returns the last file an interrupted ingestion of dir got through, or ""
+func (r *ImageRepository) Checkpoint(ctx context.Context, dir string) (string, error) {
+	file, err := r.queries.GetIngestionCheckpoint(ctx, dir)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	return file, nil
+}
+
+// SaveCheckpoint records the last file an ingestion of dir got through
+func (r *ImageRepository) SaveCheckpoint(ctx context.Context, dir, file string) error {
+	return r.queries.SaveIngestionCheckpoint(ctx, sqlc.SaveIngestionCheckpointParams{
+		Dir:      dir,
+		LastFile: file,
+	})
+}
+
+// DeleteCheckpoint forgets the checkpoint of dir, once an ingestion got through all of it
+func (r *ImageRepository) DeleteCheckpoint(ctx context.Context, dir string) error {
+	return r.queries.DeleteIngestionCheckpoint(ctx, dir)
+}
+
 // toDomainImage converts a sqlc.Image to domain.Image
 func toDomainImage(img sqlc.Image) *domain.Image {
 	d := &domain.Image{
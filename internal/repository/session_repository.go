@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lewtec/rotulador/internal/domain"
+	"github.com/lewtec/rotulador/internal/sqlc"
+)
+
+// SessionRepository implements domain.SessionRepository using SQLC
+type SessionRepository struct {
+	queries *sqlc.Queries
+}
+
+// NewSessionRepository creates a new SessionRepository
+func NewSessionRepository(db sqlc.DBTX) *SessionRepository {
+	return &SessionRepository{
+		queries: sqlc.New(db),
+	}
+}
+
+// NewSessionRepositoryWithTx creates a new SessionRepository with a transaction
+func NewSessionRepositoryWithTx(tx sqlc.DBTX) *SessionRepository {
+	return &SessionRepository{
+		queries: sqlc.New(tx),
+	}
+}
+
+// Create stores a new session
+func (r *SessionRepository) Create(ctx context.Context, session domain.Session) error {
+	params := sqlc.CreateSessionParams{
+		TokenHash: session.TokenHash,
+		Username:  session.Username,
+		ClientIp:  session.ClientIP,
+		ExpiresAt: session.ExpiresAt.UTC(),
+		Role:      session.Role,
+	}
+	return r.queries.CreateSession(ctx, params)
+}
+
+// Get retrieves a session that has not expired by then, or nil
+func (r *SessionRepository) Get(ctx context.Context, tokenHash string, now time.Time) (*domain.Session, error) {
+	params := sqlc.GetSessionParams{
+		TokenHash: tokenHash,
+		Now:       now.UTC(),
+	}
+	row, err := r.queries.GetSession(ctx, params)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &domain.Session{
+		TokenHash: row.TokenHash,
+		Username:  row.Username,
+		ClientIP:  row.ClientIp,
+		CreatedAt: row.CreatedAt,
+		ExpiresAt: row.ExpiresAt,
+		Role:      row.Role,
+	}, nil
+}
+
+// Delete removes a session
+func (r *SessionRepository) Delete(ctx context.Context, tokenHash string) error {
+	return r.queries.DeleteSession(ctx, tokenHash)
+}
+
+// DeleteUser removes all sessions of a user, returning how many there were
+func (r *SessionRepository) DeleteUser(ctx context.Context, username string) (int64, error) {
+	return r.queries.DeleteUserSessions(ctx, username)
+}
+
+// DeleteExpired removes the sessions expired by then, returning how many there were
+func (r *SessionRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	return r.queries.DeleteExpiredSessions(ctx, now.UTC())
+}
+
+// Secret returns the named secret, storing the generated one if there is none yet
+func (r *SessionRepository) Secret(ctx context.Context, name string, generate func() ([]byte, error)) ([]byte, error) {
+	value, err := r.queries.GetAppSecret(ctx, name)
+	if err == nil {
+		return value, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	value, err = generate()
+	if err != nil {
+		return nil, err
+	}
+	params := sqlc.CreateAppSecretParams{
+		Name:  name,
+		Value: value,
+	}
+	if err := r.queries.CreateAppSecret(ctx, params); err != nil {
+		return nil, err
+	}
+	// Another process may have stored its own secret first
+	return r.queries.GetAppSecret(ctx, name)
+}
+
+// Verify that SessionRepository implements domain.SessionRepository
+var _ domain.SessionRepository = (*SessionRepository)(nil)
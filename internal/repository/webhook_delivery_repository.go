@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/lewtec/rotulador/internal/domain"
+	"github.com/lewtec/rotulador/internal/sqlc"
+)
+
+// WebhookDeliveryRepository implements domain.WebhookDeliveryRepository using SQLC
+type WebhookDeliveryRepository struct {
+	queries *sqlc.Queries
+}
+
+// NewWebhookDeliveryRepository creates a new WebhookDeliveryRepository
+func NewWebhookDeliveryRepository(db sqlc.DBTX) *WebhookDeliveryRepository {
+	return &WebhookDeliveryRepository{
+		queries: sqlc.New(db),
+	}
+}
+
+// NewWebhookDeliveryRepositoryWithTx creates a new WebhookDeliveryRepository with a transaction
+func NewWebhookDeliveryRepositoryWithTx(tx sqlc.DBTX) *WebhookDeliveryRepository {
+	return &WebhookDeliveryRepository{
+		queries: sqlc.New(tx),
+	}
+}
+
+// Create queues a delivery, to be attempted from nextAttemptAt on
+func (r *WebhookDeliveryRepository) Create(ctx context.Context, url, eventType, payload string, nextAttemptAt time.Time) (*domain.WebhookDelivery, error) {
+	params := sqlc.CreateWebhookDeliveryParams{
+		Url:           url,
+		EventType:     eventType,
+		Payload:       payload,
+		NextAttemptAt: nextAttemptAt.UTC(),
+	}
+	row, err := r.queries.CreateWebhookDelivery(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return toDomainWebhookDelivery(row), nil
+}
+
+// ListDue retrieves up to limit deliveries waiting to be attempted by now, oldest first
+func (r *WebhookDeliveryRepository) ListDue(ctx context.Context, now time.Time, limit int) ([]*domain.WebhookDelivery, error) {
+	params := sqlc.ListDueWebhookDeliveriesParams{
+		Now:   now.UTC(),
+		Limit: int64(limit),
+	}
+	rows, err := r.queries.ListDueWebhookDeliveries(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return toDomainWebhookDeliveries(rows), nil
+}
+
+// List retrieves the latest deliveries, newest first
+func (r *WebhookDeliveryRepository) List(ctx context.Context, limit int) ([]*domain.WebhookDelivery, error) {
+	rows, err := r.queries.ListWebhookDeliveries(ctx, int64(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	return toDomainWebhookDeliveries(rows), nil
+}
+
+// MarkDelivered records a successful attempt
+func (r *WebhookDeliveryRepository) MarkDelivered(ctx context.Context, id int64, status int, now time.Time) error {
+	now = now.UTC()
+	lastStatus := int64(status)
+	params := sqlc.MarkWebhookDeliveredParams{
+		Now:        &now,
+		LastStatus: &lastStatus,
+		ID:         id,
+	}
+	return r.queries.MarkWebhookDelivered(ctx, params)
+}
+
+// MarkAttemptFailed records a failed attempt, to be retried at retryAt, or given up on when it is nil
+func (r *WebhookDeliveryRepository) MarkAttemptFailed(ctx context.Context, id int64, status *int, message string, retryAt *time.Time, now time.Time) error {
+	now = now.UTC()
+	params := sqlc.MarkWebhookAttemptFailedParams{
+		LastError:     &message,
+		NextAttemptAt: now,
+		ID:            id,
+	}
+	if status != nil {
+		lastStatus := int64(*status)
+		params.LastStatus = &lastStatus
+	}
+	if retryAt != nil {
+		params.NextAttemptAt = retryAt.UTC()
+	} else {
+		params.FailedAt = &now
+	}
+	return r.queries.MarkWebhookAttemptFailed(ctx, params)
+}
+
+// Retry queues a delivery that was given up on again, returning false if it was not given up on
+func (r *WebhookDeliveryRepository) Retry(ctx context.Context, id int64, now time.Time) (bool, error) {
+	params := sqlc.RetryWebhookDeliveryParams{
+		Now: now.UTC(),
+		ID:  id,
+	}
+	count, err := r.queries.RetryWebhookDelivery(ctx, params)
+	return count > 0, err
+}
+
+func toDomainWebhookDeliveries(rows []sqlc.WebhookDelivery) []*domain.WebhookDelivery {
+	result := make([]*domain.WebhookDelivery, len(rows))
+	for i, row := range rows {
+		result[i] = toDomainWebhookDelivery(row)
+	}
+	return result
+}
+
+func toDomainWebhookDelivery(row sqlc.WebhookDelivery) *domain.WebhookDelivery {
+	delivery := &domain.WebhookDelivery{
+		ID:            row.ID,
+		URL:           row.Url,
+		EventType:     row.EventType,
+		Payload:       row.Payload,
+		Attempts:      int(row.Attempts),
+		NextAttemptAt: row.NextAttemptAt,
+		CreatedAt:     row.CreatedAt,
+		DeliveredAt:   row.DeliveredAt,
+		FailedAt:      row.FailedAt,
+		LastError:     row.LastError,
+	}
+	if row.LastStatus != nil {
+		status := int(*row.LastStatus)
+		delivery.LastStatus = &status
+	}
+	return delivery
+}
+
+// Verify that WebhookDeliveryRepository implements domain.WebhookDeliveryRepository
+var _ domain.WebhookDeliveryRepository = (*WebhookDeliveryRepository)(nil)
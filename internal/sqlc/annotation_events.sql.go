@@ -0,0 +1,170 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: annotation_events.sql
+
+package sqlc
+
+import (
+	"context"
+)
+
+const createAnnotationEvent = `-- name: CreateAnnotationEvent :one
+INSERT INTO annotation_events (event_type, image_sha256, username, stage_index, old_value, new_value, actor, client_ip, config_version)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING id, event_type, image_sha256, username, stage_index, old_value, new_value, actor, client_ip, config_version, created_at
+`
+
+type CreateAnnotationEventParams struct {
+	EventType     string  `json:"event_type"`
+	ImageSha256   string  `json:"image_sha256"`
+	Username      string  `json:"username"`
+	StageIndex    int64   `json:"stage_index"`
+	OldValue      *string `json:"old_value"`
+	NewValue      *string `json:"new_value"`
+	Actor         string  `json:"actor"`
+	ClientIp      string  `json:"client_ip"`
+	ConfigVersion string  `json:"config_version"`
+}
+
+func (q *Queries) CreateAnnotationEvent(ctx context.Context, arg CreateAnnotationEventParams) (AnnotationEvent, error) {
+	row := q.db.QueryRowContext(ctx, createAnnotationEvent,
+		arg.EventType,
+		arg.ImageSha256,
+		arg.Username,
+		arg.StageIndex,
+		arg.OldValue,
+		arg.NewValue,
+		arg.Actor,
+		arg.ClientIp,
+		arg.ConfigVersion,
+	)
+	var i AnnotationEvent
+	err := row.Scan(
+		&i.ID,
+		&i.EventType,
+		&i.ImageSha256,
+		&i.Username,
+		&i.StageIndex,
+		&i.OldValue,
+		&i.NewValue,
+		&i.Actor,
+		&i.ClientIp,
+		&i.ConfigVersion,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getAnnotationEvent = `-- name: GetAnnotationEvent :one
+SELECT id, event_type, image_sha256, username, stage_index, old_value, new_value, actor, client_ip, config_version, created_at FROM annotation_events
+WHERE id = ?
+`
+
+func (q *Queries) GetAnnotationEvent(ctx context.Context, id int64) (AnnotationEvent, error) {
+	row := q.db.QueryRowContext(ctx, getAnnotationEvent, id)
+	var i AnnotationEvent
+	err := row.Scan(
+		&i.ID,
+		&i.EventType,
+		&i.ImageSha256,
+		&i.Username,
+		&i.StageIndex,
+		&i.OldValue,
+		&i.NewValue,
+		&i.Actor,
+		&i.ClientIp,
+		&i.ConfigVersion,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const listAnnotationEventsByUser = `-- name: ListAnnotationEventsByUser :many
+SELECT id, event_type, image_sha256, username, stage_index, old_value, new_value, actor, client_ip, config_version, created_at FROM annotation_events
+WHERE username = ?1 OR actor = ?1
+ORDER BY id DESC
+LIMIT ?3 OFFSET ?2
+`
+
+type ListAnnotationEventsByUserParams struct {
+	Username string `json:"username"`
+	Offset   int64  `json:"offset"`
+	Limit    int64  `json:"limit"`
+}
+
+func (q *Queries) ListAnnotationEventsByUser(ctx context.Context, arg ListAnnotationEventsByUserParams) ([]AnnotationEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listAnnotationEventsByUser, arg.Username, arg.Offset, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AnnotationEvent{}
+	for rows.Next() {
+		var i AnnotationEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.EventType,
+			&i.ImageSha256,
+			&i.Username,
+			&i.StageIndex,
+			&i.OldValue,
+			&i.NewValue,
+			&i.Actor,
+			&i.ClientIp,
+			&i.ConfigVersion,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAnnotationEventsForImage = `-- name: ListAnnotationEventsForImage :many
+SELECT id, event_type, image_sha256, username, stage_index, old_value, new_value, actor, client_ip, config_version, created_at FROM annotation_events
+WHERE image_sha256 = ?
+ORDER BY id DESC
+`
+
+func (q *Queries) ListAnnotationEventsForImage(ctx context.Context, imageSha256 string) ([]AnnotationEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listAnnotationEventsForImage, imageSha256)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AnnotationEvent{}
+	for rows.Next() {
+		var i AnnotationEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.EventType,
+			&i.ImageSha256,
+			&i.Username,
+			&i.StageIndex,
+			&i.OldValue,
+			&i.NewValue,
+			&i.Actor,
+			&i.ClientIp,
+			&i.ConfigVersion,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,127 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: annotation_timing.sql
+
+package sqlc
+
+import (
+	"context"
+	"time"
+)
+
+const deleteAnnotationViewsBefore = `-- name: DeleteAnnotationViewsBefore :execrows
+DELETE FROM annotation_views
+WHERE served_at < ?1
+`
+
+func (q *Queries) DeleteAnnotationViewsBefore(ctx context.Context, before time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteAnnotationViewsBefore, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const listAnnotationTimings = `-- name: ListAnnotationTimings :many
+SELECT username, stage_index, served_at, duration_ms
+FROM annotations
+WHERE served_at IS NOT NULL AND duration_ms IS NOT NULL
+  AND served_at >= ?1
+ORDER BY username, served_at, id
+`
+
+type ListAnnotationTimingsRow struct {
+	Username   string     `json:"username"`
+	StageIndex int64      `json:"stage_index"`
+	ServedAt   *time.Time `json:"served_at"`
+	DurationMs *int64     `json:"duration_ms"`
+}
+
+func (q *Queries) ListAnnotationTimings(ctx context.Context, since *time.Time) ([]ListAnnotationTimingsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listAnnotationTimings, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListAnnotationTimingsRow{}
+	for rows.Next() {
+		var i ListAnnotationTimingsRow
+		if err := rows.Scan(
+			&i.Username,
+			&i.StageIndex,
+			&i.ServedAt,
+			&i.DurationMs,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const recordAnnotationView = `-- name: RecordAnnotationView :exec
+INSERT INTO annotation_views (image_sha256, username, stage_index, served_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(image_sha256, username, stage_index)
+DO UPDATE SET served_at = excluded.served_at
+`
+
+type RecordAnnotationViewParams struct {
+	ImageSha256 string    `json:"image_sha256"`
+	Username    string    `json:"username"`
+	StageIndex  int64     `json:"stage_index"`
+	ServedAt    time.Time `json:"served_at"`
+}
+
+func (q *Queries) RecordAnnotationView(ctx context.Context, arg RecordAnnotationViewParams) error {
+	_, err := q.db.ExecContext(ctx, recordAnnotationView,
+		arg.ImageSha256,
+		arg.Username,
+		arg.StageIndex,
+		arg.ServedAt,
+	)
+	return err
+}
+
+const setAnnotationTiming = `-- name: SetAnnotationTiming :exec
+UPDATE annotations
+SET served_at = ?, duration_ms = ?
+WHERE id = ?
+`
+
+type SetAnnotationTimingParams struct {
+	ServedAt   *time.Time `json:"served_at"`
+	DurationMs *int64     `json:"duration_ms"`
+	ID         int64      `json:"id"`
+}
+
+func (q *Queries) SetAnnotationTiming(ctx context.Context, arg SetAnnotationTimingParams) error {
+	_, err := q.db.ExecContext(ctx, setAnnotationTiming, arg.ServedAt, arg.DurationMs, arg.ID)
+	return err
+}
+
+const takeAnnotationView = `-- name: TakeAnnotationView :one
+DELETE FROM annotation_views
+WHERE image_sha256 = ? AND username = ? AND stage_index = ?
+RETURNING served_at
+`
+
+type TakeAnnotationViewParams struct {
+	ImageSha256 string `json:"image_sha256"`
+	Username    string `json:"username"`
+	StageIndex  int64  `json:"stage_index"`
+}
+
+func (q *Queries) TakeAnnotationView(ctx context.Context, arg TakeAnnotationViewParams) (time.Time, error) {
+	row := q.db.QueryRowContext(ctx, takeAnnotationView, arg.ImageSha256, arg.Username, arg.StageIndex)
+	var served_at time.Time
+	err := row.Scan(&served_at)
+	return served_at, err
+}
@@ -52,6 +52,78 @@ func (q *Queries) CheckAnnotationExistsForImageStage(ctx context.Context, arg Ch
 	return column_1, err
 }
 
+const countAnnotationsByClass = `-- name: CountAnnotationsByClass :many
+SELECT stage_index, option_value, COUNT(*) AS annotations
+FROM annotations
+GROUP BY stage_index, option_value
+ORDER BY stage_index, option_value
+`
+
+type CountAnnotationsByClassRow struct {
+	StageIndex  int64  `json:"stage_index"`
+	OptionValue string `json:"option_value"`
+	Annotations int64  `json:"annotations"`
+}
+
+func (q *Queries) CountAnnotationsByClass(ctx context.Context) ([]CountAnnotationsByClassRow, error) {
+	rows, err := q.db.QueryContext(ctx, countAnnotationsByClass)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CountAnnotationsByClassRow{}
+	for rows.Next() {
+		var i CountAnnotationsByClassRow
+		if err := rows.Scan(&i.StageIndex, &i.OptionValue, &i.Annotations); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countAnnotationsByDay = `-- name: CountAnnotationsByDay :many
+SELECT CAST(date(annotated_at, 'localtime') AS TEXT) AS day, COUNT(*) AS annotations
+FROM annotations
+WHERE date(annotated_at, 'localtime') >= CAST(?1 AS TEXT)
+GROUP BY day
+ORDER BY day
+`
+
+type CountAnnotationsByDayRow struct {
+	Day         string `json:"day"`
+	Annotations int64  `json:"annotations"`
+}
+
+func (q *Queries) CountAnnotationsByDay(ctx context.Context, since string) ([]CountAnnotationsByDayRow, error) {
+	rows, err := q.db.QueryContext(ctx, countAnnotationsByDay, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CountAnnotationsByDayRow{}
+	for rows.Next() {
+		var i CountAnnotationsByDayRow
+		if err := rows.Scan(&i.Day, &i.Annotations); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const countAnnotationsByUser = `-- name: CountAnnotationsByUser :one
 SELECT COUNT(*) FROM annotations
 WHERE username = ?
@@ -64,6 +136,26 @@ func (q *Queries) CountAnnotationsByUser(ctx context.Context, username string) (
 	return count, err
 }
 
+const countAnnotationsByUserFiltered = `-- name: CountAnnotationsByUserFiltered :one
+SELECT COUNT(*) FROM annotations
+WHERE username = ?1
+  AND (?2 IS NULL OR stage_index = ?2)
+  AND (?3 IS NULL OR option_value = ?3)
+`
+
+type CountAnnotationsByUserFilteredParams struct {
+	Username    string      `json:"username"`
+	StageIndex  interface{} `json:"stage_index"`
+	OptionValue interface{} `json:"option_value"`
+}
+
+func (q *Queries) CountAnnotationsByUserFiltered(ctx context.Context, arg CountAnnotationsByUserFilteredParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countAnnotationsByUserFiltered, arg.Username, arg.StageIndex, arg.OptionValue)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const countImagesWithAnnotation = `-- name: CountImagesWithAnnotation :one
 SELECT COUNT(DISTINCT image_sha256)
 FROM annotations
@@ -159,8 +251,10 @@ VALUES (?, ?, ?, ?)
 ON CONFLICT(image_sha256, username, stage_index)
 DO UPDATE SET
   option_value = excluded.option_value,
-  annotated_at = CURRENT_TIMESTAMP
-RETURNING id, image_sha256, username, stage_index, option_value, annotated_at
+  annotated_at = CURRENT_TIMESTAMP,
+  flagged_at = NULL,
+  flag_reason = NULL
+RETURNING id, image_sha256, username, stage_index, option_value, annotated_at, flagged_at, flag_reason, served_at, duration_ms
 `
 
 type CreateAnnotationParams struct {
@@ -185,6 +279,10 @@ func (q *Queries) CreateAnnotation(ctx context.Context, arg CreateAnnotationPara
 		&i.StageIndex,
 		&i.OptionValue,
 		&i.AnnotatedAt,
+		&i.FlaggedAt,
+		&i.FlagReason,
+		&i.ServedAt,
+		&i.DurationMs,
 	)
 	return i, err
 }
@@ -209,6 +307,26 @@ func (q *Queries) DeleteAnnotationsForImage(ctx context.Context, imageSha256 str
 	return err
 }
 
+const flagAnnotationsForImageStage = `-- name: FlagAnnotationsForImageStage :execrows
+UPDATE annotations
+SET flagged_at = CURRENT_TIMESTAMP, flag_reason = ?
+WHERE image_sha256 = ? AND stage_index = ? AND flagged_at IS NULL
+`
+
+type FlagAnnotationsForImageStageParams struct {
+	FlagReason  *string `json:"flag_reason"`
+	ImageSha256 string  `json:"image_sha256"`
+	StageIndex  int64   `json:"stage_index"`
+}
+
+func (q *Queries) FlagAnnotationsForImageStage(ctx context.Context, arg FlagAnnotationsForImageStageParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, flagAnnotationsForImageStage, arg.FlagReason, arg.ImageSha256, arg.StageIndex)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const getAllImageSHA256s = `-- name: GetAllImageSHA256s :many
 SELECT sha256 FROM images ORDER BY sha256
 `
@@ -237,7 +355,7 @@ func (q *Queries) GetAllImageSHA256s(ctx context.Context) ([]string, error) {
 }
 
 const getAnnotation = `-- name: GetAnnotation :one
-SELECT id, image_sha256, username, stage_index, option_value, annotated_at FROM annotations
+SELECT id, image_sha256, username, stage_index, option_value, annotated_at, flagged_at, flag_reason, served_at, duration_ms FROM annotations
 WHERE image_sha256 = ? AND username = ? AND stage_index = ?
 `
 
@@ -257,6 +375,33 @@ func (q *Queries) GetAnnotation(ctx context.Context, arg GetAnnotationParams) (A
 		&i.StageIndex,
 		&i.OptionValue,
 		&i.AnnotatedAt,
+		&i.FlaggedAt,
+		&i.FlagReason,
+		&i.ServedAt,
+		&i.DurationMs,
+	)
+	return i, err
+}
+
+const getAnnotationByID = `-- name: GetAnnotationByID :one
+SELECT id, image_sha256, username, stage_index, option_value, annotated_at, flagged_at, flag_reason, served_at, duration_ms FROM annotations
+WHERE id = ?
+`
+
+func (q *Queries) GetAnnotationByID(ctx context.Context, id int64) (Annotation, error) {
+	row := q.db.QueryRowContext(ctx, getAnnotationByID, id)
+	var i Annotation
+	err := row.Scan(
+		&i.ID,
+		&i.ImageSha256,
+		&i.Username,
+		&i.StageIndex,
+		&i.OptionValue,
+		&i.AnnotatedAt,
+		&i.FlaggedAt,
+		&i.FlagReason,
+		&i.ServedAt,
+		&i.DurationMs,
 	)
 	return i, err
 }
@@ -283,7 +428,7 @@ func (q *Queries) GetAnnotationStats(ctx context.Context) (GetAnnotationStatsRow
 }
 
 const getAnnotationsByImageAndUser = `-- name: GetAnnotationsByImageAndUser :many
-SELECT id, image_sha256, username, stage_index, option_value, annotated_at FROM annotations
+SELECT id, image_sha256, username, stage_index, option_value, annotated_at, flagged_at, flag_reason, served_at, duration_ms FROM annotations
 WHERE image_sha256 = ? AND username = ?
 ORDER BY stage_index ASC
 `
@@ -309,6 +454,10 @@ func (q *Queries) GetAnnotationsByImageAndUser(ctx context.Context, arg GetAnnot
 			&i.StageIndex,
 			&i.OptionValue,
 			&i.AnnotatedAt,
+			&i.FlaggedAt,
+			&i.FlagReason,
+			&i.ServedAt,
+			&i.DurationMs,
 		); err != nil {
 			return nil, err
 		}
@@ -324,11 +473,11 @@ func (q *Queries) GetAnnotationsByImageAndUser(ctx context.Context, arg GetAnnot
 }
 
 const getAnnotationsByUser = `-- name: GetAnnotationsByUser :many
-SELECT a.id, a.image_sha256, a.username, a.stage_index, a.option_value, a.annotated_at, i.filename
+SELECT a.id, a.image_sha256, a.username, a.stage_index, a.option_value, a.annotated_at, a.flagged_at, a.flag_reason, a.served_at, a.duration_ms, i.filename
 FROM annotations a
 JOIN images i ON a.image_sha256 = i.sha256
 WHERE a.username = ?
-ORDER BY a.annotated_at DESC
+ORDER BY a.annotated_at DESC, a.id DESC
 LIMIT ? OFFSET ?
 `
 
@@ -345,6 +494,10 @@ type GetAnnotationsByUserRow struct {
 	StageIndex  int64      `json:"stage_index"`
 	OptionValue string     `json:"option_value"`
 	AnnotatedAt *time.Time `json:"annotated_at"`
+	FlaggedAt   *time.Time `json:"flagged_at"`
+	FlagReason  *string    `json:"flag_reason"`
+	ServedAt    *time.Time `json:"served_at"`
+	DurationMs  *int64     `json:"duration_ms"`
 	Filename    string     `json:"filename"`
 }
 
@@ -364,6 +517,10 @@ func (q *Queries) GetAnnotationsByUser(ctx context.Context, arg GetAnnotationsBy
 			&i.StageIndex,
 			&i.OptionValue,
 			&i.AnnotatedAt,
+			&i.FlaggedAt,
+			&i.FlagReason,
+			&i.ServedAt,
+			&i.DurationMs,
 			&i.Filename,
 		); err != nil {
 			return nil, err
@@ -380,7 +537,7 @@ func (q *Queries) GetAnnotationsByUser(ctx context.Context, arg GetAnnotationsBy
 }
 
 const getAnnotationsForImage = `-- name: GetAnnotationsForImage :many
-SELECT id, image_sha256, username, stage_index, option_value, annotated_at FROM annotations
+SELECT id, image_sha256, username, stage_index, option_value, annotated_at, flagged_at, flag_reason, served_at, duration_ms FROM annotations
 WHERE image_sha256 = ?
 ORDER BY stage_index ASC
 `
@@ -401,6 +558,10 @@ func (q *Queries) GetAnnotationsForImage(ctx context.Context, imageSha256 string
 			&i.StageIndex,
 			&i.OptionValue,
 			&i.AnnotatedAt,
+			&i.FlaggedAt,
+			&i.FlagReason,
+			&i.ServedAt,
+			&i.DurationMs,
 		); err != nil {
 			return nil, err
 		}
@@ -528,6 +689,187 @@ func (q *Queries) GetImagesWithoutAnnotationForStage(ctx context.Context) ([]Get
 	return items, nil
 }
 
+const listAnnotationsByUserFiltered = `-- name: ListAnnotationsByUserFiltered :many
+SELECT a.id, a.image_sha256, a.username, a.stage_index, a.option_value, a.annotated_at, a.flagged_at, a.flag_reason, a.served_at, a.duration_ms, i.filename
+FROM annotations a
+JOIN images i ON a.image_sha256 = i.sha256
+WHERE a.username = ?1
+  AND (?2 IS NULL OR a.stage_index = ?2)
+  AND (?3 IS NULL OR a.option_value = ?3)
+ORDER BY a.annotated_at DESC, a.id DESC
+LIMIT ?5 OFFSET ?4
+`
+
+type ListAnnotationsByUserFilteredParams struct {
+	Username    string      `json:"username"`
+	StageIndex  interface{} `json:"stage_index"`
+	OptionValue interface{} `json:"option_value"`
+	Offset      int64       `json:"offset"`
+	Limit       int64       `json:"limit"`
+}
+
+type ListAnnotationsByUserFilteredRow struct {
+	ID          int64      `json:"id"`
+	ImageSha256 string     `json:"image_sha256"`
+	Username    string     `json:"username"`
+	StageIndex  int64      `json:"stage_index"`
+	OptionValue string     `json:"option_value"`
+	AnnotatedAt *time.Time `json:"annotated_at"`
+	FlaggedAt   *time.Time `json:"flagged_at"`
+	FlagReason  *string    `json:"flag_reason"`
+	ServedAt    *time.Time `json:"served_at"`
+	DurationMs  *int64     `json:"duration_ms"`
+	Filename    string     `json:"filename"`
+}
+
+func (q *Queries) ListAnnotationsByUserFiltered(ctx context.Context, arg ListAnnotationsByUserFilteredParams) ([]ListAnnotationsByUserFilteredRow, error) {
+	rows, err := q.db.QueryContext(ctx, listAnnotationsByUserFiltered,
+		arg.Username,
+		arg.StageIndex,
+		arg.OptionValue,
+		arg.Offset,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListAnnotationsByUserFilteredRow{}
+	for rows.Next() {
+		var i ListAnnotationsByUserFilteredRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.ImageSha256,
+			&i.Username,
+			&i.StageIndex,
+			&i.OptionValue,
+			&i.AnnotatedAt,
+			&i.FlaggedAt,
+			&i.FlagReason,
+			&i.ServedAt,
+			&i.DurationMs,
+			&i.Filename,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAnnotationsPage = `-- name: ListAnnotationsPage :many
+SELECT a.id, a.image_sha256, a.username, a.stage_index, a.option_value, a.annotated_at, a.flagged_at, a.flag_reason, a.served_at, a.duration_ms, i.filename
+FROM annotations a
+JOIN images i ON a.image_sha256 = i.sha256
+WHERE (?1 IS NULL OR a.username = ?1)
+  AND (?2 IS NULL OR a.image_sha256 = ?2)
+  AND (?3 IS NULL OR a.stage_index = ?3)
+  AND (?4 IS NULL OR a.option_value = ?4)
+  AND (?5 IS NULL OR a.id < ?5)
+ORDER BY a.id DESC
+LIMIT ?6
+`
+
+type ListAnnotationsPageParams struct {
+	Username    interface{} `json:"username"`
+	ImageSha256 interface{} `json:"image_sha256"`
+	StageIndex  interface{} `json:"stage_index"`
+	OptionValue interface{} `json:"option_value"`
+	BeforeID    interface{} `json:"before_id"`
+	Limit       int64       `json:"limit"`
+}
+
+type ListAnnotationsPageRow struct {
+	ID          int64      `json:"id"`
+	ImageSha256 string     `json:"image_sha256"`
+	Username    string     `json:"username"`
+	StageIndex  int64      `json:"stage_index"`
+	OptionValue string     `json:"option_value"`
+	AnnotatedAt *time.Time `json:"annotated_at"`
+	FlaggedAt   *time.Time `json:"flagged_at"`
+	FlagReason  *string    `json:"flag_reason"`
+	ServedAt    *time.Time `json:"served_at"`
+	DurationMs  *int64     `json:"duration_ms"`
+	Filename    string     `json:"filename"`
+}
+
+func (q *Queries) ListAnnotationsPage(ctx context.Context, arg ListAnnotationsPageParams) ([]ListAnnotationsPageRow, error) {
+	rows, err := q.db.QueryContext(ctx, listAnnotationsPage,
+		arg.Username,
+		arg.ImageSha256,
+		arg.StageIndex,
+		arg.OptionValue,
+		arg.BeforeID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListAnnotationsPageRow{}
+	for rows.Next() {
+		var i ListAnnotationsPageRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.ImageSha256,
+			&i.Username,
+			&i.StageIndex,
+			&i.OptionValue,
+			&i.AnnotatedAt,
+			&i.FlaggedAt,
+			&i.FlagReason,
+			&i.ServedAt,
+			&i.DurationMs,
+			&i.Filename,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAnnotators = `-- name: ListAnnotators :many
+SELECT DISTINCT username
+FROM annotations
+ORDER BY username
+`
+
+func (q *Queries) ListAnnotators(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listAnnotators)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		items = append(items, username)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listPendingImagesForUserAndStage = `-- name: ListPendingImagesForUserAndStage :many
 WITH annotated_images AS (
   SELECT image_sha256 FROM annotations WHERE username = ? AND stage_index = ?
@@ -568,3 +910,22 @@ func (q *Queries) ListPendingImagesForUserAndStage(ctx context.Context, arg List
 	}
 	return items, nil
 }
+
+const unflagAnnotationsForImageStage = `-- name: UnflagAnnotationsForImageStage :execrows
+UPDATE annotations
+SET flagged_at = NULL, flag_reason = NULL
+WHERE image_sha256 = ? AND stage_index = ? AND flagged_at IS NOT NULL
+`
+
+type UnflagAnnotationsForImageStageParams struct {
+	ImageSha256 string `json:"image_sha256"`
+	StageIndex  int64  `json:"stage_index"`
+}
+
+func (q *Queries) UnflagAnnotationsForImageStage(ctx context.Context, arg UnflagAnnotationsForImageStageParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, unflagAnnotationsForImageStage, arg.ImageSha256, arg.StageIndex)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -49,6 +49,16 @@ func (q *Queries) DeleteImage(ctx context.Context, sha256 string) error {
 	return err
 }
 
+const deleteIngestionCheckpoint = `-- name: DeleteIngestionCheckpoint :exec
+DELETE FROM ingestion_checkpoints
+WHERE dir = ?
+`
+
+func (q *Queries) DeleteIngestionCheckpoint(ctx context.Context, dir string) error {
+	_, err := q.db.ExecContext(ctx, deleteIngestionCheckpoint, dir)
+	return err
+}
+
 const getImage = `-- name: GetImage :one
 SELECT sha256, filename, ingested_at FROM images
 WHERE sha256 = ?
@@ -73,6 +83,18 @@ func (q *Queries) GetImageByFilename(ctx context.Context, filename string) (Imag
 	return i, err
 }
 
+const getIngestionCheckpoint = `-- name: GetIngestionCheckpoint :one
+SELECT last_file FROM ingestion_checkpoints
+WHERE dir = ?
+`
+
+func (q *Queries) GetIngestionCheckpoint(ctx context.Context, dir string) (string, error) {
+	row := q.db.QueryRowContext(ctx, getIngestionCheckpoint, dir)
+	var last_file string
+	err := row.Scan(&last_file)
+	return last_file, err
+}
+
 const listImages = `-- name: ListImages :many
 SELECT sha256, filename, ingested_at FROM images
 ORDER BY filename
@@ -129,3 +151,19 @@ func (q *Queries) ListImagesNotFinished(ctx context.Context, limit int64) ([]Ima
 	}
 	return items, nil
 }
+
+const saveIngestionCheckpoint = `-- name: SaveIngestionCheckpoint :exec
+INSERT INTO ingestion_checkpoints (dir, last_file, updated_at)
+VALUES (?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT(dir) DO UPDATE SET last_file = excluded.last_file, updated_at = excluded.updated_at
+`
+
+type SaveIngestionCheckpointParams struct {
+	Dir      string `json:"dir"`
+	LastFile string `json:"last_file"`
+}
+
+func (q *Queries) SaveIngestionCheckpoint(ctx context.Context, arg SaveIngestionCheckpointParams) error {
+	_, err := q.db.ExecContext(ctx, saveIngestionCheckpoint, arg.Dir, arg.LastFile)
+	return err
+}
@@ -15,6 +15,50 @@ type Annotation struct {
 	StageIndex  int64      `json:"stage_index"`
 	OptionValue string     `json:"option_value"`
 	AnnotatedAt *time.Time `json:"annotated_at"`
+	FlaggedAt   *time.Time `json:"flagged_at"`
+	FlagReason  *string    `json:"flag_reason"`
+	ServedAt    *time.Time `json:"served_at"`
+	DurationMs  *int64     `json:"duration_ms"`
+}
+
+type AnnotationEvent struct {
+	ID            int64      `json:"id"`
+	EventType     string     `json:"event_type"`
+	ImageSha256   string     `json:"image_sha256"`
+	Username      string     `json:"username"`
+	StageIndex    int64      `json:"stage_index"`
+	OldValue      *string    `json:"old_value"`
+	NewValue      *string    `json:"new_value"`
+	Actor         string     `json:"actor"`
+	ClientIp      string     `json:"client_ip"`
+	ConfigVersion string     `json:"config_version"`
+	CreatedAt     *time.Time `json:"created_at"`
+}
+
+type AnnotationView struct {
+	ImageSha256 string    `json:"image_sha256"`
+	Username    string    `json:"username"`
+	StageIndex  int64     `json:"stage_index"`
+	ServedAt    time.Time `json:"served_at"`
+}
+
+type ApiToken struct {
+	ID         int64      `json:"id"`
+	TokenHash  string     `json:"token_hash"`
+	Prefix     string     `json:"prefix"`
+	Username   string     `json:"username"`
+	Name       string     `json:"name"`
+	Scopes     string     `json:"scopes"`
+	Role       *string    `json:"role"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type AppSecret struct {
+	Name  string `json:"name"`
+	Value []byte `json:"value"`
 }
 
 type Image struct {
@@ -22,3 +66,32 @@ type Image struct {
 	Filename   string     `json:"filename"`
 	IngestedAt *time.Time `json:"ingested_at"`
 }
+
+type IngestionCheckpoint struct {
+	Dir       string    `json:"dir"`
+	LastFile  string    `json:"last_file"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Session struct {
+	TokenHash string    `json:"token_hash"`
+	Username  string    `json:"username"`
+	ClientIp  string    `json:"client_ip"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Role      *string   `json:"role"`
+}
+
+type WebhookDelivery struct {
+	ID            int64      `json:"id"`
+	Url           string     `json:"url"`
+	EventType     string     `json:"event_type"`
+	Payload       string     `json:"payload"`
+	Attempts      int64      `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	FailedAt      *time.Time `json:"failed_at"`
+	LastStatus    *int64     `json:"last_status"`
+	LastError     *string    `json:"last_error"`
+}
@@ -6,36 +6,78 @@ package sqlc
 
 import (
 	"context"
+	"time"
 )
 
 type Querier interface {
 	CheckAnnotationExists(ctx context.Context, arg CheckAnnotationExistsParams) (int64, error)
 	CheckAnnotationExistsForImageStage(ctx context.Context, arg CheckAnnotationExistsForImageStageParams) (int64, error)
+	CountAnnotationsByClass(ctx context.Context) ([]CountAnnotationsByClassRow, error)
+	CountAnnotationsByDay(ctx context.Context, since string) ([]CountAnnotationsByDayRow, error)
 	CountAnnotationsByUser(ctx context.Context, username string) (int64, error)
+	CountAnnotationsByUserFiltered(ctx context.Context, arg CountAnnotationsByUserFilteredParams) (int64, error)
 	CountImages(ctx context.Context) (int64, error)
 	CountImagesWithAnnotation(ctx context.Context, arg CountImagesWithAnnotationParams) (int64, error)
 	CountImagesWithAnnotationInList(ctx context.Context, arg CountImagesWithAnnotationInListParams) (int64, error)
 	CountImagesWithoutAnnotationForStage(ctx context.Context, stageIndex int64) (int64, error)
 	CountPendingImagesForUserAndStage(ctx context.Context, arg CountPendingImagesForUserAndStageParams) (int64, error)
+	CreateAPIToken(ctx context.Context, arg CreateAPITokenParams) (ApiToken, error)
 	CreateAnnotation(ctx context.Context, arg CreateAnnotationParams) (Annotation, error)
+	CreateAnnotationEvent(ctx context.Context, arg CreateAnnotationEventParams) (AnnotationEvent, error)
+	CreateAppSecret(ctx context.Context, arg CreateAppSecretParams) error
 	CreateImage(ctx context.Context, arg CreateImageParams) (Image, error)
+	CreateSession(ctx context.Context, arg CreateSessionParams) error
+	CreateWebhookDelivery(ctx context.Context, arg CreateWebhookDeliveryParams) (WebhookDelivery, error)
 	DeleteAnnotation(ctx context.Context, id int64) error
+	DeleteAnnotationViewsBefore(ctx context.Context, before time.Time) (int64, error)
 	DeleteAnnotationsForImage(ctx context.Context, imageSha256 string) error
+	DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error)
 	DeleteImage(ctx context.Context, sha256 string) error
+	DeleteIngestionCheckpoint(ctx context.Context, dir string) error
+	DeleteSession(ctx context.Context, tokenHash string) error
+	DeleteUserSessions(ctx context.Context, username string) (int64, error)
+	FlagAnnotationsForImageStage(ctx context.Context, arg FlagAnnotationsForImageStageParams) (int64, error)
+	GetAPIToken(ctx context.Context, id int64) (ApiToken, error)
+	GetActiveAPITokenByHash(ctx context.Context, arg GetActiveAPITokenByHashParams) (ApiToken, error)
 	GetAllImageSHA256s(ctx context.Context) ([]string, error)
 	GetAnnotation(ctx context.Context, arg GetAnnotationParams) (Annotation, error)
+	GetAnnotationByID(ctx context.Context, id int64) (Annotation, error)
+	GetAnnotationEvent(ctx context.Context, id int64) (AnnotationEvent, error)
 	GetAnnotationStats(ctx context.Context) (GetAnnotationStatsRow, error)
 	GetAnnotationsByImageAndUser(ctx context.Context, arg GetAnnotationsByImageAndUserParams) ([]Annotation, error)
 	GetAnnotationsByUser(ctx context.Context, arg GetAnnotationsByUserParams) ([]GetAnnotationsByUserRow, error)
 	GetAnnotationsForImage(ctx context.Context, imageSha256 string) ([]Annotation, error)
 	GetAnnotationsForStageAndValue(ctx context.Context, arg GetAnnotationsForStageAndValueParams) ([]GetAnnotationsForStageAndValueRow, error)
+	GetAppSecret(ctx context.Context, name string) ([]byte, error)
 	GetImage(ctx context.Context, sha256 string) (Image, error)
 	GetImageByFilename(ctx context.Context, filename string) (Image, error)
 	GetImageHashesWithAnnotation(ctx context.Context, arg GetImageHashesWithAnnotationParams) ([]string, error)
 	GetImagesWithoutAnnotationForStage(ctx context.Context) ([]GetImagesWithoutAnnotationForStageRow, error)
+	GetIngestionCheckpoint(ctx context.Context, dir string) (string, error)
+	GetSession(ctx context.Context, arg GetSessionParams) (Session, error)
+	ListAPITokens(ctx context.Context) ([]ApiToken, error)
+	ListAPITokensByUser(ctx context.Context, username string) ([]ApiToken, error)
+	ListAnnotationEventsByUser(ctx context.Context, arg ListAnnotationEventsByUserParams) ([]AnnotationEvent, error)
+	ListAnnotationEventsForImage(ctx context.Context, imageSha256 string) ([]AnnotationEvent, error)
+	ListAnnotationTimings(ctx context.Context, since *time.Time) ([]ListAnnotationTimingsRow, error)
+	ListAnnotationsByUserFiltered(ctx context.Context, arg ListAnnotationsByUserFilteredParams) ([]ListAnnotationsByUserFilteredRow, error)
+	ListAnnotationsPage(ctx context.Context, arg ListAnnotationsPageParams) ([]ListAnnotationsPageRow, error)
+	ListAnnotators(ctx context.Context) ([]string, error)
+	ListDueWebhookDeliveries(ctx context.Context, arg ListDueWebhookDeliveriesParams) ([]WebhookDelivery, error)
 	ListImages(ctx context.Context) ([]Image, error)
 	ListImagesNotFinished(ctx context.Context, limit int64) ([]Image, error)
 	ListPendingImagesForUserAndStage(ctx context.Context, arg ListPendingImagesForUserAndStageParams) ([]Image, error)
+	ListWebhookDeliveries(ctx context.Context, limit int64) ([]WebhookDelivery, error)
+	MarkWebhookAttemptFailed(ctx context.Context, arg MarkWebhookAttemptFailedParams) error
+	MarkWebhookDelivered(ctx context.Context, arg MarkWebhookDeliveredParams) error
+	RecordAnnotationView(ctx context.Context, arg RecordAnnotationViewParams) error
+	RetryWebhookDelivery(ctx context.Context, arg RetryWebhookDeliveryParams) (int64, error)
+	RevokeAPIToken(ctx context.Context, arg RevokeAPITokenParams) (int64, error)
+	SaveIngestionCheckpoint(ctx context.Context, arg SaveIngestionCheckpointParams) error
+	SetAnnotationTiming(ctx context.Context, arg SetAnnotationTimingParams) error
+	TakeAnnotationView(ctx context.Context, arg TakeAnnotationViewParams) (time.Time, error)
+	TouchAPIToken(ctx context.Context, arg TouchAPITokenParams) error
+	UnflagAnnotationsForImageStage(ctx context.Context, arg UnflagAnnotationsForImageStageParams) (int64, error)
 }
 
 var _ Querier = (*Queries)(nil)
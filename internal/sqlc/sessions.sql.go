@@ -0,0 +1,125 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: sessions.sql
+
+package sqlc
+
+import (
+	"context"
+	"time"
+)
+
+const createAppSecret = `-- name: CreateAppSecret :exec
+INSERT INTO app_secrets (name, value)
+VALUES (?, ?)
+ON CONFLICT(name) DO NOTHING
+`
+
+type CreateAppSecretParams struct {
+	Name  string `json:"name"`
+	Value []byte `json:"value"`
+}
+
+func (q *Queries) CreateAppSecret(ctx context.Context, arg CreateAppSecretParams) error {
+	_, err := q.db.ExecContext(ctx, createAppSecret, arg.Name, arg.Value)
+	return err
+}
+
+const createSession = `-- name: CreateSession :exec
+INSERT INTO sessions (token_hash, username, client_ip, expires_at, role)
+VALUES (?, ?, ?, ?, ?)
+`
+
+type CreateSessionParams struct {
+	TokenHash string    `json:"token_hash"`
+	Username  string    `json:"username"`
+	ClientIp  string    `json:"client_ip"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Role      *string   `json:"role"`
+}
+
+func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) error {
+	_, err := q.db.ExecContext(ctx, createSession,
+		arg.TokenHash,
+		arg.Username,
+		arg.ClientIp,
+		arg.ExpiresAt,
+		arg.Role,
+	)
+	return err
+}
+
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :execrows
+DELETE FROM sessions
+WHERE expires_at <= ?1
+`
+
+func (q *Queries) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredSessions, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteSession = `-- name: DeleteSession :exec
+DELETE FROM sessions
+WHERE token_hash = ?
+`
+
+func (q *Queries) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := q.db.ExecContext(ctx, deleteSession, tokenHash)
+	return err
+}
+
+const deleteUserSessions = `-- name: DeleteUserSessions :execrows
+DELETE FROM sessions
+WHERE username = ?
+`
+
+func (q *Queries) DeleteUserSessions(ctx context.Context, username string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUserSessions, username)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getAppSecret = `-- name: GetAppSecret :one
+SELECT value
+FROM app_secrets
+WHERE name = ?
+`
+
+func (q *Queries) GetAppSecret(ctx context.Context, name string) ([]byte, error) {
+	row := q.db.QueryRowContext(ctx, getAppSecret, name)
+	var value []byte
+	err := row.Scan(&value)
+	return value, err
+}
+
+const getSession = `-- name: GetSession :one
+SELECT token_hash, username, client_ip, created_at, expires_at, role
+FROM sessions
+WHERE token_hash = ? AND expires_at > ?
+`
+
+type GetSessionParams struct {
+	TokenHash string    `json:"token_hash"`
+	Now       time.Time `json:"now"`
+}
+
+func (q *Queries) GetSession(ctx context.Context, arg GetSessionParams) (Session, error) {
+	row := q.db.QueryRowContext(ctx, getSession, arg.TokenHash, arg.Now)
+	var i Session
+	err := row.Scan(
+		&i.TokenHash,
+		&i.Username,
+		&i.ClientIp,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.Role,
+	)
+	return i, err
+}